@@ -0,0 +1,501 @@
+package bitcask
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/internal/sio"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+const (
+	// CopyFiles makes Backup copy all the datastore files.
+	CopyFiles BackupOpt = 0
+	// LinkFiles makes Backup hard link the immutable datastore files instead of copying them
+	// whenever the filesystem supports it.
+	LinkFiles BackupOpt = 1
+
+	// backupManifest is the name of the file describing the files of a backup.
+	backupManifest = "backup.json"
+)
+
+// errBackupCorrupted happens whenever a restored backup does not match its manifest.
+var errBackupCorrupted = errors.New("backup is corrupted")
+
+type (
+	// BackupOpt represents the options the user can pass to Backup.
+	BackupOpt int
+
+	// snapshotFile represents a datastore file pinned by a snapshot.
+	// Only the first size bytes of the file belong to the snapshot.
+	snapshotFile struct {
+		name    string
+		size    int64
+		mutable bool
+	}
+
+	// manifestFile describes a backed up file in the backup manifest.
+	manifestFile struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+		CRC  uint32 `json:"crc32"`
+	}
+
+	// manifest describes the files of a backup.
+	manifest struct {
+		Files []manifestFile `json:"files"`
+	}
+)
+
+// Backup writes a consistent copy of the datastore into the given directory
+// on the datastore filesystem, creating the directory if it does not exist.
+// Writes can continue while the backup is running, only the data written before
+// Backup is called is copied and Merge does not delete any file until the backup is done.
+// With LinkFiles the immutable files are hard linked instead of being copied.
+// Return an error if the directory has files in it or on any system failures.
+func (b *Bitcask) Backup(dstDir string, opts ...BackupOpt) error {
+	link := false
+	for _, opt := range opts {
+		link = opt == LinkFiles
+	}
+
+	files, release, err := b.snapshot()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	fsys := b.dataStore.FS()
+	err = prepareDir(fsys, dstDir)
+	if err != nil {
+		return fmt.Errorf("Backup: %s", err)
+	}
+
+	m := manifest{Files: make([]manifestFile, 0)}
+	for _, file := range files {
+		f, err := b.backupFile(file, fsys, dstDir, link)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, f)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileSync(fsys, path.Join(dstDir, backupManifest), data)
+	if err != nil {
+		return err
+	}
+
+	return fsys.SyncDir(dstDir)
+}
+
+// BackupTo writes a consistent copy of the datastore to the given writer as a tar archive.
+// The archive can be restored with RestoreFrom.
+// Writes can continue while the backup is running like in Backup.
+// Return an error on any system failures.
+func (b *Bitcask) BackupTo(w io.Writer) error {
+	files, release, err := b.snapshot()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tw := tar.NewWriter(w)
+	m := manifest{Files: make([]manifestFile, 0)}
+	for _, file := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name: file.name,
+			Size: file.size,
+			Mode: 0666,
+		})
+		if err != nil {
+			return err
+		}
+
+		f, err := b.copySnapshotFile(file, tw)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, f)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name: backupManifest,
+		Size: int64(len(data)),
+		Mode: 0666,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// Restore validates the backup in the source directory then copies it into the destination directory
+// creating it if it does not exist, the restored directory can then be opened as a datastore.
+// It can take WithFS and WithEncryption config options to read and validate the backup,
+// the other options are ignored.
+// Return an error if any backup file does not match the manifest or has corrupted records,
+// if the destination directory has files in it or on any system failures.
+func Restore(srcDir, dstDir string, opts ...Option) error {
+	usrOpts := parseUsrOpts(opts)
+
+	return restoreBackup(usrOpts.fsys, srcDir, usrOpts.fsys, dstDir, &usrOpts.encoding)
+}
+
+// RestoreFrom extracts the backup archive written by BackupTo into the destination directory
+// creating it if it does not exist, then validates it like Restore does.
+// The archive files are streamed into the destination directory one at a time
+// and removed from it if the archive is not valid.
+// Return an error if the archive is not valid, if the destination directory has files in it or on any system failures.
+func RestoreFrom(r io.Reader, dstDir string, opts ...Option) error {
+	usrOpts := parseUsrOpts(opts)
+
+	err := prepareDir(usrOpts.fsys, dstDir)
+	if err != nil {
+		return fmt.Errorf("RestoreFrom: %s", err)
+	}
+
+	names, err := extractArchive(r, usrOpts.fsys, dstDir)
+	if err == nil {
+		err = restoreArchive(usrOpts.fsys, dstDir, names, &usrOpts.encoding)
+	}
+	if err != nil {
+		for _, name := range names {
+			usrOpts.fsys.Remove(path.Join(dstDir, name))
+		}
+		return err
+	}
+
+	return usrOpts.fsys.SyncDir(dstDir)
+}
+
+// snapshot pins the current datastore files and records their lengths.
+// Merge cannot delete any file until the returned release function is called.
+// Return an error on any system failures.
+func (b *Bitcask) snapshot() ([]snapshotFile, func(), error) {
+	b.filesMu.RLock()
+
+	b.accessMu.Lock()
+	defer b.accessMu.Unlock()
+
+	entries, err := b.dataStore.FS().ReadDir(b.dataStore.Path())
+	if err != nil {
+		b.filesMu.RUnlock()
+		return nil, nil, err
+	}
+
+	activeName := ""
+	if b.usrOpts.accessPermission == ReadWrite {
+		activeName = b.activeFile.Name()
+	} else {
+		activeName = newestDataFile(entries)
+	}
+
+	files := make([]snapshotFile, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".data") && !strings.HasSuffix(name, ".hint") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			b.filesMu.RUnlock()
+			return nil, nil, err
+		}
+
+		files = append(files, snapshotFile{
+			name:    name,
+			size:    info.Size(),
+			mutable: name == activeName,
+		})
+	}
+
+	return files, b.filesMu.RUnlock, nil
+}
+
+// backupFile links or copies the snapshot file into the destination directory.
+// Return the manifest entry describing the file.
+// Return an error on any system failures.
+func (b *Bitcask) backupFile(file snapshotFile, dst vfs.FS, dstDir string, link bool) (manifestFile, error) {
+	srcPath := path.Join(b.dataStore.Path(), file.name)
+	dstPath := path.Join(dstDir, file.name)
+
+	if linker, ok := dst.(vfs.Linker); ok && link && !file.mutable {
+		err := linker.Link(srcPath, dstPath)
+		if err == nil {
+			data, err := dst.ReadFile(dstPath)
+			if err != nil {
+				return manifestFile{}, err
+			}
+			if int64(len(data)) == file.size {
+				return manifestFile{Name: file.name, Size: file.size, CRC: crc32.ChecksumIEEE(data)}, nil
+			}
+
+			// the file was written after the snapshot, only its pinned part is copied.
+			err = dst.Remove(dstPath)
+			if err != nil {
+				return manifestFile{}, err
+			}
+		}
+	}
+
+	out, err := sio.OpenFile(dst, dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0666))
+	if err != nil {
+		return manifestFile{}, err
+	}
+	defer out.File.Close()
+
+	f, err := b.copySnapshotFile(file, out.File)
+	if err != nil {
+		return manifestFile{}, err
+	}
+
+	return f, out.File.Sync()
+}
+
+// copySnapshotFile copies the pinned part of the snapshot file to the given writer.
+// Return the manifest entry describing the file.
+// Return an error on any system failures.
+func (b *Bitcask) copySnapshotFile(file snapshotFile, w io.Writer) (manifestFile, error) {
+	src, err := sio.Open(b.dataStore.FS(), path.Join(b.dataStore.Path(), file.name))
+	if err != nil {
+		return manifestFile{}, err
+	}
+	defer src.File.Close()
+
+	hash := crc32.NewIEEE()
+	_, err = io.Copy(io.MultiWriter(w, hash), io.NewSectionReader(src.File, 0, file.size))
+	if err != nil {
+		return manifestFile{}, err
+	}
+
+	return manifestFile{Name: file.name, Size: file.size, CRC: hash.Sum32()}, nil
+}
+
+// restoreBackup validates the backup in the source directory then copies it into the destination directory.
+// Return an error if the backup is not valid, if the destination directory has files in it or on any system failures.
+func restoreBackup(src vfs.FS, srcDir string, dst vfs.FS, dstDir string, enc *recfmt.Encoding) error {
+	m, err := readManifest(src, srcDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range m.Files {
+		err := validateBackupFile(src, srcDir, file, enc)
+		if err != nil {
+			return fmt.Errorf("Restore: %s: %s: %s", errBackupCorrupted, file.Name, err)
+		}
+	}
+
+	err = prepareDir(dst, dstDir)
+	if err != nil {
+		return fmt.Errorf("Restore: %s", err)
+	}
+
+	for _, file := range m.Files {
+		data, err := src.ReadFile(path.Join(srcDir, file.Name))
+		if err != nil {
+			return err
+		}
+
+		err = writeFileSync(dst, path.Join(dstDir, file.Name), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return dst.SyncDir(dstDir)
+}
+
+// extractArchive streams the files of the backup archive into the given directory.
+// Return the names of the extracted files.
+// Return an error if the archive is not valid or on any system failures.
+func extractArchive(r io.Reader, fsys vfs.FS, dir string) ([]string, error) {
+	names := make([]string, 0)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, fmt.Errorf("RestoreFrom: %s: %s", errBackupCorrupted, err)
+		}
+
+		name := path.Base(hdr.Name)
+		if name == "." || name == ".." || name == "/" {
+			return names, fmt.Errorf("RestoreFrom: %s: %s: invalid file name", errBackupCorrupted, hdr.Name)
+		}
+
+		out, err := sio.OpenFile(fsys, path.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(0666))
+		if err != nil {
+			return names, err
+		}
+		names = append(names, name)
+
+		_, err = io.Copy(out.File, tr)
+		if err == nil {
+			err = out.File.Sync()
+		}
+		out.File.Close()
+		if err != nil {
+			return names, err
+		}
+	}
+}
+
+// restoreArchive validates the backup extracted into the given directory against its manifest,
+// then removes the manifest and the files that are not listed in it.
+// Return an error if the backup is not valid or on any system failures.
+func restoreArchive(fsys vfs.FS, dir string, names []string, enc *recfmt.Encoding) error {
+	m, err := readManifest(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[string]bool)
+	for _, file := range m.Files {
+		err := validateBackupFile(fsys, dir, file, enc)
+		if err != nil {
+			return fmt.Errorf("RestoreFrom: %s: %s: %s", errBackupCorrupted, file.Name, err)
+		}
+		listed[path.Base(file.Name)] = true
+	}
+
+	for _, name := range names {
+		if listed[name] {
+			continue
+		}
+		err := fsys.Remove(path.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readManifest reads the manifest of the backup in the given directory.
+// Return an error if the manifest is not valid or on any system failures.
+func readManifest(fsys vfs.FS, dir string) (manifest, error) {
+	var m manifest
+
+	data, err := fsys.ReadFile(path.Join(dir, backupManifest))
+	if err != nil {
+		return m, err
+	}
+
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return m, fmt.Errorf("Restore: %s: %s", errBackupCorrupted, err)
+	}
+
+	return m, nil
+}
+
+// newestDataFile returns the name of the data file with the latest timestamp among the given entries,
+// which is the active file of the process writing the datastore.
+func newestDataFile(entries []fs.DirEntry) string {
+	newest, newestTstamp := "", int64(-1)
+	for _, entry := range entries {
+		name := entry.Name()
+		tstamp, err := strconv.ParseInt(strings.TrimSuffix(name, ".data"), 10, 64)
+		if err != nil || !strings.HasSuffix(name, ".data") {
+			continue
+		}
+		if tstamp > newestTstamp {
+			newest, newestTstamp = name, tstamp
+		}
+	}
+
+	return newest
+}
+
+// validateBackupFile checks that the backup file matches its manifest entry
+// and that all of its records can be decoded.
+// Return an error describing the first mismatch found.
+func validateBackupFile(fsys vfs.FS, dir string, file manifestFile, enc *recfmt.Encoding) error {
+	data, err := fsys.ReadFile(path.Join(dir, path.Base(file.Name)))
+	if err != nil {
+		return err
+	}
+
+	if int64(len(data)) != file.Size {
+		return fmt.Errorf("size is %d, expected %d", len(data), file.Size)
+	}
+	if sum := crc32.ChecksumIEEE(data); sum != file.CRC {
+		return fmt.Errorf("crc32 is %d, expected %d", sum, file.CRC)
+	}
+
+	hdr, i, err := recfmt.ExtractFileHdr(data)
+	if err != nil {
+		return err
+	}
+
+	for i < len(data) {
+		var n int
+		if strings.HasSuffix(file.Name, ".hint") {
+			_, _, n, err = recfmt.ExtractHintFileRec(data[i:], hdr, enc)
+		} else {
+			var recLen uint32
+			_, recLen, err = recfmt.ExtractDataFileRec(data[i:], hdr, enc)
+			n = int(recLen)
+		}
+		if err != nil {
+			return fmt.Errorf("offset %d: %s", i, err)
+		}
+		i += n
+	}
+
+	return nil
+}
+
+// prepareDir creates the given directory if it does not exist.
+// The parent directory is flushed after the directory is created to make it durable.
+// Return an error if the directory has files in it or on any system failures.
+func prepareDir(fsys vfs.FS, dir string) error {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(entries) != 0 {
+		return fmt.Errorf("%s: %s", dir, errDirNotEmpty)
+	}
+	if err == nil {
+		return nil
+	}
+
+	err = fsys.MkdirAll(dir, os.FileMode(0777))
+	if err != nil {
+		return err
+	}
+
+	return fsys.SyncDir(path.Dir(path.Clean(dir)))
+}
@@ -0,0 +1,157 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+func TestBackup(t *testing.T) {
+	t.Run("backup while writing and restore", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		for i := 0; i < 1000; i++ {
+			b.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+		}
+
+		done := make(chan struct{})
+		go func() {
+			for i := 1000; i < 2000; i++ {
+				b.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+			}
+			close(done)
+		}()
+
+		err := b.Backup("backup", LinkFiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-done
+		b.Close()
+
+		err = Restore("backup", "restored", WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, _ := OpenWith("restored", WithFS(mem))
+		for i := 0; i < 1000; i++ {
+			got, _ := r.Get(fmt.Sprintf("key%d", i+1))
+			assertString(t, got, fmt.Sprintf("value%d", i+1))
+		}
+		r.Close()
+	})
+
+	t.Run("backup to a writer and restore from it", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		for i := 0; i < 1000; i++ {
+			b.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+		}
+		b.Merge()
+
+		var buf bytes.Buffer
+		err := b.BackupTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Close()
+
+		err = RestoreFrom(&buf, "restored", WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, _ := OpenWith("restored", WithFS(mem))
+		got, _ := r.Get("key500")
+		assertString(t, got, "value500")
+		r.Close()
+	})
+
+	t.Run("link files from a read only datastore", func(t *testing.T) {
+		mem := vfs.NewMem()
+		w, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		for i := 0; i < 100; i++ {
+			w.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+		}
+		w.Close()
+
+		r, err := OpenWith(testBitcaskPath, WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = r.Backup("backup", LinkFiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+
+		// the writes after the backup must not reach the backed up files.
+		w, _ = OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		for i := 100; i < 200; i++ {
+			w.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+		}
+		w.Close()
+
+		err = Restore("backup", "restored", WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored, _ := OpenWith("restored", WithFS(mem))
+		assertString(t, strconv.Itoa(len(restored.ListKeys())), "100")
+		restored.Close()
+	})
+
+	t.Run("restore from a corrupted archive", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		b.Put("key12", "value12345")
+
+		var buf bytes.Buffer
+		b.BackupTo(&buf)
+		b.Close()
+
+		data := buf.Bytes()
+		i := bytes.Index(data, []byte("key12"))
+		data[i] ^= 0xff
+
+		err := RestoreFrom(bytes.NewReader(data), "restored", WithFS(mem))
+		if err == nil || !strings.Contains(err.Error(), "backup is corrupted") {
+			t.Errorf("expected backup corruption error, got %v", err)
+		}
+
+		entries, _ := mem.ReadDir("restored")
+		if len(entries) != 0 {
+			t.Errorf("got %d files left in the restored directory, want 0", len(entries))
+		}
+	})
+
+	t.Run("restore corrupted backup", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		b.Put("key12", "value12345")
+		b.Backup("backup")
+		b.Close()
+
+		entries, _ := mem.ReadDir("backup")
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".data") {
+				name := path.Join("backup", entry.Name())
+				data, _ := mem.ReadFile(name)
+				data[len(data)-1] ^= 0xff
+				writeFileSync(mem, name, data)
+			}
+		}
+
+		err := Restore("backup", "restored", WithFS(mem))
+		if err == nil || !strings.Contains(err.Error(), "backup is corrupted") {
+			t.Errorf("expected backup corruption error, got %v", err)
+		}
+	})
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,6 +12,9 @@ import (
 	"github.com/IslamWalid/bitcask/internal/datastore"
 	"github.com/IslamWalid/bitcask/internal/keydir"
 	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/codec"
+	"github.com/IslamWalid/bitcask/pkg/encrypt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
 )
 
 const (
@@ -24,19 +26,67 @@ const (
 	SyncOnPut ConfigOpt = 2
 	// SyncOnDemand gives the user the control on whenever to do flush operation.
 	SyncOnDemand ConfigOpt = 3
+	// InMemory keeps the whole datastore in memory with read and write permissions.
+	InMemory ConfigOpt = 4
+
+	// UpdateKeep leaves the key updated by Update unchanged.
+	UpdateKeep UpdateOp = 0
+	// UpdatePut stores the value returned by the Update function.
+	UpdatePut UpdateOp = 1
+	// UpdateDelete removes the key updated by Update.
+	UpdateDelete UpdateOp = 2
+
+	// memDataStorePath is the path of the datastore directory inside the in-memory filesystem.
+	memDataStorePath = "bitcask"
 )
 
-// errRequireWrite happens whenever a user with ReadOnly permission tries to do a writing operation.
-var errRequireWrite = errors.New("require write permission")
+var (
+	// errRequireWrite happens whenever a user with ReadOnly permission tries to do a writing operation.
+	errRequireWrite = errors.New("require write permission")
+
+	// errDirNotEmpty happens whenever the datastore is dumped into a directory that has files in it.
+	errDirNotEmpty = errors.New("directory is not empty")
+
+	// errReservedValue happens whenever a user stores one of the values reserved to mark the data file records.
+	errReservedValue = errors.New("value is reserved by the datastore")
+)
 
 type (
-	// ConfigOpt represents the config options the user can have.
+	// ConfigOpt represents the access permission and sync config options the user can have.
 	ConfigOpt int
 
+	// Option represents any option accepted by OpenWith,
+	// the ConfigOpt options and the ones returned by WithFS, WithCompression and WithEncryption.
+	Option interface {
+		apply(*options)
+	}
+
+	// UpdateOp represents the write done by Update after its function returns.
+	UpdateOp int
+
+	// fsOpt is the config option that sets the filesystem of the datastore.
+	fsOpt struct {
+		fsys vfs.FS
+	}
+
+	// compressionOpt is the config option that sets the compression of the written values.
+	compressionOpt struct {
+		codec     codec.Codec
+		threshold int
+	}
+
+	// encryptionOpt is the config option that sets the encryption of the datastore records.
+	encryptionOpt struct {
+		keys encrypt.KeyProvider
+	}
+
 	// options groups the config options passed to Open.
 	options struct {
 		syncOption       ConfigOpt
 		accessPermission ConfigOpt
+		inMemory         bool
+		fsys             vfs.FS
+		encoding         recfmt.Encoding
 	}
 
 	// Bitcask represents the bitcask object.
@@ -46,24 +96,72 @@ type (
 	Bitcask struct {
 		keyDir     keydir.KeyDir
 		usrOpts    options
-		accessMu   sync.Mutex
-		readerCnt  int32
+		accessMu   sync.RWMutex
+		filesMu    sync.RWMutex
+		compactMu  sync.Mutex
+		metrics    metrics
+		watchers   watchers
+		lastTstamp int64
+		lastDelete int64
+		following  atomic.Bool
 		dataStore  *datastore.DataStore
 		activeFile *datastore.AppendFile
 		fileFlags  int
 	}
 )
 
+// WithFS makes the bitcask use the given filesystem for all of its file operations.
+// The datastore uses the OS filesystem if it is not given.
+func WithFS(fsys vfs.FS) Option {
+	return fsOpt{fsys: fsys}
+}
+
+// WithCompression makes the bitcask compress the written values with the given codec
+// whenever the value size is at least threshold bytes.
+// The codec id is recorded in every record, so records written with other registered codecs remain readable.
+// Values are stored raw if the option is not given.
+func WithCompression(c codec.Codec, threshold int) Option {
+	return compressionOpt{codec: c, threshold: threshold}
+}
+
+// WithEncryption makes the bitcask encrypt the keys and values of all the written
+// data, hint and keydir records with AES-GCM using the keys of the given key provider.
+// Records are encrypted with the current key of the provider and decrypted with the key they were encrypted with,
+// so keys can be rotated by changing the current key, Merge then re-encrypts the old records with it.
+// Old keys must remain available until the records encrypted with them are merged.
+func WithEncryption(keys encrypt.KeyProvider) Option {
+	return encryptionOpt{keys: keys}
+}
+
 // Open creates a new bitcask object to manipulate the given datastore path.
-// It can take options ReadWrite, ReadOnly, SyncOnPut and SyncOnDemand as config options.
+// It can take options ReadWrite, ReadOnly, SyncOnPut, SyncOnDemand and InMemory as config options,
+// use OpenWith to pass the WithFS, WithCompression and WithEncryption options too.
 // Only one ReadWrite process can open a bitcask at a time.
 // Only ReadWrite permission can create a new bitcask datastore.
 // Multiple Readers or a single writer is allowed to be in the same datastore in the same time.
 // If there is no bitcask datastore in the given path a new datastore is created when ReadWrite permission is given.
+// With InMemory the datastore is kept in memory, it starts empty if the given path is empty
+// or is restored from the datastore in the given path otherwise, the given path is never modified.
+// The data files written in the legacy format without a file header are migrated to the current format
+// when the datastore is opened with write permission, a datastore with legacy files cannot be opened with ReadOnly.
 func Open(dataStorePath string, opts ...ConfigOpt) (*Bitcask, error) {
+	return OpenWith(dataStorePath, toOptions(opts)...)
+}
+
+// OpenWith creates a new bitcask object to manipulate the given datastore path like Open.
+// It can take the ConfigOpt options along with WithFS, WithCompression and WithEncryption.
+func OpenWith(dataStorePath string, opts ...Option) (*Bitcask, error) {
 	b := &Bitcask{}
 	b.usrOpts = parseUsrOpts(opts)
 
+	if b.usrOpts.inMemory {
+		memPath, err := b.prepareInMemory(dataStorePath)
+		if err != nil {
+			return nil, err
+		}
+		dataStorePath = memPath
+	}
+
 	var privacy keydir.KeyDirPrivacy
 	var lockMode datastore.LockMode
 
@@ -75,24 +173,35 @@ func Open(dataStorePath string, opts ...ConfigOpt) (*Bitcask, error) {
 			fileFlags |= os.O_SYNC
 		}
 		b.fileFlags = fileFlags
-		b.activeFile = datastore.NewAppendFile(dataStorePath, b.fileFlags, datastore.Active)
+		b.activeFile = datastore.NewAppendFile(b.usrOpts.fsys, dataStorePath, b.fileFlags, datastore.Active, &b.usrOpts.encoding)
 	} else {
 		privacy = keydir.SharedKeyDir
 		lockMode = datastore.SharedLock
 	}
 
-	dataStore, err := datastore.NewDataStore(dataStorePath, lockMode)
+	dataStore, err := datastore.NewDataStore(b.usrOpts.fsys, dataStorePath, lockMode, &b.usrOpts.encoding)
 	if err != nil {
 		return nil, err
 	}
 
-	keyDir, err := keydir.New(dataStorePath, privacy)
+	if b.usrOpts.accessPermission == ReadWrite {
+		_, err = dataStore.Migrate()
+		if err != nil {
+			dataStore.Close()
+			return nil, err
+		}
+	}
+
+	keyDir, lastTstamp, err := keydir.New(b.usrOpts.fsys, dataStorePath, privacy, &b.usrOpts.encoding)
 	if err != nil {
 		return nil, err
 	}
 
 	b.dataStore = dataStore
 	b.keyDir = keyDir
+	b.lastTstamp = lastTstamp
+	b.lastDelete = lastTstamp
+	b.metrics.resetKeyDir(keyDir)
 
 	return b, nil
 }
@@ -100,114 +209,82 @@ func Open(dataStorePath string, opts ...ConfigOpt) (*Bitcask, error) {
 // Get retrieves the value by key from a bitcask datastore.
 // Return an error if key does not exist in the bitcask datastore.
 func (b *Bitcask) Get(key string) (string, error) {
-	var value string
-	var err error
+	start := time.Now()
 
-	if b.readerCnt == 0 {
-		b.accessMu.Lock()
-	}
-	atomic.AddInt32(&b.readerCnt, 1)
+	b.accessMu.RLock()
+	value, err := b.get(key)
+	b.accessMu.RUnlock()
 
-	rec, isExist := b.keyDir[key]
-	if !isExist {
-		value = ""
-		err = fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
-	} else {
-		value, err = b.dataStore.ReadValueFromFile(rec.FileId, key, rec.ValuePos, rec.ValueSize)
-	}
-
-	atomic.AddInt32(&b.readerCnt, -1)
-	if b.readerCnt == 0 {
-		b.accessMu.Unlock()
-	}
+	b.metrics.observe(opGet, start, err)
 
 	return value, err
 }
 
-// Put stores a value by key in a bitcask datastore.
-// Return an error on any system failure when writing the data.
+// Put stores a value by key in a bitcask datastore and notifies the watchers of the key.
+// Return an error if the value is reserved by the datastore or on any system failure when writing the data.
 func (b *Bitcask) Put(key, value string) error {
-	if b.usrOpts.accessPermission == ReadOnly {
-		return fmt.Errorf("Put: %s", errRequireWrite)
-	}
-
-	tstamp := time.Now().UnixMicro()
-
-	b.accessMu.Lock()
-	defer b.accessMu.Unlock()
+	start := time.Now()
+	err := b.put(key, value)
+	b.metrics.observe(opPut, start, err)
 
-	n, err := b.activeFile.WriteData(key, value, tstamp)
-	if err != nil {
-		return err
-	}
-
-	b.keyDir[key] = recfmt.KeyDirRec{
-		FileId:    b.activeFile.Name(),
-		ValuePos:  uint32(n),
-		ValueSize: uint32(len(value)),
-		Tstamp:    tstamp,
-	}
-
-	return nil
+	return err
 }
 
 // Delete removes a key from a bitcask datastore
 // by appending a special TompStone value that will be deleted in the next merge.
+// The key is removed from the keydir, so it is not listed by ListKeys and Fold anymore, and its watchers are notified.
 // Return an error if key does not exist in the bitcask datastore.
 func (b *Bitcask) Delete(key string) error {
-	if b.usrOpts.accessPermission == ReadOnly {
-		return fmt.Errorf("Delete: %s", errRequireWrite)
-	}
+	start := time.Now()
+	err := b.delete(key)
+	b.metrics.observe(opDelete, start, err)
 
-	_, err := b.Get(key)
-	if err != nil {
-		return err
-	}
+	return err
+}
 
-	b.Put(key, datastore.TompStone)
+// Update atomically reads and rewrites the value of a key.
+// fn receives the current value of the key and whether it exists, and returns the new value
+// and whether to store it, remove the key or leave it unchanged.
+// No other write is done between reading the value and writing the result of fn, so fn must not use the bitcask.
+// Return an error if ReadWrite permission is not set, if the written value is reserved by the datastore
+// or on any system failure when writing the data.
+func (b *Bitcask) Update(key string, fn func(value string, exists bool) (string, UpdateOp)) error {
+	start := time.Now()
+	op, err := b.update(key, fn)
+	switch op {
+	case UpdatePut:
+		b.metrics.observe(opPut, start, err)
+	case UpdateDelete:
+		b.metrics.observe(opDelete, start, err)
+	}
 
-	return nil
+	return err
 }
 
 // ListKeys list all keys in a bitcask datastore.
 func (b *Bitcask) ListKeys() []string {
-	res := make([]string, 0)
-
-	if b.readerCnt == 0 {
-		b.accessMu.Lock()
-	}
-	atomic.AddInt32(&b.readerCnt, 1)
+	b.accessMu.RLock()
+	defer b.accessMu.RUnlock()
 
+	res := make([]string, 0, len(b.keyDir))
 	for key := range b.keyDir {
 		res = append(res, key)
 	}
 
-	atomic.AddInt32(&b.readerCnt, -1)
-	if b.readerCnt == 0 {
-		b.accessMu.Unlock()
-	}
-
 	return res
 }
 
 // Fold folds over all key/value pairs in a bitcask datastore.
 // fun is expected to be in the form: F(K, V, Acc) -> Acc
 func (b *Bitcask) Fold(fn func(string, string, any) any, acc any) any {
-	if b.readerCnt == 0 {
-		b.accessMu.Lock()
-	}
-	atomic.AddInt32(&b.readerCnt, 1)
+	b.accessMu.RLock()
+	defer b.accessMu.RUnlock()
 
 	for key := range b.keyDir {
-		value, _ := b.Get(key)
+		value, _ := b.get(key)
 		acc = fn(key, value, acc)
 	}
 
-	atomic.AddInt32(&b.readerCnt, -1)
-	if b.readerCnt == 0 {
-		b.accessMu.Unlock()
-	}
-
 	return acc
 }
 
@@ -215,43 +292,31 @@ func (b *Bitcask) Fold(fn func(string, string, any) any, acc any) any {
 // Delete values with older timestamps.
 // Reduces the disk usage after as it deletes unneeded values.
 // Produces hintfiles to provide a faster startup.
+// Merged records keep their timestamps, so the changes written after the merge can still be resumed by ChangesSince.
+// Merged values are rewritten with the configured compression and encryption,
+// so old records get recompressed when the codec changes and re-encrypted when the current key changes.
 // Return an error if ReadWrite permission is not set or on any system failures when writing data.
 func (b *Bitcask) Merge() error {
 	if b.usrOpts.accessPermission == ReadOnly {
 		return fmt.Errorf("Merge: %s", errRequireWrite)
 	}
 
-	oldFiles, err := b.listOldFiles()
+	start := time.Now()
+	before, err := b.diskUsage()
 	if err != nil {
 		return err
 	}
 
-	b.accessMu.Lock()
-	newKeyDir := keydir.KeyDir{}
-	mergeFile := datastore.NewAppendFile(b.dataStore.Path(), b.fileFlags, datastore.Merge)
-	defer mergeFile.Close()
-
-	for key, rec := range b.keyDir {
-		if rec.FileId != b.activeFile.Name() {
-			newRec, err := b.mergeWrite(mergeFile, key)
-			if err != nil {
-				if !strings.HasSuffix(err.Error(), datastore.ErrKeyNotExist.Error()) {
-					b.accessMu.Unlock()
-					return err
-				}
-			} else {
-				newKeyDir[key] = newRec
-			}
-		} else {
-			newKeyDir[key] = rec
-		}
-	}
+	mergedFiles, err := b.merge()
 
-	b.keyDir = newKeyDir
-	b.accessMu.Unlock()
-	b.deleteOldFiles(oldFiles)
+	after, usageErr := b.diskUsage()
+	if err == nil {
+		err = usageErr
+	}
+	b.metrics.observe(opMerge, start, err)
+	b.metrics.setLastMerge(start, mergedFiles, before.DataBytes+before.HintBytes-after.DataBytes-after.HintBytes, err)
 
-	return nil
+	return err
 }
 
 // Sync flushes all data to the disk.
@@ -261,12 +326,45 @@ func (b *Bitcask) Sync() error {
 		return fmt.Errorf("Sync: %s", errRequireWrite)
 	}
 
-	return b.activeFile.Sync()
+	start := time.Now()
+	err := b.activeFile.Sync()
+	b.metrics.observe(opSync, start, err)
+
+	return err
+}
+
+// Dump copies the data and hint files of the bitcask datastore into the given directory
+// on the OS filesystem, creating the directory if it does not exist.
+// The dumped directory can be opened later as a regular datastore or restored with InMemory.
+// Writes can continue while the datastore is being dumped, only the data written before Dump is called is copied.
+// The copied files, the directory and its parent directory are flushed to the disk.
+// Return an error if the directory has files in it or on any system failures.
+func (b *Bitcask) Dump(dirPath string) error {
+	files, release, err := b.snapshot()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = prepareDir(vfs.OS, dirPath)
+	if err != nil {
+		return fmt.Errorf("Dump: %s", err)
+	}
+
+	for _, file := range files {
+		_, err := b.backupFile(file, vfs.OS, dirPath, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return vfs.OS.SyncDir(dirPath)
 }
 
-// Close flushes all data to the disk and closes the bitcask datastore.
+// Close flushes all data to the disk, closes the channels returned by Watch and closes the bitcask datastore.
 // After close the bitcask object cannot be used anymore.
 func (b *Bitcask) Close() {
+	b.watchers.closeAll()
 	if b.usrOpts.accessPermission == ReadWrite {
 		b.Sync()
 		b.activeFile.Close()
@@ -1,45 +1,155 @@
 package bitcask
 
 import (
+	"fmt"
 	"os"
 	"path"
-	"time"
+	"strings"
 
 	"github.com/IslamWalid/bitcask/internal/datastore"
+	"github.com/IslamWalid/bitcask/internal/keydir"
 	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/internal/sio"
+	"github.com/IslamWalid/bitcask/pkg/encrypt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
 )
 
+// toOptions converts the given config options to the options accepted by OpenWith.
+func toOptions(opts []ConfigOpt) []Option {
+	res := make([]Option, 0, len(opts))
+	for _, opt := range opts {
+		res = append(res, opt)
+	}
+
+	return res
+}
+
 // parseUsrOpts fills an options struct with the passed user options.
-func parseUsrOpts(opts []ConfigOpt) options {
+func parseUsrOpts(opts []Option) options {
 	usrOpts := options{
 		syncOption:       SyncOnDemand,
 		accessPermission: ReadOnly,
+		fsys:             vfs.OS,
 	}
 
 	for _, opt := range opts {
-		switch opt {
-		case SyncOnPut:
-			usrOpts.syncOption = SyncOnPut
-		case ReadWrite:
-			usrOpts.accessPermission = ReadWrite
-		}
+		opt.apply(&usrOpts)
 	}
 
 	return usrOpts
 }
 
-// listOldFiles prepares a list with all old files to be deleted after merge.
-func (b *Bitcask) listOldFiles() ([]string, error) {
-	res := make([]string, 0)
+// apply sets the access permission or the sync option of the given options.
+func (c ConfigOpt) apply(usrOpts *options) {
+	switch c {
+	case SyncOnPut:
+		usrOpts.syncOption = SyncOnPut
+	case ReadWrite:
+		usrOpts.accessPermission = ReadWrite
+	case InMemory:
+		usrOpts.accessPermission = ReadWrite
+		usrOpts.inMemory = true
+	}
+}
+
+// apply sets the filesystem of the given options.
+func (f fsOpt) apply(usrOpts *options) {
+	usrOpts.fsys = f.fsys
+}
+
+// apply sets the values compression of the given options.
+func (c compressionOpt) apply(usrOpts *options) {
+	usrOpts.encoding.Codec = c.codec
+	usrOpts.encoding.Threshold = c.threshold
+}
+
+// apply sets the records encryption of the given options.
+func (e encryptionOpt) apply(usrOpts *options) {
+	usrOpts.encoding.Sealer = encrypt.NewAESGCM(e.keys)
+}
 
-	dataStore, err := os.Open(b.dataStore.Path())
+// prepareInMemory replaces the filesystem of the bitcask with a new in-memory filesystem
+// and restores the datastore in the given path into it if the path is not empty.
+// Return the path of the datastore inside the in-memory filesystem.
+// Return an error on any system failures when reading the restored datastore.
+func (b *Bitcask) prepareInMemory(dataStorePath string) (string, error) {
+	mem := vfs.NewMem()
+	err := mem.MkdirAll(memDataStorePath, os.FileMode(0777))
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer dataStore.Close()
+
+	if dataStorePath != "" {
+		src, err := datastore.NewDataStore(b.usrOpts.fsys, dataStorePath, datastore.SharedLock, nil)
+		if err != nil {
+			return "", err
+		}
+		defer src.Close()
+
+		err = copyDataStoreFiles(mem, memDataStorePath, src.FS(), src.Path())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	b.usrOpts.fsys = mem
+
+	return memDataStorePath, nil
+}
+
+// copyDataStoreFiles copies the data and hint files from a datastore directory to another one.
+// The copied files and the destination directory are flushed to the disk.
+// Return an error on any system failures.
+func copyDataStoreFiles(dst vfs.FS, dstPath string, src vfs.FS, srcPath string) error {
+	entries, err := src.ReadDir(srcPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".data") && !strings.HasSuffix(name, ".hint") {
+			continue
+		}
+
+		data, err := src.ReadFile(path.Join(srcPath, name))
+		if err != nil {
+			return err
+		}
+
+		err = writeFileSync(dst, path.Join(dstPath, name), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return dst.SyncDir(dstPath)
+}
+
+// writeFileSync writes the given data to a new file and flushes it to the disk.
+// Return an error on any system failures.
+func writeFileSync(fsys vfs.FS, name string, data []byte) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	file, err := sio.OpenFile(fsys, name, flags, os.FileMode(0666))
+	if err != nil {
+		return err
+	}
+	defer file.File.Close()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return file.File.Sync()
+}
+
+// listOldFiles prepares a list with all old files to be deleted after merge.
+func (b *Bitcask) listOldFiles() ([]string, error) {
+	res := make([]string, 0)
 
 	b.accessMu.Lock()
-	files, err := dataStore.Readdir(0)
+	files, err := b.dataStore.FS().ReadDir(b.dataStore.Path())
 	b.accessMu.Unlock()
 	if err != nil {
 		return nil, err
@@ -47,7 +157,7 @@ func (b *Bitcask) listOldFiles() ([]string, error) {
 
 	for _, file := range files {
 		fileName := file.Name()
-		if fileName[0] != '.' && fileName != b.activeFile.Name() && fileName != "keydir" {
+		if fileName[0] != '.' && fileName != b.activeFile.Name() && fileName != keydir.FileName {
 			res = append(res, fileName)
 		}
 	}
@@ -56,30 +166,22 @@ func (b *Bitcask) listOldFiles() ([]string, error) {
 }
 
 // mergeWrite performs a writing to the created merge file.
+// The record keeps its timestamp, so the merged records keep their sequence numbers.
 // returns the new record about the written data
 // returns error if the data is deleted and will not be written again or on any system failures.
 func (b *Bitcask) mergeWrite(mergeFile *datastore.AppendFile, key string) (recfmt.KeyDirRec, error) {
 	rec := b.keyDir[key]
 
-	value, err := b.dataStore.ReadValueFromFile(rec.FileId, key, rec.ValuePos, rec.ValueSize)
+	value, err := b.dataStore.ReadValueFromFile(rec)
 	if err != nil {
 		return recfmt.KeyDirRec{}, err
 	}
 
-	tstamp := time.Now().UnixMicro()
-
-	n, err := mergeFile.WriteData(key, value, tstamp)
+	newRec, err := mergeFile.WriteData(key, value, rec.Tstamp)
 	if err != nil {
 		return recfmt.KeyDirRec{}, err
 	}
 
-	newRec := recfmt.KeyDirRec{
-		FileId:    mergeFile.Name(),
-		ValuePos:  uint32(n),
-		ValueSize: uint32(len(value)),
-		Tstamp:    tstamp,
-	}
-
 	err = mergeFile.WriteHint(key, newRec)
 	if err != nil {
 		return recfmt.KeyDirRec{}, err
@@ -90,12 +192,211 @@ func (b *Bitcask) mergeWrite(mergeFile *datastore.AppendFile, key string) (recfm
 
 // deleteOldFiles deletes all files passed to it.
 func (b *Bitcask) deleteOldFiles(files []string) error {
-	for _, file := range files {
-		err := os.Remove(path.Join(b.dataStore.Path(), file))
+	return b.dataStore.RemoveFiles(files)
+}
+
+// get retrieves the value by key, the caller must hold the access lock.
+// Return an error if key does not exist in the bitcask datastore.
+func (b *Bitcask) get(key string) (string, error) {
+	rec, isExist := b.keyDir[key]
+	if !isExist {
+		return "", fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+	}
+
+	return b.dataStore.ReadValueFromFile(rec)
+}
+
+// put appends the key and value to the active file and points the keydir at the written record.
+// Return an error if ReadWrite permission is not set, if the datastore is a replication follower,
+// if the value is reserved by the datastore or on any system failure when writing the data.
+func (b *Bitcask) put(key, value string) error {
+	if b.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("Put: %s", errRequireWrite)
+	}
+	if b.following.Load() {
+		return fmt.Errorf("Put: %s", errFollower)
+	}
+	if datastore.IsReserved(value) {
+		return fmt.Errorf("Put: %s", errReservedValue)
+	}
+
+	b.accessMu.Lock()
+	defer b.accessMu.Unlock()
+
+	return b.writePut(key, value, b.nextTstamp())
+}
+
+// delete appends a TompStone value for the key to the active file and removes the key from the keydir.
+// Return an error if ReadWrite permission is not set, if the datastore is a replication follower,
+// if key does not exist or on any system failure when writing the data.
+func (b *Bitcask) delete(key string) error {
+	if b.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("Delete: %s", errRequireWrite)
+	}
+	if b.following.Load() {
+		return fmt.Errorf("Delete: %s", errFollower)
+	}
+
+	b.accessMu.Lock()
+	defer b.accessMu.Unlock()
+
+	if _, isExist := b.keyDir[key]; !isExist {
+		return fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+	}
+
+	return b.writeDelete(key, b.nextTstamp())
+}
+
+// update calls fn with the current value of the key and writes its result under the access lock.
+// Return the done write, or UpdateKeep if nothing was written.
+// Return an error if ReadWrite permission is not set, if the datastore is a replication follower,
+// if the value cannot be read, if the written value is reserved by the datastore
+// or on any system failure when writing the data.
+func (b *Bitcask) update(key string, fn func(string, bool) (string, UpdateOp)) (UpdateOp, error) {
+	if b.usrOpts.accessPermission == ReadOnly {
+		return UpdateKeep, fmt.Errorf("Update: %s", errRequireWrite)
+	}
+	if b.following.Load() {
+		return UpdateKeep, fmt.Errorf("Update: %s", errFollower)
+	}
+
+	b.accessMu.Lock()
+	defer b.accessMu.Unlock()
+
+	value, exists := "", false
+	if _, isExist := b.keyDir[key]; isExist {
+		var err error
+		value, err = b.get(key)
 		if err != nil {
-			return err
+			return UpdateKeep, err
 		}
+		exists = true
+	}
+
+	value, op := fn(value, exists)
+	switch {
+	case op == UpdatePut && datastore.IsReserved(value):
+		return UpdateKeep, fmt.Errorf("Update: %s", errReservedValue)
+	case op == UpdatePut:
+		return op, b.writePut(key, value, b.nextTstamp())
+	case op == UpdateDelete && exists:
+		return op, b.writeDelete(key, b.nextTstamp())
+	}
+
+	return UpdateKeep, nil
+}
+
+// writePut appends the key and value with the given timestamp to the active file,
+// points the keydir at the written record and notifies the watchers, the caller must hold the access lock.
+// Return an error on any system failure when writing the data.
+func (b *Bitcask) writePut(key, value string, tstamp int64) error {
+	rec, err := b.activeFile.WriteData(key, value, tstamp)
+	if err != nil {
+		return err
+	}
+	b.indexPut(key, value, rec, 0)
+
+	return nil
+}
+
+// writeDelete appends a TompStone value with the given timestamp for the existing key to the active file,
+// removes the key from the keydir and notifies the watchers, the caller must hold the access lock.
+// Return an error on any system failure when writing the data.
+func (b *Bitcask) writeDelete(key string, tstamp int64) error {
+	_, err := b.activeFile.WriteData(key, datastore.TompStone, tstamp)
+	if err != nil {
+		return err
 	}
+	b.indexDelete(key, tstamp, 0)
 
 	return nil
 }
+
+// indexPut points the keydir at the written record of the key and notifies the watchers
+// with the number of the changes of its batch written after it, the caller must hold the access lock.
+func (b *Bitcask) indexPut(key, value string, rec recfmt.KeyDirRec, batch int) {
+	if old, isExist := b.keyDir[key]; isExist {
+		b.metrics.removeRec(key, old)
+	}
+	b.keyDir[key] = rec
+	b.metrics.addRec(key, rec)
+	b.watchers.publish(Event{Seq: rec.Tstamp, Type: EventPut, Key: key, Value: value, Batch: batch})
+}
+
+// indexDelete removes the existing key from the keydir and notifies the watchers
+// with the number of the changes of its batch written after it, the caller must hold the access lock.
+func (b *Bitcask) indexDelete(key string, tstamp int64, batch int) {
+	b.metrics.removeRec(key, b.keyDir[key])
+	delete(b.keyDir, key)
+	b.lastDelete = tstamp
+	b.watchers.publish(Event{Seq: tstamp, Type: EventDelete, Key: key, Batch: batch})
+}
+
+// merge rewrites the live values of the old files into merge files then deletes the old files.
+// The active file is created before listing the old files, so the changes written after the merge
+// start at its beginning, and a merge file is always created, so its hint file marks that a merge happened.
+// Return the number of deleted old files.
+// Return an error on any system failures when writing data.
+func (b *Bitcask) merge() (int, error) {
+	b.compactMu.Lock()
+	defer b.compactMu.Unlock()
+
+	b.accessMu.Lock()
+	err := b.activeFile.Create()
+	b.accessMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	oldFiles, err := b.listOldFiles()
+	if err != nil {
+		return 0, err
+	}
+	if len(oldFiles) == 0 {
+		return 0, nil
+	}
+
+	b.accessMu.Lock()
+	newKeyDir := keydir.KeyDir{}
+	mergeFile := datastore.NewAppendFile(b.dataStore.FS(), b.dataStore.Path(), b.fileFlags, datastore.Merge, &b.usrOpts.encoding)
+	defer func() {
+		mergeFile.Close()
+		b.metrics.syncs.Add(mergeFile.Syncs())
+	}()
+
+	err = mergeFile.Create()
+	if err != nil {
+		b.accessMu.Unlock()
+		return 0, err
+	}
+
+	for key, rec := range b.keyDir {
+		if rec.FileId != b.activeFile.Name() {
+			newRec, err := b.mergeWrite(mergeFile, key)
+			if err != nil {
+				if !strings.HasSuffix(err.Error(), datastore.ErrKeyNotExist.Error()) {
+					b.accessMu.Unlock()
+					return 0, err
+				}
+			} else {
+				newKeyDir[key] = newRec
+			}
+		} else {
+			newKeyDir[key] = rec
+		}
+	}
+
+	b.keyDir = newKeyDir
+	b.metrics.resetKeyDir(newKeyDir)
+	b.accessMu.Unlock()
+
+	err = mergeFile.Sync()
+	if err != nil {
+		return 0, err
+	}
+
+	b.filesMu.Lock()
+	defer b.filesMu.Unlock()
+
+	return len(oldFiles), b.deleteOldFiles(oldFiles)
+}
@@ -1,64 +1,92 @@
 package bitcask
 
 import (
+	"encoding/binary"
+	"flag"
 	"fmt"
+	"hash/crc32"
 	"os"
 	"path"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/IslamWalid/bitcask/internal/datastore"
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/codec"
+	"github.com/IslamWalid/bitcask/pkg/encrypt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
 )
 
-var testBitcaskPath = path.Join("testing_dir")
+var (
+	testBitcaskPath = path.Join("testing_dir")
+
+	// memFS makes the tests run on an in-memory filesystem instead of the OS filesystem.
+	memFS = flag.Bool("memfs", false, "run the tests on an in-memory filesystem")
+
+	// testFS is the filesystem used by the tests.
+	testFS vfs.FS = vfs.OS
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if *memFS {
+		testFS = vfs.NewMem()
+	}
+
+	os.Exit(m.Run())
+}
 
 func TestOpen(t *testing.T) {
 	t.Run("open new bitcask with read and write permission", func(t *testing.T) {
-		Open(testBitcaskPath, ReadWrite)
+		OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 
-		if _, err := os.Stat(testBitcaskPath); os.IsNotExist(err) {
+		if _, err := testFS.Stat(testBitcaskPath); os.IsNotExist(err) {
 			t.Errorf("Expected to find directory: %q", testBitcaskPath)
 		}
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("open new bitcask with sync_on_put option", func(t *testing.T) {
-		Open(testBitcaskPath, ReadWrite, SyncOnPut)
+		OpenWith(testBitcaskPath, ReadWrite, SyncOnPut, WithFS(testFS))
 
-		if _, err := os.Stat(testBitcaskPath); os.IsNotExist(err) {
+		if _, err := testFS.Stat(testBitcaskPath); os.IsNotExist(err) {
 			t.Errorf("Expected to find directory: %q", testBitcaskPath)
 		}
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("open new bitcask with default options", func(t *testing.T) {
-		_, err := Open(testBitcaskPath)
+		_, err := OpenWith(testBitcaskPath, WithFS(testFS))
 		assertError(t, err, "open testing_dir: no such file or directory")
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("open existing bitcask with write permission", func(t *testing.T) {
-		b1, _ := Open(testBitcaskPath, ReadWrite)
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 		b1.Put("key12", "value12345")
 		b1.Close()
 
-		b2, _ := Open(testBitcaskPath, ReadWrite)
+		b2, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 
 		want := "value12345"
 		got, _ := b2.Get("key12")
 		b2.Close()
 
 		assertString(t, got, want)
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("two readers in the same bitcask at the same time", func(t *testing.T) {
-		b1, _ := Open(testBitcaskPath, ReadWrite)
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 		b1.Put("key2", "value2")
 		b1.Put("key3", "value3")
 		b1.Close()
 
-		b2, _ := Open(testBitcaskPath)
-		b3, _ := Open(testBitcaskPath)
+		b2, _ := OpenWith(testBitcaskPath, WithFS(testFS))
+		b3, _ := OpenWith(testBitcaskPath, WithFS(testFS))
 
 		want := "value2"
 		got, _ := b2.Get("key2")
@@ -68,11 +96,11 @@ func TestOpen(t *testing.T) {
 		got, _ = b3.Get("key2")
 		assertString(t, got, want)
 		b3.Close()
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("open existing bitcask with hint files in it", func(t *testing.T) {
-		b1, _ := Open(testBitcaskPath, ReadWrite)
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 
 		for i := 0; i < 1000; i++ {
 			key := fmt.Sprintf("key%d", i+1)
@@ -82,23 +110,46 @@ func TestOpen(t *testing.T) {
 		b1.Merge()
 		b1.Close()
 
-		b2, _ := Open(testBitcaskPath)
+		b2, _ := OpenWith(testBitcaskPath, WithFS(testFS))
 		got, _ := b2.Get("key50")
 		want := "value50"
 
 		assertString(t, got, want)
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
+	})
+
+	t.Run("open bitcask with a slice of config options", func(t *testing.T) {
+		if *memFS {
+			t.Skip("Open always uses the OS filesystem")
+		}
+
+		opts := []ConfigOpt{ConfigOpt(1), SyncOnPut}
+		b, err := Open(testBitcaskPath, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.Put("key", "value")
+		b.Close()
+
+		if err != nil {
+			t.Errorf("Expected no error, got %q", err)
+		}
+		removeTestDir()
 	})
 
 	t.Run("open bitcask with writer exists in it", func(t *testing.T) {
-		Open(testBitcaskPath, ReadWrite)
-		_, err := Open(testBitcaskPath)
+		OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
+		_, err := OpenWith(testBitcaskPath, WithFS(testFS))
 
 		assertError(t, err, "access denied: datastore is locked")
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("open bitcask failed", func(t *testing.T) {
+		if *memFS {
+			t.Skip("permissions are not supported by the in-memory filesystem")
+		}
+
 		// create a directory that cannot be openned since it has no execute permission
 		os.MkdirAll(path.Join("no open dir"), 000)
 
@@ -112,7 +163,7 @@ func TestOpen(t *testing.T) {
 
 func TestGet(t *testing.T) {
 	t.Run("get existing value", func(t *testing.T) {
-		b, _ := Open(testBitcaskPath, ReadWrite, SyncOnPut)
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, SyncOnPut, WithFS(testFS))
 		b.Put("key12", "value12345")
 
 		got, _ := b.Get("key12")
@@ -120,87 +171,246 @@ func TestGet(t *testing.T) {
 
 		assertString(t, got, want)
 		b.Close()
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("get not existing value", func(t *testing.T) {
-		b, _ := Open(testBitcaskPath, ReadWrite)
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 
 		want := "unknown key: key does not exist"
 		_, err := b.Get("unknown key")
 
 		assertError(t, err, want)
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 }
 
 func TestPut(t *testing.T) {
 	t.Run("put values with writer permission", func(t *testing.T) {
-		b, _ := Open(testBitcaskPath, ReadWrite)
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 		b.Put("key12", "value12345")
 
 		want := "value12345"
 		got, _ := b.Get("key12")
 
 		assertString(t, got, want)
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("put with no write permission", func(t *testing.T) {
-		b1, _ := Open(testBitcaskPath, ReadWrite)
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 		b1.Close()
 
-		b2, _ := Open(testBitcaskPath)
+		b2, _ := OpenWith(testBitcaskPath, WithFS(testFS))
 		err := b2.Put("key12", "value12345")
 
 		assertError(t, err, "Put: require write permission")
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
+	})
+
+	t.Run("put reserved values", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+
+		for _, value := range []string{datastore.TompStone, datastore.BatchMark} {
+			err := b.Put("3", value)
+			assertError(t, err, "Put: value is reserved by the datastore")
+
+			err = b.Update("3", func(string, bool) (string, UpdateOp) { return value, UpdatePut })
+			assertError(t, err, "Update: value is reserved by the datastore")
+
+			tx := b.Begin()
+			err = tx.Put("3", value)
+			assertError(t, err, "Put: value is reserved by the datastore")
+			tx.Discard()
+		}
+
+		if keys := b.ListKeys(); len(keys) != 0 {
+			t.Errorf("got keys %v after putting reserved values, want none", keys)
+		}
 	})
 }
 
 func TestDelete(t *testing.T) {
 	t.Run("delete existing key", func(t *testing.T) {
-		b, _ := Open(testBitcaskPath, ReadWrite, SyncOnPut)
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, SyncOnPut, WithFS(testFS))
 		b.Put("key12", "value12345")
 		b.Delete("key12")
 		_, err := b.Get("key12")
 		assertError(t, err, "key12: key does not exist")
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("delete not existing key", func(t *testing.T) {
-		b, _ := Open(testBitcaskPath, ReadWrite, SyncOnDemand)
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, SyncOnDemand, WithFS(testFS))
 		err := b.Delete("key12")
 		assertError(t, err, "key12: key does not exist")
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("delete with no write permission", func(t *testing.T) {
-		b1, _ := Open(testBitcaskPath, ReadWrite)
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 		b1.Close()
 
-		b2, _ := Open(testBitcaskPath)
+		b2, _ := OpenWith(testBitcaskPath, WithFS(testFS))
 		err := b2.Delete("key12")
 		assertError(t, err, "Delete: require write permission")
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("check if loaded delete is detected", func(t *testing.T) {
-		b1, _ := Open(testBitcaskPath, ReadWrite, SyncOnPut)
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, SyncOnPut, WithFS(testFS))
 		b1.Put("key12", "value12345")
 		b1.Delete("key12")
 		b1.Close()
 
-		b2, _ := Open(testBitcaskPath, ReadWrite, SyncOnPut)
+		b2, _ := OpenWith(testBitcaskPath, ReadWrite, SyncOnPut, WithFS(testFS))
 		_, err := b2.Get("key12")
 		want := "key12: key does not exist"
 		assertError(t, err, want)
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
+	})
+
+	t.Run("deleted keys stay deleted after merge and reopen", func(t *testing.T) {
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
+		for i := 0; i < 1000; i++ {
+			b1.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+		}
+		b1.Merge()
+
+		// the tombstones of key50 and key500 are merged away,
+		// the tombstone of key700 stays in the active file after its value is merged into a hint file.
+		b1.Delete("key50")
+		b1.Delete("key500")
+		b1.Merge()
+		b1.Delete("key700")
+		b1.Close()
+
+		for _, perm := range []ConfigOpt{ReadWrite, ReadOnly} {
+			b2, err := OpenWith(testBitcaskPath, perm, WithFS(testFS))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"key50", "key500", "key700"} {
+				_, err := b2.Get(key)
+				assertError(t, err, key+": key does not exist")
+			}
+			got, _ := b2.Get("key999")
+			assertString(t, got, "value999")
+			assertString(t, strconv.Itoa(len(b2.ListKeys())), "997")
+			b2.Close()
+		}
+		removeTestDir()
+	})
+
+	t.Run("deleted keys are not listed", func(t *testing.T) {
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
+		b1.Put("key12", "value12345")
+		b1.Put("key13", "value12345")
+		b1.Delete("key12")
+		want := []string{"key13"}
+		if got := b1.ListKeys(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got:\n%v\nwant:\n%v", got, want)
+		}
+		b1.Close()
+
+		b2, _ := OpenWith(testBitcaskPath, WithFS(testFS))
+		if got := b2.ListKeys(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got:\n%v\nwant:\n%v", got, want)
+		}
+		b2.Close()
+		removeTestDir()
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	t.Run("update puts, keeps and deletes values", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
+		b.Update("key12", func(value string, exists bool) (string, UpdateOp) {
+			if exists {
+				t.Errorf("got existing value %q for a new key", value)
+			}
+			return "value12", UpdatePut
+		})
+		b.Update("key12", func(value string, exists bool) (string, UpdateOp) {
+			return value + "345", UpdatePut
+		})
+		b.Update("key12", func(value string, exists bool) (string, UpdateOp) {
+			return "ignored", UpdateKeep
+		})
+		got, _ := b.Get("key12")
+		if got != "value12345" {
+			t.Errorf("got value %q, want %q", got, "value12345")
+		}
+
+		b.Update("key12", func(value string, exists bool) (string, UpdateOp) {
+			return "", UpdateDelete
+		})
+		_, err := b.Get("key12")
+		assertError(t, err, "key12: key does not exist")
+		b.Close()
+		removeTestDir()
+	})
+
+	t.Run("update with no write permission", func(t *testing.T) {
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
+		b1.Close()
+
+		b2, _ := OpenWith(testBitcaskPath, WithFS(testFS))
+		err := b2.Update("key12", func(value string, exists bool) (string, UpdateOp) {
+			return "value12", UpdatePut
+		})
+		assertError(t, err, "Update: require write permission")
+		removeTestDir()
 	})
 }
 
+func TestConcurrentAccess(t *testing.T) {
+	b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
+	defer removeTestDir()
+	defer b.Close()
+	for i := 0; i < 100; i++ {
+		b.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+	}
+
+	// readers share the access lock while a writer keeps updating the keys.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for i := 0; i < 100; i++ {
+					value, err := b.Get(fmt.Sprintf("key%d", i))
+					if err != nil || !strings.HasPrefix(value, "value") {
+						t.Errorf("key%d: got %q, %v", i, value, err)
+						return
+					}
+				}
+				if n := len(b.ListKeys()); n != 100 {
+					t.Errorf("got %d keys, want 100", n)
+					return
+				}
+				b.Fold(func(key, value string, acc any) any { return acc }, nil)
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		b.Put(fmt.Sprintf("key%d", i%100), fmt.Sprintf("value%d", i))
+	}
+	close(done)
+	wg.Wait()
+}
+
 func TestListkeys(t *testing.T) {
-	b, _ := Open(testBitcaskPath, ReadWrite, SyncOnDemand)
+	b, _ := OpenWith(testBitcaskPath, ReadWrite, SyncOnDemand, WithFS(testFS))
 
 	key := "key12"
 	value := "value12345"
@@ -212,11 +422,11 @@ func TestListkeys(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("got:\n%v\nwant:\n%v", got, want)
 	}
-	os.RemoveAll(testBitcaskPath)
+	removeTestDir()
 }
 
 func TestFold(t *testing.T) {
-	b, _ := Open(testBitcaskPath, ReadWrite, SyncOnDemand)
+	b, _ := OpenWith(testBitcaskPath, ReadWrite, SyncOnDemand, WithFS(testFS))
 
 	for i := 0; i < 10; i++ {
 		b.Put(fmt.Sprint(i+1), fmt.Sprint(i+1))
@@ -234,12 +444,12 @@ func TestFold(t *testing.T) {
 	if got != want {
 		t.Errorf("got:%d, want:%d", got, want)
 	}
-	os.RemoveAll(testBitcaskPath)
+	removeTestDir()
 }
 
 func TestMerge(t *testing.T) {
 	t.Run("merge with write permission", func(t *testing.T) {
-		b, _ := Open(testBitcaskPath, ReadWrite)
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 
 		for i := 0; i < 10000; i++ {
 			key := fmt.Sprintf("key%d", i+1)
@@ -252,26 +462,86 @@ func TestMerge(t *testing.T) {
 
 		b.Close()
 		assertString(t, got, want)
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("with no write permission", func(t *testing.T) {
-		b1, _ := Open(testBitcaskPath, ReadWrite)
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 		b1.Close()
 
-		b2, _ := Open(testBitcaskPath)
+		b2, _ := OpenWith(testBitcaskPath, WithFS(testFS))
 
 		err := b2.Merge()
 		want := "Merge: require write permission"
 
 		assertError(t, err, want)
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
+	})
+}
+
+func TestLegacyFormat(t *testing.T) {
+	// testdata/legacy was written by the version without file headers: 400 keys were put,
+	// every tenth key was deleted then merged, every third key was updated and key1 was deleted.
+	want := make(map[string]string)
+	for i := 0; i < 400; i++ {
+		key := fmt.Sprintf("key%d", i)
+		switch {
+		case i%3 == 0:
+			want[key] = fmt.Sprintf("updated%d", i)
+		case i%10 != 0 && i != 1:
+			want[key] = fmt.Sprintf("value%d", i)
+		}
+	}
+
+	t.Run("reading a legacy datastore requires a migration", func(t *testing.T) {
+		dir := copyLegacyDataStore(t)
+
+		_, err := OpenWith(dir, WithFS(testFS))
+		if err == nil || !strings.Contains(err.Error(), "open the datastore with write permission to migrate it") {
+			t.Fatalf("got error %v, want a migration error", err)
+		}
+	})
+
+	t.Run("legacy datastore is migrated by a writer", func(t *testing.T) {
+		dir := copyLegacyDataStore(t)
+
+		b, err := OpenWith(dir, ReadWrite, WithFS(testFS))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertKeys(t, b, want)
+		b.Close()
+
+		entries, _ := testFS.ReadDir(dir)
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".hint") || strings.HasSuffix(entry.Name(), ".migrate") {
+				t.Errorf("unexpected file %s left after the migration", entry.Name())
+			}
+		}
+
+		b, err = OpenWith(dir, WithFS(testFS))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertKeys(t, b, want)
+		b.Close()
+
+		b, err = OpenWith(dir, ReadWrite, WithFS(testFS))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		err = b.Merge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertKeys(t, b, want)
 	})
 }
 
 func TestSync(t *testing.T) {
 	t.Run("put with sync on demand option is set", func(t *testing.T) {
-		b, _ := Open(testBitcaskPath, ReadWrite)
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 		b.Put("key12", "value12345")
 		b.Sync()
 
@@ -279,21 +549,321 @@ func TestSync(t *testing.T) {
 		got, _ := b.Get("key12")
 
 		assertString(t, got, want)
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 
 	t.Run("sync with no write permission", func(t *testing.T) {
-		b1, _ := Open(testBitcaskPath, ReadWrite)
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(testFS))
 		b1.Close()
 
-		b2, _ := Open(testBitcaskPath)
+		b2, _ := OpenWith(testBitcaskPath, WithFS(testFS))
 		err := b2.Sync()
 
 		assertError(t, err, "Sync: require write permission")
-		os.RemoveAll(testBitcaskPath)
+		removeTestDir()
 	})
 }
 
+func TestInMemory(t *testing.T) {
+	t.Run("put, get and merge without a directory", func(t *testing.T) {
+		b, err := Open("", InMemory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+
+		for i := 0; i < 1000; i++ {
+			b.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+		}
+		b.Delete("key1")
+
+		err = b.Merge()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, _ := b.Get("key500")
+		assertString(t, got, "value500")
+
+		_, err = b.Get("key1")
+		assertError(t, err, "key1: key does not exist")
+	})
+
+	t.Run("dump and restore a directory", func(t *testing.T) {
+		dumpPath := t.TempDir()
+
+		b1, _ := Open("", InMemory)
+		b1.Put("key12", "value12345")
+		err := b1.Dump(dumpPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b1.Close()
+
+		b2, err := Open(dumpPath, InMemory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b2.Put("key13", "value13")
+		got, _ := b2.Get("key12")
+		assertString(t, got, "value12345")
+		b2.Close()
+
+		b3, _ := Open(dumpPath)
+		_, err = b3.Get("key13")
+		assertError(t, err, "key13: key does not exist")
+		b3.Close()
+	})
+
+	t.Run("dump into a directory with files", func(t *testing.T) {
+		dumpPath := t.TempDir()
+		os.WriteFile(path.Join(dumpPath, "file"), nil, 0666)
+
+		b, _ := Open("", InMemory)
+		err := b.Dump(dumpPath)
+		assertError(t, err, fmt.Sprintf("Dump: %s: directory is not empty", dumpPath))
+		b.Close()
+	})
+}
+
+func TestCompression(t *testing.T) {
+	value := strings.Repeat(`{"name":"bitcask","tags":["kv","log"]}`, 20)
+
+	t.Run("compressed values are readable without the option", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem), WithCompression(codec.Gzip, 64))
+		b1.Put("key12", value)
+		if size := b1.keyDir["key12"].ValueSize; int(size) >= len(value) {
+			t.Errorf("expected stored value to be smaller than %d, got %d", len(value), size)
+		}
+		b1.Close()
+
+		b2, _ := OpenWith(testBitcaskPath, WithFS(mem))
+		got, _ := b2.Get("key12")
+		assertString(t, got, value)
+		b2.Close()
+	})
+
+	t.Run("values below threshold are stored raw", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()), WithCompression(codec.Gzip, 64))
+		b.Put("key12", "value12345")
+		if size := b.keyDir["key12"].ValueSize; size != uint32(len("value12345")) {
+			t.Errorf("expected raw value of size %d, got %d", len("value12345"), size)
+		}
+		b.Close()
+	})
+
+	t.Run("merge recompresses with the new codec", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem), WithCompression(codec.Deflate, 64))
+		for i := 0; i < 100; i++ {
+			b1.Put(fmt.Sprintf("key%d", i+1), value)
+		}
+		b1.Close()
+
+		b2, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem), WithCompression(codec.Gzip, 64))
+		b2.Merge()
+		b2.Close()
+
+		entries, _ := mem.ReadDir(testBitcaskPath)
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".data") {
+				continue
+			}
+			data, _ := mem.ReadFile(path.Join(testBitcaskPath, entry.Name()))
+			for i := recfmt.FileHdr; i < len(data); {
+				rec, n, err := recfmt.ExtractDataFileRec(data[i:], recfmt.FileHeader{}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rec.Codec != codec.GzipID {
+					t.Fatalf("%s: expected codec %d, got %d", rec.Key, codec.GzipID, rec.Codec)
+				}
+				i += int(n)
+			}
+		}
+	})
+}
+
+func TestEncryption(t *testing.T) {
+	key1 := []byte("0123456789abcdef0123456789abcdef")
+	key2 := []byte("fedcba9876543210fedcba9876543210")
+
+	t.Run("keys and values are not stored in plain text", func(t *testing.T) {
+		mem := vfs.NewMem()
+		keys := &encrypt.Keys{Current: 1, ByID: map[uint32][]byte{1: key1}}
+
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem), WithEncryption(keys))
+		for i := 0; i < 100; i++ {
+			b1.Put(fmt.Sprintf("secret-key%d", i+1), fmt.Sprintf("secret-value%d", i+1))
+		}
+		b1.Merge()
+		b1.Close()
+
+		b2, _ := OpenWith(testBitcaskPath, WithFS(mem), WithEncryption(keys))
+		got, _ := b2.Get("secret-key50")
+		assertString(t, got, "secret-value50")
+		b2.Close()
+
+		entries, _ := mem.ReadDir(testBitcaskPath)
+		for _, entry := range entries {
+			data, _ := mem.ReadFile(path.Join(testBitcaskPath, entry.Name()))
+			if strings.Contains(string(data), "secret") {
+				t.Errorf("%s contains plain text data", entry.Name())
+			}
+		}
+	})
+
+	t.Run("open encrypted datastore without keys", func(t *testing.T) {
+		mem := vfs.NewMem()
+		keys := &encrypt.Keys{Current: 1, ByID: map[uint32][]byte{1: key1}}
+
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem), WithEncryption(keys))
+		b1.Put("key12", "value12345")
+		b1.Close()
+
+		_, err := OpenWith(testBitcaskPath, WithFS(mem))
+		assertError(t, err, "record is encrypted but no encryption is configured")
+	})
+
+	t.Run("values swapped between records are rejected", func(t *testing.T) {
+		mem := vfs.NewMem()
+		keys := &encrypt.Keys{Current: 1, ByID: map[uint32][]byte{1: key1}}
+
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem), WithEncryption(keys))
+		b1.Put("key1", "value1")
+		b1.Put("key2", "value2")
+		b1.Close()
+
+		entries, _ := mem.ReadDir(testBitcaskPath)
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".data") {
+				continue
+			}
+			name := path.Join(testBitcaskPath, entry.Name())
+			data, _ := mem.ReadFile(name)
+
+			// swap the sealed values of the two records and fix their checksums,
+			// only the authenticated record headers and keys can detect the swap.
+			values := make([][]byte, 0)
+			for i := recfmt.FileHdr; i < len(data); {
+				keySize := int(binary.LittleEndian.Uint16(data[i+12:]))
+				valueSize := int(binary.LittleEndian.Uint32(data[i+14:]))
+				start := i + recfmt.DataFileRecHdr + keySize
+				values = append(values, data[start:start+valueSize])
+				i = start + valueSize
+			}
+			if len(values) != 2 {
+				t.Fatalf("got %d records, want 2", len(values))
+			}
+			first := append([]byte{}, values[0]...)
+			copy(values[0], values[1])
+			copy(values[1], first)
+			for i := recfmt.FileHdr; i < len(data); {
+				keySize := int(binary.LittleEndian.Uint16(data[i+12:]))
+				valueSize := int(binary.LittleEndian.Uint32(data[i+14:]))
+				end := i + recfmt.DataFileRecHdr + keySize + valueSize
+				binary.LittleEndian.PutUint32(data[i:], crc32.ChecksumIEEE(data[i+4:end]))
+				i = end
+			}
+			writeFileSync(mem, name, data)
+		}
+
+		b2, err := OpenWith(testBitcaskPath, WithFS(mem), WithEncryption(keys))
+		if err != nil {
+			assertError(t, err, "corrution detected: datastore files are corrupted: cipher: message authentication failed")
+			return
+		}
+		defer b2.Close()
+		for _, key := range []string{"key1", "key2"} {
+			if got, err := b2.Get(key); err == nil {
+				t.Errorf("%s: got %q, want an error", key, got)
+			}
+		}
+	})
+
+	t.Run("merge re-encrypts with the rotated key", func(t *testing.T) {
+		mem := vfs.NewMem()
+
+		keys := &encrypt.Keys{Current: 1, ByID: map[uint32][]byte{1: key1}}
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem), WithEncryption(keys))
+		for i := 0; i < 100; i++ {
+			b1.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+		}
+		b1.Close()
+
+		keys = &encrypt.Keys{Current: 2, ByID: map[uint32][]byte{1: key1, 2: key2}}
+		b2, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem), WithEncryption(keys))
+		err := b2.Merge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b2.Close()
+
+		keys = &encrypt.Keys{Current: 2, ByID: map[uint32][]byte{2: key2}}
+		b3, err := OpenWith(testBitcaskPath, WithFS(mem), WithEncryption(keys))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := b3.Get("key100")
+		assertString(t, got, "value100")
+		b3.Close()
+	})
+}
+
+// removeTestDir removes the testing datastore from the filesystem used by the tests.
+// copyLegacyDataStore copies the legacy datastore in testdata/legacy into a new directory of the test filesystem.
+func copyLegacyDataStore(t *testing.T) string {
+	t.Helper()
+
+	dir := path.Join(t.TempDir(), "legacy")
+	err := testFS.MkdirAll(dir, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(path.Join("testdata", "legacy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(path.Join("testdata", "legacy", entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = writeFileSync(testFS, path.Join(dir, entry.Name()), data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// assertKeys asserts that the bitcask holds exactly the wanted keys and values.
+func assertKeys(t *testing.T, b *Bitcask, want map[string]string) {
+	t.Helper()
+
+	if got := len(b.ListKeys()); got != len(want) {
+		t.Errorf("got %d keys, want %d", got, len(want))
+	}
+	for key, value := range want {
+		got, err := b.Get(key)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		assertString(t, got, value)
+	}
+}
+
+func removeTestDir() {
+	if *memFS {
+		testFS = vfs.NewMem()
+		return
+	}
+	os.RemoveAll(testBitcaskPath)
+}
+
 func assertError(t testing.TB, err error, want string) {
 	t.Helper()
 	if err == nil {
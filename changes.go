@@ -0,0 +1,470 @@
+package bitcask
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/IslamWalid/bitcask/internal/datastore"
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+var (
+	// ErrCompacted happens whenever the requested changes were removed from the data files by a merge.
+	ErrCompacted = errors.New("changes were compacted by a merge")
+
+	// ErrIteratorClosed happens whenever an iterator is used after it or its bitcask is closed.
+	ErrIteratorClosed = errors.New("iterator is closed")
+)
+
+const (
+	// iteratorBatch is the maximum number of stored changes an iterator reads ahead from the data files.
+	iteratorBatch = 256
+
+	// iteratorReadBuffer is the size of the buffer an iterator reads the data files through.
+	iteratorReadBuffer = 64 << 10
+)
+
+// Iterator iterates over the changes of a bitcask datastore in write order.
+// It replays the changes stored in the data files then continues with the live writes.
+// The data files are read in batches from the position of the last read record,
+// so a watcher overflow resumes reading from there instead of replaying the files again.
+type Iterator struct {
+	b        *Bitcask
+	seq      int64
+	files    []string
+	offset   int64
+	hdr      recfmt.FileHeader
+	caughtUp bool
+	pending  []Event
+	events   <-chan Event
+	cancel   func()
+	closed   atomic.Bool
+}
+
+// ChangesSince returns an iterator over the changes written after the given sequence number,
+// all the stored changes are replayed if the sequence number is 0.
+// The changes of a transaction are returned one after the other, their Batch counts the changes left
+// until the end of the transaction.
+// Sequence numbers are the timestamps of the data file records, so the Seq of the last change handled
+// by a consumer can be stored and passed to ChangesSince to resume after a restart.
+// Merge rewrites the live records and removes their history, so the changes written before the last merge
+// cannot be replayed anymore.
+// A ReadOnly bitcask only replays the changes stored when ChangesSince is called.
+// Return ErrCompacted if the changes after the given sequence number were removed by a merge
+// or an error on system failures when reading the datastore directory.
+func (b *Bitcask) ChangesSince(seq int64) (*Iterator, error) {
+	it := &Iterator{b: b, seq: seq, cancel: func() {}}
+
+	// the watcher is registered while no write is in progress, so every write is either
+	// in the data files or sent to the watcher, the changes found in both are skipped by their sequence numbers.
+	b.accessMu.RLock()
+	if b.usrOpts.accessPermission == ReadWrite {
+		it.events, it.cancel = b.Watch("")
+	}
+	b.accessMu.RUnlock()
+
+	err := it.listFiles()
+	if err != nil {
+		it.cancel()
+		return nil, err
+	}
+
+	return it, nil
+}
+
+// Next returns the next change, waiting for the next write after all the stored changes are returned.
+// Return io.EOF after the stored changes if the bitcask is ReadOnly,
+// ErrIteratorClosed if the iterator or the bitcask is closed, ErrCompacted if a merge removed
+// changes that were not returned yet or an error if a data file cannot be read.
+func (it *Iterator) Next() (Event, error) {
+	for {
+		if it.closed.Load() {
+			return Event{}, ErrIteratorClosed
+		}
+
+		if len(it.pending) > 0 {
+			ev := it.pending[0]
+			it.pending = it.pending[1:]
+			if ev.Seq > it.seq {
+				it.seq = ev.Seq
+				return ev, nil
+			}
+			continue
+		}
+
+		if !it.caughtUp {
+			caughtUp, err := it.readBatch()
+			if err != nil {
+				return Event{}, err
+			}
+			it.caughtUp = caughtUp
+			continue
+		}
+
+		if it.events == nil {
+			return Event{}, io.EOF
+		}
+
+		ev, ok := <-it.events
+		if !ok {
+			return Event{}, ErrIteratorClosed
+		}
+		if ev.Type == EventOverflow {
+			// the dropped writes are still in the data files after the last read record.
+			err := it.listFiles()
+			if err != nil {
+				return Event{}, err
+			}
+			it.caughtUp = false
+			continue
+		}
+		it.pending = append(it.pending, ev)
+	}
+}
+
+// Seq returns the sequence number of the last returned change.
+func (it *Iterator) Seq() int64 {
+	return it.seq
+}
+
+// Close stops the iterator, a blocked Next returns ErrIteratorClosed.
+func (it *Iterator) Close() {
+	it.closed.Store(true)
+	it.cancel()
+}
+
+// listFiles lists the data files that can hold changes after the iterator sequence number,
+// skipping the merged files as their records are older than the last merge
+// and the files before the one holding the last read record.
+// Merge keeps the active file, so the changes after the last merge start at the first record
+// of the oldest data file that is not merged, or after all the written records if there is none yet.
+// Return ErrCompacted if the last merge removed changes after the iterator sequence number.
+func (it *Iterator) listFiles() error {
+	b := it.b
+	b.filesMu.RLock()
+	defer b.filesMu.RUnlock()
+
+	entries, err := b.dataStore.FS().ReadDir(b.dataStore.Path())
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]bool)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".hint") {
+			merged[strings.TrimSuffix(entry.Name(), ".hint")+".data"] = true
+		}
+	}
+
+	current := ""
+	if len(it.files) > 0 {
+		current = it.files[0]
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".data") && !merged[name] && name >= current {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	if len(files) == 0 || files[0] != current {
+		it.offset = 0
+	}
+	it.files = files
+
+	if len(merged) == 0 {
+		return nil
+	}
+
+	compacted, err := it.compactedSeq()
+	if err != nil {
+		return err
+	}
+	if it.seq < compacted {
+		return ErrCompacted
+	}
+
+	return nil
+}
+
+// compactedSeq returns the sequence number of the last change removed by a merge,
+// the caller must hold the files lock.
+// Only the first record of the listed files is read.
+// Return an error if a data file cannot be read.
+func (it *Iterator) compactedSeq() (int64, error) {
+	b := it.b
+	b.accessMu.RLock()
+	defer b.accessMu.RUnlock()
+
+	activeName := ""
+	if b.usrOpts.accessPermission == ReadWrite {
+		activeName = b.activeFile.Name()
+	}
+
+	var lastFile int64
+	for _, name := range it.files {
+		tstamp, ok, err := firstTstamp(b, name)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return tstamp - 1, nil
+		}
+
+		// the active file of this bitcask is created after its latest write,
+		// the empty files left by other processes bound the changes written before them.
+		if name == activeName {
+			continue
+		}
+		fileTstamp, _ := strconv.ParseInt(strings.TrimSuffix(name, ".data"), 10, 64)
+		if fileTstamp > lastFile {
+			lastFile = fileTstamp
+		}
+	}
+
+	// the not merged files are empty, so every written change was merged and the merged tombstones
+	// are bounded by the latest write of this bitcask or the creation of the empty files.
+	if b.lastTstamp > lastFile {
+		return b.lastTstamp, nil
+	}
+
+	return lastFile, nil
+}
+
+// readBatch queues up to iteratorBatch changes of the data files written after the iterator sequence number,
+// resuming from the position of the last read record.
+// The position is kept at the end of the last file, as the writes after it can be appended to it.
+// Return true once all the stored changes are queued.
+func (it *Iterator) readBatch() (bool, error) {
+	for len(it.files) > 0 {
+		last := len(it.files) == 1
+		end, err := it.readFile(last)
+		if err != nil || !end {
+			return false, err
+		}
+		if last {
+			return true, nil
+		}
+		it.files = it.files[1:]
+		it.offset = 0
+	}
+
+	return true, nil
+}
+
+// readFile queues the changes of the current data file written after the iterator sequence number,
+// from the position of the last read record until the batch is full.
+// A record being written can be partially read from the last file, so reading the last file stops
+// at the first broken record whose change is sent to the watcher.
+// The records of a transaction are queued together once they are all read, an incomplete transaction
+// is still being written in the last file and was cut by a crash in the other files, so the rest of the file is skipped.
+// If the file was removed by a merge, the files are listed again to check whether the merge removed
+// changes that were not returned yet.
+// Return true if the end of the file is reached.
+// Return ErrCompacted if the merge removed changes or an error if the file cannot be read or parsed.
+func (it *Iterator) readFile(last bool) (bool, error) {
+	b := it.b
+	b.filesMu.RLock()
+	end, err := it.readRecords(last)
+	b.filesMu.RUnlock()
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, it.listFiles()
+	}
+
+	return end, err
+}
+
+// readRecords reads the records of the current data file for readFile, the caller must hold the files lock.
+func (it *Iterator) readRecords(last bool) (bool, error) {
+	b := it.b
+	name := it.files[0]
+	f, size, err := openDataFile(b, name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if it.offset == 0 {
+		hdr, hdrLen, err := readFileHdr(f, name)
+		if err != nil {
+			return false, err
+		}
+		if hdrLen < recfmt.FileHdr {
+			// the file is empty or cut inside its header, it holds no records yet.
+			return true, nil
+		}
+		it.hdr = hdr
+		it.offset = int64(hdrLen)
+	}
+
+	r := bufio.NewReaderSize(io.NewSectionReader(f, it.offset, size-it.offset), iteratorReadBuffer)
+	for len(it.pending) < iteratorBatch {
+		if it.offset >= size {
+			return true, nil
+		}
+
+		buf, err := readRecord(r, size-it.offset)
+		if err != nil {
+			return false, err
+		}
+		rec, recLen, err := recfmt.ExtractDataFileRec(buf, it.hdr, &b.usrOpts.encoding)
+		if err != nil {
+			if last && it.events != nil {
+				return true, nil
+			}
+			return false, err
+		}
+		recs := []*recfmt.DataRec{rec}
+		length := int64(recLen)
+		if count, ok := datastore.BatchLen(rec); ok {
+			batch, batchLen, complete, err := it.readBatchRecords(r, size-it.offset-length, count)
+			if err != nil {
+				if last && it.events != nil {
+					return true, nil
+				}
+				return false, err
+			}
+			if !complete {
+				return true, nil
+			}
+			recs = batch
+			length += batchLen
+		}
+		it.offset += length
+
+		for i, rec := range recs {
+			if rec.Tstamp <= it.seq {
+				continue
+			}
+			ev := Event{Seq: rec.Tstamp, Type: EventPut, Key: rec.Key, Value: rec.Value, Batch: len(recs) - 1 - i}
+			if rec.Value == datastore.TompStone {
+				ev.Type = EventDelete
+				ev.Value = ""
+			}
+			it.pending = append(it.pending, ev)
+		}
+	}
+
+	return false, nil
+}
+
+// readBatchRecords reads the given number of records of the batch following its mark from the reader
+// holding the given number of remaining bytes of the current data file.
+// Return the records with their length, or false if the file ends before the last record of the batch.
+// Return an error if a record cannot be read or parsed.
+func (it *Iterator) readBatchRecords(r io.Reader, remaining int64, count int) ([]*recfmt.DataRec, int64, bool, error) {
+	recs := make([]*recfmt.DataRec, 0, count)
+	length := int64(0)
+	for ; count > 0; count-- {
+		buf, err := readRecord(r, remaining-length)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if _, _, ok := recfmt.PeekDataFileRec(buf, it.hdr); !ok {
+			return nil, 0, false, nil
+		}
+		rec, recLen, err := recfmt.ExtractDataFileRec(buf, it.hdr, &it.b.usrOpts.encoding)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		recs = append(recs, rec)
+		length += int64(recLen)
+	}
+
+	return recs, length, true, nil
+}
+
+// firstTstamp returns the timestamp of the first record of the given data file,
+// false is returned if the file holds no complete record.
+// Return an error if the file cannot be read.
+func firstTstamp(b *Bitcask, name string) (int64, bool, error) {
+	f, size, err := openDataFile(b, name)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	hdr, hdrLen, err := readFileHdr(f, name)
+	if err != nil {
+		return 0, false, err
+	}
+	remaining := size - int64(hdrLen)
+	buf, err := readRecord(io.NewSectionReader(f, int64(hdrLen), remaining), remaining)
+	if err != nil {
+		return 0, false, err
+	}
+	rec, _, ok := recfmt.PeekDataFileRec(buf, hdr)
+	if !ok {
+		return 0, false, nil
+	}
+
+	return rec.Tstamp, true, nil
+}
+
+// openDataFile opens the given data file of the datastore for reading.
+// Return the file and its size or an error if it cannot be opened.
+func openDataFile(b *Bitcask, name string) (vfs.File, int64, error) {
+	f, err := b.dataStore.FS().OpenFile(path.Join(b.dataStore.Path(), name), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+
+	return f, info.Size(), nil
+}
+
+// readFileHdr reads the header of the given data file.
+// Return the parsed header and its length or an error if it cannot be read or parsed.
+func readFileHdr(f vfs.File, name string) (recfmt.FileHeader, int, error) {
+	buf := make([]byte, recfmt.FileHdr)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return recfmt.FileHeader{}, 0, err
+	}
+	hdr, hdrLen, err := recfmt.ExtractFileHdr(buf[:n])
+	if err != nil {
+		return recfmt.FileHeader{}, 0, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return hdr, hdrLen, nil
+}
+
+// readRecord reads the bytes of the next data file record from the reader holding the given number
+// of remaining bytes of the file, nothing is buffered beyond the record.
+// The returned bytes are short if the record is incomplete or its header claims more than the remaining bytes.
+// Return an error on system failures.
+func readRecord(r io.Reader, remaining int64) ([]byte, error) {
+	n := int64(recfmt.DataFileRecHdr)
+	if remaining < n {
+		n = remaining
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	if err != nil || n < recfmt.DataFileRecHdr {
+		return buf, err
+	}
+
+	recLen := recfmt.DataFileRecLen(buf)
+	if recLen > uint64(remaining) {
+		return buf, nil
+	}
+	buf = append(buf, make([]byte, recLen-uint64(n))...)
+	_, err = io.ReadFull(r, buf[n:])
+
+	return buf, err
+}
@@ -0,0 +1,150 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+func TestChangesSince(t *testing.T) {
+	t.Run("replay then live writes", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+		for i := 0; i < 500; i++ {
+			b.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+		}
+		b.Delete("key1")
+
+		it, err := b.ChangesSince(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+
+		var seq int64
+		for i := 0; i < 500; i++ {
+			ev, err := it.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Type != EventPut || ev.Key != fmt.Sprintf("key%d", i) || ev.Seq <= seq {
+				t.Fatalf("change %d: got %+v after sequence %d", i, ev, seq)
+			}
+			seq = ev.Seq
+		}
+		if ev, _ := it.Next(); ev.Type != EventDelete || ev.Key != "key1" {
+			t.Errorf("expected the delete of key1, got %+v", ev)
+		}
+
+		go b.Put("live", "value")
+		if ev, _ := it.Next(); ev.Type != EventPut || ev.Key != "live" {
+			t.Errorf("expected the live put, got %+v", ev)
+		}
+	})
+
+	t.Run("resume from a sequence number", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		b1.Put("key1", "value1")
+		b1.Put("key2", "value2")
+		it, _ := b1.ChangesSince(0)
+		it.Next()
+		seq := it.Seq()
+		it.Close()
+		b1.Close()
+
+		b2, _ := OpenWith(testBitcaskPath, WithFS(mem))
+		defer b2.Close()
+		it, err := b2.ChangesSince(seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev, _ := it.Next(); ev.Key != "key2" {
+			t.Errorf("expected the put of key2, got %+v", ev)
+		}
+		_, err = it.Next()
+		if err != io.EOF {
+			t.Errorf("expected io.EOF after the stored changes of a read only bitcask, got %v", err)
+		}
+	})
+
+	t.Run("compacted by merge", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+		for i := 0; i < 500; i++ {
+			b.Put(fmt.Sprintf("key%d", i%10), fmt.Sprintf("value%d", i))
+		}
+		b.Delete("key1")
+		caughtUp := b.lastTstamp
+		b.Merge()
+
+		_, err := b.ChangesSince(0)
+		if !errors.Is(err, ErrCompacted) {
+			t.Errorf("expected %v, got %v", ErrCompacted, err)
+		}
+
+		it, err := b.ChangesSince(caughtUp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		b.Put("after", "merge")
+		if ev, _ := it.Next(); ev.Key != "after" {
+			t.Errorf("expected the put after the merge, got %+v", ev)
+		}
+	})
+
+	t.Run("resume from the last read record after an overflow", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+		b.Put("key0", "value0")
+
+		it, err := b.ChangesSince(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		it.Next()
+
+		// the writes overflow the watcher buffer before the iterator reads them.
+		n := watchBufferSize + 2*iteratorBatch
+		for i := 1; i <= n; i++ {
+			b.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+		}
+
+		for i := 1; i <= n+1; i++ {
+			ev, err := it.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 1 {
+				// the overflow is reported once the buffer has room.
+				b.Put(fmt.Sprintf("key%d", n+1), "value")
+			}
+			if ev.Key != fmt.Sprintf("key%d", i) {
+				t.Fatalf("change %d: got %+v", i, ev)
+			}
+			if len(it.pending) > iteratorBatch {
+				t.Fatalf("change %d: got %d pending changes", i, len(it.pending))
+			}
+		}
+		if it.offset == 0 || len(it.files) != 1 {
+			t.Errorf("got the position %v at %d, want the end of the active file", it.files, it.offset)
+		}
+	})
+
+	t.Run("close unblocks next", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+		it, _ := b.ChangesSince(0)
+
+		go it.Close()
+		_, err := it.Next()
+		if err != ErrIteratorClosed {
+			t.Errorf("expected %v, got %v", ErrIteratorClosed, err)
+		}
+	})
+}
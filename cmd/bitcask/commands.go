@@ -0,0 +1,390 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/IslamWalid/bitcask/internal/inspect"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+var (
+	// errUnknownMode happens whenever an import mode that is not supported is given.
+	errUnknownMode = errors.New("unknown import mode: use skip-existing or no-header")
+
+	// formats maps the names of the export formats to their values.
+	formats = map[string]bitcask.Format{
+		"jsonl": bitcask.JSONLines,
+		"csv":   bitcask.CSV,
+	}
+)
+
+type (
+	// pair represents a key/value pair printed by the scan command.
+	pair struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}
+
+	// status represents the result of the commands that print no data.
+	status struct {
+		Status string `json:"status"`
+	}
+)
+
+// get prints the value of the given key.
+func get(c *cli, args []string) error {
+	b, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	value, err := b.Get(args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(pair{Key: args[0], Value: value}, value)
+}
+
+// put stores the given value by the given key.
+func put(c *cli, args []string) error {
+	b, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	err = b.Put(args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	return c.print(status{Status: "OK"}, "OK")
+}
+
+// del removes the given keys.
+func del(c *cli, args []string) error {
+	b, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	for _, key := range args {
+		err := b.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.print(status{Status: "OK"}, "OK")
+}
+
+// keys prints the sorted keys that start with the given prefix.
+func keys(c *cli, args []string) error {
+	b, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	res := matchKeys(b, args)
+
+	return c.print(res, res...)
+}
+
+// scan prints the sorted key/value pairs whose keys start with the given prefix.
+func scan(c *cli, args []string) error {
+	b, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	pairs := make([]pair, 0)
+	lines := make([]string, 0)
+	for _, key := range matchKeys(b, args) {
+		value, err := b.Get(key)
+		if err != nil {
+			continue
+		}
+		pairs = append(pairs, pair{Key: key, Value: value})
+		lines = append(lines, fmt.Sprintf("%s\t%s", key, value))
+	}
+
+	return c.print(pairs, lines...)
+}
+
+// stats prints the statistics of the datastore.
+func stats(c *cli, args []string) error {
+	b, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	s, err := b.Stats()
+	if err != nil {
+		return err
+	}
+
+	return c.print(s,
+		fmt.Sprintf("keys:         %d", s.Keys),
+		fmt.Sprintf("data files:   %d", s.DataFiles),
+		fmt.Sprintf("hint files:   %d", s.HintFiles),
+		fmt.Sprintf("data bytes:   %d", s.DataBytes),
+		fmt.Sprintf("hint bytes:   %d", s.HintBytes),
+		fmt.Sprintf("live bytes:   %d", s.LiveBytes),
+		fmt.Sprintf("dead bytes:   %d", s.DeadBytes),
+		fmt.Sprintf("keydir bytes: %d", s.KeyDirBytes),
+	)
+}
+
+// merge compacts the datastore.
+func merge(c *cli, args []string) error {
+	b, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	err = b.Merge()
+	if err != nil {
+		return err
+	}
+
+	return c.print(status{Status: "OK"}, "OK")
+}
+
+// verify reports the corrupted and inconsistent records of the datastore.
+func verify(c *cli, args []string) error {
+	opts, err := c.options()
+	if err != nil {
+		return err
+	}
+
+	report, err := bitcask.Verify(c.path, opts...)
+	if err != nil {
+		return err
+	}
+
+	err = c.printReport(report)
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("%d problems found", len(report.Problems))
+	}
+
+	return nil
+}
+
+// repair salvages the readable records of the datastore into a new datastore.
+func repair(c *cli, args []string) error {
+	opts, err := c.options()
+	if err != nil {
+		return err
+	}
+
+	report, err := bitcask.Repair(c.path, args[0], opts...)
+	if err != nil {
+		return err
+	}
+
+	return c.printReport(report)
+}
+
+// dump copies the datastore files into the given directory.
+func dump(c *cli, args []string) error {
+	b, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	err = b.Dump(args[0])
+	if err != nil {
+		return err
+	}
+
+	return c.print(status{Status: "OK"}, "OK")
+}
+
+// load copies all the key/value pairs of the datastore in the given directory into the datastore.
+func load(c *cli, args []string) error {
+	opts, err := c.options()
+	if err != nil {
+		return err
+	}
+
+	src, err := bitcask.OpenWith(args[0], opts...)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	b, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	err, _ = src.Fold(func(key, value string, acc any) any {
+		if acc != nil {
+			return acc
+		}
+		err := b.Put(key, value)
+		if err != nil {
+			return err
+		}
+		return nil
+	}, nil).(error)
+	if err != nil {
+		return err
+	}
+
+	err = b.Sync()
+	if err != nil {
+		return err
+	}
+
+	return c.print(status{Status: "OK"}, "OK")
+}
+
+// parseFormat returns the export format with the given name.
+// Return bitcask.ErrUnknownFormat if the format is not supported.
+func parseFormat(name string) (bitcask.Format, error) {
+	format, ok := formats[name]
+	if !ok {
+		return 0, fmt.Errorf("%s: %s, use jsonl or csv", name, bitcask.ErrUnknownFormat)
+	}
+
+	return format, nil
+}
+
+// matchKeys returns the sorted keys of the datastore that start with the prefix in the given args if exists.
+func matchKeys(b *bitcask.Bitcask, args []string) []string {
+	prefix := ""
+	if len(args) != 0 {
+		prefix = args[0]
+	}
+
+	res := make([]string, 0)
+	for _, key := range b.ListKeys() {
+		if strings.HasPrefix(key, prefix) {
+			res = append(res, key)
+		}
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// printReport prints the given verify or repair report.
+func (c *cli) printReport(report *bitcask.Report) error {
+	lines := make([]string, 0)
+	for _, p := range report.Problems {
+		lines = append(lines, fmt.Sprintf("%s:%d: key %q: %s", p.File, p.Offset, p.Key, p.Err))
+	}
+	lines = append(lines, fmt.Sprintf("%d files, %d records, %d problems", report.Files, report.Records, len(report.Problems)))
+	if report.Salvaged != 0 {
+		lines = append(lines, fmt.Sprintf("%d keys salvaged", report.Salvaged))
+	}
+
+	return c.print(report, lines...)
+}
+
+// inspectFiles prints the raw records of the given datastore file, or of all the datastore files,
+// followed by the live and dead bytes of every file.
+func inspectFiles(c *cli, args []string) error {
+	name := ""
+	if len(args) != 0 {
+		name = args[0]
+	}
+
+	enc, err := c.encoding()
+	if err != nil {
+		return err
+	}
+
+	res, err := inspect.Inspect(vfs.OS, c.path, name, enc)
+	if err != nil {
+		return err
+	}
+	if c.jsonOut {
+		return c.print(res)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tOFFSET\tLENGTH\tCRC\tTSTAMP\tKEY SIZE\tVALUE SIZE\tKEY\tTOMBSTONE\tLIVE\tERROR")
+	for _, r := range res.Records {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%d\t%d\t%q\t%t\t%t\t%s\n",
+			r.File, r.Offset, r.Length, r.CRC, r.Tstamp, r.KeySize, r.ValueSize, r.Key, r.Tombstone, r.Live, r.Err)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "FILE\tTYPE\tRECORDS\tCORRUPTED\tLIVE BYTES\tDEAD BYTES")
+	for _, f := range res.Files {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\n", f.File, f.Type, f.Records, f.Corrupted, f.LiveBytes, f.DeadBytes)
+	}
+
+	return w.Flush()
+}
+
+// export writes all the key/value pairs of the datastore to the standard output in the given format.
+func export(c *cli, args []string) error {
+	format, err := parseFormat(args[0])
+	if err != nil {
+		return err
+	}
+
+	b, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	return b.Export(c.out, format)
+}
+
+// importPairs stores the key/value pairs read from the standard input in the given format
+// reporting the progress to the standard error.
+func importPairs(c *cli, args []string) error {
+	format, err := parseFormat(args[0])
+	if err != nil {
+		return err
+	}
+
+	opts := []bitcask.ImportOpt{bitcask.WithProgress(func(p bitcask.ImportProgress) {
+		fmt.Fprintf(os.Stderr, "read %d, written %d, skipped %d\n", p.Read, p.Written, p.Skipped)
+	})}
+	for _, mode := range args[1:] {
+		switch mode {
+		case "skip-existing":
+			opts = append(opts, bitcask.SkipExisting)
+		case "no-header":
+			opts = append(opts, bitcask.WithCSVHeader(false))
+		default:
+			return errUnknownMode
+		}
+	}
+
+	b, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	p, err := b.Import(os.Stdin, format, opts...)
+	if err != nil {
+		return err
+	}
+
+	return c.print(p, fmt.Sprintf("%d records imported, %d skipped", p.Written, p.Skipped))
+}
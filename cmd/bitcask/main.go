@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/codec"
+	"github.com/IslamWalid/bitcask/pkg/encrypt"
+)
+
+var (
+	// errReadOnly happens whenever a writing command runs without disabling the read only flag.
+	errReadOnly = errors.New("require write permission: run with -read-only=false")
+
+	// errUnknownCodec happens whenever a compression codec that is not supported is given.
+	errUnknownCodec = errors.New("unknown codec: use deflate or gzip")
+
+	// errMalformedKey happens whenever a line of the keys file is not a key id followed by a hex encoded key.
+	errMalformedKey = errors.New("malformed key: use \"<id> <hex key>\"")
+
+	// codecs maps the names of the compression codecs to their values.
+	codecs = map[string]codec.Codec{
+		"deflate": codec.Deflate,
+		"gzip":    codec.Gzip,
+	}
+)
+
+type (
+	// command represents a bitcask subcommand.
+	// write specifies whether the command needs to open the datastore with write permission.
+	command struct {
+		usage   string
+		minArgs int
+		maxArgs int
+		write   bool
+		run     func(c *cli, args []string) error
+	}
+
+	// cli contains the global flags shared by all the subcommands.
+	// keysFile, compress and threshold describe how the datastore records are encrypted and compressed.
+	cli struct {
+		path      string
+		readOnly  bool
+		jsonOut   bool
+		keysFile  string
+		compress  string
+		threshold int
+		out       io.Writer
+	}
+)
+
+// commands maps every subcommand name to its command.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"get":     {usage: "get <key>", minArgs: 1, maxArgs: 1, run: get},
+		"put":     {usage: "put <key> <value>", minArgs: 2, maxArgs: 2, write: true, run: put},
+		"del":     {usage: "del <key>...", minArgs: 1, maxArgs: -1, write: true, run: del},
+		"keys":    {usage: "keys [prefix]", minArgs: 0, maxArgs: 1, run: keys},
+		"scan":    {usage: "scan [prefix]", minArgs: 0, maxArgs: 1, run: scan},
+		"stats":   {usage: "stats", run: stats},
+		"merge":   {usage: "merge", write: true, run: merge},
+		"verify":  {usage: "verify", run: verify},
+		"repair":  {usage: "repair <destination>", minArgs: 1, maxArgs: 1, run: repair},
+		"inspect": {usage: "inspect [file]", minArgs: 0, maxArgs: 1, run: inspectFiles},
+		"export":  {usage: "export <jsonl|csv>", minArgs: 1, maxArgs: 1, run: export},
+		"import":  {usage: "import <jsonl|csv> [skip-existing] [no-header]", minArgs: 1, maxArgs: 3, write: true, run: importPairs},
+		"dump":    {usage: "dump <destination>", minArgs: 1, maxArgs: 1, run: dump},
+		"load":    {usage: "load <source>", minArgs: 1, maxArgs: 1, write: true, run: load},
+	}
+}
+
+func main() {
+	c := &cli{out: os.Stdout}
+	flag.StringVar(&c.path, "d", ".", "specify the datastore path")
+	flag.BoolVar(&c.readOnly, "read-only", true, "open the datastore with read only permission")
+	flag.BoolVar(&c.jsonOut, "json", false, "print the output as JSON")
+	flag.StringVar(&c.keysFile, "keys", "", "read the encryption keys from the given file, one \"<id> <hex key>\" per line, the last key is the current one")
+	flag.StringVar(&c.compress, "compress", "", "compress the written values with deflate or gzip")
+	flag.IntVar(&c.threshold, "compress-threshold", 0, "compress only the values of at least the given size")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	args := flag.Args()[1:]
+	if !ok || len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		usage()
+		os.Exit(2)
+	}
+
+	err := c.exec(cmd, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the global flags and the usage of all the subcommands.
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: bitcask [-d datastore] [-read-only=false] [-json] [-keys file] [-compress codec] <command> [args]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "flags:")
+	flag.PrintDefaults()
+}
+
+// exec runs the given command.
+// Return an error if the command writes while the read only flag is set or if the command fails.
+func (c *cli) exec(cmd command, args []string) error {
+	if cmd.write && c.readOnly {
+		return errReadOnly
+	}
+
+	return cmd.run(c, args)
+}
+
+// open opens the datastore with write permission if the command writes
+// or with read only permission otherwise, so readers can share the datastore.
+func (c *cli) open(write bool) (*bitcask.Bitcask, error) {
+	opts, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+	if write {
+		opts = append(opts, bitcask.ReadWrite)
+	}
+
+	return bitcask.OpenWith(c.path, opts...)
+}
+
+// options returns the encryption and compression options given by the flags.
+// Return an error if the keys file cannot be read or the codec is unknown.
+func (c *cli) options() ([]bitcask.Option, error) {
+	enc, err := c.encoding()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := make([]bitcask.Option, 0)
+	if enc.Codec != nil {
+		opts = append(opts, bitcask.WithCompression(enc.Codec, enc.Threshold))
+	}
+	if c.keysFile != "" {
+		keys, err := readKeys(c.keysFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, bitcask.WithEncryption(keys))
+	}
+
+	return opts, nil
+}
+
+// encoding returns the record encoding given by the flags, used to decode the raw records.
+// Return an error if the keys file cannot be read or the codec is unknown.
+func (c *cli) encoding() (*recfmt.Encoding, error) {
+	enc := &recfmt.Encoding{Threshold: c.threshold}
+	if c.compress != "" {
+		cdc, ok := codecs[c.compress]
+		if !ok {
+			return nil, errUnknownCodec
+		}
+		enc.Codec = cdc
+	}
+	if c.keysFile != "" {
+		keys, err := readKeys(c.keysFile)
+		if err != nil {
+			return nil, err
+		}
+		enc.Sealer = encrypt.NewAESGCM(keys)
+	}
+
+	return enc, nil
+}
+
+// readKeys reads the encryption keys from the given file,
+// every line holds a key id followed by the hex encoded key and the last key is the current one.
+// Return an error if the file cannot be read or a line is malformed.
+func readKeys(name string) (*encrypt.Keys, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &encrypt.Keys{ByID: make(map[uint32][]byte)}
+	for n, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: %s", name, n+1, errMalformedKey)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, n+1, errMalformedKey)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, n+1, errMalformedKey)
+		}
+		keys.ByID[uint32(id)] = key
+		keys.Current = uint32(id)
+	}
+	if len(keys.ByID) == 0 {
+		return nil, fmt.Errorf("%s: %s", name, errMalformedKey)
+	}
+
+	return keys, nil
+}
+
+// print prints the given value as JSON if the json flag is set
+// or prints the given lines otherwise.
+func (c *cli) print(v any, lines ...string) error {
+	if c.jsonOut {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	for _, line := range lines {
+		_, err := fmt.Fprintln(c.out, line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
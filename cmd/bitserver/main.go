@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 
 	resp "github.com/IslamWalid/bitcask/pkg/respserver"
@@ -11,6 +12,8 @@ import (
 func main() {
 	pathPtr := flag.String("d", "", "specify the desired datastore path")
 	port := flag.Int("p", 6379, "specify the desired server port")
+	metricsAddr := flag.String("metrics", "", "serve prometheus metrics over http in the given address, e.g. :9100")
+	notifyEvents := flag.String("notify-keyspace-events", "", "enable the keyspace notifications of the given event classes, e.g. KEA")
 	flag.Parse()
 
 	s, err := resp.New(*pathPtr, fmt.Sprintf(":%d", *port))
@@ -20,6 +23,23 @@ func main() {
 	}
 	defer s.Close()
 
+	err = s.SetNotifyKeyspaceEvents(*notifyEvents)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.MetricsHandler())
+		go func() {
+			err := http.ListenAndServe(*metricsAddr, mux)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+
 	err = s.ListenAndServe()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -0,0 +1,356 @@
+package bitcask
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/IslamWalid/bitcask/internal/datastore"
+)
+
+const (
+	// JSONLines formats every key/value pair as a JSON object in a separate line.
+	// Keys and values that are not valid UTF-8 are base64 encoded in the key_base64 and value_base64 fields.
+	JSONLines Format = 0
+	// CSV formats every key/value pair as a CSV row after a key,value header row,
+	// Import expects the header row unless WithCSVHeader(false) is given.
+	// Values are written as text, so JSONLines should be used for binary values.
+	CSV Format = 1
+
+	// OverwriteExisting makes Import overwrite the values of the existing keys.
+	OverwriteExisting ImportMode = 0
+	// SkipExisting makes Import keep the values of the existing keys.
+	SkipExisting ImportMode = 1
+
+	// defaultImportBatch is the number of records Import writes between syncs if no batch size is given.
+	defaultImportBatch = 1000
+)
+
+var (
+	// ErrUnknownFormat happens whenever an export or import format that is not supported is used.
+	ErrUnknownFormat = errors.New("unknown format")
+
+	// errInvalidRecord happens whenever an imported record cannot be parsed.
+	errInvalidRecord = errors.New("invalid record")
+
+	// errInvalidHeader happens whenever the first row of an imported CSV with a header is not the key,value header.
+	errInvalidHeader = errors.New("invalid header: expected key,value or import without a header")
+
+	// csvHeader is the header row of the CSV format.
+	csvHeader = []string{"key", "value"}
+)
+
+type (
+	// Format represents the formats supported by Export and Import.
+	Format int
+
+	// ImportOpt represents the options the user can pass to Import.
+	ImportOpt interface {
+		applyImport(*importOptions)
+	}
+
+	// ImportMode specifies what Import does with the keys that already exist.
+	ImportMode int
+
+	// ImportProgress reports the progress of an import.
+	// Read is the number of parsed records, Written is the number of stored records
+	// and Skipped is the number of records skipped because their keys exist.
+	ImportProgress struct {
+		Read    int
+		Written int
+		Skipped int
+	}
+
+	// batchSizeOpt is the import option that sets the number of records written between syncs.
+	batchSizeOpt int
+
+	// progressOpt is the import option that sets the function called with the import progress.
+	progressOpt func(ImportProgress)
+
+	// csvHeaderOpt is the import option that sets whether the first CSV row is a header row.
+	csvHeaderOpt bool
+
+	// importOptions groups the options passed to Import.
+	importOptions struct {
+		mode      ImportMode
+		batch     int
+		progress  func(ImportProgress)
+		csvHeader bool
+	}
+
+	// jsonRecord represents a key/value pair in the JSONLines format.
+	jsonRecord struct {
+		Key         *string `json:"key,omitempty"`
+		KeyBase64   *string `json:"key_base64,omitempty"`
+		Value       *string `json:"value,omitempty"`
+		ValueBase64 *string `json:"value_base64,omitempty"`
+	}
+)
+
+// WithBatchSize makes Import flush the written records to the disk after every n records.
+func WithBatchSize(n int) ImportOpt {
+	return batchSizeOpt(n)
+}
+
+// WithProgress makes Import call the given function with its progress after every flushed batch.
+func WithProgress(fn func(ImportProgress)) ImportOpt {
+	return progressOpt(fn)
+}
+
+// WithCSVHeader sets whether the first row of an imported CSV is the key,value header row written by Export.
+// The header row is expected by default, so a CSV without it must be imported with WithCSVHeader(false)
+// or its first row is rejected, and its first row is imported as a record then even if it is key,value.
+func WithCSVHeader(header bool) ImportOpt {
+	return csvHeaderOpt(header)
+}
+
+// Export writes all the key/value pairs of the datastore to the given writer in the given format, sorted by key.
+// Writes can continue while exporting, the keys written after Export is called may not be exported.
+// Return an error if the format is not supported or on any system failures.
+func (b *Bitcask) Export(w io.Writer, format Format) error {
+	if format != JSONLines && format != CSV {
+		return fmt.Errorf("Export: %s", ErrUnknownFormat)
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	if format == CSV {
+		err := cw.Write(csvHeader)
+		if err != nil {
+			return err
+		}
+	}
+
+	keys := b.ListKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := b.Get(key)
+		if err != nil {
+			if strings.HasSuffix(err.Error(), datastore.ErrKeyNotExist.Error()) {
+				continue
+			}
+			return err
+		}
+
+		if format == CSV {
+			err = cw.Write([]string{key, value})
+		} else {
+			err = writeJSONRecord(bw, key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	err := cw.Error()
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// Import reads key/value pairs in the given format from the given reader and stores them in the datastore.
+// The records are flushed to the disk in batches, 1000 records by default or as set by WithBatchSize,
+// and the progress is reported after every batch if WithProgress is given.
+// Existing keys are overwritten unless SkipExisting is given.
+// Return the final progress and an error if ReadWrite permission is not set, if a record cannot be parsed
+// or on any system failures, the records read before the error are kept.
+func (b *Bitcask) Import(r io.Reader, format Format, opts ...ImportOpt) (ImportProgress, error) {
+	p := ImportProgress{}
+	if b.usrOpts.accessPermission == ReadOnly {
+		return p, fmt.Errorf("Import: %s", errRequireWrite)
+	}
+
+	importOpts := importOptions{mode: OverwriteExisting, batch: defaultImportBatch, csvHeader: true}
+	for _, opt := range opts {
+		opt.applyImport(&importOpts)
+	}
+
+	var next func() (string, string, error)
+	switch format {
+	case JSONLines:
+		next = jsonRecordReader(r)
+	case CSV:
+		next = csvRecordReader(r, importOpts.csvHeader)
+	default:
+		return p, fmt.Errorf("Import: %s", ErrUnknownFormat)
+	}
+
+	for {
+		key, value, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return p, fmt.Errorf("Import: record %d: %s", p.Read+1, err)
+		}
+		p.Read++
+
+		if importOpts.mode == SkipExisting {
+			if _, err := b.Get(key); err == nil {
+				p.Skipped++
+				continue
+			}
+		}
+
+		err = b.Put(key, value)
+		if err != nil {
+			return p, err
+		}
+		p.Written++
+
+		if p.Written%importOpts.batch == 0 {
+			err := b.flushImport(p, importOpts.progress)
+			if err != nil {
+				return p, err
+			}
+		}
+	}
+
+	return p, b.flushImport(p, importOpts.progress)
+}
+
+// applyImport sets the import mode.
+func (m ImportMode) applyImport(importOpts *importOptions) {
+	importOpts.mode = m
+}
+
+// applyImport sets the import batch size, non positive sizes are ignored.
+func (n batchSizeOpt) applyImport(importOpts *importOptions) {
+	if n > 0 {
+		importOpts.batch = int(n)
+	}
+}
+
+// applyImport sets the import progress function.
+func (fn progressOpt) applyImport(importOpts *importOptions) {
+	importOpts.progress = fn
+}
+
+// applyImport sets whether the first CSV row is a header row.
+func (h csvHeaderOpt) applyImport(importOpts *importOptions) {
+	importOpts.csvHeader = bool(h)
+}
+
+// flushImport flushes the imported records to the disk then reports the progress if a progress function is given.
+// Return an error on system failures.
+func (b *Bitcask) flushImport(p ImportProgress, progress func(ImportProgress)) error {
+	err := b.Sync()
+	if err != nil {
+		return err
+	}
+
+	if progress != nil {
+		progress(p)
+	}
+
+	return nil
+}
+
+// writeJSONRecord writes the key/value pair as a JSON line
+// base64 encoding the key and the value if they are not valid UTF-8.
+// Return an error on system failures.
+func writeJSONRecord(w io.Writer, key, value string) error {
+	rec := jsonRecord{}
+	if utf8.ValidString(key) {
+		rec.Key = &key
+	} else {
+		encoded := base64.StdEncoding.EncodeToString([]byte(key))
+		rec.KeyBase64 = &encoded
+	}
+	if utf8.ValidString(value) {
+		rec.Value = &value
+	} else {
+		encoded := base64.StdEncoding.EncodeToString([]byte(value))
+		rec.ValueBase64 = &encoded
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+
+	return err
+}
+
+// jsonRecordReader returns a function that reads the next key/value pair in the JSONLines format.
+// The function returns io.EOF after the last record.
+func jsonRecordReader(r io.Reader) func() (string, string, error) {
+	dec := json.NewDecoder(r)
+
+	return func() (string, string, error) {
+		var rec jsonRecord
+		err := dec.Decode(&rec)
+		if err != nil {
+			return "", "", err
+		}
+
+		key, err := decodeJSONField(rec.Key, rec.KeyBase64)
+		if err != nil {
+			return "", "", fmt.Errorf("key: %s", err)
+		}
+		value, err := decodeJSONField(rec.Value, rec.ValueBase64)
+		if err != nil {
+			return "", "", fmt.Errorf("value: %s", err)
+		}
+
+		return key, value, nil
+	}
+}
+
+// decodeJSONField returns the plain field or the decoded base64 field.
+// Return an error if none or both of them are set or if the base64 field cannot be decoded.
+func decodeJSONField(plain, encoded *string) (string, error) {
+	if (plain == nil) == (encoded == nil) {
+		return "", errInvalidRecord
+	}
+	if plain != nil {
+		return *plain, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(*encoded)
+	if err != nil {
+		return "", err
+	}
+
+	return string(decoded), nil
+}
+
+// csvRecordReader returns a function that reads the next key/value pair in the CSV format
+// skipping the header row if the given header is true.
+// The function returns io.EOF after the last record and an error if the header row is not key,value.
+func csvRecordReader(r io.Reader, header bool) func() (string, string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+
+	return func() (string, string, error) {
+		row, err := cr.Read()
+		if err != nil {
+			return "", "", err
+		}
+
+		if header {
+			header = false
+			if row[0] != csvHeader[0] || row[1] != csvHeader[1] {
+				return "", "", errInvalidHeader
+			}
+			row, err = cr.Read()
+			if err != nil {
+				return "", "", err
+			}
+		}
+
+		return row[0], row[1], nil
+	}
+}
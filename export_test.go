@@ -0,0 +1,98 @@
+package bitcask
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []Format{JSONLines, CSV} {
+		t.Run(fmt.Sprintf("export and import format %d", format), func(t *testing.T) {
+			mem := vfs.NewMem()
+			src, _ := OpenWith("src", ReadWrite, WithFS(mem))
+			for i := 0; i < 100; i++ {
+				src.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value, \"%d\"\n", i+1))
+			}
+			if format == JSONLines {
+				src.Put("binary\xff", "\x00\xfe\xff")
+			}
+
+			var buf bytes.Buffer
+			err := src.Export(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dst, _ := OpenWith("dst", ReadWrite, WithFS(mem))
+			batches := 0
+			p, err := dst.Import(&buf, format, WithBatchSize(10), WithProgress(func(ImportProgress) {
+				batches++
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := src.ListKeys()
+			if p.Read != len(keys) || p.Written != len(keys) || batches != len(keys)/10+1 {
+				t.Errorf("got progress %+v after %d batches for %d keys", p, batches, len(keys))
+			}
+			for _, key := range keys {
+				want, _ := src.Get(key)
+				got, _ := dst.Get(key)
+				assertString(t, got, want)
+			}
+			src.Close()
+			dst.Close()
+		})
+	}
+
+	t.Run("import skipping existing keys", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+		b.Put("key1", "old")
+
+		data := `{"key":"key1","value":"new"}` + "\n" + `{"key":"key2","value":"new"}` + "\n"
+		p, err := b.Import(bytes.NewBufferString(data), JSONLines, SkipExisting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Skipped != 1 || p.Written != 1 {
+			t.Errorf("got progress %+v", p)
+		}
+
+		got, _ := b.Get("key1")
+		assertString(t, got, "old")
+		got, _ = b.Get("key2")
+		assertString(t, got, "new")
+	})
+
+	t.Run("import invalid record", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+
+		_, err := b.Import(bytes.NewBufferString(`{"key":"key1"}`), JSONLines)
+		assertError(t, err, "Import: record 1: value: invalid record")
+	})
+
+	t.Run("import csv without a header", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+
+		data := "key,value\nkey2,value2\n"
+		p, err := b.Import(bytes.NewBufferString(data), CSV, WithCSVHeader(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Written != 2 {
+			t.Errorf("got progress %+v, want 2 written records", p)
+		}
+		got, _ := b.Get("key")
+		assertString(t, got, "value")
+
+		_, err = b.Import(bytes.NewBufferString("key1,value1\n"), CSV)
+		assertError(t, err, "Import: record 1: invalid header: expected key,value or import without a header")
+	})
+}
@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/IslamWalid/bitcask/internal/recfmt"
 	"github.com/IslamWalid/bitcask/internal/sio"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
 )
 
 const (
@@ -24,50 +27,138 @@ type (
 	// AppendType represents the type of the append file.
 	AppendType int
 
+	// BatchRec represents a data record written by WriteBatch.
+	BatchRec struct {
+		Key    string
+		Value  string
+		Tstamp int64
+	}
+
 	// AppendFile contains the metadata about the append file.
 	AppendFile struct {
+		fsys        vfs.FS
 		fileWrapper *sio.File
 		hintWrapper *sio.File
 		fileName    string
 		filePath    string
 		fileFlags   int
+		encoding    *recfmt.Encoding
 		appendType  AppendType
 		currentPos  int
 		currentSize int
+		syncs       atomic.Int64
 	}
 )
 
 // WriteData writes a data record to the given append file.
-// Return the position of the written data.
+// Return the keydir record describing the written data.
 // Return error on system failures.
-func (a *AppendFile) WriteData(key, value string, tstamp int64) (int, error) {
-	rec := recfmt.CompressDataFileRec(key, value, tstamp)
+func (a *AppendFile) WriteData(key, value string, tstamp int64) (recfmt.KeyDirRec, error) {
+	rec, err := recfmt.CompressDataFileRec(key, value, tstamp, a.encoding)
+	if err != nil {
+		return recfmt.KeyDirRec{}, err
+	}
 
 	if a.fileWrapper == nil || len(rec)+a.currentSize > maxFileSize {
 		err := a.newAppendFile()
 		if err != nil {
-			return 0, err
+			return recfmt.KeyDirRec{}, err
 		}
 	}
 
 	n, err := a.fileWrapper.Write(rec)
 	if err != nil {
-		return 0, err
+		return recfmt.KeyDirRec{}, err
 	}
 
 	writePos := a.currentPos
 	a.currentPos += n
 	a.currentSize += n
 
-	return writePos, nil
+	written, _, _ := recfmt.PeekDataFileRec(rec, recfmt.FileHeader{})
+
+	return recfmt.KeyDirRec{
+		FileId:    a.fileName,
+		ValuePos:  uint32(writePos),
+		KeySize:   written.KeySize,
+		ValueSize: written.ValueSize,
+		Tstamp:    tstamp,
+	}, nil
+}
+
+// WriteBatch writes the given data records to the append file with a single write, so they are not split
+// between two files. Several records are preceded by a batch mark holding their number, so a batch cut by a crash
+// is recognized and ignored when the file is read back, the records are not flushed to the disk.
+// The file is rotated if the write fails, so the next records do not follow the records of a partial batch.
+// Return the keydir records describing the written data in the order of the given records.
+// Return error on system failures.
+func (a *AppendFile) WriteBatch(recs []BatchRec) ([]recfmt.KeyDirRec, error) {
+	bufs := make([][]byte, 0, len(recs)+1)
+	size := 0
+	if len(recs) > 1 {
+		// the mark takes the timestamp of the first record, so it does not use a sequence number.
+		mark, err := recfmt.CompressDataFileRec(strconv.Itoa(len(recs)), BatchMark, recs[0].Tstamp, a.encoding)
+		if err != nil {
+			return nil, err
+		}
+		bufs = append(bufs, mark)
+		size += len(mark)
+	}
+	for _, rec := range recs {
+		buf, err := recfmt.CompressDataFileRec(rec.Key, rec.Value, rec.Tstamp, a.encoding)
+		if err != nil {
+			return nil, err
+		}
+		bufs = append(bufs, buf)
+		size += len(buf)
+	}
+
+	if a.fileWrapper == nil || size+a.currentSize > maxFileSize {
+		err := a.newAppendFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	batch := make([]byte, 0, size)
+	if len(bufs) > len(recs) {
+		batch = append(batch, bufs[0]...)
+		bufs = bufs[1:]
+	}
+	keyDirRecs := make([]recfmt.KeyDirRec, 0, len(recs))
+	for i, buf := range bufs {
+		written, _, _ := recfmt.PeekDataFileRec(buf, recfmt.FileHeader{})
+		keyDirRecs = append(keyDirRecs, recfmt.KeyDirRec{
+			FileId:    a.fileName,
+			ValuePos:  uint32(a.currentPos + len(batch)),
+			KeySize:   written.KeySize,
+			ValueSize: written.ValueSize,
+			Tstamp:    recs[i].Tstamp,
+		})
+		batch = append(batch, buf...)
+	}
+
+	n, err := a.fileWrapper.Write(batch)
+	if err != nil {
+		a.currentSize = maxFileSize
+		return nil, err
+	}
+	a.currentPos += n
+	a.currentSize += n
+
+	return keyDirRecs, nil
 }
 
 // WriteData writes a hint record to the hint file
 // associated with the given append file.
 // Return error on system failures.
 func (a *AppendFile) WriteHint(key string, rec recfmt.KeyDirRec) error {
-	buf := recfmt.CompressHintFileRec(key, rec)
-	_, err := a.hintWrapper.Write(buf)
+	buf, err := recfmt.CompressHintFileRec(key, rec, a.encoding)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.hintWrapper.Write(buf)
 	if err != nil {
 		return err
 	}
@@ -75,67 +166,140 @@ func (a *AppendFile) WriteHint(key string, rec recfmt.KeyDirRec) error {
 	return nil
 }
 
-// newAppendFile creates new append file.
+// newAppendFile creates new append file starting with the file header.
 // create a hint file associated with it if the file type is merge.
+// the previous files are flushed to the disk before being closed
+// and the datastore directory is flushed after the new files are created.
+// the new files are closed and removed if their headers cannot be written or the directory cannot be flushed,
+// so no file without a header is left to be taken for a legacy file.
 // return error on system failures.
 func (a *AppendFile) newAppendFile() error {
 	if a.fileWrapper != nil {
-		err := a.fileWrapper.File.Close()
+		err := a.closeFiles()
 		if err != nil {
 			return err
 		}
-		if a.appendType == Merge {
-			err := a.hintWrapper.File.Close()
-			if err != nil {
-				return err
-			}
-		}
+		a.fileWrapper = nil
+		a.hintWrapper = nil
 	}
 
 	tstamp := time.Now().UnixMicro()
 	fileName := fmt.Sprintf("%d.data", tstamp)
-	file, err := sio.OpenFile(path.Join(a.filePath, fileName), a.fileFlags, os.FileMode(0666))
+	file, err := sio.OpenFile(a.fsys, path.Join(a.filePath, fileName), a.fileFlags, os.FileMode(0666))
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(recfmt.CompressFileHdr(a.encoding))
 	if err != nil {
+		a.discardFile(file)
 		return err
 	}
 
+	var hint *sio.File
 	if a.appendType == Merge {
 		hintName := fmt.Sprintf("%d.hint", tstamp)
-		hint, err := sio.OpenFile(path.Join(a.filePath, hintName), a.fileFlags, os.FileMode(0666))
+		hint, err = sio.OpenFile(a.fsys, path.Join(a.filePath, hintName), a.fileFlags, os.FileMode(0666))
+		if err != nil {
+			a.discardFile(file)
+			return err
+		}
+		_, err = hint.Write(recfmt.CompressFileHdr(a.encoding))
 		if err != nil {
+			a.discardFile(hint)
+			a.discardFile(file)
 			return err
 		}
-		a.hintWrapper = hint
+	}
+
+	err = a.fsys.SyncDir(a.filePath)
+	if err != nil {
+		if hint != nil {
+			a.discardFile(hint)
+		}
+		a.discardFile(file)
+		return err
 	}
 
 	a.fileWrapper = file
+	a.hintWrapper = hint
 	a.fileName = fileName
-	a.currentPos = 0
-	a.currentSize = 0
+	a.currentPos = recfmt.FileHdr
+	a.currentSize = recfmt.FileHdr
 
 	return nil
 }
 
+// discardFile closes and removes a file created by newAppendFile that cannot be used.
+func (a *AppendFile) discardFile(f *sio.File) {
+	f.File.Close()
+	a.fsys.Remove(f.File.Name())
+}
+
+// closeFiles flushes the append file and its associated hint file to the disk then closes them.
+// return error on system failures.
+func (a *AppendFile) closeFiles() error {
+	err := a.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = a.fileWrapper.File.Close()
+	if err != nil {
+		return err
+	}
+	if a.appendType == Merge {
+		err := a.hintWrapper.File.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create creates the append file and its associated hint file if they were not created by a write yet.
+// Return error on system failures.
+func (a *AppendFile) Create() error {
+	if a.fileWrapper != nil {
+		return nil
+	}
+
+	return a.newAppendFile()
+}
+
 // Name returns the name of the append file.
 func (a *AppendFile) Name() string {
 	return a.fileName
 }
 
-// Sync flushes the data written to the append file to the disk.
+// Sync flushes the data written to the append file and its associated hint file to the disk.
 func (a *AppendFile) Sync() error {
 	if a.fileWrapper != nil {
-		return a.fileWrapper.File.Sync()
+		a.syncs.Add(1)
+		err := a.fileWrapper.File.Sync()
+		if err != nil {
+			return err
+		}
+		if a.appendType == Merge {
+			a.syncs.Add(1)
+			return a.hintWrapper.File.Sync()
+		}
 	}
 
 	return nil
 }
 
-// Close closes the append file and its associated hint file if exists.
-func (a *AppendFile) Close() {
+// Syncs returns the number of times the append file and its associated hint file were flushed to the disk.
+func (a *AppendFile) Syncs() int64 {
+	return a.syncs.Load()
+}
+
+// Close flushes the append file and its associated hint file if exists to the disk then closes them.
+func (a *AppendFile) Close() error {
 	if a.fileWrapper != nil {
-		a.fileWrapper.File.Close()
-		if a.appendType == Merge {
-			a.hintWrapper.File.Close()
-		}
+		return a.closeFiles()
 	}
+
+	return nil
 }
@@ -0,0 +1,274 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+// testDir is the datastore directory used by the crash tests.
+const testDir = "datastore"
+
+// errFault is returned by the failing operations of faultFS.
+var errFault = errors.New("injected fault")
+
+type (
+	// faultFS is an in-memory filesystem failing the writes to the files with a suffix
+	// and the directory flushes if asked, it counts the files left open.
+	faultFS struct {
+		*vfs.Mem
+		writeFails   string
+		syncDirFails bool
+		open         int
+	}
+
+	// faultFile is a file opened by faultFS.
+	faultFile struct {
+		vfs.File
+		fsys *faultFS
+	}
+)
+
+// OpenFile implements FS.OpenFile.
+func (f *faultFS) OpenFile(name string, flag int, perm fs.FileMode) (vfs.File, error) {
+	file, err := f.Mem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	f.open++
+
+	return &faultFile{File: file, fsys: f}, nil
+}
+
+// SyncDir implements FS.SyncDir.
+func (f *faultFS) SyncDir(name string) error {
+	if f.syncDirFails {
+		return errFault
+	}
+
+	return f.Mem.SyncDir(name)
+}
+
+// Write implements File.Write.
+func (f *faultFile) Write(b []byte) (int, error) {
+	if f.fsys.writeFails != "" && strings.HasSuffix(f.Name(), f.fsys.writeFails) {
+		return 0, errFault
+	}
+
+	return f.File.Write(b)
+}
+
+// Close implements File.Close.
+func (f *faultFile) Close() error {
+	err := f.File.Close()
+	if err == nil {
+		f.fsys.open--
+	}
+
+	return err
+}
+
+// newCrashFS creates an in-memory filesystem containing an empty datastore directory.
+func newCrashFS(t *testing.T) *vfs.Mem {
+	t.Helper()
+	mem := vfs.NewMem()
+	err := mem.MkdirAll(testDir, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mem
+}
+
+// survivedKeys parses all the data files in the datastore directory of the given filesystem
+// and returns the keys found in them.
+func survivedKeys(t *testing.T, fsys vfs.FS) map[string]bool {
+	t.Helper()
+	keys := make(map[string]bool)
+
+	entries, err := fsys.ReadDir(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".data") {
+			continue
+		}
+		data, err := fsys.ReadFile(path.Join(testDir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, i, err := recfmt.ExtractFileHdr(data)
+		if err != nil {
+			t.Fatalf("%s: %v", entry.Name(), err)
+		}
+		for i < len(data) {
+			rec, n, err := recfmt.ExtractDataFileRec(data[i:], hdr, nil)
+			if err != nil {
+				t.Fatalf("%s: offset %d: %v", entry.Name(), i, err)
+			}
+			keys[rec.Key] = true
+			i += int(n)
+		}
+	}
+
+	return keys
+}
+
+func TestCrashDurability(t *testing.T) {
+	value := strings.Repeat("v", 500)
+
+	t.Run("synced writes survive a crash across rollovers", func(t *testing.T) {
+		mem := newCrashFS(t)
+		a := NewAppendFile(mem, testDir, os.O_CREATE|os.O_RDWR, Active, nil)
+		defer a.Close()
+
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key%d", i)
+			_, err := a.WriteData(key, value, int64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = a.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := survivedKeys(t, mem.Crash())
+			for j := 0; j <= i; j++ {
+				if !keys[fmt.Sprintf("key%d", j)] {
+					t.Fatalf("crash after writing key%d lost acknowledged key%d", i, j)
+				}
+			}
+		}
+	})
+
+	t.Run("rolled over files survive a crash without explicit sync", func(t *testing.T) {
+		mem := newCrashFS(t)
+		a := NewAppendFile(mem, testDir, os.O_CREATE|os.O_RDWR, Merge, nil)
+		defer a.Close()
+
+		n := 100
+		for i := 0; i < n; i++ {
+			_, err := a.WriteData(fmt.Sprintf("key%d", i), value, int64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		keys := survivedKeys(t, mem.Crash())
+		data, err := mem.ReadFile(path.Join(testDir, a.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		unsynced := make(map[string]bool)
+		for i := recfmt.FileHdr; i < len(data); {
+			rec, recLen, _ := recfmt.ExtractDataFileRec(data[i:], recfmt.FileHeader{}, nil)
+			unsynced[rec.Key] = true
+			i += int(recLen)
+		}
+
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if !keys[key] && !unsynced[key] {
+				t.Fatalf("%s was written to a rolled over file but lost after crash", key)
+			}
+		}
+	})
+
+	t.Run("removed files do not come back after a crash", func(t *testing.T) {
+		mem := newCrashFS(t)
+		a := NewAppendFile(mem, testDir, os.O_CREATE|os.O_RDWR, Active, nil)
+
+		for i := 0; i < 50; i++ {
+			_, err := a.WriteData(fmt.Sprintf("key%d", i), value, int64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		a.Close()
+
+		entries, err := mem.ReadDir(testDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := make([]string, 0)
+		for _, entry := range entries {
+			files = append(files, entry.Name())
+		}
+
+		d := &DataStore{fsys: mem, path: testDir}
+		err = d.RemoveFiles(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := survivedKeys(t, mem.Crash())
+		if len(keys) != 0 {
+			t.Errorf("expected no keys after removing all files, found %d", len(keys))
+		}
+	})
+}
+
+func TestAppendFileFailures(t *testing.T) {
+	testCases := []struct {
+		name       string
+		appendType AppendType
+		writeFails string
+	}{
+		{name: "active file header", appendType: Active, writeFails: ".data"},
+		{name: "merge file header", appendType: Merge, writeFails: ".data"},
+		{name: "hint file header", appendType: Merge, writeFails: ".hint"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := &faultFS{Mem: newCrashFS(t), writeFails: tc.writeFails}
+			a := NewAppendFile(fsys, testDir, os.O_CREATE|os.O_RDWR, tc.appendType, nil)
+
+			err := a.Create()
+			if !errors.Is(err, errFault) {
+				t.Fatalf("expected %v, got %v", errFault, err)
+			}
+			entries, _ := fsys.ReadDir(testDir)
+			if len(entries) != 0 || fsys.open != 0 {
+				t.Errorf("got %d files and %d open files after the failure, want none", len(entries), fsys.open)
+			}
+		})
+	}
+}
+
+func TestAppendFileRotationFailure(t *testing.T) {
+	fsys := &faultFS{Mem: newCrashFS(t)}
+	a := NewAppendFile(fsys, testDir, os.O_CREATE|os.O_RDWR, Merge, nil)
+	err := a.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys.syncDirFails = true
+	value := strings.Repeat("v", 500)
+	for i := 0; err == nil; i++ {
+		_, err = a.WriteData(fmt.Sprintf("key%d", i), value, int64(i))
+	}
+	if !errors.Is(err, errFault) {
+		t.Fatalf("expected %v, got %v", errFault, err)
+	}
+	if fsys.open != 0 {
+		t.Errorf("got %d open files after the failed rotation, want none", fsys.open)
+	}
+
+	// the old files were closed by the rotation, so they are not closed again.
+	err = a.Close()
+	if err != nil {
+		t.Errorf("expected no error when closing after the failed rotation, got %v", err)
+	}
+}
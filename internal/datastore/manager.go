@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 
 	"github.com/IslamWalid/bitcask/internal/recfmt"
 	"github.com/IslamWalid/bitcask/internal/sio"
-	"github.com/gofrs/flock"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
 )
 
 const (
@@ -20,6 +22,9 @@ const (
 
 	// TompStone is a special value to mark the deleted values.
 	TompStone = "8890fc70294d02dbde257989e802451c2276be7fb177c3ca4399dc4728e4e1e0"
+	// BatchMark is a special value of the record written before the records of a batch,
+	// the key of the record is the number of the records of the batch.
+	BatchMark = "43e60e475a629b8e7c94e3ab0f40fef1e8cc880546026fb3a700af3dc97f56fd"
 
 	// lockFile is the name of the file used to lock the datastore directory.
 	lockFile = ".lck"
@@ -38,29 +43,42 @@ type (
 	// LockMode represents the lock mode of the directory.
 	LockMode int
 
+	// Usage describes the number and size of the data and hint files of the datastore.
+	Usage struct {
+		DataFiles int
+		HintFiles int
+		DataBytes int64
+		HintBytes int64
+	}
+
 	// DataStore represents and contains the metadata of the datastore directory.
 	DataStore struct {
-		path string
-		lock LockMode
-		flck *flock.Flock
+		fsys     vfs.FS
+		path     string
+		lock     LockMode
+		flck     vfs.Locker
+		encoding *recfmt.Encoding
 	}
 )
 
-// NewDataStore creates new datastore object with the given path and lock mode.
+// NewDataStore creates new datastore object with the given path and lock mode
+// on the given filesystem, the records are read with the given encoding.
 // Return an error on system failures or when access to the directory is denied.
-func NewDataStore(dataStorePath string, lock LockMode) (*DataStore, error) {
+func NewDataStore(fsys vfs.FS, dataStorePath string, lock LockMode, enc *recfmt.Encoding) (*DataStore, error) {
 	d := &DataStore{
-		path: dataStorePath,
-		lock: lock,
+		fsys:     fsys,
+		path:     dataStorePath,
+		lock:     lock,
+		encoding: enc,
 	}
 
-	dir, errDir := os.Open(dataStorePath)
+	dir, errDir := fsys.OpenFile(dataStorePath, os.O_RDONLY, 0)
 	if errDir != nil && !os.IsNotExist(errDir) {
 		return nil, errDir
 	}
-	defer dir.Close()
 
 	if errDir == nil {
+		dir.Close()
 		acquired, err := d.openDataStoreDir()
 		if err != nil {
 			return nil, err
@@ -80,11 +98,14 @@ func NewDataStore(dataStorePath string, lock LockMode) (*DataStore, error) {
 	return d, nil
 }
 
-// NewAppendFile creates new append files object with the given path, flags and type.
-func NewAppendFile(dataStorePath string, fileFlags int, appendType AppendType) *AppendFile {
+// NewAppendFile creates new append files object with the given filesystem, path, flags and type.
+// The values of the written records are encoded with the given encoding.
+func NewAppendFile(fsys vfs.FS, dataStorePath string, fileFlags int, appendType AppendType, enc *recfmt.Encoding) *AppendFile {
 	a := &AppendFile{
+		fsys:       fsys,
 		filePath:   dataStorePath,
 		fileFlags:  fileFlags,
+		encoding:   enc,
 		appendType: appendType,
 	}
 
@@ -93,8 +114,14 @@ func NewAppendFile(dataStorePath string, fileFlags int, appendType AppendType) *
 
 // createDataStoreDir creates a new directory to be a datastore directory
 // and acquires the necessary lock.
+// the parent directory is flushed to make the new directory durable.
 func (d *DataStore) createDataStoreDir() error {
-	err := os.MkdirAll(d.path, os.FileMode(0777))
+	err := d.fsys.MkdirAll(d.path, os.FileMode(0777))
+	if err != nil {
+		return err
+	}
+
+	err = d.fsys.SyncDir(path.Dir(path.Clean(d.path)))
 	if err != nil {
 		return err
 	}
@@ -125,39 +152,38 @@ func (d *DataStore) openDataStoreDir() (bool, error) {
 // return true if it managed to acquire the lock, and false otherwise.
 // return error on system failures.
 func (d *DataStore) acquireFileLock() (bool, error) {
-	var err error
-	var ok bool
-
-	d.flck = flock.New(path.Join(d.path, lockFile))
-	switch d.lock {
-	case ExclusiveLock:
-		ok, err = d.flck.TryLock()
-	case SharedLock:
-		ok, err = d.flck.TryRLock()
-	}
-
+	flck, ok, err := d.fsys.TryLock(path.Join(d.path, lockFile), d.lock == ExclusiveLock)
 	if err != nil {
 		return false, err
 	}
+	d.flck = flck
 
 	return ok, nil
 }
 
-// ReadValueFromFile parses the valued corresponding to the given key.
+// ReadValueFromFile parses the value of the data file record the given keydir record points at.
+// The file header is read first to know whether the record is encrypted.
 // Return the parsed value and a non-nil error if values is not exist
 // or on system failures.
-func (d *DataStore) ReadValueFromFile(fileId, key string, valuePos, valueSize uint32) (string, error) {
-	bufsz := recfmt.DataFileRecHdr + uint32(len(key)) + valueSize
+func (d *DataStore) ReadValueFromFile(rec recfmt.KeyDirRec) (string, error) {
+	bufsz := recfmt.DataFileRecHdr + uint32(rec.KeySize) + rec.ValueSize
 	buf := make([]byte, bufsz)
 
-	f, err := sio.Open(path.Join(d.path, fileId))
+	f, err := sio.Open(d.fsys, path.Join(d.path, rec.FileId))
 	if err != nil {
 		return "", err
 	}
 	defer f.File.Close()
 
-	f.ReadAt(buf, int64(valuePos))
-	data, _, err := recfmt.ExtractDataFileRec(buf)
+	hdrBuf := make([]byte, recfmt.FileHdr)
+	f.ReadAt(hdrBuf, 0)
+	hdr, _, err := recfmt.ExtractFileHdr(hdrBuf)
+	if err != nil {
+		return "", err
+	}
+
+	f.ReadAt(buf, int64(rec.ValuePos))
+	data, _, err := recfmt.ExtractDataFileRec(buf, hdr, d.encoding)
 	if err != nil {
 		return "", err
 	}
@@ -169,6 +195,91 @@ func (d *DataStore) ReadValueFromFile(fileId, key string, valuePos, valueSize ui
 	return data.Value, nil
 }
 
+// IsReserved reports whether the value is one of the special values marking the records of the data files,
+// which cannot be stored as they would be read back as deletions or batch marks.
+func IsReserved(value string) bool {
+	return value == TompStone || value == BatchMark
+}
+
+// BatchLen returns the number of the records of the batch started by the given record.
+// Return false if the record is not a batch mark.
+func BatchLen(rec *recfmt.DataRec) (int, bool) {
+	if rec.Value != BatchMark {
+		return 0, false
+	}
+	n, err := strconv.Atoi(rec.Key)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// BatchComplete reports whether the data file content holds all the n records of the batch
+// starting at the given offset, the records are not decoded.
+// A batch is only incomplete at the end of the file it was being written to when the process crashed.
+func BatchComplete(data []byte, i, n int) bool {
+	for ; n > 0; n-- {
+		_, recLen, ok := recfmt.PeekDataFileRec(data[i:], recfmt.FileHeader{})
+		if !ok {
+			return false
+		}
+		i += int(recLen)
+	}
+
+	return true
+}
+
+// RemoveFiles deletes the given files from the datastore directory
+// then flushes the directory to make the deletion durable.
+// Return error on system failures.
+func (d *DataStore) RemoveFiles(files []string) error {
+	for _, file := range files {
+		err := d.fsys.Remove(path.Join(d.path, file))
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.fsys.SyncDir(d.path)
+}
+
+// DiskUsage returns the number and size of the data and hint files in the datastore directory.
+// Return error on system failures.
+func (d *DataStore) DiskUsage() (Usage, error) {
+	u := Usage{}
+	entries, err := d.fsys.ReadDir(d.path)
+	if err != nil {
+		return u, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".data") && !strings.HasSuffix(name, ".hint") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return u, err
+		}
+		if strings.HasSuffix(name, ".data") {
+			u.DataFiles++
+			u.DataBytes += info.Size()
+		} else {
+			u.HintFiles++
+			u.HintBytes += info.Size()
+		}
+	}
+
+	return u, nil
+}
+
+// FS returns the filesystem of the datastore directory.
+func (d *DataStore) FS() vfs.FS {
+	return d.fsys
+}
+
 // Path returns the path of the datastore directory.
 func (d *DataStore) Path() string {
 	return d.path
@@ -0,0 +1,106 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/internal/sio"
+)
+
+// migrateSuffix is the suffix of the temporary files written while migrating legacy data files.
+const migrateSuffix = ".migrate"
+
+// Migrate rewrites the data files written in the legacy format without a file header in the current format.
+// The migrated files keep their names and the timestamps of their records, each one is written to a temporary
+// file that replaces it once flushed, so a migration interrupted by a crash is resumed by the next one.
+// The legacy hint files are removed after their data files are migrated, so the keydir is built from the data files.
+// Return the number of migrated data files.
+// Return an error if a legacy record is corrupted or on system failures.
+func (d *DataStore) Migrate() (int, error) {
+	entries, err := d.fsys.ReadDir(d.path)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	legacyHints := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasPrefix(name, ".") && strings.HasSuffix(name, migrateSuffix):
+			err := d.fsys.Remove(path.Join(d.path, name))
+			if err != nil {
+				return 0, err
+			}
+		case strings.HasSuffix(name, ".data"):
+			data, err := d.fsys.ReadFile(path.Join(d.path, name))
+			if err != nil {
+				return 0, err
+			}
+			if _, _, err := recfmt.ExtractFileHdr(data); !errors.Is(err, recfmt.ErrLegacyFormat) {
+				continue
+			}
+			err = d.migrateDataFile(name, data)
+			if err != nil {
+				return 0, err
+			}
+			migrated++
+		case strings.HasSuffix(name, ".hint"):
+			data, err := d.fsys.ReadFile(path.Join(d.path, name))
+			if err != nil {
+				return 0, err
+			}
+			if _, _, err := recfmt.ExtractFileHdr(data); errors.Is(err, recfmt.ErrLegacyFormat) {
+				legacyHints = append(legacyHints, name)
+			}
+		}
+	}
+
+	if migrated == 0 && len(legacyHints) == 0 {
+		return 0, nil
+	}
+
+	return migrated, d.RemoveFiles(legacyHints)
+}
+
+// migrateDataFile rewrites the records of the given legacy data file content in the current format
+// into a temporary file then renames it over the data file.
+// Return an error if a legacy record is corrupted or on system failures.
+func (d *DataStore) migrateDataFile(name string, data []byte) error {
+	tmpPath := path.Join(d.path, "."+name+migrateSuffix)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	tmp, err := sio.OpenFile(d.fsys, tmpPath, flags, os.FileMode(0666))
+	if err != nil {
+		return err
+	}
+	defer tmp.File.Close()
+
+	buf := recfmt.CompressFileHdr(d.encoding)
+	for i := 0; i < len(data); {
+		rec, recLen, err := recfmt.ExtractLegacyDataFileRec(data[i:])
+		if err != nil {
+			return fmt.Errorf("%s: offset %d: %s", name, i, err)
+		}
+		migratedRec, err := recfmt.CompressDataFileRec(rec.Key, rec.Value, rec.Tstamp, d.encoding)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, migratedRec...)
+		i += int(recLen)
+	}
+
+	_, err = tmp.Write(buf)
+	if err != nil {
+		return err
+	}
+	err = tmp.File.Sync()
+	if err != nil {
+		return err
+	}
+
+	return d.fsys.Rename(tmpPath, path.Join(d.path, name))
+}
@@ -0,0 +1,336 @@
+// Package inspect provides functionality to decode the raw records of datastore files for debugging.
+package inspect
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/IslamWalid/bitcask/internal/datastore"
+	"github.com/IslamWalid/bitcask/internal/keydir"
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+const (
+	// DataFile is the type of data files.
+	DataFile = "data"
+	// HintFile is the type of hint files.
+	HintFile = "hint"
+	// KeyDirFile is the type of the shared keydir file.
+	KeyDirFile = "keydir"
+
+	// CRCValid marks the data file records that match their checksum.
+	CRCValid = "ok"
+	// CRCInvalid marks the data file records that do not match their checksum.
+	CRCInvalid = "bad"
+	// CRCNone marks the records of hint and keydir files that have no checksum.
+	CRCNone = "-"
+)
+
+// errNotDataStoreFile happens whenever the inspected file is not a data, hint or keydir file.
+var errNotDataStoreFile = errors.New("not a datastore file")
+
+type (
+	// Record describes a raw record found in a datastore file.
+	// Live specifies whether the keydir of the datastore still points at the record.
+	// Err describes why the record could not be decoded, the fields that could not be read are left empty.
+	Record struct {
+		File      string `json:"file"`
+		Offset    int64  `json:"offset"`
+		Length    int    `json:"length"`
+		CRC       string `json:"crc"`
+		Tstamp    int64  `json:"tstamp"`
+		KeySize   int    `json:"key_size"`
+		ValueSize int    `json:"value_size"`
+		Key       string `json:"key"`
+		Tombstone bool   `json:"tombstone"`
+		Live      bool   `json:"live"`
+		Err       string `json:"error,omitempty"`
+	}
+
+	// FileSummary describes the records of a datastore file.
+	// LiveBytes is the size of the records the keydir still points at and DeadBytes is the size of the rest.
+	FileSummary struct {
+		File      string `json:"file"`
+		Type      string `json:"type"`
+		Records   int    `json:"records"`
+		Corrupted int    `json:"corrupted"`
+		LiveBytes int64  `json:"live_bytes"`
+		DeadBytes int64  `json:"dead_bytes"`
+	}
+
+	// Result contains the inspected records and the summary of every inspected file.
+	Result struct {
+		Records []Record      `json:"records"`
+		Files   []FileSummary `json:"files"`
+	}
+)
+
+// Inspect decodes all the records of the given file in the datastore directory,
+// or of all the data, hint and keydir files if the name is empty.
+// The keydir used to decide whether records are live is built like Open does,
+// if it cannot be built because of corrupted files the latest readable data file record of every key is considered live.
+// The datastore is not locked, so it can be inspected while a writer is using it.
+// Return an error if the given file is not a datastore file or on any system failures.
+func Inspect(fsys vfs.FS, dataStorePath, name string, enc *recfmt.Encoding) (*Result, error) {
+	names, err := listFiles(fsys, dataStorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDir, _, err := keydir.New(fsys, dataStorePath, keydir.PrivateKeyDir, enc)
+	if err != nil {
+		keyDir, err = latestRecords(fsys, dataStorePath, names, enc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if name != "" {
+		name = path.Base(name)
+		if fileType(name) == "" {
+			return nil, fmt.Errorf("%s: %s", name, errNotDataStoreFile)
+		}
+		names = []string{name}
+	}
+
+	res := &Result{Records: make([]Record, 0), Files: make([]FileSummary, 0)}
+	for _, name := range names {
+		data, err := fsys.ReadFile(path.Join(dataStorePath, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var recs []Record
+		summary := FileSummary{File: name, Type: fileType(name)}
+		hdr, hdrLen, err := recfmt.ExtractFileHdr(data)
+		switch {
+		case err != nil:
+			recs = []Record{{File: name, Length: len(data), CRC: CRCNone, Err: err.Error()}}
+		case summary.Type == DataFile:
+			recs = inspectDataFile(name, data, hdr, hdrLen, keyDir, enc)
+		case summary.Type == HintFile:
+			recs = inspectHintFile(name, data, hdr, hdrLen, keyDir, enc)
+		default:
+			recs = inspectKeyDirFile(name, data, hdr, hdrLen, keyDir, enc)
+		}
+
+		for _, rec := range recs {
+			summary.Records++
+			if rec.Err != "" {
+				summary.Corrupted++
+			}
+			if rec.Live {
+				summary.LiveBytes += int64(rec.Length)
+			} else {
+				summary.DeadBytes += int64(rec.Length)
+			}
+		}
+
+		res.Records = append(res.Records, recs...)
+		res.Files = append(res.Files, summary)
+	}
+
+	return res, nil
+}
+
+// listFiles returns the sorted names of the data, hint and keydir files in the datastore directory.
+// Return an error on system failures.
+func listFiles(fsys vfs.FS, dataStorePath string) ([]string, error) {
+	entries, err := fsys.ReadDir(dataStorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, entry := range entries {
+		if fileType(entry.Name()) != "" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// fileType returns the type of the given datastore file or an empty string if it is not a datastore file.
+func fileType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".data"):
+		return DataFile
+	case strings.HasSuffix(name, ".hint"):
+		return HintFile
+	case name == keydir.FileName:
+		return KeyDirFile
+	}
+
+	return ""
+}
+
+// latestRecords builds a keydir pointing at the latest readable data file record of every key,
+// skipping the corrupted records and dropping the deleted keys.
+// Return an error on system failures.
+func latestRecords(fsys vfs.FS, dataStorePath string, names []string, enc *recfmt.Encoding) (keydir.KeyDir, error) {
+	k := keydir.KeyDir{}
+	deleted := make(map[string]int64)
+
+	for _, name := range names {
+		if fileType(name) != DataFile {
+			continue
+		}
+
+		data, err := fsys.ReadFile(path.Join(dataStorePath, name))
+		if err != nil {
+			return nil, err
+		}
+		hdr, i, err := recfmt.ExtractFileHdr(data)
+		if err != nil {
+			continue
+		}
+
+		for i < len(data) {
+			rec, recLen, err := recfmt.ExtractDataFileRec(data[i:], hdr, enc)
+			if err != nil {
+				i += recfmt.NextDataFileRec(data[i:], hdr)
+				continue
+			}
+			if count, ok := datastore.BatchLen(rec); ok {
+				if !datastore.BatchComplete(data, i+int(recLen), count) {
+					break
+				}
+				i += int(recLen)
+				continue
+			}
+
+			if rec.Value == datastore.TompStone {
+				if deleted[rec.Key] < rec.Tstamp {
+					deleted[rec.Key] = rec.Tstamp
+				}
+			} else if old, isExist := k[rec.Key]; !isExist || old.Tstamp < rec.Tstamp {
+				k[rec.Key] = recfmt.KeyDirRec{FileId: name, ValuePos: uint32(i), Tstamp: rec.Tstamp}
+			}
+			i += int(recLen)
+		}
+	}
+
+	for key, tstamp := range deleted {
+		if rec, isExist := k[key]; isExist && rec.Tstamp < tstamp {
+			delete(k, key)
+		}
+	}
+
+	return k, nil
+}
+
+// inspectDataFile decodes the records of the given data file content
+// described by the given file header, starting at the given offset after it.
+// Corrupted records are described by the fields of their header if it can be read,
+// and cover the bytes skipped until the next readable record.
+func inspectDataFile(name string, data []byte, hdr recfmt.FileHeader, start int, keyDir keydir.KeyDir, enc *recfmt.Encoding) []Record {
+	recs := make([]Record, 0)
+
+	for i := start; i < len(data); {
+		r := Record{File: name, Offset: int64(i), CRC: CRCInvalid}
+		if recfmt.ValidCheckSum(data[i:]) {
+			r.CRC = CRCValid
+		}
+
+		rec, recLen, err := recfmt.ExtractDataFileRec(data[i:], hdr, enc)
+		if err != nil {
+			if peeked, _, ok := recfmt.PeekDataFileRec(data[i:], hdr); ok {
+				rec = peeked
+			}
+			r.Err = err.Error()
+			recLen = uint32(recfmt.NextDataFileRec(data[i:], hdr))
+		}
+
+		r.Length = int(recLen)
+		if rec != nil {
+			r.Tstamp = rec.Tstamp
+			r.KeySize = int(rec.KeySize)
+			r.ValueSize = int(rec.ValueSize)
+			r.Key = rec.Key
+		}
+		if err == nil {
+			r.Tombstone = rec.Value == datastore.TompStone
+			kRec, isExist := keyDir[rec.Key]
+			r.Live = isExist && kRec.FileId == name && kRec.ValuePos == uint32(i)
+		}
+
+		recs = append(recs, r)
+		i += int(recLen)
+	}
+
+	return recs
+}
+
+// inspectHintFile decodes the records of the given hint file content
+// described by the given file header, starting at the given offset after it.
+// Decoding stops at the first corrupted record which covers the rest of the file.
+func inspectHintFile(name string, data []byte, hdr recfmt.FileHeader, start int, keyDir keydir.KeyDir, enc *recfmt.Encoding) []Record {
+	recs := make([]Record, 0)
+	dataFile := strings.TrimSuffix(name, ".hint") + ".data"
+
+	for i := start; i < len(data); {
+		key, rec, recLen, err := recfmt.ExtractHintFileRec(data[i:], hdr, enc)
+		if err != nil {
+			recs = append(recs, Record{File: name, Offset: int64(i), Length: len(data) - i, CRC: CRCNone, Err: err.Error()})
+			break
+		}
+
+		kRec, isExist := keyDir[key]
+		recs = append(recs, Record{
+			File:      name,
+			Offset:    int64(i),
+			Length:    recLen,
+			CRC:       CRCNone,
+			Tstamp:    rec.Tstamp,
+			KeySize:   len(key),
+			ValueSize: int(rec.ValueSize),
+			Key:       key,
+			Live:      isExist && kRec.FileId == dataFile && kRec.ValuePos == rec.ValuePos,
+		})
+		i += recLen
+	}
+
+	return recs
+}
+
+// inspectKeyDirFile decodes the records of the given keydir file content
+// described by the given file header, starting at the given offset after it
+// and after the latest datastore timestamp written in the file.
+// Decoding stops at the first corrupted record which covers the rest of the file.
+func inspectKeyDirFile(name string, data []byte, hdr recfmt.FileHeader, start int, keyDir keydir.KeyDir, enc *recfmt.Encoding) []Record {
+	recs := make([]Record, 0)
+
+	if _, err := recfmt.ExtractKeyDirFileTstamp(data[start:]); err != nil {
+		return append(recs, Record{File: name, Offset: int64(start), Length: len(data) - start, CRC: CRCNone, Err: err.Error()})
+	}
+
+	for i := start + recfmt.KeyDirFileTstamp; i < len(data); {
+		key, rec, recLen, err := recfmt.ExtractKeyDirRec(data[i:], hdr, enc)
+		if err != nil {
+			recs = append(recs, Record{File: name, Offset: int64(i), Length: len(data) - i, CRC: CRCNone, Err: err.Error()})
+			break
+		}
+
+		kRec, isExist := keyDir[key]
+		recs = append(recs, Record{
+			File:      name,
+			Offset:    int64(i),
+			Length:    recLen,
+			CRC:       CRCNone,
+			Tstamp:    rec.Tstamp,
+			KeySize:   len(key),
+			ValueSize: int(rec.ValueSize),
+			Key:       key,
+			Live:      isExist && kRec.FileId == rec.FileId && kRec.ValuePos == rec.ValuePos,
+		})
+		i += recLen
+	}
+
+	return recs
+}
@@ -0,0 +1,55 @@
+package inspect
+
+import (
+	"testing"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+// testDir is the datastore directory used by the inspect tests.
+const testDir = "datastore"
+
+func TestInspect(t *testing.T) {
+	mem := vfs.NewMem()
+	b, err := bitcask.OpenWith(testDir, bitcask.ReadWrite, bitcask.WithFS(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Put("key1", "value1")
+	b.Put("key2", "value2")
+	b.Put("key1", "value3")
+	b.Delete("key2")
+	b.Close()
+
+	res, err := Inspect(mem, testDir, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Files) != 1 || len(res.Records) != 4 {
+		t.Fatalf("expected 4 records in 1 file, found %d records in %d files", len(res.Records), len(res.Files))
+	}
+
+	wantLive := []bool{false, false, true, false}
+	wantTombstone := []bool{false, false, false, true}
+	var liveBytes, deadBytes int64
+	for i, rec := range res.Records {
+		if rec.CRC != CRCValid || rec.Err != "" {
+			t.Errorf("record %d: expected a valid record, got crc %s and error %q", i, rec.CRC, rec.Err)
+		}
+		if rec.Live != wantLive[i] || rec.Tombstone != wantTombstone[i] {
+			t.Errorf("record %d: got live %t and tombstone %t, want live %t and tombstone %t",
+				i, rec.Live, rec.Tombstone, wantLive[i], wantTombstone[i])
+		}
+		if rec.Live {
+			liveBytes += int64(rec.Length)
+		} else {
+			deadBytes += int64(rec.Length)
+		}
+	}
+
+	if got := res.Files[0]; got.LiveBytes != liveBytes || got.DeadBytes != deadBytes {
+		t.Errorf("got live bytes %d and dead bytes %d, want %d and %d", got.LiveBytes, got.DeadBytes, liveBytes, deadBytes)
+	}
+}
@@ -2,13 +2,16 @@
 package keydir
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"strings"
 
+	"github.com/IslamWalid/bitcask/internal/datastore"
 	"github.com/IslamWalid/bitcask/internal/recfmt"
 	"github.com/IslamWalid/bitcask/internal/sio"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
 )
 
 const (
@@ -18,8 +21,11 @@ const (
 	// available writers to used it instead of parsing the whole datastore files.
 	SharedKeyDir KeyDirPrivacy = 1
 
-	// keyDirFile is the name of the file used to share the keydir map.
-	keyDirFile = "keydir"
+	// FileName is the name of the file used to share the keydir map.
+	FileName = "keydir"
+
+	// entryOverhead is the estimated memory used by a keydir entry in addition to its key.
+	entryOverhead = 80
 
 	// data represents that the file is a data file.
 	data fileType = 0
@@ -38,73 +44,113 @@ type (
 	KeyDir map[string]recfmt.KeyDirRec
 )
 
-// New creates a new keydir map from the given datastore.
+// New creates a new keydir map from the given datastore on the given filesystem.
+// The datastore records are decoded with the given encoding.
 // Select the convenient mechanism of building the keydir.
 // Share the built keydir map if shared privacy is specified.
+// Return the keydir and the latest timestamp of the datastore records, including the tombstones
+// of the deleted keys that are not in the keydir.
 // Return an error on system failures.
-func New(dataStorePath string, privacy KeyDirPrivacy) (KeyDir, error) {
+func New(fsys vfs.FS, dataStorePath string, privacy KeyDirPrivacy, enc *recfmt.Encoding) (KeyDir, int64, error) {
 	k := KeyDir{}
 
-	okay, err := k.keyDirFileBuild(dataStorePath)
+	okay, lastTstamp, err := k.keyDirFileBuild(fsys, dataStorePath, enc)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if okay {
-		return k, nil
+		return k, lastTstamp, nil
 	}
 
-	err = k.dataStoreFilesBuild(dataStorePath)
+	lastTstamp, err = k.dataStoreFilesBuild(fsys, dataStorePath, enc)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if privacy == SharedKeyDir {
-		k.share(dataStorePath)
+		k.share(fsys, dataStorePath, lastTstamp, enc)
 	}
 
-	return k, nil
+	return k, lastTstamp, nil
+}
+
+// DiskSize returns the size of the data file record the given keydir record points at.
+func DiskSize(rec recfmt.KeyDirRec) int64 {
+	return int64(recfmt.DataFileRecHdr + int(rec.KeySize) + int(rec.ValueSize))
+}
+
+// MemSize returns the estimated memory used by the keydir entry of the given key and record.
+// The file ids are shared between the records of the same file, so they are not accounted for.
+func MemSize(key string, rec recfmt.KeyDirRec) int64 {
+	return int64(len(key) + entryOverhead)
+}
+
+// Usage returns the total size of the data file records the keydir points at
+// and the estimated memory used by the keydir.
+func (k KeyDir) Usage() (int64, int64) {
+	var diskSize, memSize int64
+	for key, rec := range k {
+		diskSize += DiskSize(rec)
+		memSize += MemSize(key, rec)
+	}
+
+	return diskSize, memSize
 }
 
 // keyDirFileBuild tries to build the keydir from the shared keydir file.
-// return false if there is no keydir or the existing keydir is old.
+// return false if there is no keydir or the existing keydir is old,
+// otherwise return the latest timestamp of the datastore records written in the keydir file.
 // return an error on system failures.
-func (k KeyDir) keyDirFileBuild(dataStorePath string) (bool, error) {
-	data, err := os.ReadFile(path.Join(dataStorePath, keyDirFile))
+func (k KeyDir) keyDirFileBuild(fsys vfs.FS, dataStorePath string, enc *recfmt.Encoding) (bool, int64, error) {
+	data, err := fsys.ReadFile(path.Join(dataStorePath, FileName))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return false, nil
+			return false, 0, nil
 		}
-		return false, err
+		return false, 0, err
+	}
+
+	old, err := IsOld(fsys, dataStorePath)
+	if err != nil || old {
+		return false, 0, nil
 	}
 
-	okay, err := isOld(dataStorePath)
-	if err != nil || !okay {
-		return false, nil
+	// a legacy or truncated keydir file is ignored, the keydir is built from the data files then.
+	hdr, i, err := recfmt.ExtractFileHdr(data)
+	if err != nil {
+		return false, 0, nil
+	}
+	lastTstamp, err := recfmt.ExtractKeyDirFileTstamp(data[i:])
+	if err != nil {
+		return false, 0, nil
 	}
+	i += recfmt.KeyDirFileTstamp
 
-	i := 0
 	n := len(data)
 	for i < n {
-		key, rec, recLen := recfmt.ExtractKeyDirRec(data[i:])
+		key, rec, recLen, err := recfmt.ExtractKeyDirRec(data[i:], hdr, enc)
+		if err != nil {
+			return false, 0, err
+		}
 		k[key] = rec
 		i += recLen
 	}
 
-	return true, nil
+	return true, lastTstamp, nil
 }
 
-// isOld specifies whether the keydir file contains the data
+// IsOld specifies whether the keydir file does not contain the data
 // that represents the current state of the datastore directory.
 // if the keydir is old this means that write operations happened
 // so this file is not representing the current state and should
 // be ignored when building the current keydir.
-func isOld(dataStorePath string) (bool, error) {
-	dataStoreStat, err := os.Stat(dataStorePath)
+func IsOld(fsys vfs.FS, dataStorePath string) (bool, error) {
+	dataStoreStat, err := fsys.Stat(dataStorePath)
 	if err != nil {
 		return false, err
 	}
 
-	keydirStat, err := os.Stat(path.Join(dataStorePath, "keydir"))
+	keydirStat, err := fsys.Stat(path.Join(dataStorePath, FileName))
 	if err != nil {
 		return false, err
 	}
@@ -115,16 +161,12 @@ func isOld(dataStorePath string) (bool, error) {
 // dataStoreFilesBuild is another mechanism of building the keydir.
 // it uses the current data and hint files to build it.
 // it prefer the hint files on data files.
+// return the latest timestamp of the parsed records.
 // return and error on system failures.
-func (k KeyDir) dataStoreFilesBuild(dataStorePath string) error {
-	dataStore, err := os.Open(dataStorePath)
+func (k KeyDir) dataStoreFilesBuild(fsys vfs.FS, dataStorePath string, enc *recfmt.Encoding) (int64, error) {
+	files, err := fsys.ReadDir(dataStorePath)
 	if err != nil {
-		return err
-	}
-	defer dataStore.Close()
-	files, err := dataStore.Readdir(0)
-	if err != nil {
-		return err
+		return 0, err
 	}
 
 	fileNames := make([]string, 0)
@@ -134,57 +176,93 @@ func (k KeyDir) dataStoreFilesBuild(dataStorePath string) error {
 		}
 	}
 
-	err = k.parseFiles(dataStorePath, categorizeFiles(fileNames))
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return k.parseFiles(fsys, dataStorePath, categorizeFiles(fileNames), enc)
 }
 
 // parseFiles parses the data from the given data and hint files
 // to create the keydir map.
+// return the latest timestamp of the parsed records, the tombstones included.
 // return and error on system failures.
-func (k KeyDir) parseFiles(dataStorePath string, files map[string]fileType) error {
+func (k KeyDir) parseFiles(fsys vfs.FS, dataStorePath string, files map[string]fileType, enc *recfmt.Encoding) (int64, error) {
+	deleted := make(map[string]int64)
 	for name, ftype := range files {
 		switch ftype {
 		case data:
-			err := k.parseDataFile(dataStorePath, name)
+			err := k.parseDataFile(fsys, dataStorePath, name, deleted, enc)
 			if err != nil {
-				return err
+				return 0, err
 			}
 		case hint:
-			err := k.parseHintFile(dataStorePath, name)
+			err := k.parseHintFile(fsys, dataStorePath, name, enc)
 			if err != nil {
-				return err
+				return 0, err
 			}
 		}
 	}
 
-	return nil
+	var lastTstamp int64
+	for key, tstamp := range deleted {
+		if tstamp > lastTstamp {
+			lastTstamp = tstamp
+		}
+		if rec, isExist := k[key]; isExist && rec.Tstamp < tstamp {
+			delete(k, key)
+		}
+	}
+	for _, rec := range k {
+		if rec.Tstamp > lastTstamp {
+			lastTstamp = rec.Tstamp
+		}
+	}
+
+	return lastTstamp, nil
 }
 
 // parseDataFile parses the data from a data files.
+// the timestamps of the deleted keys are recorded in the given deleted map
+// to remove them from the keydir after all files are parsed.
 // return and error on system failures.
-func (k KeyDir) parseDataFile(dataStorePath, name string) error {
-	data, err := os.ReadFile(path.Join(dataStorePath, name))
+func (k KeyDir) parseDataFile(fsys vfs.FS, dataStorePath, name string, deleted map[string]int64, enc *recfmt.Encoding) error {
+	data, err := fsys.ReadFile(path.Join(dataStorePath, name))
 	if err != nil {
 		return err
 	}
 
-	i := 0
+	hdr, i, err := recfmt.ExtractFileHdr(data)
+	if err != nil {
+		return legacyError(name, err)
+	}
+
 	n := len(data)
 	for i < n {
-		rec, recLen, err := recfmt.ExtractDataFileRec(data[i:])
+		rec, recLen, err := recfmt.ExtractDataFileRec(data[i:], hdr, enc)
 		if err != nil {
 			return err
 		}
 
+		if count, ok := datastore.BatchLen(rec); ok {
+			if !datastore.BatchComplete(data, i+int(recLen), count) {
+				// the batch was cut by a crash, so none of its records is applied.
+				break
+			}
+			i += int(recLen)
+			continue
+		}
+
+		if rec.Value == datastore.TompStone {
+			if deleted[rec.Key] < rec.Tstamp {
+				deleted[rec.Key] = rec.Tstamp
+			}
+			i += int(recLen)
+			continue
+		}
+
 		old, isExist := k[rec.Key]
 		if !isExist || old.Tstamp < rec.Tstamp {
 			k[rec.Key] = recfmt.KeyDirRec{
 				FileId:    name,
 				ValuePos:  uint32(i),
+				KeySize:   rec.KeySize,
 				ValueSize: rec.ValueSize,
 				Tstamp:    rec.Tstamp,
 			}
@@ -196,25 +274,45 @@ func (k KeyDir) parseDataFile(dataStorePath, name string) error {
 }
 
 // parseHintFile parses the data from hint files.
+// the record with the latest timestamp of every key is kept.
 // return and error on system failures.
-func (k KeyDir) parseHintFile(dataStorePath, name string) error {
-	data, err := os.ReadFile(path.Join(dataStorePath, name))
+func (k KeyDir) parseHintFile(fsys vfs.FS, dataStorePath, name string, enc *recfmt.Encoding) error {
+	data, err := fsys.ReadFile(path.Join(dataStorePath, name))
 	if err != nil {
 		return err
 	}
 
-	i := 0
+	hdr, i, err := recfmt.ExtractFileHdr(data)
+	if err != nil {
+		return legacyError(name, err)
+	}
+
 	n := len(data)
 	for i < n {
-		key, rec, recLen := recfmt.ExtractHintFileRec(data[i:])
-		rec.FileId = fmt.Sprintf("%s.data", strings.Trim(name, ".hint"))
-		k[key] = rec
+		key, rec, recLen, err := recfmt.ExtractHintFileRec(data[i:], hdr, enc)
+		if err != nil {
+			return err
+		}
+		rec.FileId = fmt.Sprintf("%s.data", strings.TrimSuffix(name, ".hint"))
+		if old, isExist := k[key]; !isExist || old.Tstamp <= rec.Tstamp {
+			k[key] = rec
+		}
 		i += recLen
 	}
 
 	return nil
 }
 
+// legacyError describes the error of reading the header of the given file,
+// the legacy files have to be migrated by a writer before the datastore can be read.
+func legacyError(name string, err error) error {
+	if errors.Is(err, recfmt.ErrLegacyFormat) {
+		return fmt.Errorf("%s: %s, open the datastore with write permission to migrate it", name, err)
+	}
+
+	return fmt.Errorf("%s: %s", name, err)
+}
+
 // categorizeFiles specifies whether the file is data or hint file.
 func categorizeFiles(allFiles []string) map[string]fileType {
 	res := make(map[string]fileType)
@@ -222,7 +320,7 @@ func categorizeFiles(allFiles []string) map[string]fileType {
 	hintFiles := make(map[string]int)
 	for _, file := range allFiles {
 		if strings.HasSuffix(file, ".hint") {
-			fileWithoutExt := strings.Trim(file, ".hint")
+			fileWithoutExt := strings.TrimSuffix(file, ".hint")
 			hintFiles[fileWithoutExt] = 1
 			res[file] = hint
 		}
@@ -230,7 +328,7 @@ func categorizeFiles(allFiles []string) map[string]fileType {
 
 	for _, file := range allFiles {
 		if strings.HasSuffix(file, ".data") {
-			if _, okay := hintFiles[strings.Trim(file, ".data")]; !okay {
+			if _, okay := hintFiles[strings.TrimSuffix(file, ".data")]; !okay {
 				res[file] = data
 			}
 		}
@@ -239,23 +337,39 @@ func categorizeFiles(allFiles []string) map[string]fileType {
 	return res
 }
 
-// share writes the keydir map data in keydir file to be used by other readers.
+// share writes the given latest timestamp of the datastore records and the keydir map data
+// after the file header in keydir file to be used by other readers.
+// the file and the datastore directory are flushed to the disk after writing.
 // return an error on system failures.
-func (k KeyDir) share(dataStorePath string) error {
+func (k KeyDir) share(fsys vfs.FS, dataStorePath string, lastTstamp int64, enc *recfmt.Encoding) error {
 	flags := os.O_CREATE | os.O_RDWR | os.O_TRUNC
 	perm := os.FileMode(0666)
-	file, err := sio.OpenFile(path.Join(dataStorePath, "keydir"), flags, perm)
+	file, err := sio.OpenFile(fsys, path.Join(dataStorePath, FileName), flags, perm)
+	if err != nil {
+		return err
+	}
+	defer file.File.Close()
+
+	_, err = file.Write(append(recfmt.CompressFileHdr(enc), recfmt.CompressKeyDirFileTstamp(lastTstamp)...))
 	if err != nil {
 		return err
 	}
 
 	for key, rec := range k {
-		buf := recfmt.CompressKeyDirRec(key, rec)
-		_, err := file.Write(buf)
+		buf, err := recfmt.CompressKeyDirRec(key, rec, enc)
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(buf)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	err = file.File.Sync()
+	if err != nil {
+		return err
+	}
+
+	return fsys.SyncDir(dataStorePath)
 }
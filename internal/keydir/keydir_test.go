@@ -0,0 +1,203 @@
+package keydir
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/IslamWalid/bitcask/internal/datastore"
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+// testDir is the datastore directory used by the keydir tests.
+const testDir = "datastore"
+
+func TestKeyDirFile(t *testing.T) {
+	t.Run("fresh keydir file is used", func(t *testing.T) {
+		mem := newTestFS(t)
+		writeDataFile(t, mem, "1.data", "key1", "value1", 1)
+		writeKeyDirFile(t, mem, map[string]recfmt.KeyDirRec{
+			"shared": {FileId: "1.data", ValuePos: recfmt.FileHdr, ValueSize: 6, Tstamp: 1},
+		})
+
+		k, _, err := New(mem, testDir, PrivateKeyDir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertKeys(t, k, "shared")
+	})
+
+	t.Run("stale keydir file is ignored", func(t *testing.T) {
+		mem := newTestFS(t)
+		writeKeyDirFile(t, mem, map[string]recfmt.KeyDirRec{
+			"stale": {FileId: "1.data", ValuePos: recfmt.FileHdr, ValueSize: 6, Tstamp: 1},
+		})
+		// the data file written after the keydir file makes it stale.
+		time.Sleep(time.Millisecond)
+		writeDataFile(t, mem, "2.data", "key2", "value2", 2)
+
+		old, err := IsOld(mem, testDir)
+		if err != nil || !old {
+			t.Fatalf("IsOld = %v, %v, want true", old, err)
+		}
+
+		k, _, err := New(mem, testDir, PrivateKeyDir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertKeys(t, k, "key2")
+	})
+}
+
+func TestHintFile(t *testing.T) {
+	mem := newTestFS(t)
+	n := writeDataFile(t, mem, "1700000000.data", "key1", "value1", 5)
+	writeHintFile(t, mem, "1700000000.hint", "key1", recfmt.KeyDirRec{
+		ValuePos:  recfmt.FileHdr,
+		KeySize:   uint16(len("key1")),
+		ValueSize: uint32(n - recfmt.DataFileRecHdr - len("key1")),
+		Tstamp:    5,
+	})
+
+	k, _, err := New(mem, testDir, PrivateKeyDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, k, "key1")
+
+	rec := k["key1"]
+	if rec.FileId != "1700000000.data" {
+		t.Fatalf("got file id %q, want %q", rec.FileId, "1700000000.data")
+	}
+
+	d, err := datastore.NewDataStore(mem, testDir, datastore.SharedLock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.ReadValueFromFile(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "value1" {
+		t.Errorf("got %q, want %q", got, "value1")
+	}
+}
+
+func TestLastTstamp(t *testing.T) {
+	mem := newTestFS(t)
+	writeDataFile(t, mem, "1.data", "key1", "value1", 1)
+	writeDataFile(t, mem, "2.data", "key1", datastore.TompStone, 7)
+
+	// the tombstone of the deleted key is the latest record of the datastore.
+	k, last, err := New(mem, testDir, SharedKeyDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, k)
+	if last != 7 {
+		t.Errorf("got latest timestamp %d, want %d", last, 7)
+	}
+
+	// the shared keydir file keeps the latest timestamp.
+	k, last, err = New(mem, testDir, PrivateKeyDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, k)
+	if last != 7 {
+		t.Errorf("got latest timestamp %d from the keydir file, want %d", last, 7)
+	}
+}
+
+func TestCategorizeFiles(t *testing.T) {
+	got := categorizeFiles([]string{"hint.data", "hint.hint", "data.data", "1.data", "1.hint", "keydir"})
+	want := map[string]fileType{"hint.hint": hint, "data.data": data, "1.hint": hint}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for name, ftype := range want {
+		if got[name] != ftype {
+			t.Errorf("%s: got file type %d, want %d", name, got[name], ftype)
+		}
+	}
+}
+
+// newTestFS creates an in-memory filesystem containing an empty datastore directory.
+func newTestFS(t *testing.T) *vfs.Mem {
+	t.Helper()
+	mem := vfs.NewMem()
+	err := mem.MkdirAll(testDir, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mem
+}
+
+// writeDataFile writes a data file holding a single record.
+// Return the length of the record.
+func writeDataFile(t *testing.T, fsys vfs.FS, name, key, value string, tstamp int64) int {
+	t.Helper()
+	rec, err := recfmt.CompressDataFileRec(key, value, tstamp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fsys, name, append(recfmt.CompressFileHdr(nil), rec...))
+
+	return len(rec)
+}
+
+// writeHintFile writes a hint file holding a single record.
+func writeHintFile(t *testing.T, fsys vfs.FS, name, key string, rec recfmt.KeyDirRec) {
+	t.Helper()
+	buf, err := recfmt.CompressHintFileRec(key, rec, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fsys, name, append(recfmt.CompressFileHdr(nil), buf...))
+}
+
+// writeKeyDirFile writes a keydir file holding the given records.
+func writeKeyDirFile(t *testing.T, fsys vfs.FS, recs map[string]recfmt.KeyDirRec) {
+	t.Helper()
+	data := append(recfmt.CompressFileHdr(nil), recfmt.CompressKeyDirFileTstamp(0)...)
+	for key, rec := range recs {
+		buf, err := recfmt.CompressKeyDirRec(key, rec, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf...)
+	}
+	writeFile(t, fsys, FileName, data)
+}
+
+// writeFile writes the given data into a new file in the datastore directory.
+func writeFile(t *testing.T, fsys vfs.FS, name string, data []byte) {
+	t.Helper()
+	f, err := fsys.OpenFile(path.Join(testDir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertKeys asserts that the keydir holds exactly the wanted keys.
+func assertKeys(t *testing.T, k KeyDir, want ...string) {
+	t.Helper()
+	if len(k) != len(want) {
+		t.Errorf("got %d keys, want %d", len(k), len(want))
+	}
+	for _, key := range want {
+		if _, ok := k[key]; !ok {
+			t.Errorf("%s: key is missing", key)
+		}
+	}
+}
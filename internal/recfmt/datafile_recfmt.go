@@ -4,66 +4,314 @@ package recfmt
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash/crc32"
+
+	"github.com/IslamWalid/bitcask/pkg/codec"
 )
 
 // DataFileRecHdr represents the constant header length of data file records.
-const DataFileRecHdr = 18
-
-// errDataCorruption happens whenever a data file record is corrupted.
-var errDataCorruption = errors.New("corrution detected: datastore files are corrupted")
-
-// DataRec represents the data parsed from a data file record.
-type DataRec struct {
-	Key       string
-	Value     string
-	Tstamp    int64
-	KeySize   uint16
-	ValueSize uint32
-}
+const DataFileRecHdr = 19
+
+var (
+	// errDataCorruption happens whenever a data file record is corrupted.
+	errDataCorruption = errors.New("corrution detected: datastore files are corrupted")
+
+	// errUnknownCodec happens whenever a data file record is compressed with a codec that is not registered.
+	errUnknownCodec = errors.New("unknown compression codec")
+
+	// errMissingSealer happens whenever an encrypted record is read without an encryption key provider.
+	errMissingSealer = errors.New("record is encrypted but no encryption is configured")
+)
+
+type (
+	// DataRec represents the data parsed from a data file record.
+	// ValueSize is the size of the value as stored in the file.
+	DataRec struct {
+		Key       string
+		Value     string
+		Tstamp    int64
+		KeySize   uint16
+		ValueSize uint32
+		Codec     uint8
+		Encrypted bool
+	}
 
-// CompressDataFileRec compresses the given data into a data file record.
-func CompressDataFileRec(key, value string, tstamp int64) []byte {
-	buf := make([]byte, DataFileRecHdr+len(key)+len(value))
+	// Encoding represents how the keys and values of the written records are encoded.
+	// Values are compressed with Codec when their size is at least Threshold bytes
+	// and they are stored raw when Codec is nil or compression does not make them smaller.
+	// Keys and values are encrypted with Sealer when it is not nil, the Sealer is also
+	// needed to read the encrypted records back.
+	// Whether the records of a file are encrypted is recorded in its file header.
+	Encoding struct {
+		Codec     codec.Codec
+		Threshold int
+		Sealer    Sealer
+	}
+
+	// Sealer encrypts and decrypts the keys and values of records.
+	// The additional data is authenticated along with the field but not stored in it,
+	// so a field only opens with the additional data it was sealed with.
+	Sealer interface {
+		Seal(plain, additional []byte) ([]byte, error)
+		Open(sealed, additional []byte) ([]byte, error)
+		// Overhead returns the number of bytes a sealed field adds to the plain field.
+		Overhead() int
+	}
+)
+
+// CompressDataFileRec compresses the given data into a data file record
+// encoding the key and value with the given encoding, a nil encoding stores them raw.
+// An encrypted key is authenticated with the timestamp, and an encrypted value is authenticated
+// with the record header and the plain key, so the value cannot be moved to another record.
+// Return an error if the value could not be compressed or the record could not be encrypted.
+func CompressDataFileRec(key, value string, tstamp int64, enc *Encoding) ([]byte, error) {
+	storedValue, codecID, err := enc.encodeValue([]byte(value))
+	if err != nil {
+		return nil, err
+	}
+
+	storedKey, err := enc.sealField([]byte(key), tstampData(tstamp))
+	if err != nil {
+		return nil, err
+	}
+
+	valueSize := len(storedValue) + enc.overhead()
+	buf := make([]byte, DataFileRecHdr+len(storedKey)+valueSize)
 
 	binary.LittleEndian.PutUint64(buf[4:], uint64(tstamp))
-	binary.LittleEndian.PutUint16(buf[12:], uint16(len(key)))
-	binary.LittleEndian.PutUint32(buf[14:], uint32(len(value)))
-	copy(buf[DataFileRecHdr:], []byte(key))
-	copy(buf[DataFileRecHdr+len(key):], []byte(value))
+	binary.LittleEndian.PutUint16(buf[12:], uint16(len(storedKey)))
+	binary.LittleEndian.PutUint32(buf[14:], uint32(valueSize))
+	buf[18] = codecID
+	copy(buf[DataFileRecHdr:], storedKey)
+
+	storedValue, err = enc.sealField(storedValue, valueData(buf, key))
+	if err != nil {
+		return nil, err
+	}
+	copy(buf[DataFileRecHdr+len(storedKey):], storedValue)
 
 	checkSum := crc32.ChecksumIEEE(buf[4:])
 	binary.LittleEndian.PutUint32(buf, checkSum)
 
-	return buf
+	return buf, nil
 }
 
-// ExtractDataFileRec extracts the data file record into a data record.
+// ExtractDataFileRec extracts the data file record into a data record
+// decoding the key and value with the given encoding if the file header marks them as encrypted.
 // Return the data record and its length in the file.
-// Return an error whenever the data is corrupted.
-func ExtractDataFileRec(buf []byte) (*DataRec, uint32, error) {
+// Return an error whenever the data is corrupted or cannot be decoded.
+func ExtractDataFileRec(buf []byte, hdr FileHeader, enc *Encoding) (*DataRec, uint32, error) {
+	if len(buf) < DataFileRecHdr {
+		return nil, 0, errDataCorruption
+	}
+
 	parsedSum := binary.LittleEndian.Uint32(buf)
 	tstamp := binary.LittleEndian.Uint64(buf[4:])
 	keySize := binary.LittleEndian.Uint16(buf[12:])
 	valueSize := binary.LittleEndian.Uint32(buf[14:])
-	key := string(buf[DataFileRecHdr : DataFileRecHdr+keySize])
+	codecID := buf[18]
+	if uint64(len(buf)) < DataFileRecHdr+uint64(keySize)+uint64(valueSize) {
+		return nil, 0, errDataCorruption
+	}
+
+	storedKey := buf[DataFileRecHdr : DataFileRecHdr+keySize]
 	valueOffset := uint32(DataFileRecHdr + keySize)
-	value := string(buf[valueOffset : valueOffset+valueSize])
+	storedValue := buf[valueOffset : valueOffset+valueSize]
 
 	err := validateCheckSum(parsedSum, buf[4:DataFileRecHdr+uint32(keySize)+valueSize])
 	if err != nil {
 		return nil, 0, err
 	}
 
+	key, err := enc.openField(storedKey, hdr, tstampData(int64(tstamp)))
+	if err != nil {
+		return nil, 0, err
+	}
+	value, err := enc.openField(storedValue, hdr, valueData(buf, string(key)))
+	if err != nil {
+		return nil, 0, err
+	}
+	value, err = decodeValue(value, codecID)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	return &DataRec{
-		Key:       key,
-		Value:     value,
+		Key:       string(key),
+		Value:     string(value),
 		Tstamp:    int64(tstamp),
 		KeySize:   keySize,
 		ValueSize: valueSize,
+		Codec:     codecID,
+		Encrypted: hdr.Encrypted,
 	}, DataFileRecHdr + valueSize + uint32(keySize), nil
 }
 
+// PeekDataFileRec parses the header of the data file record without validating or decoding the record.
+// The key of the returned record is only set if the file header does not mark it as encrypted and its value is never set.
+// Return the record and its length in the file.
+// Return false if the buffer is too short to hold the record described by the header.
+func PeekDataFileRec(buf []byte, hdr FileHeader) (*DataRec, uint32, bool) {
+	if len(buf) < DataFileRecHdr {
+		return nil, 0, false
+	}
+
+	keySize := binary.LittleEndian.Uint16(buf[12:])
+	valueSize := binary.LittleEndian.Uint32(buf[14:])
+	recLen := DataFileRecLen(buf)
+	if uint64(len(buf)) < recLen {
+		return nil, 0, false
+	}
+
+	rec := &DataRec{
+		Tstamp:    int64(binary.LittleEndian.Uint64(buf[4:])),
+		KeySize:   keySize,
+		ValueSize: valueSize,
+		Codec:     buf[18],
+		Encrypted: hdr.Encrypted,
+	}
+	if !rec.Encrypted {
+		rec.Key = string(buf[DataFileRecHdr : DataFileRecHdr+uint32(keySize)])
+	}
+
+	return rec, uint32(recLen), true
+}
+
+// DataFileRecLen returns the length of the data file record described by the record header at the start of the buffer,
+// the buffer must hold at least DataFileRecHdr bytes.
+func DataFileRecLen(buf []byte) uint64 {
+	keySize := binary.LittleEndian.Uint16(buf[12:])
+	valueSize := binary.LittleEndian.Uint32(buf[14:])
+
+	return uint64(DataFileRecHdr) + uint64(keySize) + uint64(valueSize)
+}
+
+// ValidCheckSum reports whether the data file record at the start of the buffer is complete
+// and matches its checksum.
+func ValidCheckSum(buf []byte) bool {
+	_, recLen, ok := PeekDataFileRec(buf, FileHeader{})
+	if !ok {
+		return false
+	}
+
+	return validateCheckSum(binary.LittleEndian.Uint32(buf), buf[4:recLen]) == nil
+}
+
+// NextDataFileRec finds the first record after the corrupted record at the start of the buffer.
+// The length in the corrupted record header is trusted if a record with a valid checksum follows it,
+// otherwise the buffer is scanned for the next record with a valid checksum, the records are not decoded.
+// Return the offset of the found record or the buffer length if no record is found.
+func NextDataFileRec(buf []byte, hdr FileHeader) int {
+	if _, recLen, ok := PeekDataFileRec(buf, hdr); ok {
+		if int(recLen) == len(buf) || ValidCheckSum(buf[recLen:]) {
+			return int(recLen)
+		}
+	}
+
+	for i := 1; i < len(buf); i++ {
+		if ValidCheckSum(buf[i:]) {
+			return i
+		}
+	}
+
+	return len(buf)
+}
+
+// encodeValue compresses the value with the encoding codec if it is worth it.
+// Return the stored value and the id of the codec used.
+func (e *Encoding) encodeValue(value []byte) ([]byte, uint8, error) {
+	if e == nil || e.Codec == nil || len(value) < e.Threshold {
+		return value, codec.NoneID, nil
+	}
+
+	compressed, err := e.Codec.Compress(value)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(compressed) >= len(value) {
+		return value, codec.NoneID, nil
+	}
+
+	return compressed, e.Codec.ID(), nil
+}
+
+// sealField encrypts the field with the encoding sealer if it is configured,
+// authenticating the given additional data along with it.
+// Return the stored field.
+func (e *Encoding) sealField(field, additional []byte) ([]byte, error) {
+	if e == nil || e.Sealer == nil {
+		return field, nil
+	}
+
+	return e.Sealer.Seal(field, additional)
+}
+
+// openField decrypts the stored field with the encoding sealer if the file header marks it as encrypted.
+// The field must have been sealed with the given additional data.
+// Return an error if the field is encrypted and no sealer is configured or the decryption fails.
+func (e *Encoding) openField(storedField []byte, hdr FileHeader, additional []byte) ([]byte, error) {
+	if !hdr.Encrypted {
+		return storedField, nil
+	}
+	if e == nil || e.Sealer == nil {
+		return nil, errMissingSealer
+	}
+
+	field, err := e.Sealer.Open(storedField, additional)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", errDataCorruption, err)
+	}
+
+	return field, nil
+}
+
+// overhead returns the number of bytes the encoding sealer adds to the sealed fields.
+func (e *Encoding) overhead() int {
+	if e == nil || e.Sealer == nil {
+		return 0
+	}
+
+	return e.Sealer.Overhead()
+}
+
+// encrypted reports whether the records written with the encoding are encrypted.
+func (e *Encoding) encrypted() bool {
+	return e != nil && e.Sealer != nil
+}
+
+// tstampData returns the additional data authenticated with the encrypted keys of records with the given timestamp.
+func tstampData(tstamp int64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(tstamp))
+
+	return buf
+}
+
+// valueData returns the additional data authenticated with the encrypted value of the given data file record,
+// which is the record header after its checksum followed by the plain key.
+func valueData(rec []byte, key string) []byte {
+	buf := make([]byte, 0, DataFileRecHdr-4+len(key))
+	buf = append(buf, rec[4:DataFileRecHdr]...)
+
+	return append(buf, key...)
+}
+
+// decodeValue decompresses the stored value with the codec of the given id.
+// Return an error if the codec is not registered or the value cannot be decompressed.
+func decodeValue(storedValue []byte, codecID uint8) ([]byte, error) {
+	if codecID == codec.NoneID {
+		return storedValue, nil
+	}
+
+	c, ok := codec.Lookup(codecID)
+	if !ok {
+		return nil, fmt.Errorf("%d: %s", codecID, errUnknownCodec)
+	}
+
+	return c.Decompress(storedValue)
+}
+
 // validateCheckSum runs the validate check on the data.
 // return an error if the data is corrupted.
 func validateCheckSum(parsedSum uint32, rec []byte) error {
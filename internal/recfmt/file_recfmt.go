@@ -0,0 +1,119 @@
+package recfmt
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+const (
+	// FileHdr represents the constant length of the header written at the start of data, hint and keydir files.
+	FileHdr = 8
+
+	// Version is the version of the record layout of the files written by this package.
+	Version uint8 = 1
+
+	// fileMagic starts the header of the files written by this package,
+	// the legacy files written before the header existed start with their first record.
+	fileMagic = "bitcsk"
+
+	// flagEncrypted marks the files whose record keys and values are encrypted.
+	flagEncrypted uint8 = 1
+
+	// legacyDataFileRecHdr represents the constant header length of legacy data file records.
+	legacyDataFileRecHdr = 18
+)
+
+var (
+	// ErrLegacyFormat happens whenever a file written in the legacy format without a file header is read.
+	ErrLegacyFormat = errors.New("datastore file is written in the legacy format")
+
+	// errUnknownVersion happens whenever a file is written with an unknown version of the record layout.
+	errUnknownVersion = errors.New("unknown datastore file format version")
+)
+
+// FileHeader represents the data parsed from the header of a datastore file.
+// Encrypted specifies whether the keys and values of the file records are encrypted.
+type FileHeader struct {
+	Version   uint8
+	Encrypted bool
+}
+
+// CompressFileHdr returns the header written at the start of new data, hint and keydir files
+// whose records are written with the given encoding.
+func CompressFileHdr(enc *Encoding) []byte {
+	buf := make([]byte, FileHdr)
+	copy(buf, fileMagic)
+	buf[len(fileMagic)] = Version
+	if enc.encrypted() {
+		buf[len(fileMagic)+1] = flagEncrypted
+	}
+
+	return buf
+}
+
+// ExtractFileHdr parses the header at the start of the content of a datastore file.
+// An empty file or a file cut inside its header by a crash holds no records,
+// its header length is the file length.
+// Return the parsed header and its length, the records of the file start after it.
+// Return ErrLegacyFormat if the file does not start with a header
+// or an error if the file is written with an unknown version.
+func ExtractFileHdr(buf []byte) (FileHeader, int, error) {
+	if len(buf) < FileHdr {
+		n := len(buf)
+		if n > len(fileMagic) {
+			n = len(fileMagic)
+		}
+		if !strings.HasPrefix(fileMagic, string(buf[:n])) {
+			return FileHeader{}, 0, ErrLegacyFormat
+		}
+		return FileHeader{Version: Version}, len(buf), nil
+	}
+
+	if string(buf[:len(fileMagic)]) != fileMagic {
+		return FileHeader{}, 0, ErrLegacyFormat
+	}
+	hdr := FileHeader{
+		Version:   buf[len(fileMagic)],
+		Encrypted: buf[len(fileMagic)+1]&flagEncrypted != 0,
+	}
+	if hdr.Version != Version {
+		return FileHeader{}, 0, errUnknownVersion
+	}
+
+	return hdr, FileHdr, nil
+}
+
+// ExtractLegacyDataFileRec extracts a data file record written in the legacy format,
+// whose header has no codec and no flags and whose key and value are stored raw.
+// Return the data record and its length in the file.
+// Return an error whenever the data is corrupted.
+func ExtractLegacyDataFileRec(buf []byte) (*DataRec, uint32, error) {
+	if len(buf) < legacyDataFileRecHdr {
+		return nil, 0, errDataCorruption
+	}
+
+	parsedSum := binary.LittleEndian.Uint32(buf)
+	tstamp := binary.LittleEndian.Uint64(buf[4:])
+	keySize := binary.LittleEndian.Uint16(buf[12:])
+	valueSize := binary.LittleEndian.Uint32(buf[14:])
+	recLen := uint64(legacyDataFileRecHdr) + uint64(keySize) + uint64(valueSize)
+	if uint64(len(buf)) < recLen {
+		return nil, 0, errDataCorruption
+	}
+
+	err := validateCheckSum(parsedSum, buf[4:recLen])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	valueOffset := legacyDataFileRecHdr + uint32(keySize)
+
+	return &DataRec{
+		Key:       string(buf[legacyDataFileRecHdr:valueOffset]),
+		Value:     string(buf[valueOffset : valueOffset+valueSize]),
+		Tstamp:    int64(tstamp),
+		KeySize:   keySize,
+		ValueSize: valueSize,
+	}, uint32(recLen), nil
+}
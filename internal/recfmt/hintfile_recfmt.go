@@ -14,30 +14,51 @@ type HintRec struct {
 	valueSize uint32
 }
 
-// CompressHintFileRec compresses the given data into a hint file record.
-func CompressHintFileRec(key string, rec KeyDirRec) []byte {
-	buf := make([]byte, HintFileRecHdr+len(key))
+// CompressHintFileRec compresses the given data into a hint file record
+// encrypting the key authenticated with its timestamp if the given encoding has a sealer.
+// Return an error if the key could not be encrypted.
+func CompressHintFileRec(key string, rec KeyDirRec, enc *Encoding) ([]byte, error) {
+	storedKey, err := enc.sealField([]byte(key), tstampData(rec.Tstamp))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, HintFileRecHdr+len(storedKey))
 	binary.LittleEndian.PutUint64(buf, uint64(rec.Tstamp))
-	binary.LittleEndian.PutUint16(buf[8:], uint16(len(key)))
+	binary.LittleEndian.PutUint16(buf[8:], uint16(len(storedKey)))
 	binary.LittleEndian.PutUint32(buf[10:], rec.ValueSize)
 	binary.LittleEndian.PutUint32(buf[14:], rec.ValuePos)
-	copy(buf[18:], []byte(key))
+	copy(buf[HintFileRecHdr:], storedKey)
 
-	return buf
+	return buf, nil
 }
 
-// ExtractDataFileRec extracts the hint file record into a hint record.
+// ExtractHintFileRec extracts the hint file record into a hint record
+// decrypting the key with the given encoding if the file header marks it as encrypted.
 // Return the hint record and its length in the file.
-func ExtractHintFileRec(buf []byte) (string, KeyDirRec, int) {
+// Return an error if the record is truncated or the key cannot be decrypted.
+func ExtractHintFileRec(buf []byte, hdr FileHeader, enc *Encoding) (string, KeyDirRec, int, error) {
+	if len(buf) < HintFileRecHdr {
+		return "", KeyDirRec{}, 0, errDataCorruption
+	}
+
 	tstamp := binary.LittleEndian.Uint64(buf)
 	keySize := binary.LittleEndian.Uint16(buf[8:])
 	valueSize := binary.LittleEndian.Uint32(buf[10:])
 	valuePos := binary.LittleEndian.Uint32(buf[14:])
-	key := string(buf[HintFileRecHdr : HintFileRecHdr+keySize])
+	if len(buf) < HintFileRecHdr+int(keySize) {
+		return "", KeyDirRec{}, 0, errDataCorruption
+	}
+
+	key, err := enc.openField(buf[HintFileRecHdr:HintFileRecHdr+keySize], hdr, tstampData(int64(tstamp)))
+	if err != nil {
+		return "", KeyDirRec{}, 0, err
+	}
 
-	return key, KeyDirRec{
+	return string(key), KeyDirRec{
 		ValuePos:  valuePos,
+		KeySize:   keySize,
 		ValueSize: valueSize,
 		Tstamp:    int64(tstamp),
-	}, HintFileRecHdr + int(keySize)
+	}, HintFileRecHdr + int(keySize), nil
 }
@@ -3,48 +3,99 @@ package recfmt
 import (
 	"encoding/binary"
 	"strconv"
+	"strings"
 )
 
-// keyDirFileHdr represents the constant header length of keydir file records.
-const keyDirFileHdr = 26
+const (
+	// KeyDirFileTstamp represents the length of the latest datastore timestamp written after the keydir file header.
+	KeyDirFileTstamp = 8
+
+	// keyDirFileHdr represents the constant header length of keydir file records.
+	keyDirFileHdr = 28
+)
 
 // KeyDirRec represents the data parsed from a keydir file record.
+// KeySize and ValueSize are the sizes of the key and value as stored in the data file record,
+// so the record spans DataFileRecHdr + KeySize + ValueSize bytes from ValuePos.
 type KeyDirRec struct {
 	FileId    string
 	ValuePos  uint32
+	KeySize   uint16
 	ValueSize uint32
 	Tstamp    int64
 }
 
-// CompressKeyDirRec compresses the given data into a keydir file record.
-func CompressKeyDirRec(key string, rec KeyDirRec) []byte {
-	keySize := len(key)
+// CompressKeyDirFileTstamp returns the latest timestamp of the datastore records, including the tombstones,
+// written after the keydir file header.
+func CompressKeyDirFileTstamp(tstamp int64) []byte {
+	buf := make([]byte, KeyDirFileTstamp)
+	binary.LittleEndian.PutUint64(buf, uint64(tstamp))
+
+	return buf
+}
+
+// ExtractKeyDirFileTstamp extracts the latest timestamp of the datastore records written after the keydir file header.
+// Return an error if the timestamp is truncated.
+func ExtractKeyDirFileTstamp(buf []byte) (int64, error) {
+	if len(buf) < KeyDirFileTstamp {
+		return 0, errDataCorruption
+	}
+
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// CompressKeyDirRec compresses the given data into a keydir file record
+// along with the size of the key stored in the data file record, encrypting the key authenticated with its timestamp if the given encoding has a sealer.
+// Return an error if the key could not be encrypted.
+func CompressKeyDirRec(key string, rec KeyDirRec, enc *Encoding) ([]byte, error) {
+	storedKey, err := enc.sealField([]byte(key), tstampData(rec.Tstamp))
+	if err != nil {
+		return nil, err
+	}
+
+	keySize := len(storedKey)
 	buf := make([]byte, keyDirFileHdr+keySize)
-	fid, _ := strconv.ParseUint(rec.FileId, 10, 64)
+	fid, _ := strconv.ParseUint(strings.TrimSuffix(rec.FileId, ".data"), 10, 64)
 	binary.LittleEndian.PutUint64(buf, fid)
 	binary.LittleEndian.PutUint16(buf[8:], uint16(keySize))
 	binary.LittleEndian.PutUint32(buf[10:], rec.ValueSize)
 	binary.LittleEndian.PutUint32(buf[14:], rec.ValuePos)
 	binary.LittleEndian.PutUint64(buf[18:], uint64(rec.Tstamp))
-	copy(buf[26:], []byte(key))
+	binary.LittleEndian.PutUint16(buf[26:], rec.KeySize)
+	copy(buf[keyDirFileHdr:], storedKey)
 
-	return buf
+	return buf, nil
 }
 
-// ExtractKeyDirRec extracts the keydir file record into a keydir record.
+// ExtractKeyDirRec extracts the keydir file record into a keydir record
+// decrypting the key with the given encoding if the file header marks it as encrypted.
 // Return the keydir record and its length in the file.
-func ExtractKeyDirRec(buf []byte) (string, KeyDirRec, int) {
-	fileId := strconv.FormatUint(binary.LittleEndian.Uint64(buf), 10)
+// Return an error if the record is truncated or the key cannot be decrypted.
+func ExtractKeyDirRec(buf []byte, hdr FileHeader, enc *Encoding) (string, KeyDirRec, int, error) {
+	if len(buf) < keyDirFileHdr {
+		return "", KeyDirRec{}, 0, errDataCorruption
+	}
+
+	fileId := strconv.FormatUint(binary.LittleEndian.Uint64(buf), 10) + ".data"
 	keySize := binary.LittleEndian.Uint16(buf[8:])
 	valueSize := binary.LittleEndian.Uint32(buf[10:])
 	valuePos := binary.LittleEndian.Uint32(buf[14:])
 	tstamp := binary.LittleEndian.Uint64(buf[18:])
-	key := string(buf[26 : keySize+26])
+	dataKeySize := binary.LittleEndian.Uint16(buf[26:])
+	if len(buf) < keyDirFileHdr+int(keySize) {
+		return "", KeyDirRec{}, 0, errDataCorruption
+	}
+
+	key, err := enc.openField(buf[keyDirFileHdr:keyDirFileHdr+keySize], hdr, tstampData(int64(tstamp)))
+	if err != nil {
+		return "", KeyDirRec{}, 0, err
+	}
 
-	return key, KeyDirRec{
+	return string(key), KeyDirRec{
 		FileId:    fileId,
 		ValuePos:  valuePos,
+		KeySize:   dataKeySize,
 		ValueSize: valueSize,
 		Tstamp:    int64(tstamp),
-	}, keyDirFileHdr + int(keySize)
+	}, keyDirFileHdr + int(keySize), nil
 }
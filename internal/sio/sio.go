@@ -5,6 +5,8 @@ package sio
 import (
 	"io/fs"
 	"os"
+
+	"github.com/IslamWalid/bitcask/pkg/vfs"
 )
 
 // maxAttempts defines the total number of attempts done by read
@@ -13,13 +15,14 @@ const maxAttempts = 5
 
 // File represents the file with safe i/o functions.
 type File struct {
-	File *os.File
+	File vfs.File
 }
 
-// OpenFile Create a new sio file object with the given flag and permissions.
+// OpenFile Create a new sio file object with the given flag and permissions
+// on the given filesystem.
 // Return error on system failures.
-func OpenFile(name string, flag int, perm fs.FileMode) (*File, error) {
-	file, err := os.OpenFile(name, flag, perm)
+func OpenFile(fsys vfs.FS, name string, flag int, perm fs.FileMode) (*File, error) {
+	file, err := fsys.OpenFile(name, flag, perm)
 	if err != nil {
 		return nil, err
 	}
@@ -31,10 +34,11 @@ func OpenFile(name string, flag int, perm fs.FileMode) (*File, error) {
 	return f, nil
 }
 
-// Open opens an new file with the given name with readonly permission.
+// Open opens an new file with the given name with readonly permission
+// on the given filesystem.
 // Return error on system failures.
-func Open(name string) (*File, error) {
-	file, err := os.Open(name)
+func Open(fsys vfs.FS, name string) (*File, error) {
+	file, err := fsys.OpenFile(name, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +63,7 @@ func (f *File) ReadAt(b []byte, off int64) (int, error) {
 		}
 		off += int64(i)
 		n, err = f.File.ReadAt(b[i:], int64(off))
-        attempts++
+		attempts++
 	}
 
 	return len(b), nil
@@ -0,0 +1,153 @@
+// Package codec provides the compression codecs used to compress the values of bitcask records.
+// The codec of every record is recorded in the record header by its id,
+// so codecs used to write a datastore must be registered to read it back.
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// NoneID is the id of records stored without compression.
+	NoneID uint8 = 0
+	// DeflateID is the id of the DEFLATE codec.
+	DeflateID uint8 = 1
+	// GzipID is the id of the gzip codec.
+	GzipID uint8 = 2
+
+	// minUserID is the smallest id that can be used by registered codecs.
+	minUserID uint8 = 128
+)
+
+var (
+	// Deflate compresses values using DEFLATE.
+	Deflate Codec = flateCodec{}
+	// Gzip compresses values using gzip.
+	Gzip Codec = gzipCodec{}
+
+	// errReservedID happens when registering a codec with an id reserved for this package.
+	errReservedID = errors.New("codec id is reserved")
+	// errDuplicateID happens when registering a codec with an id that is already registered.
+	errDuplicateID = errors.New("codec id is already registered")
+
+	registryMu sync.RWMutex
+	registry   = map[uint8]Codec{
+		DeflateID: Deflate,
+		GzipID:    Gzip,
+	}
+)
+
+type (
+	// Codec represents a compression codec of record values.
+	Codec interface {
+		// ID returns the id recorded in the header of the records compressed by the codec.
+		ID() uint8
+		// Compress returns the compressed form of src.
+		Compress(src []byte) ([]byte, error)
+		// Decompress returns the original form of the compressed src.
+		Decompress(src []byte) ([]byte, error)
+	}
+
+	// flateCodec implements the DEFLATE codec.
+	flateCodec struct{}
+
+	// gzipCodec implements the gzip codec.
+	gzipCodec struct{}
+)
+
+// Register makes the codec available to decompress the records carrying its id.
+// Ids below 128 are reserved for the codecs provided by this package.
+// Return an error if the id is reserved or already registered.
+func Register(c Codec) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if c.ID() < minUserID {
+		return fmt.Errorf("%d: %s", c.ID(), errReservedID)
+	}
+	if _, ok := registry[c.ID()]; ok {
+		return fmt.Errorf("%d: %s", c.ID(), errDuplicateID)
+	}
+	registry[c.ID()] = c
+
+	return nil
+}
+
+// Lookup returns the registered codec with the given id.
+func Lookup(id uint8) (Codec, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	c, ok := registry[id]
+
+	return c, ok
+}
+
+// ID implements Codec.ID.
+func (flateCodec) ID() uint8 {
+	return DeflateID
+}
+
+// Compress implements Codec.Compress.
+func (flateCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	return compress(&buf, w, src)
+}
+
+// Decompress implements Codec.Decompress.
+func (flateCodec) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// ID implements Codec.ID.
+func (gzipCodec) ID() uint8 {
+	return GzipID
+}
+
+// Compress implements Codec.Compress.
+func (gzipCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	return compress(&buf, gzip.NewWriter(&buf), src)
+}
+
+// Decompress implements Codec.Decompress.
+func (gzipCodec) Decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// compress writes src to the given compressor and returns the content of its underlying buffer.
+func compress(buf *bytes.Buffer, w io.WriteCloser, src []byte) ([]byte, error) {
+	_, err := w.Write(src)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,119 @@
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// reverseCodec is a user codec storing the values reversed.
+type reverseCodec struct {
+	id uint8
+}
+
+func (c reverseCodec) ID() uint8 {
+	return c.id
+}
+
+func (c reverseCodec) Compress(src []byte) ([]byte, error) {
+	return reverse(src), nil
+}
+
+func (c reverseCodec) Decompress(src []byte) ([]byte, error) {
+	return reverse(src), nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	values := [][]byte{
+		{},
+		[]byte("value"),
+		bytes.Repeat([]byte("compressible value "), 100),
+	}
+
+	for _, c := range []Codec{Deflate, Gzip} {
+		for _, value := range values {
+			compressed, err := c.Compress(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.Decompress(compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, value) {
+				t.Errorf("codec %d: got %q, want %q", c.ID(), got, value)
+			}
+		}
+	}
+
+	compressed, _ := Gzip.Compress(values[2])
+	if len(compressed) >= len(values[2]) {
+		t.Errorf("got %d compressed bytes, want less than %d", len(compressed), len(values[2]))
+	}
+}
+
+func TestLookup(t *testing.T) {
+	cases := []struct {
+		id   uint8
+		want Codec
+	}{
+		{DeflateID, Deflate},
+		{GzipID, Gzip},
+		{NoneID, nil},
+		{3, nil},
+		{200, nil},
+	}
+
+	for _, c := range cases {
+		got, ok := Lookup(c.id)
+		if ok != (c.want != nil) || got != c.want {
+			t.Errorf("Lookup(%d) = %v, %v, want %v", c.id, got, ok, c.want)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	user := reverseCodec{id: 130}
+
+	err := Register(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := Lookup(user.ID())
+	if !ok || got != user {
+		t.Fatalf("Lookup(%d) = %v, %v, want the registered codec", user.ID(), got, ok)
+	}
+
+	cases := []struct {
+		codec Codec
+		want  string
+	}{
+		{reverseCodec{id: 130}, "130: codec id is already registered"},
+		{reverseCodec{id: GzipID}, "2: codec id is reserved"},
+		{reverseCodec{id: 127}, "127: codec id is reserved"},
+	}
+	for _, c := range cases {
+		err := Register(c.codec)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("Register(%d) = %v, want %q", c.codec.ID(), err, c.want)
+		}
+	}
+
+	// the rejected registrations keep the registered codecs.
+	if got, _ := Lookup(GzipID); got != Gzip {
+		t.Errorf("Lookup(%d) = %v, want gzip", GzipID, got)
+	}
+	if got, _ := Lookup(user.ID()); got != user {
+		t.Errorf("Lookup(%d) = %v, want the first registered codec", user.ID(), got)
+	}
+}
+
+// reverse returns a reversed copy of src.
+func reverse(src []byte) []byte {
+	res := make([]byte, len(src))
+	for i, b := range src {
+		res[len(src)-1-i] = b
+	}
+
+	return res
+}
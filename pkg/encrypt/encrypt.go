@@ -0,0 +1,183 @@
+// Package encrypt provides the encryption used to protect bitcask records at rest.
+// Keys and values are sealed with AES-GCM using keys supplied by a KeyProvider,
+// every sealed field carries the id of its key, so keys can be rotated
+// while the old keys remain available to decrypt the old records.
+// Fields are sealed with additional data identifying the record they belong to,
+// so a sealed field moved to another record fails to open.
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// keyIDSize is the size of the key id prefixed to every sealed field.
+	keyIDSize = 4
+	// nonceSize is the size of the random nonce prefixed to every sealed field.
+	nonceSize = 12
+	// tagSize is the size of the authentication tag appended to every sealed field.
+	tagSize = 16
+
+	// Overhead is the number of bytes a sealed field adds to the plain field.
+	Overhead = keyIDSize + nonceSize + tagSize
+)
+
+var (
+	// errUnknownKey happens whenever a key id is not provided by the key provider.
+	errUnknownKey = errors.New("unknown encryption key")
+
+	// errShortField happens whenever a sealed field is smaller than the encryption overhead.
+	errShortField = errors.New("sealed field is too short")
+)
+
+type (
+	// KeyProvider provides the keys used to encrypt the datastore records.
+	// Keys must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+	KeyProvider interface {
+		// CurrentKey returns the id and the key used to encrypt new records.
+		CurrentKey() (uint32, []byte, error)
+		// Key returns the key with the given id to decrypt records encrypted with it.
+		Key(id uint32) ([]byte, error)
+	}
+
+	// Keys is a KeyProvider holding its keys in memory.
+	Keys struct {
+		// Current is the id of the key used to encrypt new records.
+		Current uint32
+		// ByID maps key ids to keys.
+		ByID map[uint32][]byte
+	}
+
+	// AESGCM seals and opens record fields with AES-GCM.
+	// The ciphers of the provided keys are cached after their first use.
+	AESGCM struct {
+		keys  KeyProvider
+		mu    sync.RWMutex
+		aeads map[uint32]cipher.AEAD
+	}
+)
+
+// CurrentKey implements KeyProvider.CurrentKey.
+func (k *Keys) CurrentKey() (uint32, []byte, error) {
+	key, err := k.Key(k.Current)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return k.Current, key, nil
+}
+
+// Key implements KeyProvider.Key.
+func (k *Keys) Key(id uint32) ([]byte, error) {
+	key, ok := k.ByID[id]
+	if !ok {
+		return nil, fmt.Errorf("%d: %s", id, errUnknownKey)
+	}
+
+	return key, nil
+}
+
+// NewAESGCM creates a new AES-GCM sealer using the keys of the given key provider.
+func NewAESGCM(keys KeyProvider) *AESGCM {
+	return &AESGCM{
+		keys:  keys,
+		aeads: make(map[uint32]cipher.AEAD),
+	}
+}
+
+// Seal encrypts the plain field with the current key and authenticates it along with the additional data.
+// Return the sealed field in the form: key id | nonce | ciphertext | tag.
+// Return an error if the current key is not valid.
+func (a *AESGCM) Seal(plain, additional []byte) ([]byte, error) {
+	id, key, err := a.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := a.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, keyIDSize+nonceSize, Overhead+len(plain))
+	binary.LittleEndian.PutUint32(buf, id)
+	_, err = rand.Read(buf[keyIDSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	// the key id is authenticated too, so it cannot be changed to another key.
+	return aead.Seal(buf, buf[keyIDSize:], plain, associatedData(buf[:keyIDSize], additional)), nil
+}
+
+// Open decrypts the sealed field with the key it was sealed with.
+// Return an error if the key is not provided, the field was tampered with
+// or it was sealed with other additional data.
+func (a *AESGCM) Open(sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < Overhead {
+		return nil, errShortField
+	}
+
+	id := binary.LittleEndian.Uint32(sealed)
+	aead, err := a.aead(id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := sealed[keyIDSize : keyIDSize+nonceSize]
+
+	return aead.Open(nil, nonce, sealed[keyIDSize+nonceSize:], associatedData(sealed[:keyIDSize], additional))
+}
+
+// Overhead returns the number of bytes a sealed field adds to the plain field.
+func (a *AESGCM) Overhead() int {
+	return Overhead
+}
+
+// associatedData returns the data authenticated with a field sealed with the given key id and additional data.
+func associatedData(keyID, additional []byte) []byte {
+	buf := make([]byte, 0, len(keyID)+len(additional))
+	buf = append(buf, keyID...)
+
+	return append(buf, additional...)
+}
+
+// aead returns the cached cipher of the given key id,
+// the key is requested from the key provider if it is not given.
+func (a *AESGCM) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	a.mu.RLock()
+	aead, ok := a.aeads[id]
+	a.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	if key == nil {
+		var err error
+		key, err = a.keys.Key(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.aeads[id] = aead
+	a.mu.Unlock()
+
+	return aead, nil
+}
@@ -0,0 +1,85 @@
+package encrypt
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	key1 = []byte("0123456789abcdef0123456789abcdef")
+	key2 = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestRoundTrip(t *testing.T) {
+	keys := &Keys{Current: 1, ByID: map[uint32][]byte{1: key1, 2: key2}}
+	a := NewAESGCM(keys)
+
+	for _, plain := range [][]byte{{}, []byte("value"), bytes.Repeat([]byte("v"), 4096)} {
+		sealed, err := a.Seal(plain, []byte("record"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sealed) != len(plain)+a.Overhead() {
+			t.Errorf("got %d sealed bytes, want %d", len(sealed), len(plain)+a.Overhead())
+		}
+		if len(plain) > 0 && bytes.Contains(sealed, plain) {
+			t.Errorf("sealed field contains the plain field")
+		}
+
+		got, err := a.Open(sealed, []byte("record"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("got %q, want %q", got, plain)
+		}
+	}
+
+	// a rotated key still opens the fields sealed with the old key.
+	sealed, _ := a.Seal([]byte("old"), nil)
+	keys.Current = 2
+	got, err := a.Open(sealed, nil)
+	if err != nil || string(got) != "old" {
+		t.Errorf("got %q, %v after rotation, want %q", got, err, "old")
+	}
+}
+
+func TestOpenFailures(t *testing.T) {
+	a := NewAESGCM(&Keys{Current: 1, ByID: map[uint32][]byte{1: key1}})
+	sealed, err := a.Seal([]byte("value"), []byte("record1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongKey := NewAESGCM(&Keys{Current: 1, ByID: map[uint32][]byte{1: key2}})
+	missingKey := NewAESGCM(&Keys{Current: 2, ByID: map[uint32][]byte{2: key1}})
+
+	cases := []struct {
+		desc       string
+		sealer     *AESGCM
+		sealed     []byte
+		additional string
+	}{
+		{"wrong key", wrongKey, sealed, "record1"},
+		{"missing key", missingKey, sealed, "record1"},
+		{"swapped to another record", a, sealed, "record2"},
+		{"tampered nonce", a, flip(sealed, keyIDSize), "record1"},
+		{"tampered ciphertext", a, flip(sealed, keyIDSize+nonceSize), "record1"},
+		{"tampered tag", a, flip(sealed, len(sealed)-1), "record1"},
+		{"tampered key id", a, flip(sealed, 0), "record1"},
+		{"short field", a, sealed[:Overhead-1], "record1"},
+	}
+	for _, c := range cases {
+		if _, err := c.sealer.Open(c.sealed, []byte(c.additional)); err == nil {
+			t.Errorf("%s: opened the sealed field", c.desc)
+		}
+	}
+}
+
+// flip returns a copy of the sealed field with the byte at the given index changed.
+func flip(sealed []byte, i int) []byte {
+	res := append([]byte{}, sealed...)
+	res[i] ^= 0xff
+
+	return res
+}
@@ -0,0 +1,410 @@
+package respserver
+
+import (
+	"errors"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+var (
+	// errTimeout happens whenever the timeout of a blocking command cannot be parsed.
+	errTimeout = errors.New("ERR timeout is not a float or out of range")
+	// errNegativeTimeout happens whenever the timeout of a blocking command is negative.
+	errNegativeTimeout = errors.New("ERR timeout is negative")
+
+	// blockingCommands are the commands that wait for their keys without holding the EXEC lock.
+	blockingCommands = map[string]bool{
+		"blpop":  true,
+		"brpop":  true,
+		"blmove": true,
+	}
+)
+
+type (
+	// waiter represents a client blocked on list keys of a database.
+	// wake is signaled whenever the client is the first one blocked on a key that is pushed to.
+	waiter struct {
+		client int64
+		keys   []string
+		wake   chan struct{}
+	}
+
+	// blocking represents the blocked clients, queued by the datastore keys they are blocked on
+	// in the order they blocked, so they are woken first come first served.
+	blocking struct {
+		mu      sync.Mutex
+		queues  map[string][]*waiter
+		clients map[int64]*waiter
+	}
+
+	// connReader represents the connection of a client as read by its commands reader.
+	// The connection is watched for disconnects while the client is blocked,
+	// and the bytes read meanwhile are kept for the next commands.
+	connReader struct {
+		net.Conn
+		pending []byte
+	}
+)
+
+// blpop implements the callback method that handles blpop requests.
+// Reply with the key and the value popped from the head of the first non empty list,
+// or a null array if the timeout passes first.
+func (r *RespServer) blpop(c *client, args []resp.Value) resp.Value {
+	return r.blockingPop(c, args, true)
+}
+
+// brpop implements the callback method that handles brpop requests.
+// Reply with the key and the value popped from the tail of the first non empty list,
+// or a null array if the timeout passes first.
+func (r *RespServer) brpop(c *client, args []resp.Value) resp.Value {
+	return r.blockingPop(c, args, false)
+}
+
+// lmove implements the callback method that handles lmove requests.
+// Reply with the moved value, or nil if the source list does not exist.
+func (r *RespServer) lmove(c *client, args []resp.Value) resp.Value {
+	fromHead, toHead, err := parseSides(args[3].String(), args[4].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	var item string
+	var moved bool
+	err = r.atomically(c, func(tx *txn) error {
+		var err error
+		item, moved, err = tx.moveItem(c.db, args[1].String(), args[2].String(), fromHead, toHead, r.waitersBefore(c, args[1].String()))
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !moved {
+		return resp.NullValue()
+	}
+
+	return resp.StringValue(item)
+}
+
+// blmove implements the callback method that handles blmove requests.
+// Reply with the moved value, or nil if the timeout passes first.
+func (r *RespServer) blmove(c *client, args []resp.Value) resp.Value {
+	fromHead, toHead, err := parseSides(args[3].String(), args[4].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	timeout, err := parseTimeout(args[5].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	var item string
+	moved, err := r.block(c, args[1:2], timeout, func(tx *txn) (bool, error) {
+		var moved bool
+		var err error
+		item, moved, err = tx.moveItem(c.db, args[1].String(), args[2].String(), fromHead, toHead, r.waitersBefore(c, args[1].String()))
+		return moved, err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !moved {
+		return resp.NullValue()
+	}
+
+	return resp.StringValue(item)
+}
+
+// blockingPop pops a value from the head or the tail of the first non empty list of the given keys,
+// the last argument is the timeout in seconds.
+// Reply with the key and the popped value, or a null array if the timeout passes first.
+func (r *RespServer) blockingPop(c *client, args []resp.Value, head bool) resp.Value {
+	timeout, err := parseTimeout(args[len(args)-1].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	keys := args[1 : len(args)-1]
+
+	var reply resp.Value
+	popped, err := r.block(c, keys, timeout, func(tx *txn) (bool, error) {
+		for _, key := range keys {
+			items, _, err := tx.popItems(c.db, key.String(), 1, head, r.waitersBefore(c, key.String()))
+			if err != nil {
+				return false, err
+			}
+			if len(items) > 0 {
+				reply = resp.ArrayValue([]resp.Value{key, resp.StringValue(items[0])})
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !popped {
+		return nullArray
+	}
+
+	return reply
+}
+
+// block runs pop on a new transaction until it pops, blocking the client meanwhile until one of the keys
+// is pushed to, the timeout passes or the client disconnects, a zero timeout blocks forever.
+// pop is run with the EXEC lock held for reading, which is released while the client is blocked.
+// Inside a transaction pop runs once without blocking.
+// Return whether pop popped, or the error returned by pop.
+func (r *RespServer) block(c *client, keys []resp.Value, timeout time.Duration, pop func(tx *txn) (bool, error)) (bool, error) {
+	var popped bool
+	try := func(tx *txn) error {
+		var err error
+		popped, err = pop(tx)
+		return err
+	}
+	if _, ok := c.store.s.(*txn); ok {
+		err := r.atomically(c, try)
+		return popped, err
+	}
+
+	// the client is queued before its first try, so a push right after the try wakes it.
+	w := r.wait(c, keys)
+	defer r.stopWaiting(w)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var closed <-chan struct{}
+	for {
+		r.execMu.RLock()
+		err := r.atomically(c, try)
+		r.execMu.RUnlock()
+		if err != nil || popped {
+			return popped, err
+		}
+
+		if closed == nil {
+			var stop func()
+			closed, stop = c.watchConn()
+			defer stop()
+		}
+		select {
+		case <-w.wake:
+		case <-expired:
+			return false, nil
+		case <-closed:
+			return false, nil
+		}
+	}
+}
+
+// moveItem pops an item from the head or the tail of the source list, leaving the given number of items
+// to the blocked clients, and pushes it to the head or the tail of the destination list of the database.
+// Return the moved item and whether an item was moved, or errWrongType if any of the keys is not a list.
+func (tx *txn) moveItem(db int, src, dst string, fromHead, toHead bool, keep int) (string, bool, error) {
+	// the destination is checked first, so the item is not popped if it cannot be pushed.
+	_, _, err := tx.collection(db, dst, listType)
+	if err != nil {
+		return "", false, err
+	}
+
+	popped, _, err := tx.popItems(db, src, 1, fromHead, keep)
+	if err != nil || len(popped) == 0 {
+		return "", false, err
+	}
+	_, err = tx.pushItems(db, dst, popped, toHead)
+	if err != nil {
+		return "", false, err
+	}
+
+	return popped[0], true, nil
+}
+
+// wait queues the client on the given keys of its database.
+func (r *RespServer) wait(c *client, keys []resp.Value) *waiter {
+	w := &waiter{client: c.id, wake: make(chan struct{}, 1)}
+	for _, key := range keys {
+		w.keys = append(w.keys, dbKey(c.db, key.String()))
+	}
+
+	r.blocked.mu.Lock()
+	defer r.blocked.mu.Unlock()
+
+	for _, key := range w.keys {
+		r.blocked.queues[key] = append(r.blocked.queues[key], w)
+	}
+	r.blocked.clients[w.client] = w
+
+	return w
+}
+
+// stopWaiting removes the waiter from the queues of its keys,
+// the next clients blocked on them are woken as the waiter might have been woken for them.
+func (r *RespServer) stopWaiting(w *waiter) {
+	r.blocked.mu.Lock()
+	for _, key := range w.keys {
+		queue := r.blocked.queues[key][:0]
+		for _, other := range r.blocked.queues[key] {
+			if other != w {
+				queue = append(queue, other)
+			}
+		}
+		if len(queue) == 0 {
+			delete(r.blocked.queues, key)
+		} else {
+			r.blocked.queues[key] = queue
+		}
+	}
+	delete(r.blocked.clients, w.client)
+	r.blocked.mu.Unlock()
+
+	r.wake(w.keys...)
+}
+
+// wake wakes the first client blocked on each of the given datastore keys.
+func (r *RespServer) wake(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	r.blocked.mu.Lock()
+	defer r.blocked.mu.Unlock()
+
+	for _, key := range keys {
+		if queue := r.blocked.queues[key]; len(queue) > 0 {
+			select {
+			case queue[0].wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// blockedKeys returns the keys of both given databases that clients are blocked on.
+func (r *RespServer) blockedKeys(first, second int) []string {
+	r.blocked.mu.Lock()
+	defer r.blocked.mu.Unlock()
+
+	keys := make([]string, 0)
+	for key := range r.blocked.queues {
+		if db, _, ok := parseDBKey(key); ok && (db == first || db == second) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// waitersBefore returns the number of clients blocked on the key of the database of the client before it,
+// or all of them if the client is not blocked on the key.
+// The items pushed to the key are left to them, so they pop first come first served.
+func (r *RespServer) waitersBefore(c *client, key string) int {
+	r.blocked.mu.Lock()
+	defer r.blocked.mu.Unlock()
+
+	queue := r.blocked.queues[dbKey(c.db, key)]
+	for i, w := range queue {
+		if w.client == c.id {
+			return i
+		}
+	}
+
+	return len(queue)
+}
+
+// isBlocked reports whether the client is blocked.
+func (r *RespServer) isBlocked(c *client) bool {
+	r.blocked.mu.Lock()
+	defer r.blocked.mu.Unlock()
+
+	return r.blocked.clients[c.id] != nil
+}
+
+// watchConn watches the connection of the client until the returned function is called,
+// which must be called before the client reads its next command.
+// The connection is read until it fails, so a disconnect is detected after pipelined commands are kept.
+// Return a channel closed if the connection is closed meanwhile.
+func (c *client) watchConn() (<-chan struct{}, func()) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 512)
+		for {
+			n, err := c.reader.Conn.Read(buf)
+			c.reader.pending = append(c.reader.pending, buf[:n]...)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				close(closed)
+			}
+			return
+		}
+	}()
+
+	return closed, func() {
+		c.reader.SetReadDeadline(time.Now())
+		<-done
+		c.reader.SetReadDeadline(time.Time{})
+	}
+}
+
+// Read reads the bytes kept while the client was blocked first, then reads from the connection.
+func (cr *connReader) Read(p []byte) (int, error) {
+	if len(cr.pending) > 0 {
+		n := copy(p, cr.pending)
+		cr.pending = cr.pending[n:]
+		return n, nil
+	}
+
+	return cr.Conn.Read(p)
+}
+
+// parseTimeout parses the timeout of a blocking command in seconds.
+// Return errTimeout if the timeout is not a float or errNegativeTimeout if it is negative.
+func parseTimeout(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds > math.MaxInt64/float64(time.Second) {
+		return 0, errTimeout
+	}
+	if seconds < 0 {
+		return 0, errNegativeTimeout
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// parseSides parses the LEFT or RIGHT sides of the source and the destination lists of LMOVE and BLMOVE.
+// Return whether each of them is the head of its list, or errSyntax if any of them is not a side.
+func parseSides(from, to string) (bool, bool, error) {
+	fromHead, err := parseSide(from)
+	if err != nil {
+		return false, false, err
+	}
+	toHead, err := parseSide(to)
+
+	return fromHead, toHead, err
+}
+
+// parseSide parses a LEFT or RIGHT side of a list.
+// Return whether the side is the head of the list, or errSyntax if it is not a side.
+func parseSide(side string) (bool, error) {
+	switch strings.ToLower(side) {
+	case "left":
+		return true, nil
+	case "right":
+		return false, nil
+	}
+
+	return false, errSyntax
+}
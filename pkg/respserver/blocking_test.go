@@ -0,0 +1,155 @@
+package respserver
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+func TestBlockingCommands(t *testing.T) {
+	r, conn := newTestServer(t)
+	other := newTestClient(t, r)
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"rpush", "jobs", "a", "b"}, ":2\r\n"},
+		{[]interface{}{"blpop", "missing", "jobs", "0"}, "*2\r\n$4\r\njobs\r\n$1\r\na\r\n"},
+		{[]interface{}{"brpop", "jobs", "0.01"}, "*2\r\n$4\r\njobs\r\n$1\r\nb\r\n"},
+		{[]interface{}{"exists", "jobs"}, ":0\r\n"},
+		{[]interface{}{"blpop", "jobs", "0.01"}, "*-1\r\n"},
+		{[]interface{}{"blpop", "jobs", "soon"}, "-ERR timeout is not a float or out of range\r\n"},
+		{[]interface{}{"blpop", "jobs", "-1"}, "-ERR timeout is negative\r\n"},
+		{[]interface{}{"set", "string", "value"}, "+OK\r\n"},
+		{[]interface{}{"blpop", "string", "0"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		{[]interface{}{"rpush", "src", "1", "2", "3"}, ":3\r\n"},
+		{[]interface{}{"lmove", "src", "dst", "left", "right"}, "$1\r\n1\r\n"},
+		{[]interface{}{"lmove", "src", "dst", "right", "left"}, "$1\r\n3\r\n"},
+		{[]interface{}{"lmove", "src", "src", "left", "right"}, "$1\r\n2\r\n"},
+		{[]interface{}{"lmove", "src", "string", "left", "right"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]interface{}{"lmove", "src", "dst", "up", "right"}, "-ERR syntax error\r\n"},
+		{[]interface{}{"lmove", "missing", "dst", "left", "right"}, "$-1\r\n"},
+		{[]interface{}{"lrange", "dst", "0", "-1"}, "*2\r\n$1\r\n3\r\n$1\r\n1\r\n"},
+		{[]interface{}{"blmove", "src", "dst", "left", "left", "0"}, "$1\r\n2\r\n"},
+		{[]interface{}{"blmove", "src", "dst", "left", "left", "0.01"}, "$-1\r\n"},
+
+		{[]interface{}{"multi"}, "+OK\r\n"},
+		{[]interface{}{"blpop", "empty", "0"}, "+QUEUED\r\n"},
+		{[]interface{}{"rpush", "empty", "x"}, "+QUEUED\r\n"},
+		{[]interface{}{"brpop", "empty", "0"}, "+QUEUED\r\n"},
+		{[]interface{}{"blmove", "empty", "dst", "left", "left", "0"}, "+QUEUED\r\n"},
+		{[]interface{}{"exec"}, "*4\r\n*-1\r\n:1\r\n*2\r\n$5\r\nempty\r\n$1\r\nx\r\n$-1\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+
+	// a blocked client does not block the other clients.
+	send(t, conn, "blpop", "queue", "0")
+	waitBlocked(t, r, 1)
+	assertReply(t, do(t, other, "multi"), "+OK\r\n")
+	assertReply(t, do(t, other, "set", "key", "value"), "+QUEUED\r\n")
+	assertReply(t, do(t, other, "exec"), "*1\r\n+OK\r\n")
+	if list := do(t, other, "client", "list").String(); !strings.Contains(list, "flags=b") {
+		t.Errorf("got client list %q, want a blocked client", list)
+	}
+	assertReply(t, do(t, other, "rpush", "queue", "job"), ":1\r\n")
+	assertReply(t, read(t, conn), "*2\r\n$5\r\nqueue\r\n$3\r\njob\r\n")
+	assertReply(t, do(t, other, "exists", "queue"), ":0\r\n")
+}
+
+func TestBlockingOrder(t *testing.T) {
+	r, conn := newTestServer(t)
+	workers := []*resp.Conn{newTestClient(t, r), newTestClient(t, r), newTestClient(t, r)}
+
+	for i, worker := range workers {
+		send(t, worker, "brpop", "jobs", "0")
+		waitBlocked(t, r, i+1)
+	}
+
+	// the workers are served in the order they blocked.
+	assertReply(t, do(t, conn, "lpush", "jobs", "1", "2"), ":2\r\n")
+	assertReply(t, read(t, workers[0]), "*2\r\n$4\r\njobs\r\n$1\r\n1\r\n")
+	assertReply(t, read(t, workers[1]), "*2\r\n$4\r\njobs\r\n$1\r\n2\r\n")
+	waitBlocked(t, r, 1)
+
+	// a move to a list wakes the clients blocked on it.
+	send(t, workers[0], "blmove", "pending", "jobs", "right", "left", "0")
+	waitBlocked(t, r, 2)
+	assertReply(t, do(t, conn, "rpush", "pending", "3"), ":1\r\n")
+	assertReply(t, read(t, workers[0]), "$1\r\n3\r\n")
+	assertReply(t, read(t, workers[2]), "*2\r\n$4\r\njobs\r\n$1\r\n3\r\n")
+	waitBlocked(t, r, 0)
+
+	// the item is not popped for a client that disconnects while it is blocked.
+	id := do(t, workers[1], "client", "id").String()
+	send(t, workers[1], "blpop", "jobs", "0")
+	waitBlocked(t, r, 1)
+	assertReply(t, do(t, conn, "client", "kill", "id", id), ":1\r\n")
+	waitBlocked(t, r, 0)
+	assertReply(t, do(t, conn, "rpush", "jobs", "4"), ":1\r\n")
+	assertReply(t, do(t, conn, "llen", "jobs"), ":1\r\n")
+	assertReply(t, do(t, conn, "del", "jobs"), ":1\r\n")
+
+	// the items pushed to the blocked clients are not popped by the clients that pop after them.
+	send(t, workers[0], "blpop", "jobs", "0")
+	waitBlocked(t, r, 1)
+	assertReply(t, do(t, conn, "rpush", "jobs", "5"), ":1\r\n")
+	assertReply(t, do(t, conn, "lpop", "jobs"), "$-1\r\n")
+	assertReply(t, do(t, workers[2], "blpop", "jobs", "0.01"), "*-1\r\n")
+	assertReply(t, read(t, workers[0]), "*2\r\n$4\r\njobs\r\n$1\r\n5\r\n")
+
+	// the items left after the blocked clients are served can be popped.
+	send(t, workers[0], "blpop", "jobs", "0")
+	waitBlocked(t, r, 1)
+	assertReply(t, do(t, conn, "rpush", "jobs", "6", "6"), ":2\r\n")
+	assertReply(t, do(t, workers[2], "blpop", "jobs", "0.01"), "*2\r\n$4\r\njobs\r\n$1\r\n6\r\n")
+	assertReply(t, read(t, workers[0]), "*2\r\n$4\r\njobs\r\n$1\r\n6\r\n")
+}
+
+func TestBlockingDisconnect(t *testing.T) {
+	r, conn := newTestServer(t)
+	serverConn, clientConn := net.Pipe()
+	go r.serveConn(serverConn)
+	client := resp.NewConn(clientConn)
+
+	// the disconnect is detected after the client sends commands while it is blocked.
+	send(t, client, "blpop", "jobs", "0")
+	waitBlocked(t, r, 1)
+	send(t, client, "ping")
+	clientConn.Close()
+	waitBlocked(t, r, 0)
+
+	assertReply(t, do(t, conn, "rpush", "jobs", "1"), ":1\r\n")
+	assertReply(t, do(t, conn, "llen", "jobs"), ":1\r\n")
+}
+
+// send sends the given command without reading its reply.
+func send(t *testing.T, conn *resp.Conn, args ...interface{}) {
+	t.Helper()
+
+	err := conn.WriteMultiBulk(args[0].(string), args[1:]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitBlocked waits until the given number of clients are blocked.
+func waitBlocked(t *testing.T, r *RespServer, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		r.blocked.mu.Lock()
+		blocked := len(r.blocked.clients)
+		r.blocked.mu.Unlock()
+		if blocked == n {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %d blocked clients", n)
+}
@@ -0,0 +1,660 @@
+package respserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IslamWalid/bitcask"
+)
+
+const (
+	// hashType is the type tag of the hashes.
+	hashType byte = 'h'
+	// listType is the type tag of the lists.
+	listType byte = 'l'
+	// setType is the type tag of the sets.
+	setType byte = 's'
+	// zsetType is the type tag of the sorted sets.
+	zsetType byte = 'z'
+
+	// elemPrefix is the prefix of the keys of the hashes and sets elements records.
+	elemPrefix = "\x00e"
+	// itemPrefix is the prefix of the keys of the lists items records.
+	itemPrefix = "\x00i"
+	// scorePrefix is the prefix of the keys of the sorted sets members records, whose values are their scores.
+	scorePrefix = "\x00z"
+
+	// maxConflicts is the number of conflicts after which a transaction is given up.
+	maxConflicts = 64
+	// maxConflictBackoff is the longest wait before running a conflicting transaction again.
+	maxConflictBackoff = 10 * time.Millisecond
+)
+
+// errTooManyConflicts happens whenever a transaction conflicts with concurrent writes maxConflicts times in a row.
+var errTooManyConflicts = errors.New("transaction keeps conflicting with concurrent writes")
+
+// typeNames are the names of the collection types by their tags.
+var typeNames = map[byte]string{
+	hashType: "hash",
+	listType: "list",
+	setType:  "set",
+	zsetType: "zset",
+}
+
+type (
+	// meta represents the record stored at the key of a collection.
+	// The elements of a collection are stored in their own records under keys made of the collection id,
+	// so a collection is renamed or moved by moving its meta record only.
+	// The meta record is rewritten whenever the collection changes, so watching the key watches the collection.
+	// The items of a list are stored by their positions starting at head, so pushing and popping
+	// at both ends writes a single item.
+	meta struct {
+		typ  byte
+		id   string
+		size int64
+		head int64
+	}
+
+	// txn represents a datastore transaction with the changes it makes to the members index,
+	// which are applied to the index once the transaction is committed,
+	// and the keys of the lists it pushes to, whose blocked clients are woken once it is committed.
+	// dbs holds the stored databases of the logical databases as seen by the transaction,
+	// they are used by the server once it is committed.
+	txn struct {
+		*bitcask.Tx
+		changes []indexChange
+		ready   []string
+		dbs     [databases]int
+	}
+
+	// indexChange represents a change of the members index.
+	// An empty id clears the whole index and an empty member removes all the members of the collection.
+	// The changes of the sorted sets members carry their scores.
+	// The changes of the databases keys carry the key used in the datastore as their member.
+	indexChange struct {
+		id       string
+		member   string
+		removed  bool
+		sorted   bool
+		score    float64
+		keyspace bool
+	}
+
+	// index represents the members of the hashes and sets and the sorted sets by their ids,
+	// it is built from the elements records when the server starts, so the members are listed without
+	// listing all the datastore keys and the sorted sets are ordered without reading all their scores.
+	// It holds the keys of every logical database too, ordered by their hashes as SCAN returns them,
+	// so the keys are scanned, counted and picked at random without listing all the datastore keys.
+	index struct {
+		mu      sync.Mutex
+		members map[string]map[string]bool
+		sorted  map[string]*zset
+		keys    [databases]*zset
+	}
+
+	// indexedStore represents the datastore with the keys of the members index kept up to date with its writes.
+	// The index is locked while writing, so the index changes are applied in the order of the writes.
+	indexedStore struct {
+		r *RespServer
+	}
+)
+
+// newIndex builds the members index from the elements records of the given datastore,
+// the sorted sets scores are read from their records.
+// Return an error if a score cannot be read.
+func newIndex(b *bitcask.Bitcask) (*index, error) {
+	ix := &index{}
+	ix.apply(indexChange{})
+	for _, key := range b.ListKeys() {
+		if _, _, ok := parseDBKey(key); ok {
+			ix.apply(indexChange{member: key, keyspace: true})
+		}
+		if id, member, ok := parseElemKey(elemPrefix, key); ok {
+			ix.apply(indexChange{id: id, member: member})
+		}
+		if id, member, ok := parseElemKey(scorePrefix, key); ok {
+			value, err := b.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			score, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid score %q", key, value)
+			}
+			ix.apply(indexChange{id: id, member: member, sorted: true, score: score})
+		}
+	}
+
+	return ix, nil
+}
+
+// apply applies a change to the index, the caller must hold the index lock or own the index.
+func (ix *index) apply(change indexChange) {
+	switch {
+	case change.keyspace:
+		db, key, _ := parseDBKey(change.member)
+		if change.removed {
+			ix.keys[db].remove(key)
+		} else {
+			ix.keys[db].add(key, float64(keyHash(key)))
+		}
+	case change.id == "":
+		ix.members = make(map[string]map[string]bool)
+		ix.sorted = make(map[string]*zset)
+		for db := range ix.keys {
+			ix.keys[db] = newZSet()
+		}
+	case change.member == "" && change.removed:
+		delete(ix.members, change.id)
+		delete(ix.sorted, change.id)
+	case change.sorted && change.removed:
+		if z := ix.sorted[change.id]; z != nil {
+			z.remove(change.member)
+			if z.len() == 0 {
+				delete(ix.sorted, change.id)
+			}
+		}
+	case change.sorted:
+		if ix.sorted[change.id] == nil {
+			ix.sorted[change.id] = newZSet()
+		}
+		ix.sorted[change.id].add(change.member, change.score)
+	case change.removed:
+		delete(ix.members[change.id], change.member)
+		if len(ix.members[change.id]) == 0 {
+			delete(ix.members, change.id)
+		}
+	default:
+		if ix.members[change.id] == nil {
+			ix.members[change.id] = make(map[string]bool)
+		}
+		ix.members[change.id][change.member] = true
+	}
+}
+
+// begin starts a new transaction.
+func (r *RespServer) begin() *txn {
+	return &txn{Tx: r.bitcask.Begin(), dbs: *r.dbs.Load()}
+}
+
+// commit commits the transaction then applies its changes to the members index and to the order of the databases,
+// and wakes the clients blocked on the lists it pushed to.
+// The index is locked while committing, so the index changes are applied in the order of the commits,
+// the transactions that do not change the index or the order of the databases, like the ones of the read only
+// commands, are committed without locking it.
+// Return bitcask.ErrConflict if the transaction conflicts with a concurrent write or an error on datastore failures.
+func (r *RespServer) commit(tx *txn) error {
+	if len(tx.changes) == 0 && tx.dbs == *r.dbs.Load() {
+		err := tx.Commit()
+		if err != nil {
+			return err
+		}
+		r.wake(tx.ready...)
+		return nil
+	}
+
+	r.index.mu.Lock()
+	defer r.index.mu.Unlock()
+
+	err := tx.Commit()
+	if err != nil {
+		return err
+	}
+	for _, change := range tx.changes {
+		r.index.apply(change)
+	}
+	if tx.dbs != *r.dbs.Load() {
+		dbs := tx.dbs
+		r.dbs.Store(&dbs)
+	}
+	r.wake(tx.ready...)
+
+	return nil
+}
+
+// Get retrieves the value of the key.
+func (s indexedStore) Get(key string) (string, error) {
+	return s.r.bitcask.Get(key)
+}
+
+// Put stores the value of the key and adds the key to the index.
+func (s indexedStore) Put(key, value string) error {
+	s.r.index.mu.Lock()
+	defer s.r.index.mu.Unlock()
+
+	err := s.r.bitcask.Put(key, value)
+	if err != nil {
+		return err
+	}
+	s.r.index.applyKey(key, false)
+
+	return nil
+}
+
+// Delete removes the key and removes it from the index.
+func (s indexedStore) Delete(key string) error {
+	s.r.index.mu.Lock()
+	defer s.r.index.mu.Unlock()
+
+	err := s.r.bitcask.Delete(key)
+	if err != nil {
+		return err
+	}
+	s.r.index.applyKey(key, true)
+
+	return nil
+}
+
+// Update rewrites the value of the key with the value returned by fn and adds or removes the key from the index.
+func (s indexedStore) Update(key string, fn func(value string, exists bool) (string, bitcask.UpdateOp)) error {
+	s.r.index.mu.Lock()
+	defer s.r.index.mu.Unlock()
+
+	var op bitcask.UpdateOp
+	err := s.r.bitcask.Update(key, func(value string, exists bool) (string, bitcask.UpdateOp) {
+		value, op = fn(value, exists)
+		return value, op
+	})
+	if err != nil {
+		return err
+	}
+	if op != bitcask.UpdateKeep {
+		s.r.index.applyKey(key, op == bitcask.UpdateDelete)
+	}
+
+	return nil
+}
+
+// ListKeys lists all the datastore keys.
+func (s indexedStore) ListKeys() []string {
+	return s.r.bitcask.ListKeys()
+}
+
+// storedDB returns the database whose keys are stored for the given logical database.
+func (s indexedStore) storedDB(db int) int {
+	return s.r.dbs.Load()[db]
+}
+
+// applyKey adds or removes the key of the datastore from the keys of its database,
+// the keys that do not belong to a database are ignored. The caller must hold the index lock.
+func (ix *index) applyKey(key string, removed bool) {
+	if _, _, ok := parseDBKey(key); ok {
+		ix.apply(indexChange{member: key, removed: removed, keyspace: true})
+	}
+}
+
+// transact runs fn on a new transaction committed after fn returns, fn is run again on conflicts
+// after a wait that doubles with every conflict up to maxConflictBackoff.
+// Return the error returned by fn, errTooManyConflicts if the transaction conflicts maxConflicts times
+// or an error if the transaction cannot be committed.
+func (r *RespServer) transact(fn func(tx *txn) error) error {
+	backoff := 50 * time.Microsecond
+	for conflicts := 0; conflicts < maxConflicts; conflicts++ {
+		if conflicts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > maxConflictBackoff {
+				backoff = maxConflictBackoff
+			}
+		}
+
+		tx := r.begin()
+		err := fn(tx)
+		if err != nil {
+			tx.Discard()
+			return err
+		}
+
+		err = r.commit(tx)
+		if err != bitcask.ErrConflict {
+			return err
+		}
+	}
+
+	return errTooManyConflicts
+}
+
+// Put buffers a write of the value by key and records the key if it belongs to a database.
+func (tx *txn) Put(key, value string) error {
+	err := tx.Tx.Put(key, value)
+	if err != nil {
+		return err
+	}
+	tx.recordKey(key, false)
+
+	return nil
+}
+
+// Delete buffers the removal of the key and records it if it belongs to a database.
+func (tx *txn) Delete(key string) error {
+	err := tx.Tx.Delete(key)
+	if err != nil {
+		return err
+	}
+	tx.recordKey(key, true)
+
+	return nil
+}
+
+// Update buffers the write returned by fn for the value of the key and records the key if it belongs to a database.
+func (tx *txn) Update(key string, fn func(value string, exists bool) (string, bitcask.UpdateOp)) error {
+	var op bitcask.UpdateOp
+	var existed bool
+	err := tx.Tx.Update(key, func(value string, exists bool) (string, bitcask.UpdateOp) {
+		existed = exists
+		value, op = fn(value, exists)
+		return value, op
+	})
+	if err != nil {
+		return err
+	}
+	if op == bitcask.UpdatePut || (op == bitcask.UpdateDelete && existed) {
+		tx.recordKey(key, op == bitcask.UpdateDelete)
+	}
+
+	return nil
+}
+
+// storedDB returns the database whose keys are stored for the given logical database as seen by the transaction.
+func (tx *txn) storedDB(db int) int {
+	return tx.dbs[db]
+}
+
+// recordKey records that the key is added or removed if it belongs to a database.
+func (tx *txn) recordKey(key string, removed bool) {
+	if _, _, ok := parseDBKey(key); ok {
+		tx.changes = append(tx.changes, indexChange{member: key, removed: removed, keyspace: true})
+	}
+}
+
+// keys runs fn with the keys of the database as seen by the transaction, fn must not use the index.
+// fn gets the shared keys while the index is locked if the transaction did not change them,
+// otherwise it gets a copy of the keys with the changes of the transaction.
+func (tx *txn) keys(r *RespServer, db int, fn func(z *zset)) {
+	changes := make([]indexChange, 0)
+	for _, change := range tx.changes {
+		if change.keyspace {
+			if changed, _, _ := parseDBKey(change.member); changed == db {
+				changes = append(changes, change)
+			}
+		} else if change.id == "" {
+			changes = append(changes, change)
+		}
+	}
+
+	r.index.mu.Lock()
+	if len(changes) == 0 {
+		defer r.index.mu.Unlock()
+		fn(r.index.keys[db])
+		return
+	}
+
+	ix := &index{}
+	ix.apply(indexChange{})
+	ix.keys[db] = r.index.keys[db].clone()
+	r.index.mu.Unlock()
+
+	for _, change := range changes {
+		ix.apply(change)
+	}
+	fn(ix.keys[db])
+}
+
+// members returns the sorted members of the hash or set as seen by the transaction.
+// The meta of the collection must be read by the transaction first, so its commit fails
+// if the members change after they are listed.
+func (tx *txn) members(r *RespServer, id string) []string {
+	var members []string
+	tx.view(r, id, func(ix *index) {
+		members = sortedKeys(ix.members[id])
+	})
+
+	return members
+}
+
+// sortedSet runs fn with the sorted set as seen by the transaction, fn must not use the index.
+// The meta of the sorted set must be read by the transaction first, so its commit fails
+// if the sorted set changes after it is read.
+func (tx *txn) sortedSet(r *RespServer, id string, fn func(z *zset)) {
+	tx.view(r, id, func(ix *index) {
+		z := ix.sorted[id]
+		if z == nil {
+			z = newZSet()
+		}
+		fn(z)
+	})
+}
+
+// view runs fn with the members index as seen by the transaction for the collection with the given id.
+// fn gets the shared index while it is locked if the transaction did not change the collection,
+// otherwise it gets a copy of the collection members with the changes of the transaction.
+func (tx *txn) view(r *RespServer, id string, fn func(ix *index)) {
+	changes := make([]indexChange, 0)
+	for _, change := range tx.changes {
+		if !change.keyspace && (change.id == "" || change.id == id) {
+			changes = append(changes, change)
+		}
+	}
+
+	r.index.mu.Lock()
+	if len(changes) == 0 {
+		defer r.index.mu.Unlock()
+		fn(r.index)
+		return
+	}
+
+	ix := &index{}
+	ix.apply(indexChange{})
+	if set := r.index.members[id]; set != nil {
+		ix.members[id] = make(map[string]bool, len(set))
+		for member := range set {
+			ix.members[id][member] = true
+		}
+	}
+	if z := r.index.sorted[id]; z != nil {
+		ix.sorted[id] = z.clone()
+	}
+	r.index.mu.Unlock()
+
+	for _, change := range changes {
+		ix.apply(change)
+	}
+	fn(ix)
+}
+
+// addMember records that the member is added to the collection.
+func (tx *txn) addMember(id, member string) {
+	tx.changes = append(tx.changes, indexChange{id: id, member: member})
+}
+
+// removeMember records that the member is removed from the collection.
+func (tx *txn) removeMember(id, member string) {
+	tx.changes = append(tx.changes, indexChange{id: id, member: member, removed: true})
+}
+
+// addScore records that the member is added to the sorted set or that its score is changed.
+func (tx *txn) addScore(id, member string, score float64) {
+	tx.changes = append(tx.changes, indexChange{id: id, member: member, sorted: true, score: score})
+}
+
+// removeScore records that the member is removed from the sorted set.
+func (tx *txn) removeScore(id, member string) {
+	tx.changes = append(tx.changes, indexChange{id: id, member: member, removed: true, sorted: true})
+}
+
+// clearIndex records that all the collections are removed.
+func (tx *txn) clearIndex() {
+	tx.changes = append(tx.changes, indexChange{})
+}
+
+// collection returns the meta of the collection of the given type stored at the key of the database.
+// Return false if the key does not exist or errWrongType if the key holds another type.
+func (tx *txn) collection(db int, key string, typ byte) (meta, bool, error) {
+	stored, err := inDB(tx, db).Get(key)
+	if isNotExist(err) {
+		return meta{}, false, nil
+	}
+	if err != nil {
+		return meta{}, false, err
+	}
+
+	m, ok := parseMeta(stored)
+	if !ok || m.typ != typ {
+		return meta{}, false, errWrongType
+	}
+
+	return m, true, nil
+}
+
+// collectionOrNew returns the meta of the collection of the given type stored at the key of the database,
+// or the meta of a new empty collection if the key does not exist.
+// Return errWrongType if the key holds another type.
+func (tx *txn) collectionOrNew(db int, key string, typ byte) (meta, error) {
+	m, ok, err := tx.collection(db, key, typ)
+	if err != nil || ok {
+		return m, err
+	}
+
+	return newCollection(typ)
+}
+
+// saveCollection writes the meta of the collection at the key of the database,
+// the key is removed if the collection is empty.
+func (tx *txn) saveCollection(db int, key string, m meta) error {
+	if m.size > 0 {
+		return inDB(tx, db).Put(key, m.String())
+	}
+
+	err := inDB(tx, db).Delete(key)
+	if isNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// removeElements removes the elements or the items records of the collection.
+func (tx *txn) removeElements(r *RespServer, m meta) error {
+	if m.typ == listType {
+		for i := int64(0); i < m.size; i++ {
+			err := tx.Delete(itemKey(m.id, m.head+i))
+			if err != nil && !isNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	keys := make([]string, 0, m.size)
+	if m.typ == zsetType {
+		tx.sortedSet(r, m.id, func(z *zset) {
+			for member := range z.scores {
+				keys = append(keys, scoreKey(m.id, member))
+			}
+		})
+	} else {
+		for _, member := range tx.members(r, m.id) {
+			keys = append(keys, elemKey(m.id, member))
+		}
+	}
+
+	for _, key := range keys {
+		err := tx.Delete(key)
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+	}
+	tx.changes = append(tx.changes, indexChange{id: m.id, removed: true})
+
+	return nil
+}
+
+// newCollection returns the meta of a new empty collection of the given type with a random id.
+func newCollection(typ byte) (meta, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return meta{}, err
+	}
+
+	return meta{typ: typ, id: hex.EncodeToString(id)}, nil
+}
+
+// String returns the stored value of the meta, a zero byte followed by the type tag, the id and the size,
+// followed by the head for the lists.
+func (m meta) String() string {
+	if m.typ == listType {
+		return fmt.Sprintf("\x00%c%s\x00%d\x00%d", m.typ, m.id, m.size, m.head)
+	}
+
+	return fmt.Sprintf("\x00%c%s\x00%d", m.typ, m.id, m.size)
+}
+
+// typeName returns the name of the type of the collection.
+func (m meta) typeName() string {
+	return typeNames[m.typ]
+}
+
+// parseMeta parses the meta of a collection from a stored value.
+// Return false if the value is not a collection.
+func parseMeta(stored string) (meta, bool) {
+	if len(stored) < 2 || stored[0] != 0 || typeNames[stored[1]] == "" {
+		return meta{}, false
+	}
+	m := meta{typ: stored[1]}
+
+	id, rest, ok := strings.Cut(stored[2:], "\x00")
+	if !ok {
+		return meta{}, false
+	}
+	m.id = id
+
+	size, head, isList := strings.Cut(rest, "\x00")
+	if isList != (m.typ == listType) {
+		return meta{}, false
+	}
+	var err error
+	m.size, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return meta{}, false
+	}
+	if isList {
+		m.head, err = strconv.ParseInt(head, 10, 64)
+		if err != nil {
+			return meta{}, false
+		}
+	}
+
+	return m, true
+}
+
+// elemKey returns the key of the record of the member of the collection.
+func elemKey(id, member string) string {
+	return elemPrefix + id + "\x00" + member
+}
+
+// itemKey returns the key of the record of the list item at the given position.
+func itemKey(id string, pos int64) string {
+	return itemPrefix + id + "\x00" + strconv.FormatInt(pos, 10)
+}
+
+// scoreKey returns the key of the record of the member of the sorted set.
+func scoreKey(id, member string) string {
+	return scorePrefix + id + "\x00" + member
+}
+
+// parseElemKey returns the collection id and the member of an element record key with the given prefix.
+// Return false if the key is not an element record key with the prefix.
+func parseElemKey(prefix, key string) (string, string, bool) {
+	if !strings.HasPrefix(key, prefix) {
+		return "", "", false
+	}
+
+	return strings.Cut(key[len(prefix):], "\x00")
+}
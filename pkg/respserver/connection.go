@@ -0,0 +1,291 @@
+package respserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	// redisVersion is the redis version reported to the clients, the implemented commands follow its semantics.
+	redisVersion = "7.0.0"
+	// protocolVersion is the only supported RESP protocol version.
+	protocolVersion = 2
+
+	// errUnknownSubcommand is the error format of the container commands called with an unknown subcommand.
+	errUnknownSubcommand = "ERR unknown subcommand '%s'. Try %s HELP."
+)
+
+var (
+	// errNoProto happens whenever HELLO asks for a protocol version other than RESP2.
+	errNoProto = errors.New("NOPROTO unsupported protocol version")
+	// errProtoVersion happens whenever HELLO is called with a protocol version that is not an integer.
+	errProtoVersion = errors.New("ERR Protocol version is not an integer or out of range")
+	// errClientName happens whenever a client name has spaces, newlines or special characters.
+	errClientName = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+	// errNoSuchClient happens whenever CLIENT KILL does not find the client to kill.
+	errNoSuchClient = errors.New("ERR No such client")
+	// errClientID happens whenever CLIENT KILL is called with an invalid client id.
+	errClientID = errors.New("ERR client-id should be greater than 0")
+)
+
+// ping implements the callback method that handles ping requests.
+// Subscribed clients receive the reply as a pong message.
+func (r *RespServer) ping(c *client, args []resp.Value) resp.Value {
+	if len(args) > 2 {
+		return resp.ErrorValue(fmt.Errorf(errWrongArgs, "ping"))
+	}
+
+	message := ""
+	if len(args) == 2 {
+		message = args[1].String()
+	}
+	if c.queue != nil && r.isSubscribed(c) {
+		return resp.ArrayValue([]resp.Value{resp.StringValue("pong"), resp.StringValue(message)})
+	}
+	if len(args) == 2 {
+		return resp.StringValue(message)
+	}
+
+	return resp.SimpleStringValue("PONG")
+}
+
+// echo implements the callback method that handles echo requests.
+func (r *RespServer) echo(c *client, args []resp.Value) resp.Value {
+	return resp.StringValue(args[1].String())
+}
+
+// quit implements the callback method that handles quit requests,
+// the connection is closed after the reply is written.
+func (r *RespServer) quit(c *client, args []resp.Value) resp.Value {
+	c.quit = true
+
+	return resp.SimpleStringValue("OK")
+}
+
+// hello implements the callback method that handles hello requests.
+// Only RESP2 is supported, so NOPROTO is replied if RESP3 is requested.
+// The server has no users, so AUTH is accepted with any credentials.
+func (r *RespServer) hello(c *client, args []resp.Value) resp.Value {
+	if len(args) > 1 {
+		version, err := parseInt(args[1].String())
+		if err != nil {
+			return resp.ErrorValue(errProtoVersion)
+		}
+		if version != protocolVersion {
+			return resp.ErrorValue(errNoProto)
+		}
+	}
+
+	name := ""
+	setName := false
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i].String()); {
+		case opt == "auth" && i+2 < len(args):
+			i += 2
+		case opt == "setname" && i+1 < len(args):
+			name = args[i+1].String()
+			if !validClientName(name) {
+				return resp.ErrorValue(errClientName)
+			}
+			setName = true
+			i++
+		default:
+			return resp.ErrorValue(fmt.Errorf("ERR Syntax error in HELLO option '%s'", args[i].String()))
+		}
+	}
+	if setName {
+		c.mu.Lock()
+		c.name = name
+		c.mu.Unlock()
+	}
+
+	return resp.ArrayValue([]resp.Value{
+		resp.StringValue("server"), resp.StringValue("redis"),
+		resp.StringValue("version"), resp.StringValue(redisVersion),
+		resp.StringValue("proto"), resp.IntegerValue(protocolVersion),
+		resp.StringValue("id"), resp.IntegerValue(int(c.id)),
+		resp.StringValue("mode"), resp.StringValue("standalone"),
+		resp.StringValue("role"), resp.StringValue("master"),
+		resp.StringValue("modules"), resp.ArrayValue([]resp.Value{}),
+	})
+}
+
+// client implements the callback method that handles the client subcommands.
+func (r *RespServer) client(c *client, args []resp.Value) resp.Value {
+	sub := strings.ToLower(args[1].String())
+	switch {
+	case sub == "id" && len(args) == 2:
+		return resp.IntegerValue(int(c.id))
+	case sub == "getname" && len(args) == 2:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.name == "" {
+			return resp.NullValue()
+		}
+		return resp.StringValue(c.name)
+	case sub == "setname" && len(args) == 3:
+		name := args[2].String()
+		if !validClientName(name) {
+			return resp.ErrorValue(errClientName)
+		}
+		c.mu.Lock()
+		c.name = name
+		c.mu.Unlock()
+		return resp.SimpleStringValue("OK")
+	case sub == "setinfo" && len(args) == 4:
+		// the library name and version are accepted but not tracked.
+		return resp.SimpleStringValue("OK")
+	case sub == "info" && len(args) == 2:
+		return resp.StringValue(r.clientInfo(c))
+	case sub == "list" && len(args) == 2:
+		var b strings.Builder
+		for _, listed := range r.listClients() {
+			b.WriteString(r.clientInfo(listed))
+		}
+		return resp.StringValue(b.String())
+	case sub == "kill" && len(args) > 2:
+		return r.clientKill(c, args[2:])
+	case sub == "help":
+		return helpReply("CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ID", "GETNAME", "SETNAME <name>", "SETINFO <attr> <value>", "INFO", "LIST",
+			"KILL <ip:port>", "KILL <option> <value> [<option> <value> [...]]")
+	default:
+		return resp.ErrorValue(fmt.Errorf(errUnknownSubcommand, args[1].String(), "CLIENT"))
+	}
+}
+
+// clientKill closes the connections of the clients matching the given filters.
+// The old form takes the address of a single client and replies with OK,
+// the new form takes ID, ADDR, LADDR and SKIPME filters and replies with the number of the killed clients.
+// The calling client is skipped unless SKIPME no is given, and its connection is closed after the reply.
+func (r *RespServer) clientKill(c *client, args []resp.Value) resp.Value {
+	if len(args) == 1 {
+		for _, killed := range r.listClients() {
+			if killed.conn.RemoteAddr().String() == args[0].String() {
+				r.kill(c, killed)
+				return resp.SimpleStringValue("OK")
+			}
+		}
+		return resp.ErrorValue(errNoSuchClient)
+	}
+	if len(args)%2 != 0 {
+		return resp.ErrorValue(errSyntax)
+	}
+
+	var id int64
+	addr, laddr, skipMe := "", "", true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1].String()
+		switch strings.ToLower(args[i].String()) {
+		case "id":
+			n, err := parseInt(value)
+			if err != nil || n <= 0 {
+				return resp.ErrorValue(errClientID)
+			}
+			id = n
+		case "addr":
+			addr = value
+		case "laddr":
+			laddr = value
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return resp.ErrorValue(errSyntax)
+			}
+		default:
+			return resp.ErrorValue(errSyntax)
+		}
+	}
+
+	killed := 0
+	for _, listed := range r.listClients() {
+		if (id != 0 && listed.id != id) ||
+			(addr != "" && listed.conn.RemoteAddr().String() != addr) ||
+			(laddr != "" && listed.conn.LocalAddr().String() != laddr) ||
+			(skipMe && listed == c) {
+			continue
+		}
+		r.kill(c, listed)
+		killed++
+	}
+
+	return resp.IntegerValue(killed)
+}
+
+// kill closes the connection of the killed client, the calling client quits after its reply is written.
+func (r *RespServer) kill(c, killed *client) {
+	if killed == c {
+		c.quit = true
+		return
+	}
+
+	killed.conn.Close()
+}
+
+// listClients returns the connected clients sorted by id.
+func (r *RespServer) listClients() []*client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	clients := make([]*client, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+
+	return clients
+}
+
+// clientInfo returns the line describing the client in the CLIENT LIST format.
+func (r *RespServer) clientInfo(c *client) string {
+	r.pubsubMu.Lock()
+	sub, psub := len(c.channels), len(c.patterns)
+	r.pubsubMu.Unlock()
+
+	flags := "N"
+	if sub+psub > 0 {
+		flags = "P"
+	}
+	if r.isBlocked(c) {
+		flags = "b"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d cmd=%s\n",
+		c.id, c.conn.RemoteAddr(), c.conn.LocalAddr(), c.name,
+		int64(now.Sub(c.created).Seconds()), int64(now.Sub(c.lastActive).Seconds()),
+		flags, c.db, sub, psub, c.lastCmd)
+}
+
+// validClientName reports whether the client name has no spaces, newlines or special characters.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// helpReply returns the reply of the HELP subcommands.
+func helpReply(lines ...string) resp.Value {
+	values := make([]resp.Value, 0, len(lines))
+	for _, line := range lines {
+		values = append(values, resp.SimpleStringValue(line))
+	}
+
+	return resp.ArrayValue(values)
+}
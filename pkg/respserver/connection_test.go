@@ -0,0 +1,87 @@
+package respserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConnectionCommands(t *testing.T) {
+	r, conn := newTestServer(t)
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"ping"}, "+PONG\r\n"},
+		{[]interface{}{"ping", "hi"}, "$2\r\nhi\r\n"},
+		{[]interface{}{"echo", "hi"}, "$2\r\nhi\r\n"},
+		{[]interface{}{"hello", "3"}, "-NOPROTO unsupported protocol version\r\n"},
+		{[]interface{}{"hello", "x"}, "-ERR Protocol version is not an integer or out of range\r\n"},
+		{[]interface{}{"client", "id"}, ":1\r\n"},
+		{[]interface{}{"client", "getname"}, "$-1\r\n"},
+		{[]interface{}{"client", "setname", "bad name"}, "-ERR Client names cannot contain spaces, newlines or special characters.\r\n"},
+		{[]interface{}{"client", "setname", "worker"}, "+OK\r\n"},
+		{[]interface{}{"client", "getname"}, "$6\r\nworker\r\n"},
+		{[]interface{}{"client", "setinfo", "lib-name", "go-redis"}, "+OK\r\n"},
+		{[]interface{}{"client", "bogus"}, "-ERR unknown subcommand 'bogus'. Try CLIENT HELP.\r\n"},
+		{[]interface{}{"command", "info", "get", "bogus"},
+			"*2\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n*0\r\n*0\r\n*0\r\n*0\r\n$-1\r\n"},
+		{[]interface{}{"command", "docs"}, "*0\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+
+	hello := do(t, conn, "hello", "2", "setname", "renamed").Array()
+	if len(hello) != 14 || hello[3].String() != redisVersion || hello[5].Integer() != 2 {
+		t.Errorf("unexpected hello reply %v", hello)
+	}
+	if n := do(t, conn, "command", "count").Integer(); n != len(r.commands) {
+		t.Errorf("command count replied %d, want %d", n, len(r.commands))
+	}
+	if n := len(do(t, conn, "command").Array()); n != len(r.commands) {
+		t.Errorf("command described %d commands, want %d", n, len(r.commands))
+	}
+
+	other := newTestClient(t, r)
+	do(t, other, "client", "setname", "other")
+	list := do(t, conn, "client", "list").String()
+	for _, want := range []string{"id=1 ", "name=renamed ", "id=2 ", "name=other ", "cmd=client\n"} {
+		if !strings.Contains(list, want) {
+			t.Errorf("client list %q does not contain %q", list, want)
+		}
+	}
+
+	assertReply(t, do(t, conn, "client", "kill", "id", "2"), ":1\r\n")
+	if _, _, err := other.ReadValue(); err == nil {
+		t.Error("killed client connection is still open")
+	}
+	assertReply(t, do(t, conn, "client", "kill", "id", "1"), ":0\r\n")
+	assertReply(t, do(t, conn, "client", "kill", "id", "0"), "-ERR client-id should be greater than 0\r\n")
+
+	sub := newTestClient(t, r)
+	do(t, sub, "subscribe", "channel")
+	assertReply(t, do(t, sub, "ping"), "*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+}
+
+func TestInfo(t *testing.T) {
+	_, conn := newTestServer(t)
+
+	do(t, conn, "set", "key", "value")
+
+	info := do(t, conn, "info").String()
+	for _, want := range []string{
+		"# Server\r\n", "redis_version:7.0.0\r\n", "# Clients\r\n", "connected_clients:1\r\n",
+		"# Stats\r\n", "total_commands_processed:1\r\n", "# Bitcask\r\n", "keys:2\r\n",
+		"# Keyspace\r\n", "db0:keys=1,expires=0,avg_ttl=0\r\n",
+	} {
+		if !strings.Contains(info, want) {
+			t.Errorf("info %q does not contain %q", info, want)
+		}
+	}
+
+	info = do(t, conn, "info", "keyspace").String()
+	if info != "# Keyspace\r\ndb0:keys=1,expires=0,avg_ttl=0\r\n" {
+		t.Errorf("got keyspace section %q", info)
+	}
+}
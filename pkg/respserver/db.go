@@ -0,0 +1,397 @@
+package respserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/tidwall/resp"
+)
+
+const (
+	// databases is the number of the logical databases.
+	databases = 16
+
+	// dbsKey is the key of the record holding the stored databases of the logical databases,
+	// which is written by SWAPDB and is missing until the databases are swapped.
+	dbsKey = "\x00dbs"
+)
+
+var (
+	// errDBIndex happens whenever a database index is not in the range of the logical databases.
+	errDBIndex = errors.New("ERR DB index is out of range")
+	// errSameObject happens whenever MOVE is called with the current database.
+	errSameObject = errors.New("ERR source and destination objects are the same")
+
+	// exclusiveCommands are the commands that hold the EXEC lock for writing while they run,
+	// as they write all the keys of databases and would conflict with any concurrent write.
+	exclusiveCommands = map[string]bool{
+		"flushdb":  true,
+		"flushall": true,
+		"swapdb":   true,
+	}
+)
+
+type (
+	// dbStore represents a logical database inside a store.
+	// The keys of database 0 are stored as they are, so the datastores written before databases
+	// were supported are read as database 0, and its keys starting with a zero byte are escaped
+	// with an extra zero byte. The keys of the other databases are prefixed with a zero byte,
+	// the database index and another zero byte.
+	// The index used in the stored keys is the stored database of the logical database,
+	// so SWAPDB swaps two databases without moving their keys.
+	dbStore struct {
+		s  store
+		db int
+	}
+
+	// dbMapper represents the stores that know the stored databases of the logical databases.
+	dbMapper interface {
+		storedDB(db int) int
+	}
+)
+
+// inDB returns the given logical database of the store.
+func inDB(s store, db int) dbStore {
+	return dbStore{s: s, db: storedDB(s, db)}
+}
+
+// storedDB returns the database whose keys are stored for the given logical database of the store,
+// the logical databases of the stores that are not a dbMapper are stored as they are.
+func storedDB(s store, db int) int {
+	if m, ok := s.(dbMapper); ok {
+		return m.storedDB(db)
+	}
+
+	return db
+}
+
+// Get retrieves the value of the key in the database.
+func (d dbStore) Get(key string) (string, error) {
+	return d.s.Get(dbKey(d.db, key))
+}
+
+// Put stores the value of the key in the database.
+func (d dbStore) Put(key, value string) error {
+	return d.s.Put(dbKey(d.db, key), value)
+}
+
+// Delete removes the key from the database.
+func (d dbStore) Delete(key string) error {
+	return d.s.Delete(dbKey(d.db, key))
+}
+
+// Update rewrites the value of the key in the database.
+func (d dbStore) Update(key string, fn func(value string, exists bool) (string, bitcask.UpdateOp)) error {
+	return d.s.Update(dbKey(d.db, key), fn)
+}
+
+// ListKeys lists the keys of the database.
+func (d dbStore) ListKeys() []string {
+	keys := make([]string, 0)
+	for _, stored := range d.s.ListKeys() {
+		if db, key, ok := parseDBKey(stored); ok && db == d.db {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// selectDB implements the callback method that handles select requests.
+func (r *RespServer) selectDB(c *client, args []resp.Value) resp.Value {
+	db, err := parseDB(args[1].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	r.use(c, c.store.s, db)
+
+	return resp.SimpleStringValue("OK")
+}
+
+// flushdb implements the callback method that handles flushdb requests.
+// The keys are removed together, ASYNC and SYNC are accepted and both remove the keys before replying.
+func (r *RespServer) flushdb(c *client, args []resp.Value) resp.Value {
+	if !validFlushArgs(args) {
+		return resp.ErrorValue(errSyntax)
+	}
+
+	err := r.atomically(c, func(tx *txn) error {
+		return deleteKeys(r.keyspace(tx, c.db))
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.SimpleStringValue("OK")
+}
+
+// flushall implements the callback method that handles flushall requests.
+func (r *RespServer) flushall(c *client, args []resp.Value) resp.Value {
+	if !validFlushArgs(args) {
+		return resp.ErrorValue(errSyntax)
+	}
+
+	// the order of the databases is reset as all of them are empty, while the encoding version is kept.
+	err := r.atomically(c, func(tx *txn) error {
+		tx.clearIndex()
+		tx.dbs = identityDBs()
+		err := deleteKeys(tx)
+		if err != nil {
+			return err
+		}
+		return tx.Put(encodingKey, encodingVersion)
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.SimpleStringValue("OK")
+}
+
+// swapdb implements the callback method that handles swapdb requests.
+// The stored databases of both logical databases are swapped without moving their keys,
+// so the clients using one of them see the other one's keys.
+// The clients blocked on the keys of both databases are woken, as their keys might hold items now.
+func (r *RespServer) swapdb(c *client, args []resp.Value) resp.Value {
+	first, err := parseDB(args[1].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	second, err := parseDB(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	if first == second {
+		return resp.SimpleStringValue("OK")
+	}
+
+	err = r.atomically(c, func(tx *txn) error {
+		tx.dbs[first], tx.dbs[second] = tx.dbs[second], tx.dbs[first]
+		tx.ready = append(tx.ready, r.blockedKeys(first, second)...)
+		return tx.Put(dbsKey, formatDBs(tx.dbs))
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.SimpleStringValue("OK")
+}
+
+// move implements the callback method that handles move requests.
+// Reply with 1 if the key is moved, or 0 if it does not exist or already exists in the other database.
+func (r *RespServer) move(c *client, args []resp.Value) resp.Value {
+	key := args[1].String()
+	db, err := parseDB(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	if db == c.db {
+		return resp.ErrorValue(errSameObject)
+	}
+
+	var moved bool
+	err = r.atomically(c, func(tx *txn) error {
+		moved = false
+		src, dst := inDB(tx, c.db), inDB(tx, db)
+
+		value, err := src.Get(key)
+		if isNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = dst.Get(key)
+		if !isNotExist(err) {
+			return err
+		}
+
+		err = dst.Put(key, value)
+		if err != nil {
+			return err
+		}
+		moved = true
+		return src.Delete(key)
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !moved {
+		return resp.IntegerValue(0)
+	}
+
+	return resp.IntegerValue(1)
+}
+
+// use makes the client run its commands on the given database of the given store.
+func (r *RespServer) use(c *client, s store, db int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.db = db
+	c.store = r.keyspace(s, db)
+}
+
+// dbKey returns the key used in the datastore for the key of the given database.
+func dbKey(db int, key string) string {
+	if db != 0 {
+		return "\x00" + strconv.Itoa(db) + "\x00" + key
+	}
+	if strings.HasPrefix(key, "\x00") {
+		return "\x00" + key
+	}
+
+	return key
+}
+
+// parseDBKey returns the database and the key of a key used in the datastore.
+// Return false if the key does not belong to a database.
+func parseDBKey(stored string) (int, string, bool) {
+	if !strings.HasPrefix(stored, "\x00") {
+		return 0, stored, true
+	}
+	if strings.HasPrefix(stored, "\x00\x00") {
+		return 0, stored[1:], true
+	}
+
+	index, key, ok := strings.Cut(stored[1:], "\x00")
+	if !ok {
+		return 0, "", false
+	}
+	db, err := parseDB(index)
+	if err != nil || db == 0 {
+		return 0, "", false
+	}
+
+	return db, key, true
+}
+
+// parseDB parses a database index.
+// Return an error if the index is not an integer or is out of the range of the databases.
+func parseDB(s string) (int, error) {
+	n, err := parseInt(s)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if n < 0 || n >= databases {
+		return 0, errDBIndex
+	}
+
+	return int(n), nil
+}
+
+// validFlushArgs reports whether the flush mode argument is valid if given.
+func validFlushArgs(args []resp.Value) bool {
+	if len(args) == 1 {
+		return true
+	}
+	mode := strings.ToLower(args[1].String())
+
+	return len(args) == 2 && (mode == "async" || mode == "sync")
+}
+
+// deleteKeys removes all the keys of the store.
+func deleteKeys(s store) error {
+	for _, key := range s.ListKeys() {
+		err := s.Delete(key)
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadDBs reads the stored databases of the logical databases from the datastore,
+// every logical database is stored as it is if the databases were never swapped.
+// Return an error if the record cannot be read or is not an order of the databases.
+func loadDBs(b *bitcask.Bitcask) (*[databases]int, error) {
+	value, err := b.Get(dbsKey)
+	if isNotExist(err) {
+		dbs := identityDBs()
+		return &dbs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dbs, ok := parseDBs(value)
+	if !ok {
+		return nil, fmt.Errorf("invalid databases order %q", value)
+	}
+
+	return &dbs, nil
+}
+
+// identityDBs returns the order of the databases that stores every logical database as it is.
+func identityDBs() [databases]int {
+	var dbs [databases]int
+	for db := range dbs {
+		dbs[db] = db
+	}
+
+	return dbs
+}
+
+// formatDBs returns the stored value of the order of the databases, their indexes separated by spaces.
+func formatDBs(dbs [databases]int) string {
+	fields := make([]string, 0, databases)
+	for _, db := range dbs {
+		fields = append(fields, strconv.Itoa(db))
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// parseDBs parses the stored value of the order of the databases.
+// Return false if the value does not hold every database exactly once.
+func parseDBs(value string) ([databases]int, bool) {
+	var dbs [databases]int
+	fields := strings.Fields(value)
+	if len(fields) != databases {
+		return dbs, false
+	}
+
+	seen := make(map[int]bool)
+	for i, field := range fields {
+		db, err := parseDB(field)
+		if err != nil || seen[db] {
+			return dbs, false
+		}
+		seen[db] = true
+		dbs[i] = db
+	}
+
+	return dbs, true
+}
+
+// storedKey returns the key used in the datastore for the key of a logical database given as returned by dbKey.
+func (r *RespServer) storedKey(key string) string {
+	db, key, _ := parseDBKey(key)
+
+	return dbKey(r.dbs.Load()[db], key)
+}
+
+// logicalDB returns the logical database stored in the given database.
+func (r *RespServer) logicalDB(stored int) int {
+	for db, s := range r.dbs.Load() {
+		if s == stored {
+			return db
+		}
+	}
+
+	return stored
+}
+
+// keyspaceChannel returns the keyspace notifications channel of the key of the given database.
+func keyspaceChannel(db int, key string) string {
+	return fmt.Sprintf(keyspacePrefix, db) + key
+}
+
+// keyeventChannel returns the keyevent notifications channel of the event of the given database.
+func keyeventChannel(db int, event string) string {
+	return fmt.Sprintf(keyeventPrefix, db) + event
+}
@@ -0,0 +1,177 @@
+package respserver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+func TestDatabases(t *testing.T) {
+	r, conn := newTestServer(t)
+	other := newTestClient(t, r)
+
+	cases := []struct {
+		conn *resp.Conn
+		args []interface{}
+		want string
+	}{
+		{conn, []interface{}{"select", "16"}, "-ERR DB index is out of range\r\n"},
+		{conn, []interface{}{"select", "one"}, "-ERR value is not an integer or out of range\r\n"},
+		{conn, []interface{}{"set", "key", "zero"}, "+OK\r\n"},
+		{conn, []interface{}{"set", "\x00key", "escaped"}, "+OK\r\n"},
+		{conn, []interface{}{"select", "1"}, "+OK\r\n"},
+		{conn, []interface{}{"get", "key"}, "$-1\r\n"},
+		{conn, []interface{}{"set", "key", "one"}, "+OK\r\n"},
+		{conn, []interface{}{"mset", "a", "1", "b", "2"}, "+OK\r\n"},
+		{conn, []interface{}{"keys", "*"}, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$3\r\nkey\r\n"},
+		{conn, []interface{}{"dbsize"}, ":3\r\n"},
+		{other, []interface{}{"dbsize"}, ":2\r\n"},
+		{other, []interface{}{"get", "\x00key"}, "$7\r\nescaped\r\n"},
+		{other, []interface{}{"get", "key"}, "$4\r\nzero\r\n"},
+
+		{conn, []interface{}{"move", "key", "1"}, "-ERR source and destination objects are the same\r\n"},
+		{conn, []interface{}{"move", "key", "0"}, ":0\r\n"},
+		{conn, []interface{}{"move", "a", "0"}, ":1\r\n"},
+		{conn, []interface{}{"move", "missing", "0"}, ":0\r\n"},
+		{other, []interface{}{"get", "a"}, "$1\r\n1\r\n"},
+		{conn, []interface{}{"exists", "a"}, ":0\r\n"},
+
+		{conn, []interface{}{"swapdb", "0", "1"}, "+OK\r\n"},
+		{conn, []interface{}{"get", "key"}, "$4\r\nzero\r\n"},
+		{other, []interface{}{"get", "key"}, "$3\r\none\r\n"},
+		{other, []interface{}{"get", "a"}, "$-1\r\n"},
+
+		{conn, []interface{}{"multi"}, "+OK\r\n"},
+		{conn, []interface{}{"select", "2"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"set", "key", "two"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"exec"}, "*2\r\n+OK\r\n+OK\r\n"},
+		{conn, []interface{}{"get", "key"}, "$3\r\ntwo\r\n"},
+
+		{conn, []interface{}{"flushdb", "now"}, "-ERR syntax error\r\n"},
+		{conn, []interface{}{"flushdb"}, "+OK\r\n"},
+		{conn, []interface{}{"dbsize"}, ":0\r\n"},
+		{other, []interface{}{"dbsize"}, ":2\r\n"},
+		{conn, []interface{}{"flushall", "async"}, "+OK\r\n"},
+		{other, []interface{}{"dbsize"}, ":0\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, c.conn, c.args...), c.want)
+	}
+}
+
+func TestDatabasesInfo(t *testing.T) {
+	r, conn := newTestServer(t)
+	other := newTestClient(t, r)
+
+	do(t, conn, "set", "key", "value")
+	do(t, other, "select", "3")
+	do(t, other, "set", "key", "value")
+	do(t, other, "set", "other", "value")
+
+	info := do(t, conn, "info", "keyspace").String()
+	if !strings.Contains(info, "db0:keys=1,") || !strings.Contains(info, "db3:keys=2,") {
+		t.Errorf("unexpected keyspace section:\n%s", info)
+	}
+
+	list := do(t, conn, "client", "list").String()
+	if !strings.Contains(list, " db=0 ") || !strings.Contains(list, " db=3 ") {
+		t.Errorf("unexpected client list:\n%s", list)
+	}
+}
+
+func TestDBKey(t *testing.T) {
+	cases := []struct {
+		db  int
+		key string
+	}{
+		{0, "key"},
+		{0, "\x00key"},
+		{0, "\x00\x00"},
+		{0, ""},
+		{1, "key"},
+		{15, "\x00key"},
+	}
+	for _, c := range cases {
+		db, key, ok := parseDBKey(dbKey(c.db, c.key))
+		if !ok || db != c.db || key != c.key {
+			t.Errorf("parseDBKey(dbKey(%d, %q)) = %d, %q, %v", c.db, c.key, db, key, ok)
+		}
+	}
+}
+
+func TestSwapDB(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New(dir, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newTestClient(t, r)
+	other := newTestClient(t, r)
+
+	do(t, conn, "set", "key", "zero")
+	do(t, conn, "rpush", "list", "item")
+	do(t, other, "select", "1")
+	do(t, other, "set", "key", "one")
+	do(t, conn, "watch", "key")
+	assertReply(t, do(t, conn, "swapdb", "0", "1"), "+OK\r\n")
+
+	// the watched key is in the other database after the swap.
+	do(t, conn, "multi")
+	do(t, conn, "set", "key", "watched")
+	assertReply(t, do(t, conn, "exec"), "*-1\r\n")
+	assertReply(t, do(t, conn, "get", "key"), "$3\r\none\r\n")
+	assertReply(t, do(t, other, "lrange", "list", "0", "-1"), "*1\r\n$4\r\nitem\r\n")
+	r.Close()
+
+	// the order of the databases is kept after reopening.
+	r, err = New(dir, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	conn = newTestClient(t, r)
+	assertReply(t, do(t, conn, "get", "key"), "$3\r\none\r\n")
+	assertReply(t, do(t, conn, "dbsize"), ":1\r\n")
+	info := do(t, conn, "info", "keyspace").String()
+	if !strings.Contains(info, "db0:keys=1,") || !strings.Contains(info, "db1:keys=2,") {
+		t.Errorf("unexpected keyspace section:\n%s", info)
+	}
+
+	assertReply(t, do(t, conn, "flushall"), "+OK\r\n")
+	do(t, conn, "set", "key", "flushed")
+	assertReply(t, do(t, newTestClient(t, r), "get", "key"), "$7\r\nflushed\r\n")
+	if _, err := r.bitcask.Get("key"); err != nil {
+		t.Errorf("the order of the databases is not reset by FLUSHALL: %v", err)
+	}
+}
+
+func TestFlushWhileWriting(t *testing.T) {
+	r, conn := newTestServer(t)
+	writer := newTestClient(t, r)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			writer.WriteMultiBulk("set", fmt.Sprintf("key%d", i), "value")
+			writer.ReadValue()
+		}
+	}()
+
+	// the flushes list all the keys, so they must not be retried for every concurrent write.
+	for i := 0; i < 20; i++ {
+		assertReply(t, do(t, conn, "flushdb"), "+OK\r\n")
+		assertReply(t, do(t, conn, "flushall"), "+OK\r\n")
+		assertReply(t, do(t, conn, "swapdb", "0", "1"), "+OK\r\n")
+	}
+	close(done)
+	<-stopped
+}
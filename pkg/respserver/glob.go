@@ -0,0 +1,83 @@
+package respserver
+
+// matchPattern reports whether the string matches the glob-style pattern.
+// The pattern supports * for any sequence of bytes, ? for a single byte,
+// [abc], [^abc] and [a-z] for a byte class and \ to escape the next byte.
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var matched bool
+			matched, pattern = matchClass(pattern[1:], s[0])
+			if !matched {
+				return false
+			}
+			s = s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+
+	return len(s) == 0
+}
+
+// matchClass reports whether the byte matches the class at the start of the pattern,
+// the pattern starts after the opening bracket.
+// Return the rest of the pattern after the closing bracket.
+func matchClass(pattern string, c byte) (bool, string) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return matched != not, pattern
+}
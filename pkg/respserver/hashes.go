@@ -0,0 +1,401 @@
+package respserver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/tidwall/resp"
+)
+
+// errHashNotInteger happens whenever HINCRBY is called on a field whose value is not an integer.
+var errHashNotInteger = errors.New("ERR hash value is not an integer")
+
+// hset implements the callback method that handles hset requests.
+// Reply with the number of the added fields.
+func (r *RespServer) hset(c *client, args []resp.Value) resp.Value {
+	if len(args)%2 != 0 {
+		return resp.ErrorValue(fmt.Errorf(errWrongArgs, "hset"))
+	}
+
+	added, err := r.setFields(c, args[1].String(), args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(added)
+}
+
+// hmset implements the callback method that handles hmset requests.
+func (r *RespServer) hmset(c *client, args []resp.Value) resp.Value {
+	if len(args)%2 != 0 {
+		return resp.ErrorValue(fmt.Errorf(errWrongArgs, "hmset"))
+	}
+
+	_, err := r.setFields(c, args[1].String(), args[2:])
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.SimpleStringValue("OK")
+}
+
+// hsetnx implements the callback method that handles hsetnx requests.
+// Reply with 1 if the field is set or 0 if it already exists.
+func (r *RespServer) hsetnx(c *client, args []resp.Value) resp.Value {
+	key, field := args[1].String(), args[2].String()
+
+	var set bool
+	err := r.atomically(c, func(tx *txn) error {
+		set = false
+		m, err := tx.collectionOrNew(c.db, key, hashType)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Get(elemKey(m.id, field))
+		if !isNotExist(err) {
+			return err
+		}
+		err = tx.Put(elemKey(m.id, field), args[3].String())
+		if err != nil {
+			return err
+		}
+		tx.addMember(m.id, field)
+		m.size++
+		set = true
+		return tx.saveCollection(c.db, key, m)
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !set {
+		return resp.IntegerValue(0)
+	}
+
+	return resp.IntegerValue(1)
+}
+
+// hget implements the callback method that handles hget requests.
+// Reply with nil if the key or the field does not exist.
+func (r *RespServer) hget(c *client, args []resp.Value) resp.Value {
+	values, err := r.hashValues(c, args[1].String(), args[2].String())
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return values[0]
+}
+
+// hmget implements the callback method that handles hmget requests.
+// Reply with the values of the given fields, nil is replied for the fields that do not exist.
+func (r *RespServer) hmget(c *client, args []resp.Value) resp.Value {
+	fields := make([]string, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		fields = append(fields, arg.String())
+	}
+
+	values, err := r.hashValues(c, args[1].String(), fields...)
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.ArrayValue(values)
+}
+
+// hdel implements the callback method that handles hdel requests.
+// The key is removed with its last field.
+// Reply with the number of the removed fields.
+func (r *RespServer) hdel(c *client, args []resp.Value) resp.Value {
+	key := args[1].String()
+
+	var removed int
+	err := r.atomically(c, func(tx *txn) error {
+		removed = 0
+		m, ok, err := tx.collection(c.db, key, hashType)
+		if err != nil || !ok {
+			return err
+		}
+
+		for _, arg := range args[2:] {
+			field := arg.String()
+			err := tx.Delete(elemKey(m.id, field))
+			if isNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			tx.removeMember(m.id, field)
+			m.size--
+			removed++
+		}
+		if removed == 0 {
+			return nil
+		}
+		return tx.saveCollection(c.db, key, m)
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(removed)
+}
+
+// hexists implements the callback method that handles hexists requests.
+func (r *RespServer) hexists(c *client, args []resp.Value) resp.Value {
+	values, err := r.hashValues(c, args[1].String(), args[2].String())
+	if err != nil {
+		return errorReply(err)
+	}
+	if values[0].IsNull() {
+		return resp.IntegerValue(0)
+	}
+
+	return resp.IntegerValue(1)
+}
+
+// hstrlen implements the callback method that handles hstrlen requests.
+// Reply with 0 if the key or the field does not exist.
+func (r *RespServer) hstrlen(c *client, args []resp.Value) resp.Value {
+	values, err := r.hashValues(c, args[1].String(), args[2].String())
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(len(values[0].String()))
+}
+
+// hlen implements the callback method that handles hlen requests.
+func (r *RespServer) hlen(c *client, args []resp.Value) resp.Value {
+	var size int64
+	err := r.atomically(c, func(tx *txn) error {
+		m, _, err := tx.collection(c.db, args[1].String(), hashType)
+		size = m.size
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(int(size))
+}
+
+// hkeys implements the callback method that handles hkeys requests.
+// Reply with the fields sorted.
+func (r *RespServer) hkeys(c *client, args []resp.Value) resp.Value {
+	return r.hashItems(c, args[1].String(), true, false)
+}
+
+// hvals implements the callback method that handles hvals requests.
+// Reply with the values in the order of their sorted fields.
+func (r *RespServer) hvals(c *client, args []resp.Value) resp.Value {
+	return r.hashItems(c, args[1].String(), false, true)
+}
+
+// hgetall implements the callback method that handles hgetall requests.
+// Reply with the fields sorted, each followed by its value.
+func (r *RespServer) hgetall(c *client, args []resp.Value) resp.Value {
+	return r.hashItems(c, args[1].String(), true, true)
+}
+
+// hincrby implements the callback method that handles hincrby requests.
+// A field that does not exist is set to the increment.
+// Reply with the value after the increment.
+func (r *RespServer) hincrby(c *client, args []resp.Value) resp.Value {
+	key, field := args[1].String(), args[2].String()
+	delta, err := parseInt(args[3].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	var result int64
+	err = r.atomically(c, func(tx *txn) error {
+		m, err := tx.collectionOrNew(c.db, key, hashType)
+		if err != nil {
+			return err
+		}
+
+		var n int64
+		value, err := tx.Get(elemKey(m.id, field))
+		exists := err == nil
+		if err != nil && !isNotExist(err) {
+			return err
+		}
+		if exists {
+			n, err = parseInt(value)
+			if err != nil {
+				return errHashNotInteger
+			}
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return errOverflow
+		}
+
+		result = n + delta
+		err = tx.Put(elemKey(m.id, field), strconv.FormatInt(result, 10))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			tx.addMember(m.id, field)
+			m.size++
+		}
+		return tx.saveCollection(c.db, key, m)
+	})
+	if err == errHashNotInteger || err == errOverflow {
+		return resp.ErrorValue(err)
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(int(result))
+}
+
+// hscan implements the callback method that handles hscan requests.
+// Fields are returned in the order of their hashes like the keys returned by SCAN,
+// each followed by its value unless NOVALUES is given.
+func (r *RespServer) hscan(c *client, args []resp.Value) resp.Value {
+	key := args[1].String()
+	cursor, err := parseCursor(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	opts, err := parseScanOpts(args[3:], "match", "count", "novalues")
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	var items []resp.Value
+	var next uint64
+	err = r.atomically(c, func(tx *txn) error {
+		items = make([]resp.Value, 0)
+		m, ok, err := tx.collection(c.db, key, hashType)
+		if err != nil || !ok {
+			return err
+		}
+
+		var page []string
+		page, next = scanPage(tx.members(r, m.id), cursor, opts.count)
+		for _, field := range page {
+			if !matchPattern(opts.pattern, field) {
+				continue
+			}
+			value, err := tx.Get(elemKey(m.id, field))
+			if err != nil {
+				return err
+			}
+			items = append(items, resp.StringValue(field))
+			if !opts.noValues {
+				items = append(items, resp.StringValue(value))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return scanReply(next, items)
+}
+
+// setFields sets the given field value pairs in the hash stored at the key, the hash is created if needed.
+// Return the number of the added fields, errWrongType if the key is not a hash or an error on datastore failures.
+func (r *RespServer) setFields(c *client, key string, pairs []resp.Value) (int, error) {
+	var added int
+	err := r.atomically(c, func(tx *txn) error {
+		added = 0
+		m, err := tx.collectionOrNew(c.db, key, hashType)
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < len(pairs); i += 2 {
+			field := pairs[i].String()
+			_, err := tx.Get(elemKey(m.id, field))
+			if err != nil && !isNotExist(err) {
+				return err
+			}
+			if err != nil {
+				tx.addMember(m.id, field)
+				m.size++
+				added++
+			}
+
+			err = tx.Put(elemKey(m.id, field), pairs[i+1].String())
+			if err != nil {
+				return err
+			}
+		}
+		return tx.saveCollection(c.db, key, m)
+	})
+
+	return added, err
+}
+
+// hashValues returns the replies of the values of the fields of the hash stored at the key,
+// nil is replied for the fields that do not exist.
+// Return errWrongType if the key is not a hash or an error on datastore failures.
+func (r *RespServer) hashValues(c *client, key string, fields ...string) ([]resp.Value, error) {
+	var values []resp.Value
+	err := r.atomically(c, func(tx *txn) error {
+		values = make([]resp.Value, 0, len(fields))
+		m, ok, err := tx.collection(c.db, key, hashType)
+		if err != nil {
+			return err
+		}
+
+		for _, field := range fields {
+			if !ok {
+				values = append(values, resp.NullValue())
+				continue
+			}
+			value, err := tx.Get(elemKey(m.id, field))
+			if isNotExist(err) {
+				values = append(values, resp.NullValue())
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			values = append(values, resp.StringValue(value))
+		}
+		return nil
+	})
+
+	return values, err
+}
+
+// hashItems returns the reply of the sorted fields of the hash stored at the key,
+// their values or both of them with every field followed by its value.
+func (r *RespServer) hashItems(c *client, key string, fields, values bool) resp.Value {
+	var items []resp.Value
+	err := r.atomically(c, func(tx *txn) error {
+		items = make([]resp.Value, 0)
+		m, ok, err := tx.collection(c.db, key, hashType)
+		if err != nil || !ok {
+			return err
+		}
+
+		for _, field := range tx.members(r, m.id) {
+			value, err := tx.Get(elemKey(m.id, field))
+			if err != nil {
+				return err
+			}
+			if fields {
+				items = append(items, resp.StringValue(field))
+			}
+			if values {
+				items = append(items, resp.StringValue(value))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.ArrayValue(items)
+}
@@ -0,0 +1,119 @@
+package respserver
+
+import (
+	"testing"
+)
+
+func TestHashCommands(t *testing.T) {
+	r, conn := newTestServer(t)
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"hset", "user", "name"}, "-ERR wrong number of arguments for 'hset' command\r\n"},
+		{[]interface{}{"hset", "user", "name", "islam", "age", "25"}, ":2\r\n"},
+		{[]interface{}{"hset", "user", "name", "walid", "city", "cairo"}, ":1\r\n"},
+		{[]interface{}{"hsetnx", "user", "name", "other"}, ":0\r\n"},
+		{[]interface{}{"hmset", "user", "email", "x@y.z"}, "+OK\r\n"},
+		{[]interface{}{"hget", "user", "name"}, "$5\r\nwalid\r\n"},
+		{[]interface{}{"hget", "user", "missing"}, "$-1\r\n"},
+		{[]interface{}{"hget", "missing", "name"}, "$-1\r\n"},
+		{[]interface{}{"hmget", "user", "age", "missing", "city"}, "*3\r\n$2\r\n25\r\n$-1\r\n$5\r\ncairo\r\n"},
+		{[]interface{}{"hlen", "user"}, ":4\r\n"},
+		{[]interface{}{"hexists", "user", "age"}, ":1\r\n"},
+		{[]interface{}{"hexists", "user", "missing"}, ":0\r\n"},
+		{[]interface{}{"hstrlen", "user", "city"}, ":5\r\n"},
+		{[]interface{}{"hkeys", "user"}, "*4\r\n$3\r\nage\r\n$4\r\ncity\r\n$5\r\nemail\r\n$4\r\nname\r\n"},
+		{[]interface{}{"hvals", "user"}, "*4\r\n$2\r\n25\r\n$5\r\ncairo\r\n$5\r\nx@y.z\r\n$5\r\nwalid\r\n"},
+		{[]interface{}{"hincrby", "user", "age", "5"}, ":30\r\n"},
+		{[]interface{}{"hincrby", "user", "visits", "1"}, ":1\r\n"},
+		{[]interface{}{"hincrby", "user", "name", "1"}, "-ERR hash value is not an integer\r\n"},
+		{[]interface{}{"hincrby", "user", "age", "9223372036854775807"}, "-ERR increment or decrement would overflow\r\n"},
+		{[]interface{}{"hdel", "user", "email", "visits", "missing"}, ":2\r\n"},
+		{[]interface{}{"hgetall", "user"},
+			"*6\r\n$3\r\nage\r\n$2\r\n30\r\n$4\r\ncity\r\n$5\r\ncairo\r\n$4\r\nname\r\n$5\r\nwalid\r\n"},
+		{[]interface{}{"hgetall", "missing"}, "*0\r\n"},
+
+		{[]interface{}{"type", "user"}, "+hash\r\n"},
+		{[]interface{}{"exists", "user"}, ":1\r\n"},
+		{[]interface{}{"get", "user"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]interface{}{"incr", "user"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]interface{}{"setnx", "user", "value"}, ":0\r\n"},
+		{[]interface{}{"set", "string", "value"}, "+OK\r\n"},
+		{[]interface{}{"hget", "string", "field"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]interface{}{"keys", "*"}, "*2\r\n$6\r\nstring\r\n$4\r\nuser\r\n"},
+
+		{[]interface{}{"rename", "user", "renamed"}, "+OK\r\n"},
+		{[]interface{}{"hget", "renamed", "city"}, "$5\r\ncairo\r\n"},
+		{[]interface{}{"move", "renamed", "1"}, ":1\r\n"},
+		{[]interface{}{"select", "1"}, "+OK\r\n"},
+		{[]interface{}{"hlen", "renamed"}, ":3\r\n"},
+		{[]interface{}{"set", "renamed", "value"}, "+OK\r\n"},
+		{[]interface{}{"get", "renamed"}, "$5\r\nvalue\r\n"},
+		{[]interface{}{"hset", "user", "a", "1", "b", "2"}, ":2\r\n"},
+		{[]interface{}{"hdel", "user", "a", "b"}, ":2\r\n"},
+		{[]interface{}{"exists", "user"}, ":0\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+
+	// the elements of the overwritten and the emptied hashes are removed.
+	for _, key := range r.bitcask.ListKeys() {
+		if _, _, ok := parseElemKey(elemPrefix, key); ok {
+			t.Errorf("unexpected element record %q", key)
+		}
+	}
+}
+
+func TestHashPersistence(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New(dir, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newTestClient(t, r)
+
+	for _, field := range []string{"a", "b", "c", "d"} {
+		do(t, conn, "hset", "hash", field, field)
+	}
+	do(t, conn, "hdel", "hash", "b", "d")
+	err = r.bitcask.Merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	// the members index is rebuilt from the elements records.
+	r, err = New(dir, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	conn = newTestClient(t, r)
+
+	assertReply(t, do(t, conn, "hgetall", "hash"), "*4\r\n$1\r\na\r\n$1\r\na\r\n$1\r\nc\r\n$1\r\nc\r\n")
+	assertReply(t, do(t, conn, "hscan", "hash", "0", "novalues"), "*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$1\r\nc\r\n")
+	assertReply(t, do(t, conn, "hscan", "hash", "0", "match", "c"), "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nc\r\n$1\r\nc\r\n")
+}
+
+func TestHashTransactions(t *testing.T) {
+	r, conn := newTestServer(t)
+	other := newTestClient(t, r)
+
+	do(t, conn, "hset", "hash", "field", "value")
+	assertReply(t, do(t, conn, "watch", "hash"), "+OK\r\n")
+	assertReply(t, do(t, other, "hset", "hash", "field", "changed"), ":0\r\n")
+	assertReply(t, do(t, conn, "multi"), "+OK\r\n")
+	assertReply(t, do(t, conn, "hset", "hash", "other", "value"), "+QUEUED\r\n")
+	assertReply(t, do(t, conn, "exec"), "*-1\r\n")
+
+	assertReply(t, do(t, conn, "multi"), "+OK\r\n")
+	assertReply(t, do(t, conn, "hset", "hash", "other", "value"), "+QUEUED\r\n")
+	assertReply(t, do(t, conn, "hkeys", "hash"), "+QUEUED\r\n")
+	assertReply(t, do(t, conn, "del", "hash"), "+QUEUED\r\n")
+	assertReply(t, do(t, conn, "hlen", "hash"), "+QUEUED\r\n")
+	assertReply(t, do(t, conn, "exec"), "*4\r\n:1\r\n*2\r\n$5\r\nfield\r\n$5\r\nother\r\n:1\r\n:0\r\n")
+	assertReply(t, do(t, other, "hgetall", "hash"), "*0\r\n")
+}
@@ -0,0 +1,279 @@
+package respserver
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+type (
+	// infoField is a field of an INFO section.
+	infoField struct {
+		name  string
+		value string
+	}
+
+	// infoSection is a section of the INFO reply.
+	infoSection struct {
+		name   string
+		fields func() ([]infoField, error)
+	}
+)
+
+// info implements the callback method that handles info requests.
+// Reply with the fields of the given sections in the redis format, all the sections are written if none is given.
+func (r *RespServer) info(c *client, args []resp.Value) resp.Value {
+	sections := []infoSection{
+		{"Server", r.serverInfo},
+		{"Clients", r.clientsInfo},
+		{"Stats", r.statsInfo},
+		{"Bitcask", r.bitcaskInfo},
+		{"Keyspace", r.keyspaceInfo},
+	}
+
+	selected := make(map[string]bool)
+	for _, arg := range args[1:] {
+		name := strings.ToLower(arg.String())
+		if name != "all" && name != "everything" && name != "default" {
+			selected[name] = true
+		}
+	}
+
+	var b strings.Builder
+	for _, section := range sections {
+		if len(selected) > 0 && !selected[strings.ToLower(section.name)] {
+			continue
+		}
+		fields, err := section.fields()
+		if err != nil {
+			return errorReply(err)
+		}
+
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", section.name)
+		for _, field := range fields {
+			fmt.Fprintf(&b, "%s:%s\r\n", field.name, field.value)
+		}
+	}
+
+	return resp.StringValue(b.String())
+}
+
+// serverInfo returns the fields of the server section.
+func (r *RespServer) serverInfo() ([]infoField, error) {
+	uptime := int64(time.Since(r.started).Seconds())
+	_, port, _ := strings.Cut(r.port, ":")
+
+	return []infoField{
+		{"redis_version", redisVersion},
+		{"redis_mode", "standalone"},
+		{"os", runtime.GOOS + " " + runtime.GOARCH},
+		{"arch_bits", strconv.Itoa(strconv.IntSize)},
+		{"go_version", runtime.Version()},
+		{"process_id", strconv.Itoa(os.Getpid())},
+		{"tcp_port", port},
+		{"uptime_in_seconds", strconv.FormatInt(uptime, 10)},
+		{"uptime_in_days", strconv.FormatInt(uptime/86400, 10)},
+		{"datastore_dir", r.dataStoreDir},
+	}, nil
+}
+
+// clientsInfo returns the fields of the clients section.
+func (r *RespServer) clientsInfo() ([]infoField, error) {
+	r.pubsubMu.Lock()
+	subscribers := len(r.subscribers)
+	r.pubsubMu.Unlock()
+
+	r.blocked.mu.Lock()
+	blocked := len(r.blocked.clients)
+	r.blocked.mu.Unlock()
+
+	return []infoField{
+		{"connected_clients", strconv.FormatInt(r.stats.connections.Load(), 10)},
+		{"pubsub_clients", strconv.Itoa(subscribers)},
+		{"blocked_clients", strconv.Itoa(blocked)},
+	}, nil
+}
+
+// statsInfo returns the fields of the stats section.
+func (r *RespServer) statsInfo() ([]infoField, error) {
+	calls, errors := r.stats.totals()
+
+	return []infoField{
+		{"total_connections_received", strconv.FormatUint(r.stats.totalConnections.Load(), 10)},
+		{"total_commands_processed", strconv.FormatUint(calls, 10)},
+		{"total_error_replies", strconv.FormatUint(errors, 10)},
+	}, nil
+}
+
+// bitcaskInfo returns the fields of the datastore engine section.
+func (r *RespServer) bitcaskInfo() ([]infoField, error) {
+	s, err := r.bitcask.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastMerge int64
+	if !s.LastMerge.Start.IsZero() {
+		lastMerge = s.LastMerge.Start.Unix()
+	}
+	mergeStatus := "ok"
+	if s.LastMerge.Err != "" {
+		mergeStatus = "err"
+	}
+
+	return []infoField{
+		{"keys", strconv.Itoa(s.Keys)},
+		{"data_files", strconv.Itoa(s.DataFiles)},
+		{"hint_files", strconv.Itoa(s.HintFiles)},
+		{"data_bytes", strconv.FormatInt(s.DataBytes, 10)},
+		{"hint_bytes", strconv.FormatInt(s.HintBytes, 10)},
+		{"live_bytes", strconv.FormatInt(s.LiveBytes, 10)},
+		{"dead_bytes", strconv.FormatInt(s.DeadBytes, 10)},
+		{"keydir_bytes", strconv.FormatInt(s.KeyDirBytes, 10)},
+		{"sync_calls", strconv.FormatInt(s.SyncCalls, 10)},
+		{"last_merge_time", strconv.FormatInt(lastMerge, 10)},
+		{"last_merge_duration_usec", strconv.FormatInt(s.LastMerge.Duration.Microseconds(), 10)},
+		{"last_merge_removed_files", strconv.Itoa(s.LastMerge.RemovedFiles)},
+		{"last_merge_reclaimed_bytes", strconv.FormatInt(s.LastMerge.ReclaimedBytes, 10)},
+		{"last_merge_status", mergeStatus},
+	}, nil
+}
+
+// keyspaceInfo returns the fields of the keyspace section with a field for every database that has keys,
+// the datastore keys never expire.
+func (r *RespServer) keyspaceInfo() ([]infoField, error) {
+	keys := make([]int, databases)
+	dbs := r.dbs.Load()
+	r.index.mu.Lock()
+	for db := range keys {
+		keys[db] = r.index.keys[dbs[db]].len()
+	}
+	r.index.mu.Unlock()
+
+	fields := make([]infoField, 0)
+	for db, n := range keys {
+		if n > 0 {
+			fields = append(fields, infoField{fmt.Sprintf("db%d", db), fmt.Sprintf("keys=%d,expires=0,avg_ttl=0", n)})
+		}
+	}
+
+	return fields, nil
+}
+
+// command implements the callback method that handles the command subcommands.
+// Reply with the description of all the commands if no subcommand is given.
+func (r *RespServer) command(c *client, args []resp.Value) resp.Value {
+	if len(args) == 1 {
+		names := r.commandNames()
+		infos := make([]resp.Value, 0, len(names))
+		for _, name := range names {
+			infos = append(infos, commandInfo(name, r.commands[name]))
+		}
+		return resp.ArrayValue(infos)
+	}
+
+	sub := strings.ToLower(args[1].String())
+	switch {
+	case sub == "count" && len(args) == 2:
+		return resp.IntegerValue(len(r.commands))
+	case sub == "list" && len(args) == 2:
+		names := r.commandNames()
+		values := make([]resp.Value, 0, len(names))
+		for _, name := range names {
+			values = append(values, resp.StringValue(name))
+		}
+		return resp.ArrayValue(values)
+	case sub == "info":
+		infos := make([]resp.Value, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			name := strings.ToLower(arg.String())
+			cmd, ok := r.commands[name]
+			if !ok {
+				infos = append(infos, resp.NullValue())
+				continue
+			}
+			infos = append(infos, commandInfo(name, cmd))
+		}
+		return resp.ArrayValue(infos)
+	case sub == "docs":
+		// the commands have no documentation, clients fall back to their own.
+		return resp.ArrayValue([]resp.Value{})
+	case sub == "help":
+		return helpReply("COMMAND <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"(no subcommand)", "COUNT", "LIST", "INFO [<command-name> ...]", "DOCS [<command-name> ...]")
+	default:
+		return resp.ErrorValue(fmt.Errorf(errUnknownSubcommand, args[1].String(), "COMMAND"))
+	}
+}
+
+// config implements the callback method that handles the config subcommands,
+// notify-keyspace-events is the only supported parameter.
+func (r *RespServer) config(c *client, args []resp.Value) resp.Value {
+	sub := strings.ToLower(args[1].String())
+	switch {
+	case sub == "get" && len(args) > 2:
+		values := make([]resp.Value, 0, 2)
+		for _, arg := range args[2:] {
+			if matchPattern(strings.ToLower(arg.String()), notifyKeyspaceEventsParam) {
+				values = append(values, resp.StringValue(notifyKeyspaceEventsParam), resp.StringValue(r.notifyKeyspaceEvents()))
+				break
+			}
+		}
+		return resp.ArrayValue(values)
+	case sub == "set" && len(args) == 4:
+		param := strings.ToLower(args[2].String())
+		if param != notifyKeyspaceEventsParam {
+			return resp.ErrorValue(fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[2].String()))
+		}
+		err := r.SetNotifyKeyspaceEvents(args[3].String())
+		if err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET '%s' - %s", args[3].String(), param, err))
+		}
+		return resp.SimpleStringValue("OK")
+	case sub == "help":
+		return helpReply("CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GET <pattern> [<pattern> ...]", "SET <parameter> <value>")
+	default:
+		return resp.ErrorValue(fmt.Errorf(errUnknownSubcommand, args[1].String(), "CONFIG"))
+	}
+}
+
+// commandNames returns the sorted names of the registered commands.
+func (r *RespServer) commandNames() []string {
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// commandInfo returns the description of a command in the COMMAND reply format,
+// the acl categories, tips, key specs and subcommands are left empty.
+func commandInfo(name string, cmd command) resp.Value {
+	flags := make([]resp.Value, 0)
+	for _, flag := range strings.Fields(cmd.flags) {
+		flags = append(flags, resp.SimpleStringValue(flag))
+	}
+	empty := resp.ArrayValue([]resp.Value{})
+
+	return resp.ArrayValue([]resp.Value{
+		resp.StringValue(name),
+		resp.IntegerValue(cmd.arity),
+		resp.ArrayValue(flags),
+		resp.IntegerValue(cmd.firstKey),
+		resp.IntegerValue(cmd.lastKey),
+		resp.IntegerValue(cmd.step),
+		empty, empty, empty, empty,
+	})
+}
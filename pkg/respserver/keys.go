@@ -0,0 +1,330 @@
+package respserver
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/tidwall/resp"
+)
+
+// scanCount is the number of keys returned by SCAN if COUNT is not given.
+const scanCount = 10
+
+// scanOpts represents the options of the scan commands.
+type scanOpts struct {
+	pattern  string
+	typ      string
+	count    int64
+	noValues bool
+}
+
+var (
+	// errSyntax happens whenever a command is called with invalid options.
+	errSyntax = errors.New("ERR syntax error")
+	// errInvalidCursor happens whenever SCAN is called with a cursor that is not an unsigned integer.
+	errInvalidCursor = errors.New("ERR invalid cursor")
+	// errNoSuchKey happens whenever a command needs a key that does not exist.
+	errNoSuchKey = errors.New("ERR no such key")
+)
+
+// keys implements the callback method that handles keys requests.
+// Reply with the sorted keys matching the glob pattern.
+func (r *RespServer) keys(c *client, args []resp.Value) resp.Value {
+	pattern := args[1].String()
+
+	keys := c.store.ListKeys()
+	sort.Strings(keys)
+
+	matched := make([]resp.Value, 0)
+	for _, key := range keys {
+		if matchPattern(pattern, key) {
+			matched = append(matched, resp.StringValue(key))
+		}
+	}
+
+	return resp.ArrayValue(matched)
+}
+
+// scan implements the callback method that handles scan requests.
+// Keys are returned in the order of their hashes and the cursor is the hash of the next key to return,
+// so every key that exists during the whole iteration is returned even if other keys are written meanwhile.
+// The keys are read from the keys index in O(log n) plus the page size, so the datastore is not locked
+// between the calls and no call lists all the keys.
+// MATCH and TYPE filter the keys after COUNT keys are picked, so fewer keys can be returned.
+func (r *RespServer) scan(c *client, args []resp.Value) resp.Value {
+	cursor, err := parseCursor(args[1].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	opts, err := parseScanOpts(args[2:], "match", "count", "type")
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	var page []string
+	var next uint64
+	c.store.keys(func(z *zset) {
+		page, next = scanKeys(z, cursor, opts.count)
+	})
+
+	keys := make([]resp.Value, 0, len(page))
+	for _, key := range page {
+		if !matchPattern(opts.pattern, key) {
+			continue
+		}
+		if opts.typ != "" && opts.typ != r.keyType(c, key) {
+			continue
+		}
+		keys = append(keys, resp.StringValue(key))
+	}
+
+	return scanReply(next, keys)
+}
+
+// dbsize implements the callback method that handles dbsize requests.
+func (r *RespServer) dbsize(c *client, args []resp.Value) resp.Value {
+	var n int
+	c.store.keys(func(z *zset) {
+		n = z.len()
+	})
+
+	return resp.IntegerValue(n)
+}
+
+// randomkey implements the callback method that handles randomkey requests.
+// Reply with nil if the datastore is empty.
+func (r *RespServer) randomkey(c *client, args []resp.Value) resp.Value {
+	var key string
+	var ok bool
+	c.store.keys(func(z *zset) {
+		if z.len() > 0 {
+			key, ok = z.nodeAt(rand.Intn(z.len())).member, true
+		}
+	})
+	if !ok {
+		return resp.NullValue()
+	}
+
+	return resp.StringValue(key)
+}
+
+// rename implements the callback method that handles rename requests.
+func (r *RespServer) rename(c *client, args []resp.Value) resp.Value {
+	_, err := r.renameKey(c, args[1].String(), args[2].String(), false)
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	return resp.SimpleStringValue("OK")
+}
+
+// renamenx implements the callback method that handles renamenx requests.
+// Reply with 1 if the key is renamed or 0 if the new key already exists.
+func (r *RespServer) renamenx(c *client, args []resp.Value) resp.Value {
+	renamed, err := r.renameKey(c, args[1].String(), args[2].String(), true)
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	if !renamed {
+		return resp.IntegerValue(0)
+	}
+
+	return resp.IntegerValue(1)
+}
+
+// typ implements the callback method that handles type requests.
+func (r *RespServer) typ(c *client, args []resp.Value) resp.Value {
+	return resp.SimpleStringValue(r.keyType(c, args[1].String()))
+}
+
+// renameKey moves the value of the src key to the dst key atomically,
+// the dst key is left unchanged if nx is set and it already exists.
+// Return whether the key is renamed.
+// Return errNoSuchKey if the src key does not exist or an error on datastore failures.
+func (r *RespServer) renameKey(c *client, src, dst string, nx bool) (bool, error) {
+	var renamed bool
+	err := r.atomically(c, func(tx *txn) error {
+		renamed = false
+		s := inDB(tx, c.db)
+		value, err := s.Get(src)
+		if isNotExist(err) {
+			return errNoSuchKey
+		}
+		if err != nil {
+			return err
+		}
+
+		if nx {
+			_, err := s.Get(dst)
+			if !isNotExist(err) {
+				return err
+			}
+		}
+		renamed = true
+		if src == dst {
+			return nil
+		}
+
+		// the value is moved as it is stored, so a collection keeps its elements.
+		err = r.keyspace(tx, c.db).replace(tx, dst, value, bitcask.UpdatePut)
+		if err != nil {
+			return err
+		}
+		return s.Delete(src)
+	})
+	if err != nil && err != errNoSuchKey {
+		return false, fmt.Errorf("ERR %s", err)
+	}
+
+	return renamed, err
+}
+
+// keyType returns the name of the type of the key value, or none if the key does not exist.
+func (r *RespServer) keyType(c *client, key string) string {
+	typ, err := c.store.Type(key)
+	if err != nil {
+		return "none"
+	}
+
+	return typ
+}
+
+// parseScanOpts parses the options of the scan commands given after the cursor, allowed are the accepted options.
+// NOVALUES is the only option without a value.
+// Return errSyntax if an option is not allowed, its value is missing or COUNT is not positive.
+func parseScanOpts(args []resp.Value, allowed ...string) (scanOpts, error) {
+	opts := scanOpts{pattern: "*", count: scanCount}
+	for i := 0; i < len(args); i += 2 {
+		name := strings.ToLower(args[i].String())
+		if !contains(allowed, name) {
+			return scanOpts{}, errSyntax
+		}
+		if name == "novalues" {
+			opts.noValues = true
+			i--
+			continue
+		}
+		if i+1 == len(args) {
+			return scanOpts{}, errSyntax
+		}
+
+		value := args[i+1].String()
+		switch name {
+		case "match":
+			opts.pattern = value
+		case "type":
+			opts.typ = strings.ToLower(value)
+		case "count":
+			count, err := parseInt(value)
+			if err != nil {
+				return scanOpts{}, err
+			}
+			if count < 1 {
+				return scanOpts{}, errSyntax
+			}
+			opts.count = count
+		}
+	}
+
+	return opts, nil
+}
+
+// parseCursor parses the cursor of the scan commands.
+// Return errInvalidCursor if the cursor is not an unsigned integer.
+func parseCursor(s string) (uint64, error) {
+	cursor, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// scanPage returns count of the given names starting at the cursor in the order of their hashes,
+// and the cursor of the next page, which is 0 after the last page.
+// The names with the same hash are returned together, as the cursor cannot point between them.
+func scanPage(names []string, cursor uint64, count int64) ([]string, uint64) {
+	type hashedName struct {
+		name string
+		hash uint64
+	}
+	candidates := make([]hashedName, 0)
+	for _, name := range names {
+		if h := keyHash(name); h >= cursor {
+			candidates = append(candidates, hashedName{name, h})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].hash != candidates[j].hash {
+			return candidates[i].hash < candidates[j].hash
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	n := 0
+	for n < len(candidates) && (int64(n) < count || candidates[n].hash == candidates[n-1].hash) {
+		n++
+	}
+
+	next := uint64(0)
+	if n < len(candidates) {
+		next = candidates[n].hash
+	}
+
+	page := make([]string, 0, n)
+	for _, candidate := range candidates[:n] {
+		page = append(page, candidate.name)
+	}
+
+	return page, next
+}
+
+// scanKeys returns count of the keys starting at the cursor in the order of their hashes,
+// and the cursor of the next page, which is 0 after the last page.
+// The keys with the same hash are returned together, as the cursor cannot point between them.
+func scanKeys(z *zset, cursor uint64, count int64) ([]string, uint64) {
+	page := make([]string, 0)
+	x := z.firstInRange(zbound{score: float64(cursor)})
+	for ; x != nil && (int64(len(page)) < count || x.score == x.prev.score); x = x.levels[0].next {
+		page = append(page, x.member)
+	}
+
+	if x == nil {
+		return page, 0
+	}
+
+	return page, uint64(x.score)
+}
+
+// scanReply returns the reply of the scan commands.
+func scanReply(next uint64, items []resp.Value) resp.Value {
+	return resp.ArrayValue([]resp.Value{
+		resp.StringValue(strconv.FormatUint(next, 10)), resp.ArrayValue(items),
+	})
+}
+
+// contains reports whether the list contains the string.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keyHash returns the hash that orders the keys returned by SCAN.
+// The hash is cut to 53 bits, so it is kept exactly as the score of the keys in the keys index.
+func keyHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	return h.Sum64() >> 11
+}
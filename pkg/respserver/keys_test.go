@@ -0,0 +1,130 @@
+package respserver
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+func TestKeyspaceCommands(t *testing.T) {
+	_, conn := newTestServer(t)
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"randomkey"}, "$-1\r\n"},
+		{[]interface{}{"mset", "user:1", "a", "user:2", "b", "other", "c"}, "+OK\r\n"},
+		{[]interface{}{"keys", "user:*"}, "*2\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n"},
+		{[]interface{}{"dbsize"}, ":3\r\n"},
+		{[]interface{}{"type", "other"}, "+string\r\n"},
+		{[]interface{}{"type", "missing"}, "+none\r\n"},
+		{[]interface{}{"rename", "other", "renamed"}, "+OK\r\n"},
+		{[]interface{}{"rename", "other", "renamed"}, "-ERR no such key\r\n"},
+		{[]interface{}{"renamenx", "renamed", "user:1"}, ":0\r\n"},
+		{[]interface{}{"renamenx", "renamed", "other"}, ":1\r\n"},
+		{[]interface{}{"mget", "other", "renamed"}, "*2\r\n$1\r\nc\r\n$-1\r\n"},
+		{[]interface{}{"scan", "x"}, "-ERR invalid cursor\r\n"},
+		{[]interface{}{"scan", "0", "count", "0"}, "-ERR syntax error\r\n"},
+		{[]interface{}{"scan", "0", "match"}, "-ERR syntax error\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	_, conn := newTestServer(t)
+
+	for i := 0; i < 100; i++ {
+		do(t, conn, "set", fmt.Sprintf("key%d", i), "value")
+	}
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for i := 0; ; i++ {
+		// keys written during the iteration must not make the existing keys skipped.
+		do(t, conn, "set", fmt.Sprintf("new%d", i), "value")
+
+		reply := do(t, conn, "scan", cursor, "match", "key*", "count", "7").Array()
+		for _, key := range reply[1].Array() {
+			seen[key.String()] = true
+		}
+		cursor = reply[0].String()
+		if cursor == "0" {
+			break
+		}
+	}
+
+	if len(seen) != 100 {
+		t.Errorf("scan returned %d keys, want 100", len(seen))
+	}
+}
+
+func TestKeysIndex(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New(dir, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newTestClient(t, r)
+
+	do(t, conn, "mset", "a", "1", "b", "2", "\x00c", "3")
+	do(t, conn, "hset", "hash", "field", "value")
+	do(t, conn, "rpush", "list", "item")
+	do(t, conn, "del", "b")
+	do(t, conn, "rename", "a", "renamed")
+	do(t, conn, "move", "hash", "1")
+	do(t, conn, "multi")
+	do(t, conn, "set", "queued", "value")
+	do(t, conn, "dbsize")
+	assertReply(t, do(t, conn, "exec"), "*2\r\n+OK\r\n:4\r\n")
+
+	want := map[int]int{0: 4, 1: 1}
+	assertKeysIndex(t, r, conn, want)
+	r.Close()
+
+	// the keys index is rebuilt from the datastore keys.
+	r, err = New(dir, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	assertKeysIndex(t, r, newTestClient(t, r), want)
+}
+
+// assertKeysIndex checks that DBSIZE and a whole SCAN of every database match the keys stored in the datastore,
+// and that every database has the wanted number of keys.
+func assertKeysIndex(t *testing.T, r *RespServer, conn *resp.Conn, want map[int]int) {
+	t.Helper()
+	for db := 0; db < databases; db++ {
+		stored := make(map[string]bool)
+		for _, key := range inDB(r.bitcask, db).ListKeys() {
+			stored[key] = true
+		}
+		if len(stored) != want[db] {
+			t.Errorf("db%d: got %d keys in the datastore, want %d", db, len(stored), want[db])
+		}
+
+		do(t, conn, "select", db)
+		assertReply(t, do(t, conn, "dbsize"), fmt.Sprintf(":%d\r\n", len(stored)))
+
+		scanned := make(map[string]bool)
+		cursor := "0"
+		for {
+			reply := do(t, conn, "scan", cursor, "count", "2").Array()
+			for _, key := range reply[1].Array() {
+				scanned[key.String()] = true
+			}
+			cursor = reply[0].String()
+			if cursor == "0" {
+				break
+			}
+		}
+		if !reflect.DeepEqual(scanned, stored) {
+			t.Errorf("db%d: scan returned %v, want %v", db, scanned, stored)
+		}
+	}
+}
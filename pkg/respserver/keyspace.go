@@ -0,0 +1,246 @@
+package respserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/IslamWalid/bitcask/internal/datastore"
+)
+
+const (
+	// encodingKey is the key of the record holding the version of the encoding of the stored values.
+	encodingKey = "\x00version"
+	// encodingVersion is the version of the encoding of the stored values,
+	// the strings starting with a zero byte are escaped since version 1.
+	encodingVersion = "1"
+)
+
+// errWrongType happens whenever a command is used on a key holding a value of another type.
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// keyspace represents the keys of a logical database of a store, their values are strings or collections.
+// It implements store with the semantics of the string commands, so the strings are read and written as they are
+// and reading a collection fails with errWrongType, while writing or removing a key removes its collection.
+// The stored strings starting with a zero byte are escaped with another zero byte,
+// as the values starting with a zero byte followed by a type tag are collections.
+// The datastores written before the strings were escaped are migrated when the server starts.
+type keyspace struct {
+	r  *RespServer
+	s  store
+	db int
+}
+
+// keyspace returns the given logical database of the store, which is the datastore or a transaction.
+func (r *RespServer) keyspace(s store, db int) keyspace {
+	return keyspace{r: r, s: s, db: db}
+}
+
+// Get retrieves the string value of the key.
+// Return an error if the key does not exist or errWrongType if it holds a collection.
+func (k keyspace) Get(key string) (string, error) {
+	stored, err := inDB(k.s, k.db).Get(key)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := parseMeta(stored); ok {
+		return "", errWrongType
+	}
+
+	return decodeString(stored), nil
+}
+
+// Put stores the string value of the key, removing the collection it holds if any.
+func (k keyspace) Put(key, value string) error {
+	return k.write(key, encodeString(value), bitcask.UpdatePut)
+}
+
+// Delete removes the key and the collection it holds if any.
+// Return an error if the key does not exist.
+func (k keyspace) Delete(key string) error {
+	return k.write(key, "", bitcask.UpdateDelete)
+}
+
+// Update rewrites the string value of the key with the value returned by fn.
+// Return errWrongType if the key holds a collection.
+func (k keyspace) Update(key string, fn func(value string, exists bool) (string, bitcask.UpdateOp)) error {
+	var wrongType bool
+	err := inDB(k.s, k.db).Update(key, func(stored string, exists bool) (string, bitcask.UpdateOp) {
+		if _, ok := parseMeta(stored); ok {
+			wrongType = true
+			return "", bitcask.UpdateKeep
+		}
+
+		value, op := fn(decodeString(stored), exists)
+		return encodeString(value), op
+	})
+	if err != nil {
+		return err
+	}
+	if wrongType {
+		return errWrongType
+	}
+
+	return nil
+}
+
+// ListKeys lists the keys of the database.
+func (k keyspace) ListKeys() []string {
+	return inDB(k.s, k.db).ListKeys()
+}
+
+// Type returns the name of the type of the key value, or none if the key does not exist.
+func (k keyspace) Type(key string) (string, error) {
+	stored, err := inDB(k.s, k.db).Get(key)
+	if isNotExist(err) {
+		return "none", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if m, ok := parseMeta(stored); ok {
+		return m.typeName(), nil
+	}
+
+	return "string", nil
+}
+
+// keys runs fn with the keys of the database ordered by their hashes, fn must not use the index.
+// If the keyspace is in a transaction, fn gets the keys as seen by the transaction.
+func (k keyspace) keys(fn func(z *zset)) {
+	db := storedDB(k.s, k.db)
+	if tx, ok := k.s.(*txn); ok {
+		tx.keys(k.r, db, fn)
+		return
+	}
+
+	k.r.index.mu.Lock()
+	defer k.r.index.mu.Unlock()
+	fn(k.r.index.keys[db])
+}
+
+// atomically runs fn on a transaction committed after fn returns, so its writes are applied together.
+// fn gets the whole transaction, so it can use any database with inDB.
+// If the keyspace is already in a transaction, fn uses it.
+// fn is run again if the transaction conflicts with a concurrent write.
+// Return the error returned by fn or an error if the transaction cannot be committed.
+func (k keyspace) atomically(fn func(tx *txn) error) error {
+	if tx, ok := k.s.(*txn); ok {
+		return fn(tx)
+	}
+
+	return k.r.transact(fn)
+}
+
+// write stores the given stored value or removes the key depending on op.
+// Plain values are written with a single update, and the keys holding collections
+// are rewritten in a transaction that removes their elements too.
+func (k keyspace) write(key, stored string, op bitcask.UpdateOp) error {
+	if tx, ok := k.s.(*txn); ok {
+		return k.replace(tx, key, stored, op)
+	}
+
+	var collection, exists bool
+	err := inDB(k.s, k.db).Update(key, func(old string, found bool) (string, bitcask.UpdateOp) {
+		exists = found
+		if _, ok := parseMeta(old); ok {
+			collection = true
+			return "", bitcask.UpdateKeep
+		}
+		return stored, op
+	})
+	if err != nil {
+		return err
+	}
+	if collection {
+		return k.r.transact(func(tx *txn) error {
+			return k.replace(tx, key, stored, op)
+		})
+	}
+	if op == bitcask.UpdateDelete && !exists {
+		return fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+	}
+
+	return nil
+}
+
+// replace stores the given stored value or removes the key depending on op in the transaction,
+// the elements of the collection held by the key are removed.
+// Return an error if the key is removed and it does not exist.
+func (k keyspace) replace(tx *txn, key, stored string, op bitcask.UpdateOp) error {
+	old, err := inDB(tx, k.db).Get(key)
+	if err != nil && !(isNotExist(err) && op == bitcask.UpdatePut) {
+		return err
+	}
+	if m, ok := parseMeta(old); ok {
+		err := tx.removeElements(k.r, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	if op == bitcask.UpdateDelete {
+		return inDB(tx, k.db).Delete(key)
+	}
+
+	return inDB(tx, k.db).Put(key, stored)
+}
+
+// migrateStrings escapes the stored strings starting with a zero byte if the datastore has no encoding version,
+// as it was written before the strings were escaped and the collections were supported, then records the version.
+// The strings are rewritten in a single transaction, so an interrupted migration is run again.
+// Return an error if the encoding version is unknown or if the values cannot be read or written.
+func migrateStrings(b *bitcask.Bitcask) error {
+	version, err := b.Get(encodingKey)
+	if err == nil && version != encodingVersion {
+		return fmt.Errorf("unknown values encoding version %q", version)
+	}
+	if !isNotExist(err) {
+		return err
+	}
+
+	tx := b.Begin()
+	for _, key := range b.ListKeys() {
+		if _, _, ok := parseDBKey(key); !ok {
+			continue
+		}
+		value, err := tx.Get(key)
+		if err != nil {
+			tx.Discard()
+			return err
+		}
+		if strings.HasPrefix(value, "\x00") {
+			err = tx.Put(key, "\x00"+value)
+			if err != nil {
+				tx.Discard()
+				return err
+			}
+		}
+	}
+	err = tx.Put(encodingKey, encodingVersion)
+	if err != nil {
+		tx.Discard()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// encodeString returns the stored value of a string value.
+func encodeString(value string) string {
+	if strings.HasPrefix(value, "\x00") {
+		return "\x00" + value
+	}
+
+	return value
+}
+
+// decodeString returns the string value of a stored value.
+func decodeString(stored string) string {
+	if strings.HasPrefix(stored, "\x00\x00") {
+		return stored[1:]
+	}
+
+	return stored
+}
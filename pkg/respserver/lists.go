@@ -0,0 +1,276 @@
+package respserver
+
+import (
+	"errors"
+
+	"github.com/tidwall/resp"
+)
+
+// errNotPositive happens whenever a count argument is negative.
+var errNotPositive = errors.New("ERR value is out of range, must be positive")
+
+// lpush implements the callback method that handles lpush requests.
+// The values are pushed one after the other, so they end up in the reverse order.
+// Reply with the length of the list after pushing.
+func (r *RespServer) lpush(c *client, args []resp.Value) resp.Value {
+	return r.push(c, args[1].String(), args[2:], true)
+}
+
+// rpush implements the callback method that handles rpush requests.
+// Reply with the length of the list after pushing.
+func (r *RespServer) rpush(c *client, args []resp.Value) resp.Value {
+	return r.push(c, args[1].String(), args[2:], false)
+}
+
+// lpop implements the callback method that handles lpop requests.
+// Reply with the popped value, or an array of the popped values if a count is given,
+// nil is replied if the key does not exist.
+func (r *RespServer) lpop(c *client, args []resp.Value) resp.Value {
+	return r.pop(c, args, true)
+}
+
+// rpop implements the callback method that handles rpop requests.
+// Reply with the popped value, or an array of the popped values if a count is given,
+// nil is replied if the key does not exist.
+func (r *RespServer) rpop(c *client, args []resp.Value) resp.Value {
+	return r.pop(c, args, false)
+}
+
+// llen implements the callback method that handles llen requests.
+func (r *RespServer) llen(c *client, args []resp.Value) resp.Value {
+	var size int64
+	err := r.atomically(c, func(tx *txn) error {
+		m, _, err := tx.collection(c.db, args[1].String(), listType)
+		size = m.size
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(int(size))
+}
+
+// lindex implements the callback method that handles lindex requests.
+// Negative indexes count from the end of the list.
+// Reply with nil if the index is out of the list.
+func (r *RespServer) lindex(c *client, args []resp.Value) resp.Value {
+	index, err := parseInt(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	value := resp.NullValue()
+	err = r.atomically(c, func(tx *txn) error {
+		value = resp.NullValue()
+		m, ok, err := tx.collection(c.db, args[1].String(), listType)
+		if err != nil || !ok {
+			return err
+		}
+
+		i := index
+		if i < 0 {
+			i += m.size
+		}
+		if i < 0 || i >= m.size {
+			return nil
+		}
+		item, err := tx.Get(itemKey(m.id, m.head+i))
+		if err != nil {
+			return err
+		}
+		value = resp.StringValue(item)
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return value
+}
+
+// lrange implements the callback method that handles lrange requests.
+// Negative indexes count from the end of the list and the range is clamped to the list.
+// Reply with the values from start to stop inclusive.
+func (r *RespServer) lrange(c *client, args []resp.Value) resp.Value {
+	start, err := parseInt(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	stop, err := parseInt(args[3].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	var items []resp.Value
+	err = r.atomically(c, func(tx *txn) error {
+		items = make([]resp.Value, 0)
+		m, ok, err := tx.collection(c.db, args[1].String(), listType)
+		if err != nil || !ok {
+			return err
+		}
+
+		first, last := start, stop
+		if first < 0 {
+			first += m.size
+		}
+		if last < 0 {
+			last += m.size
+		}
+		if first < 0 {
+			first = 0
+		}
+		if last >= m.size {
+			last = m.size - 1
+		}
+
+		for i := first; i <= last; i++ {
+			item, err := tx.Get(itemKey(m.id, m.head+i))
+			if err != nil {
+				return err
+			}
+			items = append(items, resp.StringValue(item))
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.ArrayValue(items)
+}
+
+// push pushes the values to the head or the tail of the list stored at the key, the list is created if needed.
+// Reply with the length of the list after pushing.
+func (r *RespServer) push(c *client, key string, values []resp.Value, head bool) resp.Value {
+	items := make([]string, 0, len(values))
+	for _, value := range values {
+		items = append(items, value.String())
+	}
+
+	var size int64
+	err := r.atomically(c, func(tx *txn) error {
+		var err error
+		size, err = tx.pushItems(c.db, key, items, head)
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(int(size))
+}
+
+// pushItems pushes the items to the head or the tail of the list stored at the key of the database,
+// the list is created if needed and the clients blocked on the key are woken once the transaction is committed.
+// Return the length of the list after pushing, or errWrongType if the key is not a list.
+func (tx *txn) pushItems(db int, key string, items []string, head bool) (int64, error) {
+	m, err := tx.collectionOrNew(db, key, listType)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range items {
+		pos := m.head + m.size
+		if head {
+			m.head--
+			pos = m.head
+		}
+		err := tx.Put(itemKey(m.id, pos), item)
+		if err != nil {
+			return 0, err
+		}
+		m.size++
+	}
+	tx.ready = append(tx.ready, dbKey(db, key))
+
+	return m.size, tx.saveCollection(db, key, m)
+}
+
+// pop pops values from the head or the tail of the list stored at the key,
+// the values left for the blocked clients are not popped and the key is removed with its last value.
+// Reply with the popped value, or an array of the popped values if a count is given.
+func (r *RespServer) pop(c *client, args []resp.Value, head bool) resp.Value {
+	if len(args) > 3 {
+		return resp.ErrorValue(errSyntax)
+	}
+	count := int64(1)
+	if len(args) == 3 {
+		n, err := parseInt(args[2].String())
+		if err != nil || n < 0 {
+			return resp.ErrorValue(errNotPositive)
+		}
+		count = n
+	}
+
+	var popped []string
+	var exists bool
+	err := r.atomically(c, func(tx *txn) error {
+		var err error
+		popped, exists, err = tx.popItems(c.db, args[1].String(), count, head, r.waitersBefore(c, args[1].String()))
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if len(args) == 2 {
+		if len(popped) == 0 {
+			return resp.NullValue()
+		}
+		return resp.StringValue(popped[0])
+	}
+	if !exists {
+		return nullArray
+	}
+
+	values := make([]resp.Value, 0, len(popped))
+	for _, value := range popped {
+		values = append(values, resp.StringValue(value))
+	}
+
+	return resp.ArrayValue(values)
+}
+
+// popItems removes up to count items from the head or the tail of the list stored at the key of the database,
+// leaving the given number of items to the clients blocked on the key before the popping one,
+// the key is removed with its last item.
+// Return the removed items and whether the list exists, or errWrongType if the key is not a list.
+// The list does not exist for the popping client if all its items are left to the blocked clients.
+func (tx *txn) popItems(db int, key string, count int64, head bool, keep int) ([]string, bool, error) {
+	m, ok, err := tx.collection(db, key, listType)
+	if err != nil || !ok || m.size <= int64(keep) {
+		return nil, false, err
+	}
+	if avail := m.size - int64(keep); count > avail {
+		count = avail
+	}
+
+	popped := make([]string, 0)
+	for ; count > 0 && m.size > 0; count-- {
+		pos := m.head + m.size - 1
+		if head {
+			pos = m.head
+		}
+
+		item, err := tx.Get(itemKey(m.id, pos))
+		if err != nil {
+			return nil, false, err
+		}
+		err = tx.Delete(itemKey(m.id, pos))
+		if err != nil {
+			return nil, false, err
+		}
+		popped = append(popped, item)
+
+		if head {
+			m.head++
+		}
+		m.size--
+	}
+	if len(popped) == 0 {
+		return popped, true, nil
+	}
+
+	return popped, true, tx.saveCollection(db, key, m)
+}
@@ -0,0 +1,66 @@
+package respserver
+
+import (
+	"testing"
+)
+
+func TestListCommands(t *testing.T) {
+	r, conn := newTestServer(t)
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"rpush", "list", "b", "c"}, ":2\r\n"},
+		{[]interface{}{"lpush", "list", "a", "z"}, ":4\r\n"},
+		{[]interface{}{"lrange", "list", "0", "-1"}, "*4\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]interface{}{"lrange", "list", "-2", "10"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]interface{}{"lrange", "list", "3", "1"}, "*0\r\n"},
+		{[]interface{}{"lrange", "missing", "0", "-1"}, "*0\r\n"},
+		{[]interface{}{"lindex", "list", "1"}, "$1\r\na\r\n"},
+		{[]interface{}{"lindex", "list", "-1"}, "$1\r\nc\r\n"},
+		{[]interface{}{"lindex", "list", "4"}, "$-1\r\n"},
+		{[]interface{}{"lindex", "list", "one"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]interface{}{"llen", "list"}, ":4\r\n"},
+		{[]interface{}{"lpop", "list"}, "$1\r\nz\r\n"},
+		{[]interface{}{"rpop", "list"}, "$1\r\nc\r\n"},
+		{[]interface{}{"lpop", "list", "-1"}, "-ERR value is out of range, must be positive\r\n"},
+		{[]interface{}{"lpop", "list", "0"}, "*0\r\n"},
+		{[]interface{}{"rpop", "list", "5"}, "*2\r\n$1\r\nb\r\n$1\r\na\r\n"},
+		{[]interface{}{"exists", "list"}, ":0\r\n"},
+		{[]interface{}{"lpop", "list"}, "$-1\r\n"},
+		{[]interface{}{"lpop", "list", "1"}, "*-1\r\n"},
+		{[]interface{}{"llen", "list"}, ":0\r\n"},
+
+		{[]interface{}{"rpush", "list", "a"}, ":1\r\n"},
+		{[]interface{}{"type", "list"}, "+list\r\n"},
+		{[]interface{}{"set", "string", "value"}, "+OK\r\n"},
+		{[]interface{}{"lpush", "string", "a"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]interface{}{"strlen", "list"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]interface{}{"del", "list"}, ":1\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+
+	for _, key := range r.bitcask.ListKeys() {
+		if key != "string" && key != encodingKey {
+			t.Errorf("unexpected record %q", key)
+		}
+	}
+}
+
+func TestListQueue(t *testing.T) {
+	_, conn := newTestServer(t)
+
+	// pushing and popping moves the head without rewriting the other items.
+	for i := 0; i < 100; i++ {
+		do(t, conn, "rpush", "queue", i)
+		if i%2 == 1 {
+			do(t, conn, "lpop", "queue")
+		}
+	}
+	assertReply(t, do(t, conn, "llen", "queue"), ":50\r\n")
+	assertReply(t, do(t, conn, "lindex", "queue", "0"), "$2\r\n50\r\n")
+	assertReply(t, do(t, conn, "lrange", "queue", "-2", "-1"), "*2\r\n$2\r\n98\r\n$2\r\n99\r\n")
+}
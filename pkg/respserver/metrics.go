@@ -0,0 +1,245 @@
+package respserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/tidwall/resp"
+)
+
+// unknownCommand is the command label used for the commands that have no handler.
+const unknownCommand = "unknown"
+
+// latencyBuckets are the upper bounds in seconds of the command latency histogram buckets.
+var latencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+type (
+	// serverStats collects the statistics of the connections and the commands served.
+	serverStats struct {
+		connections      atomic.Int64
+		totalConnections atomic.Uint64
+
+		mu       sync.Mutex
+		commands map[string]*commandStats
+	}
+
+	// commandStats collects the calls of a command.
+	// buckets counts the calls per latency bucket, not cumulatively, the last bucket is +Inf.
+	commandStats struct {
+		calls   uint64
+		errors  uint64
+		seconds float64
+		buckets []uint64
+	}
+
+	// operation names a bitcask operation statistics.
+	operation struct {
+		name  string
+		stats bitcask.OpStats
+	}
+)
+
+// MetricsHandler returns an HTTP handler that serves the datastore and the server statistics
+// in the Prometheus text format.
+func (r *RespServer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err := r.WriteMetrics(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// WriteMetrics writes the datastore and the server statistics to the given writer in the Prometheus text format.
+// Return an error if the datastore statistics cannot be read or on write failures.
+func (r *RespServer) WriteMetrics(w io.Writer) error {
+	s, err := r.bitcask.Stats()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	writeEngineMetrics(bw, s)
+	r.stats.write(bw)
+
+	return bw.Flush()
+}
+
+// writeEngineMetrics writes the bitcask datastore statistics.
+func writeEngineMetrics(w io.Writer, s bitcask.Stats) {
+	writeGauge(w, "bitcask_keys", "Number of keys in the datastore.", float64(s.Keys))
+	writeGauge(w, "bitcask_data_files", "Number of data files.", float64(s.DataFiles))
+	writeGauge(w, "bitcask_hint_files", "Number of hint files.", float64(s.HintFiles))
+	writeGauge(w, "bitcask_data_bytes", "Size of the data files in bytes.", float64(s.DataBytes))
+	writeGauge(w, "bitcask_hint_bytes", "Size of the hint files in bytes.", float64(s.HintBytes))
+	writeGauge(w, "bitcask_live_bytes", "Size of the data records the keydir points at in bytes.", float64(s.LiveBytes))
+	writeGauge(w, "bitcask_dead_bytes", "Size of the data records that can be reclaimed by a merge in bytes.", float64(s.DeadBytes))
+	writeGauge(w, "bitcask_keydir_bytes", "Estimated memory used by the keydir in bytes.", float64(s.KeyDirBytes))
+	writeCounter(w, "bitcask_syncs_total", "Number of data and hint files flushes to the disk.", float64(s.SyncCalls))
+
+	ops := []operation{
+		{"get", s.Get}, {"put", s.Put}, {"delete", s.Delete}, {"merge", s.Merge}, {"sync", s.Sync},
+	}
+	writeHeader(w, "bitcask_operations_total", "counter", "Number of datastore operations.")
+	for _, op := range ops {
+		writeSample(w, "bitcask_operations_total", opLabel(op.name), float64(op.stats.Count))
+	}
+	writeHeader(w, "bitcask_operation_errors_total", "counter", "Number of failed datastore operations.")
+	for _, op := range ops {
+		writeSample(w, "bitcask_operation_errors_total", opLabel(op.name), float64(op.stats.Errors))
+	}
+	writeHeader(w, "bitcask_operation_seconds_total", "counter", "Total time spent in datastore operations.")
+	for _, op := range ops {
+		writeSample(w, "bitcask_operation_seconds_total", opLabel(op.name), op.stats.TotalTime.Seconds())
+	}
+	writeHeader(w, "bitcask_operation_max_seconds", "gauge", "Longest datastore operation.")
+	for _, op := range ops {
+		writeSample(w, "bitcask_operation_max_seconds", opLabel(op.name), op.stats.MaxTime.Seconds())
+	}
+
+	var lastMerge float64
+	if !s.LastMerge.Start.IsZero() {
+		lastMerge = float64(s.LastMerge.Start.UnixNano()) / float64(time.Second)
+	}
+	writeGauge(w, "bitcask_last_merge_timestamp_seconds", "Start time of the last merge.", lastMerge)
+	writeGauge(w, "bitcask_last_merge_duration_seconds", "Duration of the last merge.", s.LastMerge.Duration.Seconds())
+	writeGauge(w, "bitcask_last_merge_removed_files", "Number of files removed by the last merge.", float64(s.LastMerge.RemovedFiles))
+	writeGauge(w, "bitcask_last_merge_reclaimed_bytes", "Bytes reclaimed by the last merge.", float64(s.LastMerge.ReclaimedBytes))
+}
+
+// connect counts a new connection.
+func (s *serverStats) connect() {
+	s.connections.Add(1)
+	s.totalConnections.Add(1)
+}
+
+// disconnect counts a closed connection.
+func (s *serverStats) disconnect() {
+	s.connections.Add(-1)
+}
+
+// observe counts a call of the given command that started at the given time and replied with the given value.
+func (s *serverStats) observe(name string, start time.Time, reply resp.Value) {
+	elapsed := time.Since(start).Seconds()
+	bucket := sort.SearchFloat64s(latencyBuckets, elapsed)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.commands == nil {
+		s.commands = make(map[string]*commandStats)
+	}
+	c, ok := s.commands[name]
+	if !ok {
+		c = &commandStats{buckets: make([]uint64, len(latencyBuckets)+1)}
+		s.commands[name] = c
+	}
+
+	c.calls++
+	if reply.Type() == resp.Error {
+		c.errors++
+	}
+	c.seconds += elapsed
+	c.buckets[bucket]++
+}
+
+// totals returns the number of served commands and of the commands replied with an error.
+func (s *serverStats) totals() (uint64, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var calls, errors uint64
+	for _, c := range s.commands {
+		calls += c.calls
+		errors += c.errors
+	}
+
+	return calls, errors
+}
+
+// write writes the server statistics sorted by command name.
+func (s *serverStats) write(w io.Writer) {
+	writeGauge(w, "bitserver_connections", "Number of open client connections.", float64(s.connections.Load()))
+	writeCounter(w, "bitserver_connections_total", "Number of accepted client connections.", float64(s.totalConnections.Load()))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.commands))
+	for name := range s.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeHeader(w, "bitserver_commands_total", "counter", "Number of served commands.")
+	for _, name := range names {
+		writeSample(w, "bitserver_commands_total", commandLabel(name), float64(s.commands[name].calls))
+	}
+	writeHeader(w, "bitserver_command_errors_total", "counter", "Number of commands replied with an error.")
+	for _, name := range names {
+		writeSample(w, "bitserver_command_errors_total", commandLabel(name), float64(s.commands[name].errors))
+	}
+
+	writeHeader(w, "bitserver_command_duration_seconds", "histogram", "Latency of the served commands.")
+	for _, name := range names {
+		c := s.commands[name]
+		var cumulative uint64
+		for i, count := range c.buckets {
+			cumulative += count
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = formatFloat(latencyBuckets[i])
+			}
+			writeSample(w, "bitserver_command_duration_seconds_bucket",
+				fmt.Sprintf(`{command=%q,le=%q}`, name, le), float64(cumulative))
+		}
+		writeSample(w, "bitserver_command_duration_seconds_sum", commandLabel(name), c.seconds)
+		writeSample(w, "bitserver_command_duration_seconds_count", commandLabel(name), float64(c.calls))
+	}
+}
+
+// writeGauge writes a gauge metric with a single sample.
+func writeGauge(w io.Writer, name, help string, value float64) {
+	writeHeader(w, name, "gauge", help)
+	writeSample(w, name, "", value)
+}
+
+// writeCounter writes a counter metric with a single sample.
+func writeCounter(w io.Writer, name, help string, value float64) {
+	writeHeader(w, name, "counter", help)
+	writeSample(w, name, "", value)
+}
+
+// writeHeader writes the help and type lines of a metric.
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeSample writes a metric sample with the given labels.
+func writeSample(w io.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(value))
+}
+
+// opLabel returns the label set of a datastore operation.
+func opLabel(name string) string {
+	return fmt.Sprintf(`{op=%q}`, name)
+}
+
+// commandLabel returns the label set of a command.
+func commandLabel(name string) string {
+	return fmt.Sprintf(`{command=%q}`, name)
+}
+
+// formatFloat formats a sample value as expected by the Prometheus text format.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,37 @@
+package respserver
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	r, conn := newTestServer(t)
+
+	assertReply(t, do(t, conn, "set", "key", "value"), "+OK\r\n")
+	assertReply(t, do(t, conn, "get", "key"), "$5\r\nvalue\r\n")
+	assertReply(t, do(t, conn, "get"), "-ERR wrong number of arguments for 'get' command\r\n")
+	assertReply(t, do(t, conn, "bogus"), "-ERR unknown command 'bogus'\r\n")
+
+	var buf bytes.Buffer
+	err := r.WriteMetrics(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"bitcask_keys 2\n",
+		`bitcask_operations_total{op="put"} 2` + "\n",
+		"bitserver_connections 1\n",
+		`bitserver_commands_total{command="get"} 2` + "\n",
+		`bitserver_command_errors_total{command="get"} 1` + "\n",
+		`bitserver_command_errors_total{command="unknown"} 1` + "\n",
+		`bitserver_command_duration_seconds_bucket{command="set",le="+Inf"} 1` + "\n",
+		`bitserver_command_duration_seconds_count{command="set"} 1` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
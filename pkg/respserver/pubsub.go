@@ -0,0 +1,399 @@
+package respserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/tidwall/resp"
+)
+
+const (
+	// queueSize is the number of replies and messages queued for a subscribed client,
+	// the client is disconnected if it does not read its messages fast enough to keep the queue from filling.
+	queueSize = 1024
+
+	// keyspacePrefix is the format of the prefix of the channels that receive the events of a key of a database.
+	keyspacePrefix = "__keyspace@%d__:"
+	// keyeventPrefix is the format of the prefix of the channels that receive the keys of a database changed by an event.
+	keyeventPrefix = "__keyevent@%d__:"
+
+	// notifyKeyspaceEventsParam is the name of the config parameter of the keyspace notifications setting.
+	notifyKeyspaceEventsParam = "notify-keyspace-events"
+
+	// errSubscribedContext is the error format of the commands that cannot be used by subscribed clients.
+	errSubscribedContext = "ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"
+)
+
+var (
+	// noReply is returned by the handlers that write their replies themselves.
+	noReply = resp.Value{}
+
+	// subscribedCommands are the commands that subscribed clients can use.
+	subscribedCommands = map[string]bool{
+		"subscribe":    true,
+		"psubscribe":   true,
+		"unsubscribe":  true,
+		"punsubscribe": true,
+		"ping":         true,
+		"quit":         true,
+	}
+
+	// keyEvents are the names of the notified datastore events.
+	keyEvents = map[bitcask.EventType]string{
+		bitcask.EventPut:    "set",
+		bitcask.EventDelete: "del",
+		bitcask.EventExpire: "expired",
+	}
+
+	// eventClasses are the classes of the notified datastore events.
+	eventClasses = map[bitcask.EventType]notifyFlags{
+		bitcask.EventPut:    notifyString,
+		bitcask.EventDelete: notifyGeneric,
+		bitcask.EventExpire: notifyExpired,
+	}
+
+	// notifyClasses are the characters of the notify-keyspace-events setting, in the order they are formatted.
+	notifyClasses = []struct {
+		char  byte
+		flags notifyFlags
+	}{
+		{'A', notifyAll},
+		{'g', notifyGeneric},
+		{'$', notifyString},
+		{'x', notifyExpired},
+		{'K', notifyKeyspace},
+		{'E', notifyKeyevent},
+	}
+)
+
+// notifyFlags represents the keyspace notifications enabled by the notify-keyspace-events setting.
+type notifyFlags int
+
+const (
+	// notifyKeyspace enables the notifications of the keyspace channels.
+	notifyKeyspace notifyFlags = 1 << iota
+	// notifyKeyevent enables the notifications of the keyevent channels.
+	notifyKeyevent
+	// notifyGeneric enables the notifications of the generic commands events, the deletions.
+	notifyGeneric
+	// notifyString enables the notifications of the string commands events, the writes.
+	notifyString
+	// notifyExpired enables the notifications of the expired keys.
+	notifyExpired
+	// notifyAll is the alias of all the event classes.
+	notifyAll = notifyGeneric | notifyString | notifyExpired
+)
+
+// SetNotifyKeyspaceEvents enables the keyspace notifications of the event classes in the given
+// notify-keyspace-events setting, as in redis: K and E select the keyspace and keyevent channels,
+// g, $ and x select the deletions, the writes and the expirations, and A is an alias of g$x.
+// The notifications are disabled unless a channel kind and an event class are selected,
+// the datastore is only watched while they are enabled, they are disabled by default.
+// Return an error if the setting has an unknown character.
+func (r *RespServer) SetNotifyKeyspaceEvents(classes string) error {
+	flags, err := parseNotifyFlags(classes)
+	if err != nil {
+		return err
+	}
+
+	r.notifyMu.Lock()
+	defer r.notifyMu.Unlock()
+
+	if r.stopNotify != nil {
+		r.stopNotify()
+		r.stopNotify = nil
+	}
+	r.notifyFlags = flags
+	if flags&(notifyKeyspace|notifyKeyevent) != 0 && flags&notifyAll != 0 {
+		events, cancel := r.bitcask.Watch("")
+		r.stopNotify = cancel
+		go r.notify(events, flags)
+	}
+
+	return nil
+}
+
+// notifyKeyspaceEvents returns the current notify-keyspace-events setting.
+func (r *RespServer) notifyKeyspaceEvents() string {
+	r.notifyMu.Lock()
+	defer r.notifyMu.Unlock()
+
+	return r.notifyFlags.String()
+}
+
+// parseNotifyFlags parses the given notify-keyspace-events setting.
+// Return an error if it has an unknown character.
+func parseNotifyFlags(classes string) (notifyFlags, error) {
+	var flags notifyFlags
+	for i := 0; i < len(classes); i++ {
+		known := false
+		for _, class := range notifyClasses {
+			if class.char == classes[i] {
+				flags |= class.flags
+				known = true
+			}
+		}
+		if !known {
+			return 0, fmt.Errorf("unknown keyspace events class '%c'", classes[i])
+		}
+	}
+
+	return flags, nil
+}
+
+// String returns the notify-keyspace-events setting of the flags.
+func (f notifyFlags) String() string {
+	var b strings.Builder
+	for _, class := range notifyClasses {
+		if f&class.flags == class.flags {
+			b.WriteByte(class.char)
+			f &^= class.flags
+		}
+	}
+
+	return b.String()
+}
+
+// notify publishes keyspace notifications for the given datastore events of the classes enabled by the given flags,
+// every event is published to the keyspace channel of its key and the keyevent channel of its type
+// in the database of its key, if the kind of the channel is enabled.
+func (r *RespServer) notify(events <-chan bitcask.Event, flags notifyFlags) {
+	for ev := range events {
+		name, ok := keyEvents[ev.Type]
+		if !ok || flags&eventClasses[ev.Type] == 0 {
+			// overflow events have no key, the dropped notifications cannot be recovered.
+			continue
+		}
+
+		stored, key, ok := parseDBKey(ev.Key)
+		if !ok {
+			continue
+		}
+		db := r.logicalDB(stored)
+		if flags&notifyKeyspace != 0 {
+			r.publish(keyspaceChannel(db, key), name)
+		}
+		if flags&notifyKeyevent != 0 {
+			r.publish(keyeventChannel(db, name), key)
+		}
+	}
+}
+
+// publish queues the message to the clients subscribed to the channel or to a pattern matching it.
+// Return the number of clients that received the message.
+func (r *RespServer) publish(channel, message string) int {
+	r.pubsubMu.Lock()
+	defer r.pubsubMu.Unlock()
+
+	receivers := 0
+	for _, c := range r.subscribers {
+		if c.channels[channel] {
+			c.push(resp.ArrayValue([]resp.Value{
+				resp.StringValue("message"), resp.StringValue(channel), resp.StringValue(message),
+			}))
+			receivers++
+		}
+		for pattern := range c.patterns {
+			if matchPattern(pattern, channel) {
+				c.push(resp.ArrayValue([]resp.Value{
+					resp.StringValue("pmessage"), resp.StringValue(pattern), resp.StringValue(channel), resp.StringValue(message),
+				}))
+				receivers++
+			}
+		}
+	}
+
+	return receivers
+}
+
+// publishMessage implements the callback method that handles publish requests.
+// Reply with the number of clients that received the message.
+func (r *RespServer) publishMessage(c *client, args []resp.Value) resp.Value {
+	return resp.IntegerValue(r.publish(args[1].String(), args[2].String()))
+}
+
+// pubsub implements the callback method that handles the pubsub subcommands.
+func (r *RespServer) pubsub(c *client, args []resp.Value) resp.Value {
+	sub := strings.ToLower(args[1].String())
+	switch {
+	case sub == "channels" && len(args) <= 3:
+		pattern := "*"
+		if len(args) == 3 {
+			pattern = args[2].String()
+		}
+		channels := make([]resp.Value, 0)
+		for _, channel := range sortedKeys(r.activeChannels()) {
+			if matchPattern(pattern, channel) {
+				channels = append(channels, resp.StringValue(channel))
+			}
+		}
+		return resp.ArrayValue(channels)
+	case sub == "numsub":
+		r.pubsubMu.Lock()
+		defer r.pubsubMu.Unlock()
+		counts := make([]resp.Value, 0, 2*(len(args)-2))
+		for _, arg := range args[2:] {
+			subscribers := 0
+			for _, subscriber := range r.subscribers {
+				if subscriber.channels[arg.String()] {
+					subscribers++
+				}
+			}
+			counts = append(counts, resp.StringValue(arg.String()), resp.IntegerValue(subscribers))
+		}
+		return resp.ArrayValue(counts)
+	case sub == "numpat" && len(args) == 2:
+		r.pubsubMu.Lock()
+		defer r.pubsubMu.Unlock()
+		patterns := make(map[string]bool)
+		for _, subscriber := range r.subscribers {
+			for pattern := range subscriber.patterns {
+				patterns[pattern] = true
+			}
+		}
+		return resp.IntegerValue(len(patterns))
+	case sub == "help":
+		return helpReply("PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"CHANNELS [<pattern>]", "NUMSUB [<channel> ...]", "NUMPAT")
+	default:
+		return resp.ErrorValue(fmt.Errorf(errUnknownSubcommand, args[1].String(), "PUBSUB"))
+	}
+}
+
+// activeChannels returns the channels with at least one subscribed client.
+func (r *RespServer) activeChannels() map[string]bool {
+	r.pubsubMu.Lock()
+	defer r.pubsubMu.Unlock()
+
+	channels := make(map[string]bool)
+	for _, subscriber := range r.subscribers {
+		for channel := range subscriber.channels {
+			channels[channel] = true
+		}
+	}
+
+	return channels
+}
+
+// subscribe implements the callback method that handles subscribe requests.
+func (r *RespServer) subscribe(c *client, args []resp.Value) resp.Value {
+	return r.addSubscriptions(c, args, "subscribe", func(c *client) map[string]bool { return c.channels })
+}
+
+// psubscribe implements the callback method that handles psubscribe requests.
+func (r *RespServer) psubscribe(c *client, args []resp.Value) resp.Value {
+	return r.addSubscriptions(c, args, "psubscribe", func(c *client) map[string]bool { return c.patterns })
+}
+
+// unsubscribe implements the callback method that handles unsubscribe requests.
+func (r *RespServer) unsubscribe(c *client, args []resp.Value) resp.Value {
+	return r.removeSubscriptions(c, args, "unsubscribe", func(c *client) map[string]bool { return c.channels })
+}
+
+// punsubscribe implements the callback method that handles punsubscribe requests.
+func (r *RespServer) punsubscribe(c *client, args []resp.Value) resp.Value {
+	return r.removeSubscriptions(c, args, "punsubscribe", func(c *client) map[string]bool { return c.patterns })
+}
+
+// addSubscriptions subscribes the client to the given channels or patterns
+// and queues a confirmation for each of them.
+// The confirmations are queued under the pubsub lock, so they are written before the messages of the new subscriptions.
+func (r *RespServer) addSubscriptions(c *client, args []resp.Value, kind string, subs func(*client) map[string]bool) resp.Value {
+	r.pubsubMu.Lock()
+	defer r.pubsubMu.Unlock()
+
+	r.startQueue(c)
+	for _, arg := range args[1:] {
+		subs(c)[arg.String()] = true
+		c.push(subscriptionReply(kind, resp.StringValue(arg.String()), c))
+	}
+	r.subscribers[c.id] = c
+
+	return noReply
+}
+
+// removeSubscriptions unsubscribes the client from the given channels or patterns, or from all of them
+// if none is given, and queues a confirmation for each of them.
+func (r *RespServer) removeSubscriptions(c *client, args []resp.Value, kind string, subs func(*client) map[string]bool) resp.Value {
+	r.pubsubMu.Lock()
+	defer r.pubsubMu.Unlock()
+
+	r.startQueue(c)
+	names := make([]string, 0, len(args))
+	for _, arg := range args[1:] {
+		names = append(names, arg.String())
+	}
+	if len(names) == 0 {
+		for name := range subs(c) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	if len(names) == 0 {
+		c.push(subscriptionReply(kind, resp.NullValue(), c))
+	}
+	for _, name := range names {
+		delete(subs(c), name)
+		c.push(subscriptionReply(kind, resp.StringValue(name), c))
+	}
+	if len(c.channels)+len(c.patterns) == 0 {
+		delete(r.subscribers, c.id)
+	}
+
+	return noReply
+}
+
+// isSubscribed reports whether the client is subscribed to any channel or pattern.
+func (r *RespServer) isSubscribed(c *client) bool {
+	r.pubsubMu.Lock()
+	defer r.pubsubMu.Unlock()
+
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+// startQueue makes the client write its replies and messages from a queue, the caller must hold the pubsub lock.
+func (r *RespServer) startQueue(c *client) {
+	if c.queue != nil {
+		return
+	}
+
+	c.queue = make(chan resp.Value, queueSize)
+	c.channels = make(map[string]bool)
+	c.patterns = make(map[string]bool)
+	go c.writeQueue()
+}
+
+// writeQueue writes the queued values until the queue is closed then closes the connection.
+// The queue is still drained after a write failure, so queueing never blocks forever.
+func (c *client) writeQueue() {
+	var err error
+	for v := range c.queue {
+		if err == nil {
+			err = c.WriteValue(v)
+			if err != nil {
+				c.conn.Close()
+			}
+		}
+	}
+
+	c.conn.Close()
+}
+
+// push queues a message without blocking, the client is disconnected if its queue is full.
+func (c *client) push(v resp.Value) {
+	select {
+	case c.queue <- v:
+	default:
+		c.conn.Close()
+	}
+}
+
+// subscriptionReply returns the confirmation of a subscription change with the number of the client subscriptions.
+func subscriptionReply(kind string, name resp.Value, c *client) resp.Value {
+	return resp.ArrayValue([]resp.Value{
+		resp.StringValue(kind), name, resp.IntegerValue(len(c.channels) + len(c.patterns)),
+	})
+}
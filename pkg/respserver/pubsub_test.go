@@ -0,0 +1,138 @@
+package respserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyspaceNotifications(t *testing.T) {
+	r, conn := newTestServer(t)
+	sub := newTestClient(t, r)
+
+	assertReply(t, do(t, sub, "subscribe", "__keyspace@0__:key1"), "*3\r\n$9\r\nsubscribe\r\n$19\r\n__keyspace@0__:key1\r\n:1\r\n")
+	assertReply(t, do(t, sub, "psubscribe", "__keyevent@0__:*"), "*3\r\n$10\r\npsubscribe\r\n$16\r\n__keyevent@0__:*\r\n:2\r\n")
+	assertReply(t, do(t, sub, "get", "key1"),
+		"-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context\r\n")
+
+	// the notifications are disabled by default, so the published marker is the first message.
+	assertReply(t, do(t, conn, "set", "key1", "value"), "+OK\r\n")
+	assertReply(t, do(t, conn, "publish", "__keyspace@0__:key1", "marker"), ":1\r\n")
+	assertReply(t, read(t, sub), "*3\r\n$7\r\nmessage\r\n$19\r\n__keyspace@0__:key1\r\n$6\r\nmarker\r\n")
+
+	assertReply(t, do(t, conn, "config", "set", "notify-keyspace-events", "KEA"), "+OK\r\n")
+	assertReply(t, do(t, conn, "set", "key1", "value"), "+OK\r\n")
+	assertReply(t, read(t, sub), "*3\r\n$7\r\nmessage\r\n$19\r\n__keyspace@0__:key1\r\n$3\r\nset\r\n")
+	assertReply(t, read(t, sub), "*4\r\n$8\r\npmessage\r\n$16\r\n__keyevent@0__:*\r\n$18\r\n__keyevent@0__:set\r\n$4\r\nkey1\r\n")
+
+	assertReply(t, do(t, sub, "unsubscribe"), "*3\r\n$11\r\nunsubscribe\r\n$19\r\n__keyspace@0__:key1\r\n:1\r\n")
+	assertReply(t, do(t, conn, "del", "key1"), ":1\r\n")
+	assertReply(t, read(t, sub), "*4\r\n$8\r\npmessage\r\n$16\r\n__keyevent@0__:*\r\n$18\r\n__keyevent@0__:del\r\n$4\r\nkey1\r\n")
+
+	assertReply(t, do(t, sub, "punsubscribe", "__keyevent@0__:*"), "*3\r\n$12\r\npunsubscribe\r\n$16\r\n__keyevent@0__:*\r\n:0\r\n")
+	assertReply(t, do(t, sub, "get", "key1"), "$-1\r\n")
+}
+
+func TestConfig(t *testing.T) {
+	_, conn := newTestServer(t)
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"config", "get", "notify-keyspace-events"}, "*2\r\n$22\r\nnotify-keyspace-events\r\n$0\r\n\r\n"},
+		{[]interface{}{"config", "set", "notify-keyspace-events", "KEA"}, "+OK\r\n"},
+		{[]interface{}{"config", "get", "notify-*"}, "*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\nAKE\r\n"},
+		{[]interface{}{"config", "set", "notify-keyspace-events", "Ex$"}, "+OK\r\n"},
+		{[]interface{}{"config", "get", "*", "notify-keyspace-events"}, "*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\n$xE\r\n"},
+		{[]interface{}{"config", "set", "notify-keyspace-events", "Kl"},
+			"-ERR Invalid argument 'Kl' for CONFIG SET 'notify-keyspace-events' - unknown keyspace events class 'l'\r\n"},
+		{[]interface{}{"config", "get", "notify-keyspace-events"}, "*2\r\n$22\r\nnotify-keyspace-events\r\n$3\r\n$xE\r\n"},
+		{[]interface{}{"config", "set", "maxmemory", "1"}, "-ERR Unknown option or number of arguments for CONFIG SET - 'maxmemory'\r\n"},
+		{[]interface{}{"config", "get", "maxmemory"}, "*0\r\n"},
+		{[]interface{}{"config", "bogus"}, "-ERR unknown subcommand 'bogus'. Try CONFIG HELP.\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	r, conn := newTestServer(t)
+	sub := newTestClient(t, r)
+	psub := newTestClient(t, r)
+
+	assertReply(t, do(t, sub, "subscribe", "news", "sports"), "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+	assertReply(t, read(t, sub), "*3\r\n$9\r\nsubscribe\r\n$6\r\nsports\r\n:2\r\n")
+	assertReply(t, do(t, psub, "psubscribe", "n*"), "*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:1\r\n")
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"pubsub", "channels"}, "*2\r\n$4\r\nnews\r\n$6\r\nsports\r\n"},
+		{[]interface{}{"pubsub", "channels", "s*"}, "*1\r\n$6\r\nsports\r\n"},
+		{[]interface{}{"pubsub", "numsub", "news", "missing"}, "*4\r\n$4\r\nnews\r\n:1\r\n$7\r\nmissing\r\n:0\r\n"},
+		{[]interface{}{"pubsub", "numsub"}, "*0\r\n"},
+		{[]interface{}{"pubsub", "numpat"}, ":1\r\n"},
+		{[]interface{}{"pubsub", "bogus"}, "-ERR unknown subcommand 'bogus'. Try PUBSUB HELP.\r\n"},
+		{[]interface{}{"publish", "news", "hello"}, ":2\r\n"},
+		{[]interface{}{"publish", "sports", "goal"}, ":1\r\n"},
+		{[]interface{}{"publish", "weather", "rain"}, ":0\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+
+	assertReply(t, read(t, sub), "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+	assertReply(t, read(t, sub), "*3\r\n$7\r\nmessage\r\n$6\r\nsports\r\n$4\r\ngoal\r\n")
+	assertReply(t, read(t, psub), "*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+	assertReply(t, do(t, sub, "publish", "news", "hello"),
+		"-ERR Can't execute 'publish': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context\r\n")
+
+	assertReply(t, do(t, sub, "unsubscribe", "news"), "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+	assertReply(t, do(t, conn, "pubsub", "channels"), "*1\r\n$6\r\nsports\r\n")
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	r, conn := newTestServer(t)
+	sub := newTestClient(t, r)
+
+	assertReply(t, do(t, sub, "subscribe", "news"), "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+
+	// the subscriber does not read its messages, so it is disconnected once its queue is full.
+	for i := 0; i <= queueSize+1; i++ {
+		do(t, conn, "publish", "news", "message")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if do(t, conn, "publish", "news", "message").Integer() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the slow subscriber is not disconnected")
+		}
+	}
+	assertReply(t, do(t, conn, "pubsub", "numsub", "news"), "*2\r\n$4\r\nnews\r\n:0\r\n")
+}
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"h?llo", "hello", true},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"key*", "other", false},
+	}
+
+	for _, c := range cases {
+		if got := matchPattern(c.pattern, c.s); got != c.want {
+			t.Errorf("matchPattern(%q, %q) = %t, want %t", c.pattern, c.s, got, c.want)
+		}
+	}
+}
@@ -3,22 +3,106 @@ package respserver
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IslamWalid/bitcask"
 	"github.com/tidwall/resp"
 )
 
-// errInvalidArgsNum is return whenever something wrong with arguments number.
-var errInvalidArgsNum = errors.New("invalid number of arguments passed")
+// errWrongArgs is the error format of the commands called with a wrong number of arguments.
+const errWrongArgs = "ERR wrong number of arguments for '%s' command"
 
-// RespServer represents the server object.
-// RespServer contains the metadata needed to manage the server.
-type RespServer struct {
-	port         string
-	server       *resp.Server
-	bitcask      *bitcask.Bitcask
-	dataStoreDir string
-}
+type (
+	// RespServer represents the server object.
+	// RespServer contains the metadata needed to manage the server.
+	RespServer struct {
+		port         string
+		commands     map[string]command
+		bitcask      *bitcask.Bitcask
+		dataStoreDir string
+		started      time.Time
+		stats        serverStats
+
+		mu       sync.Mutex
+		listener net.Listener
+		clients  map[int64]*client
+		nextID   int64
+		closed   bool
+
+		pubsubMu    sync.Mutex
+		subscribers map[int64]*client
+
+		// notifyMu guards the keyspace notifications setting and the function that stops watching the datastore for them.
+		notifyMu    sync.Mutex
+		notifyFlags notifyFlags
+		stopNotify  func()
+
+		index   *index
+		blocked blocking
+
+		// execMu is held by EXEC while it runs the queued commands and by the exclusive commands,
+		// the other commands hold it for reading.
+		execMu sync.RWMutex
+
+		// dbs holds the databases whose keys are stored for the logical databases by their indexes.
+		dbs atomic.Pointer[[databases]int]
+	}
+
+	// handler represents the callback method that handles a command.
+	// The returned value is written to the client as the command reply.
+	handler func(c *client, args []resp.Value) resp.Value
+
+	// command represents a registered command.
+	// arity is the number of arguments including the command name,
+	// a negative arity is the minimum number of arguments.
+	// flags are the space separated redis command flags, and firstKey, lastKey and step
+	// are the positions of the key arguments reported by COMMAND, a negative lastKey counts from the end.
+	command struct {
+		handler  handler
+		arity    int
+		flags    string
+		firstKey int
+		lastKey  int
+		step     int
+	}
+
+	// client represents a connection to the server.
+	// The commands are read through reader, so the connection can be watched while the client is blocked.
+	// Once the client subscribes, its replies and messages are written in order from its queue
+	// and its channels and patterns are guarded by the server pubsub lock.
+	// The commands of the client run on the keyspace of its selected database in the datastore
+	// or in the transaction of a running EXEC.
+	// The name, the database and the last command of the client are guarded by its lock, as they are listed by other clients.
+	client struct {
+		*resp.Conn
+		id       int64
+		conn     net.Conn
+		reader   *connReader
+		created  time.Time
+		quit     bool
+		queue    chan resp.Value
+		channels map[string]bool
+		patterns map[string]bool
+
+		store       keyspace
+		multi       bool
+		multiFailed bool
+		queued      [][]resp.Value
+		watched     map[string]int64
+
+		mu         sync.Mutex
+		name       string
+		db         int
+		lastCmd    string
+		lastActive time.Time
+	}
+)
 
 // New creates new resp server object listening in the given port
 // and using a datastore in the given directory path.
@@ -28,80 +112,370 @@ func New(dataStoreDir, port string) (*RespServer, error) {
 		return nil, err
 	}
 
-	return &RespServer{
+	err = migrateStrings(bitcask)
+	if err != nil {
+		bitcask.Close()
+		return nil, err
+	}
+
+	index, err := newIndex(bitcask)
+	if err != nil {
+		bitcask.Close()
+		return nil, err
+	}
+
+	r := &RespServer{
 		port:         port,
-		server:       resp.NewServer(),
 		bitcask:      bitcask,
 		dataStoreDir: dataStoreDir,
-	}, nil
+		started:      time.Now(),
+		clients:      make(map[int64]*client),
+		subscribers:  make(map[int64]*client),
+		index:        index,
+		blocked: blocking{
+			queues:  make(map[string][]*waiter),
+			clients: make(map[int64]*waiter),
+		},
+	}
+	dbs, err := loadDBs(bitcask)
+	if err != nil {
+		bitcask.Close()
+		return nil, err
+	}
+	r.dbs.Store(dbs)
+	r.registerHandlers()
+
+	return r, nil
 }
 
-// ListenAndServe registers the needed handlers then starts the server.
+// ListenAndServe starts the server and serves every connection in a separate goroutine.
+// Return an error if the server cannot listen in its port or on accept failures,
+// nil is returned after the server is closed.
 func (r *RespServer) ListenAndServe() error {
-	r.registerHandlers()
-	err := r.server.ListenAndServe(r.port)
+	l, err := net.Listen("tcp", r.port)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	r.listener = l
+	r.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go r.serveConn(conn)
+	}
 }
 
-// Close closes the used bitcask datastore.
+// Close stops the server, closes the open connections and the used bitcask datastore.
 func (r *RespServer) Close() {
+	r.mu.Lock()
+	r.closed = true
+	if r.listener != nil {
+		r.listener.Close()
+	}
+	for _, c := range r.clients {
+		c.conn.Close()
+	}
+	r.mu.Unlock()
+
+	r.notifyMu.Lock()
+	if r.stopNotify != nil {
+		r.stopNotify()
+		r.stopNotify = nil
+	}
+	r.notifyMu.Unlock()
+
 	r.bitcask.Close()
 }
 
 // registerHandlers register the callback methods to the server.
 func (r *RespServer) registerHandlers() {
-	r.server.HandleFunc("set", r.set)
-	r.server.HandleFunc("get", r.get)
-	r.server.HandleFunc("del", r.del)
+	r.commands = map[string]command{
+		"set":           {r.set, 3, "write denyoom", 1, 1, 1},
+		"get":           {r.get, 2, "readonly fast", 1, 1, 1},
+		"del":           {r.del, -2, "write", 1, -1, 1},
+		"exists":        {r.exists, -2, "readonly fast", 1, -1, 1},
+		"mget":          {r.mget, -2, "readonly fast", 1, -1, 1},
+		"mset":          {r.mset, -3, "write denyoom", 1, -1, 2},
+		"setnx":         {r.setnx, 3, "write denyoom fast", 1, 1, 1},
+		"getset":        {r.getset, 3, "write denyoom fast", 1, 1, 1},
+		"getdel":        {r.getdel, 2, "write fast", 1, 1, 1},
+		"append":        {r.append, 3, "write denyoom fast", 1, 1, 1},
+		"strlen":        {r.strlen, 2, "readonly fast", 1, 1, 1},
+		"getrange":      {r.getrange, 4, "readonly", 1, 1, 1},
+		"setrange":      {r.setrange, 4, "write denyoom", 1, 1, 1},
+		"incr":          {r.incr, 2, "write denyoom fast", 1, 1, 1},
+		"decr":          {r.decr, 2, "write denyoom fast", 1, 1, 1},
+		"incrby":        {r.incrby, 3, "write denyoom fast", 1, 1, 1},
+		"decrby":        {r.decrby, 3, "write denyoom fast", 1, 1, 1},
+		"incrbyfloat":   {r.incrbyfloat, 3, "write denyoom fast", 1, 1, 1},
+		"keys":          {r.keys, 2, "readonly", 0, 0, 0},
+		"scan":          {r.scan, -2, "readonly", 0, 0, 0},
+		"dbsize":        {r.dbsize, 1, "readonly fast", 0, 0, 0},
+		"randomkey":     {r.randomkey, 1, "readonly", 0, 0, 0},
+		"rename":        {r.rename, 3, "write", 1, 2, 1},
+		"renamenx":      {r.renamenx, 3, "write fast", 1, 2, 1},
+		"type":          {r.typ, 2, "readonly fast", 1, 1, 1},
+		"hset":          {r.hset, -4, "write denyoom fast", 1, 1, 1},
+		"hmset":         {r.hmset, -4, "write denyoom fast", 1, 1, 1},
+		"hsetnx":        {r.hsetnx, 4, "write denyoom fast", 1, 1, 1},
+		"hget":          {r.hget, 3, "readonly fast", 1, 1, 1},
+		"hmget":         {r.hmget, -3, "readonly fast", 1, 1, 1},
+		"hdel":          {r.hdel, -3, "write fast", 1, 1, 1},
+		"hexists":       {r.hexists, 3, "readonly fast", 1, 1, 1},
+		"hstrlen":       {r.hstrlen, 3, "readonly fast", 1, 1, 1},
+		"hlen":          {r.hlen, 2, "readonly fast", 1, 1, 1},
+		"hkeys":         {r.hkeys, 2, "readonly", 1, 1, 1},
+		"hvals":         {r.hvals, 2, "readonly", 1, 1, 1},
+		"hgetall":       {r.hgetall, 2, "readonly", 1, 1, 1},
+		"hincrby":       {r.hincrby, 4, "write denyoom fast", 1, 1, 1},
+		"hscan":         {r.hscan, -3, "readonly", 1, 1, 1},
+		"lpush":         {r.lpush, -3, "write denyoom fast", 1, 1, 1},
+		"rpush":         {r.rpush, -3, "write denyoom fast", 1, 1, 1},
+		"lpop":          {r.lpop, -2, "write fast", 1, 1, 1},
+		"rpop":          {r.rpop, -2, "write fast", 1, 1, 1},
+		"llen":          {r.llen, 2, "readonly fast", 1, 1, 1},
+		"lindex":        {r.lindex, 3, "readonly", 1, 1, 1},
+		"lrange":        {r.lrange, 4, "readonly", 1, 1, 1},
+		"lmove":         {r.lmove, 5, "write denyoom", 1, 2, 1},
+		"blpop":         {r.blpop, -3, "write noscript", 1, -2, 1},
+		"brpop":         {r.brpop, -3, "write noscript", 1, -2, 1},
+		"blmove":        {r.blmove, 6, "write denyoom noscript", 1, 2, 1},
+		"sadd":          {r.sadd, -3, "write denyoom fast", 1, 1, 1},
+		"srem":          {r.srem, -3, "write fast", 1, 1, 1},
+		"sismember":     {r.sismember, 3, "readonly fast", 1, 1, 1},
+		"smembers":      {r.smembers, 2, "readonly", 1, 1, 1},
+		"scard":         {r.scard, 2, "readonly fast", 1, 1, 1},
+		"sinter":        {r.sinter, -2, "readonly", 1, -1, 1},
+		"sunion":        {r.sunion, -2, "readonly", 1, -1, 1},
+		"sdiff":         {r.sdiff, -2, "readonly", 1, -1, 1},
+		"zadd":          {r.zadd, -4, "write denyoom fast", 1, 1, 1},
+		"zincrby":       {r.zincrby, 4, "write denyoom fast", 1, 1, 1},
+		"zrem":          {r.zrem, -3, "write fast", 1, 1, 1},
+		"zcard":         {r.zcard, 2, "readonly fast", 1, 1, 1},
+		"zscore":        {r.zscore, 3, "readonly fast", 1, 1, 1},
+		"zrank":         {r.zrank, -3, "readonly fast", 1, 1, 1},
+		"zrange":        {r.zrange, -4, "readonly", 1, 1, 1},
+		"zrangebyscore": {r.zrangebyscore, -4, "readonly", 1, 1, 1},
+		"select":        {r.selectDB, 2, "loading stale fast", 0, 0, 0},
+		"flushdb":       {r.flushdb, -1, "write", 0, 0, 0},
+		"flushall":      {r.flushall, -1, "write", 0, 0, 0},
+		"swapdb":        {r.swapdb, 3, "write fast", 0, 0, 0},
+		"move":          {r.move, 3, "write fast", 1, 1, 1},
+
+		"multi":   {r.multi, 1, "noscript loading stale fast", 0, 0, 0},
+		"exec":    {r.execTx, 1, "noscript loading stale", 0, 0, 0},
+		"discard": {r.discard, 1, "noscript loading stale fast", 0, 0, 0},
+		"watch":   {r.watch, -2, "noscript loading stale fast", 1, -1, 1},
+		"unwatch": {r.unwatch, 1, "noscript loading stale fast", 0, 0, 0},
+
+		"ping":    {r.ping, -1, "fast", 0, 0, 0},
+		"echo":    {r.echo, 2, "fast", 0, 0, 0},
+		"quit":    {r.quit, -1, "loading stale fast", 0, 0, 0},
+		"hello":   {r.hello, -1, "noscript loading stale fast", 0, 0, 0},
+		"client":  {r.client, -2, "admin noscript loading stale", 0, 0, 0},
+		"command": {r.command, -1, "loading stale", 0, 0, 0},
+		"info":    {r.info, -1, "loading stale", 0, 0, 0},
+		"config":  {r.config, -2, "admin noscript loading stale", 0, 0, 0},
+
+		"subscribe":    {r.subscribe, -2, "pubsub noscript loading stale", 0, 0, 0},
+		"psubscribe":   {r.psubscribe, -2, "pubsub noscript loading stale", 0, 0, 0},
+		"unsubscribe":  {r.unsubscribe, -1, "pubsub noscript loading stale", 0, 0, 0},
+		"punsubscribe": {r.punsubscribe, -1, "pubsub noscript loading stale", 0, 0, 0},
+		"publish":      {r.publishMessage, 3, "pubsub loading stale fast", 0, 0, 0},
+		"pubsub":       {r.pubsub, -2, "pubsub random loading stale", 0, 0, 0},
+	}
 }
 
-// set implements the callback method that handles set requests.
-func (r *RespServer) set(conn *resp.Conn, args []resp.Value) bool {
-	if len(args) != 3 {
-		conn.WriteError(errInvalidArgsNum)
-	} else {
-		err := r.bitcask.Put(args[1].String(), args[2].String())
+// serveConn reads the commands sent over the given connection and writes their replies
+// until the connection is closed or the client quits.
+func (r *RespServer) serveConn(conn net.Conn) {
+	c := r.addClient(conn)
+	defer r.removeClient(c)
+
+	for !c.quit {
+		v, _, _, err := c.ReadMultiBulk()
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				c.WriteError(fmt.Errorf("ERR %s", err))
+			}
+			return
+		}
+
+		args := v.Array()
+		if len(args) == 0 {
+			continue
+		}
+
+		err = c.reply(r.exec(c, args))
 		if err != nil {
-			conn.WriteError(err)
+			return
 		}
-		conn.WriteSimpleString("OK")
+	}
+}
+
+// exec checks the given command then runs it, or queues it if the client is inside a transaction.
+// The commands that cannot be queued make EXEC abort the transaction.
+// Commands run while EXEC is not running, as EXEC runs the queued commands without locking the datastore,
+// the blocking commands wait for EXEC themselves, so they do not hold EXEC while they are blocked.
+func (r *RespServer) exec(c *client, args []resp.Value) resp.Value {
+	start := time.Now()
+	name := strings.ToLower(args[0].String())
+
+	cmd, ok := r.commands[name]
+	if !ok {
+		c.multiFailed = c.multi
+		reply := resp.ErrorValue(fmt.Errorf("ERR unknown command '%s'", args[0].String()))
+		r.stats.observe(unknownCommand, start, reply)
+		return reply
+	}
+	if c.queue != nil && !subscribedCommands[name] && r.isSubscribed(c) {
+		reply := resp.ErrorValue(fmt.Errorf(errSubscribedContext, name))
+		r.stats.observe(name, start, reply)
+		return reply
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity {
+		c.multiFailed = c.multi
+		reply := resp.ErrorValue(fmt.Errorf(errWrongArgs, name))
+		r.stats.observe(name, start, reply)
+		return reply
+	}
+
+	if c.multi && !multiCommands[name] {
+		c.queued = append(c.queued, args)
+		reply := resp.SimpleStringValue("QUEUED")
+		r.stats.observe(name, start, reply)
+		return reply
 	}
 
-	return true
+	switch {
+	case name == "exec" || blockingCommands[name]:
+	case exclusiveCommands[name]:
+		r.execMu.Lock()
+		defer r.execMu.Unlock()
+	default:
+		r.execMu.RLock()
+		defer r.execMu.RUnlock()
+	}
+
+	return r.run(c, args)
 }
 
-// get implements the callback method that handles get requests.
-func (r *RespServer) get(conn *resp.Conn, args []resp.Value) bool {
-	if len(args) != 2 {
-		conn.WriteError(errInvalidArgsNum)
+// run runs the handler of the given checked command and counts its call in the server statistics.
+func (r *RespServer) run(c *client, args []resp.Value) resp.Value {
+	start := time.Now()
+	name := strings.ToLower(args[0].String())
+
+	c.mu.Lock()
+	c.lastCmd = name
+	c.lastActive = start
+	c.mu.Unlock()
+
+	reply := r.commands[name].handler(c, args)
+	r.stats.observe(name, start, reply)
+
+	return reply
+}
+
+// addClient registers a client for the given connection.
+func (r *RespServer) addClient(conn net.Conn) *client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	reader := &connReader{Conn: conn}
+	c := &client{
+		Conn:       resp.NewConn(reader),
+		id:         r.nextID,
+		conn:       conn,
+		reader:     reader,
+		created:    now,
+		lastActive: now,
+		store:      r.keyspace(indexedStore{r}, 0),
+	}
+	r.clients[c.id] = c
+	r.stats.connect()
+
+	return c
+}
+
+// removeClient closes the connection of the given client and unregisters it.
+// The connection of a subscribed client is closed after its queue is written.
+func (r *RespServer) removeClient(c *client) {
+	r.pubsubMu.Lock()
+	delete(r.subscribers, c.id)
+	if c.queue != nil {
+		close(c.queue)
 	} else {
-		value, err := r.bitcask.Get(args[1].String())
-		if err != nil {
-			conn.WriteError(err)
-		} else {
-			conn.WriteString(value)
-		}
+		c.conn.Close()
+	}
+	r.pubsubMu.Unlock()
+
+	r.mu.Lock()
+	delete(r.clients, c.id)
+	r.mu.Unlock()
+
+	r.stats.disconnect()
+}
+
+// reply writes the reply of a command, nothing is written for noReply.
+// The reply is queued after the pending messages if the client has subscribed.
+func (c *client) reply(v resp.Value) error {
+	if v.Type() == noReply.Type() {
+		return nil
+	}
+	if c.queue != nil {
+		c.queue <- v
+		return nil
 	}
 
-	return true
+	return c.WriteValue(v)
+}
+
+// set implements the callback method that handles set requests.
+func (r *RespServer) set(c *client, args []resp.Value) resp.Value {
+	err := c.store.Put(args[1].String(), args[2].String())
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.SimpleStringValue("OK")
+}
+
+// get implements the callback method that handles get requests.
+func (r *RespServer) get(c *client, args []resp.Value) resp.Value {
+	return r.value(c, args[1].String())
 }
 
 // del implements the callback method that handles delete requests.
-func (r *RespServer) del(conn *resp.Conn, args []resp.Value) bool {
-	if len(args) != 2 {
-		conn.WriteError(errInvalidArgsNum)
-	} else {
-		err := r.bitcask.Delete(args[1].String())
+// Reply with the number of the removed keys.
+func (r *RespServer) del(c *client, args []resp.Value) resp.Value {
+	removed := 0
+	for _, arg := range args[1:] {
+		err := c.store.Delete(arg.String())
+		if isNotExist(err) {
+			continue
+		}
 		if err != nil {
-			conn.WriteError(err)
-		} else {
-			conn.WriteSimpleString("OK")
+			return errorReply(err)
 		}
+		removed++
 	}
 
-	return true
+	return resp.IntegerValue(removed)
 }
@@ -0,0 +1,66 @@
+package respserver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+// newTestServer creates a server using a temporary datastore
+// and returns it with a client connected to it.
+func newTestServer(t *testing.T) (*RespServer, *resp.Conn) {
+	t.Helper()
+
+	r, err := New(t.TempDir(), ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+
+	return r, newTestClient(t, r)
+}
+
+// newTestClient returns a new client connected to the given server.
+func newTestClient(t *testing.T, r *RespServer) *resp.Conn {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	go r.serveConn(serverConn)
+	t.Cleanup(func() { clientConn.Close() })
+
+	return resp.NewConn(clientConn)
+}
+
+// read reads the next value sent by the server.
+func read(t *testing.T, conn *resp.Conn) resp.Value {
+	t.Helper()
+
+	v, _, err := conn.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+// do sends the given command and returns its reply.
+func do(t *testing.T, conn *resp.Conn, args ...interface{}) resp.Value {
+	t.Helper()
+
+	err := conn.WriteMultiBulk(args[0].(string), args[1:]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read(t, conn)
+}
+
+// assertReply checks the RESP encoding of the given reply.
+func assertReply(t *testing.T, got resp.Value, want string) {
+	t.Helper()
+
+	data, _ := got.MarshalRESP()
+	if string(data) != want {
+		t.Errorf("got reply %q, want %q", data, want)
+	}
+}
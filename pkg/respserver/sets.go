@@ -0,0 +1,226 @@
+package respserver
+
+import (
+	"sort"
+
+	"github.com/tidwall/resp"
+)
+
+// sadd implements the callback method that handles sadd requests.
+// Reply with the number of the added members.
+func (r *RespServer) sadd(c *client, args []resp.Value) resp.Value {
+	key := args[1].String()
+
+	var added int
+	err := r.atomically(c, func(tx *txn) error {
+		added = 0
+		m, err := tx.collectionOrNew(c.db, key, setType)
+		if err != nil {
+			return err
+		}
+
+		for _, arg := range args[2:] {
+			member := arg.String()
+			_, err := tx.Get(elemKey(m.id, member))
+			if !isNotExist(err) {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			err = tx.Put(elemKey(m.id, member), "")
+			if err != nil {
+				return err
+			}
+			tx.addMember(m.id, member)
+			m.size++
+			added++
+		}
+		if added == 0 {
+			return nil
+		}
+		return tx.saveCollection(c.db, key, m)
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(added)
+}
+
+// srem implements the callback method that handles srem requests.
+// The key is removed with its last member.
+// Reply with the number of the removed members.
+func (r *RespServer) srem(c *client, args []resp.Value) resp.Value {
+	key := args[1].String()
+
+	var removed int
+	err := r.atomically(c, func(tx *txn) error {
+		removed = 0
+		m, ok, err := tx.collection(c.db, key, setType)
+		if err != nil || !ok {
+			return err
+		}
+
+		for _, arg := range args[2:] {
+			member := arg.String()
+			err := tx.Delete(elemKey(m.id, member))
+			if isNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			tx.removeMember(m.id, member)
+			m.size--
+			removed++
+		}
+		if removed == 0 {
+			return nil
+		}
+		return tx.saveCollection(c.db, key, m)
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(removed)
+}
+
+// sismember implements the callback method that handles sismember requests.
+func (r *RespServer) sismember(c *client, args []resp.Value) resp.Value {
+	var found bool
+	err := r.atomically(c, func(tx *txn) error {
+		found = false
+		m, ok, err := tx.collection(c.db, args[1].String(), setType)
+		if err != nil || !ok {
+			return err
+		}
+
+		_, err = tx.Get(elemKey(m.id, args[2].String()))
+		if isNotExist(err) {
+			return nil
+		}
+		found = err == nil
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if !found {
+		return resp.IntegerValue(0)
+	}
+
+	return resp.IntegerValue(1)
+}
+
+// smembers implements the callback method that handles smembers requests.
+// Reply with the sorted members.
+func (r *RespServer) smembers(c *client, args []resp.Value) resp.Value {
+	return r.combineSets(c, args[1:2], func(sets []map[string]bool, member string) bool {
+		return true
+	})
+}
+
+// scard implements the callback method that handles scard requests.
+func (r *RespServer) scard(c *client, args []resp.Value) resp.Value {
+	var size int64
+	err := r.atomically(c, func(tx *txn) error {
+		m, _, err := tx.collection(c.db, args[1].String(), setType)
+		size = m.size
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(int(size))
+}
+
+// sinter implements the callback method that handles sinter requests.
+// Reply with the sorted members of all the given sets, the keys that do not exist are empty sets.
+func (r *RespServer) sinter(c *client, args []resp.Value) resp.Value {
+	return r.combineSets(c, args[1:], func(sets []map[string]bool, member string) bool {
+		for _, set := range sets {
+			if !set[member] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// sunion implements the callback method that handles sunion requests.
+// Reply with the sorted members of any of the given sets.
+func (r *RespServer) sunion(c *client, args []resp.Value) resp.Value {
+	return r.combineSets(c, args[1:], func(sets []map[string]bool, member string) bool {
+		return true
+	})
+}
+
+// sdiff implements the callback method that handles sdiff requests.
+// Reply with the sorted members of the first set that are not members of the other sets.
+func (r *RespServer) sdiff(c *client, args []resp.Value) resp.Value {
+	return r.combineSets(c, args[1:], func(sets []map[string]bool, member string) bool {
+		if !sets[0][member] {
+			return false
+		}
+		for _, set := range sets[1:] {
+			if set[member] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// combineSets returns the reply of the sorted members of the sets stored at the given keys
+// for which keep returns true.
+// keep gets the members of the sets by the order of their keys and the member to check.
+func (r *RespServer) combineSets(c *client, keys []resp.Value, keep func(sets []map[string]bool, member string) bool) resp.Value {
+	var members []resp.Value
+	err := r.atomically(c, func(tx *txn) error {
+		members = make([]resp.Value, 0)
+		sets := make([]map[string]bool, 0, len(keys))
+		all := make(map[string]bool)
+		for _, key := range keys {
+			m, ok, err := tx.collection(c.db, key.String(), setType)
+			if err != nil {
+				return err
+			}
+
+			set := make(map[string]bool)
+			if ok {
+				for _, member := range tx.members(r, m.id) {
+					set[member] = true
+					all[member] = true
+				}
+			}
+			sets = append(sets, set)
+		}
+
+		for _, member := range sortedKeys(all) {
+			if keep(sets, member) {
+				members = append(members, resp.StringValue(member))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.ArrayValue(members)
+}
+
+// sortedKeys returns the sorted keys of the set.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,47 @@
+package respserver
+
+import (
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	r, conn := newTestServer(t)
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"sadd", "a", "x", "y", "z", "x"}, ":3\r\n"},
+		{[]interface{}{"sadd", "a", "x", "w"}, ":1\r\n"},
+		{[]interface{}{"sadd", "b", "y", "z", "v"}, ":3\r\n"},
+		{[]interface{}{"scard", "a"}, ":4\r\n"},
+		{[]interface{}{"scard", "missing"}, ":0\r\n"},
+		{[]interface{}{"sismember", "a", "x"}, ":1\r\n"},
+		{[]interface{}{"sismember", "a", "v"}, ":0\r\n"},
+		{[]interface{}{"sismember", "missing", "v"}, ":0\r\n"},
+		{[]interface{}{"smembers", "a"}, "*4\r\n$1\r\nw\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\nz\r\n"},
+		{[]interface{}{"sinter", "a", "b"}, "*2\r\n$1\r\ny\r\n$1\r\nz\r\n"},
+		{[]interface{}{"sinter", "a", "missing"}, "*0\r\n"},
+		{[]interface{}{"sunion", "a", "b", "missing"}, "*5\r\n$1\r\nv\r\n$1\r\nw\r\n$1\r\nx\r\n$1\r\ny\r\n$1\r\nz\r\n"},
+		{[]interface{}{"sdiff", "a", "b"}, "*2\r\n$1\r\nw\r\n$1\r\nx\r\n"},
+		{[]interface{}{"sdiff", "missing", "a"}, "*0\r\n"},
+		{[]interface{}{"srem", "a", "x", "v"}, ":1\r\n"},
+		{[]interface{}{"srem", "b", "y", "z", "v"}, ":3\r\n"},
+		{[]interface{}{"exists", "b"}, ":0\r\n"},
+		{[]interface{}{"type", "a"}, "+set\r\n"},
+
+		{[]interface{}{"set", "string", "value"}, "+OK\r\n"},
+		{[]interface{}{"sadd", "string", "x"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]interface{}{"sinter", "a", "string"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]interface{}{"flushdb"}, "+OK\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+
+	for _, key := range r.bitcask.ListKeys() {
+		if key != encodingKey {
+			t.Errorf("unexpected record %q", key)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package respserver
+
+import (
+	"math/rand"
+)
+
+const (
+	// zsetMaxLevel is the maximum number of levels of the sorted sets skiplists.
+	zsetMaxLevel = 32
+	// zsetLevelP is the probability of a skiplist node to have an additional level.
+	zsetLevelP = 0.25
+)
+
+type (
+	// zset represents the members of a sorted set ordered by their scores then by the members themselves.
+	// The members are kept in a skiplist whose links count the nodes they skip,
+	// so finding a member, its rank or a range of scores or ranks takes O(log n).
+	zset struct {
+		scores map[string]float64
+		header *zsetNode
+		level  int
+	}
+
+	// zsetNode represents a member of a sorted set skiplist.
+	zsetNode struct {
+		member string
+		score  float64
+		prev   *zsetNode
+		levels []zsetLevel
+	}
+
+	// zsetLevel represents the link of a skiplist node in one of its levels,
+	// span is the number of nodes the link skips including the next node.
+	zsetLevel struct {
+		next *zsetNode
+		span int
+	}
+
+	// zmember represents a member of a sorted set with its score.
+	zmember struct {
+		member string
+		score  float64
+	}
+
+	// zbound represents a bound of a range of scores.
+	zbound struct {
+		score     float64
+		exclusive bool
+	}
+)
+
+// newZSet creates an empty sorted set.
+func newZSet() *zset {
+	return &zset{
+		scores: make(map[string]float64),
+		header: &zsetNode{levels: make([]zsetLevel, zsetMaxLevel)},
+		level:  1,
+	}
+}
+
+// len returns the number of the members.
+func (z *zset) len() int {
+	return len(z.scores)
+}
+
+// score returns the score of the member and whether it is a member.
+func (z *zset) score(member string) (float64, bool) {
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// add adds the member with the given score or updates its score.
+func (z *zset) add(member string, score float64) {
+	if old, ok := z.scores[member]; ok {
+		if old == score {
+			return
+		}
+		z.unlink(member, old)
+	}
+	z.scores[member] = score
+
+	var update [zsetMaxLevel]*zsetNode
+	var rank [zsetMaxLevel]int
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		if i < z.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].next != nil && x.levels[i].next.before(member, score) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			update[i] = z.header
+			update[i].levels[i].span = len(z.scores) - 1
+		}
+		z.level = level
+	}
+
+	x = &zsetNode{member: member, score: score, levels: make([]zsetLevel, level)}
+	for i := 0; i < level; i++ {
+		x.levels[i].next = update[i].levels[i].next
+		update[i].levels[i].next = x
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < z.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != z.header {
+		x.prev = update[0]
+	}
+	if x.levels[0].next != nil {
+		x.levels[0].next.prev = x
+	}
+}
+
+// remove removes the member, it reports whether the member was removed.
+func (z *zset) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	delete(z.scores, member)
+	z.unlink(member, score)
+
+	return true
+}
+
+// rank returns the 0 based rank of the member in the ascending order and whether it is a member.
+func (z *zset) rank(member string) (int, bool) {
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false
+	}
+
+	rank := 0
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && (x.levels[i].next.before(member, score) || x.levels[i].next.member == member) {
+			rank += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if x != z.header && x.member == member {
+			return rank - 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// byRank returns the members from the start rank to the stop rank inclusive,
+// the ranks must be in the range of the members and they are counted from the end if rev is set.
+func (z *zset) byRank(start, stop int, rev bool) []zmember {
+	n := stop - start + 1
+	if n <= 0 {
+		return nil
+	}
+
+	members := make([]zmember, 0, n)
+	if rev {
+		for x := z.nodeAt(z.len() - 1 - start); x != nil && len(members) < n; x = x.prev {
+			members = append(members, zmember{x.member, x.score})
+		}
+		return members
+	}
+	for x := z.nodeAt(start); x != nil && len(members) < n; x = x.levels[0].next {
+		members = append(members, zmember{x.member, x.score})
+	}
+
+	return members
+}
+
+// byScore returns the members whose scores are in the range from min to max,
+// in the descending order if rev is set, skipping offset members and returning up to count members,
+// a negative count returns all the members after offset.
+func (z *zset) byScore(min, max zbound, rev bool, offset, count int) []zmember {
+	members := make([]zmember, 0)
+
+	var x *zsetNode
+	if rev {
+		x = z.lastInRange(max)
+	} else {
+		x = z.firstInRange(min)
+	}
+	for ; x != nil && count != 0; count-- {
+		if !min.below(x.score) || !max.above(x.score) {
+			break
+		}
+		if offset > 0 {
+			offset--
+			count++
+		} else {
+			members = append(members, zmember{x.member, x.score})
+		}
+
+		if rev {
+			x = x.prev
+		} else {
+			x = x.levels[0].next
+		}
+	}
+
+	return members
+}
+
+// clone returns a copy of the sorted set.
+func (z *zset) clone() *zset {
+	c := newZSet()
+	for x := z.header.levels[0].next; x != nil; x = x.levels[0].next {
+		c.add(x.member, x.score)
+	}
+
+	return c
+}
+
+// unlink removes the node of the member with the given score from the skiplist.
+func (z *zset) unlink(member string, score float64) {
+	var update [zsetMaxLevel]*zsetNode
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.before(member, score) {
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+
+	x = x.levels[0].next
+	if x == nil || x.member != member {
+		return
+	}
+	for i := 0; i < z.level; i++ {
+		if update[i].levels[i].next == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].next = x.levels[i].next
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+
+	if x.levels[0].next != nil {
+		x.levels[0].next.prev = x.prev
+	}
+	for z.level > 1 && z.header.levels[z.level-1].next == nil {
+		z.level--
+	}
+}
+
+// nodeAt returns the node of the given 0 based rank, or nil if the rank is out of the members.
+func (z *zset) nodeAt(rank int) *zsetNode {
+	traversed := 0
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// firstInRange returns the first node whose score is not below min, or nil if there is none.
+func (z *zset) firstInRange(min zbound) *zsetNode {
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && !min.below(x.levels[i].next.score) {
+			x = x.levels[i].next
+		}
+	}
+
+	return x.levels[0].next
+}
+
+// lastInRange returns the last node whose score is not above max, or nil if there is none.
+func (z *zset) lastInRange(max zbound) *zsetNode {
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && max.above(x.levels[i].next.score) {
+			x = x.levels[i].next
+		}
+	}
+	if x == z.header {
+		return nil
+	}
+
+	return x
+}
+
+// before reports whether the node is ordered before the given member with the given score.
+func (n *zsetNode) before(member string, score float64) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// below reports whether the score is in the range starting at the bound.
+func (b zbound) below(score float64) bool {
+	if b.exclusive {
+		return b.score < score
+	}
+
+	return b.score <= score
+}
+
+// above reports whether the score is in the range ending at the bound.
+func (b zbound) above(score float64) bool {
+	if b.exclusive {
+		return b.score > score
+	}
+
+	return b.score >= score
+}
+
+// randomLevel returns a random level for a new skiplist node,
+// every additional level is picked with zsetLevelP probability.
+func randomLevel() int {
+	level := 1
+	for level < zsetMaxLevel && rand.Float64() < zsetLevelP {
+		level++
+	}
+
+	return level
+}
@@ -0,0 +1,408 @@
+package respserver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/IslamWalid/bitcask/internal/datastore"
+	"github.com/tidwall/resp"
+)
+
+// maxStringSize is the maximum size of the values built by APPEND and SETRANGE.
+const maxStringSize = 512 * 1024 * 1024
+
+var (
+	// errNotInteger happens whenever an integer argument or value cannot be parsed.
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	// errNotFloat happens whenever a float argument or value cannot be parsed.
+	errNotFloat = errors.New("ERR value is not a valid float")
+	// errOverflow happens whenever an integer increment overflows.
+	errOverflow = errors.New("ERR increment or decrement would overflow")
+	// errNaN happens whenever a float increment produces NaN or Infinity.
+	errNaN = errors.New("ERR increment would produce NaN or Infinity")
+	// errOffset happens whenever SETRANGE is called with a negative offset.
+	errOffset = errors.New("ERR offset is out of range")
+	// errMaxSize happens whenever a value would grow over maxStringSize.
+	errMaxSize = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+// exists implements the callback method that handles exists requests.
+// Reply with the number of the given keys that exist, a key given twice is counted twice.
+func (r *RespServer) exists(c *client, args []resp.Value) resp.Value {
+	found := 0
+	for _, arg := range args[1:] {
+		typ, err := c.store.Type(arg.String())
+		if err != nil {
+			return errorReply(err)
+		}
+		if typ != "none" {
+			found++
+		}
+	}
+
+	return resp.IntegerValue(found)
+}
+
+// mget implements the callback method that handles mget requests.
+// Reply with the values of the given keys, nil is replied for the keys that do not exist.
+func (r *RespServer) mget(c *client, args []resp.Value) resp.Value {
+	values := make([]resp.Value, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		v := r.value(c, arg.String())
+		if v.Type() == resp.Error {
+			return v
+		}
+		values = append(values, v)
+	}
+
+	return resp.ArrayValue(values)
+}
+
+// mset implements the callback method that handles mset requests.
+func (r *RespServer) mset(c *client, args []resp.Value) resp.Value {
+	if len(args)%2 == 0 {
+		return resp.ErrorValue(fmt.Errorf(errWrongArgs, "mset"))
+	}
+
+	err := r.atomically(c, func(tx *txn) error {
+		s := r.keyspace(tx, c.db)
+		for i := 1; i < len(args); i += 2 {
+			err := s.Put(args[i].String(), args[i+1].String())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.SimpleStringValue("OK")
+}
+
+// setnx implements the callback method that handles setnx requests.
+// Reply with 1 if the value is set or 0 if the key already exists.
+func (r *RespServer) setnx(c *client, args []resp.Value) resp.Value {
+	set := 0
+	err := c.store.Update(args[1].String(), func(value string, exists bool) (string, bitcask.UpdateOp) {
+		if exists {
+			return "", bitcask.UpdateKeep
+		}
+		set = 1
+		return args[2].String(), bitcask.UpdatePut
+	})
+	if err == errWrongType {
+		return resp.IntegerValue(0)
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(set)
+}
+
+// getset implements the callback method that handles getset requests.
+// Reply with the old value of the key, or nil if it did not exist.
+func (r *RespServer) getset(c *client, args []resp.Value) resp.Value {
+	old := resp.NullValue()
+	err := c.store.Update(args[1].String(), func(value string, exists bool) (string, bitcask.UpdateOp) {
+		if exists {
+			old = resp.StringValue(value)
+		}
+		return args[2].String(), bitcask.UpdatePut
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return old
+}
+
+// getdel implements the callback method that handles getdel requests.
+// Reply with the removed value, or nil if the key did not exist.
+func (r *RespServer) getdel(c *client, args []resp.Value) resp.Value {
+	old := resp.NullValue()
+	err := c.store.Update(args[1].String(), func(value string, exists bool) (string, bitcask.UpdateOp) {
+		if exists {
+			old = resp.StringValue(value)
+		}
+		return "", bitcask.UpdateDelete
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return old
+}
+
+// append implements the callback method that handles append requests.
+// Reply with the length of the value after appending.
+func (r *RespServer) append(c *client, args []resp.Value) resp.Value {
+	suffix := args[2].String()
+
+	var length int
+	var appendErr error
+	err := c.store.Update(args[1].String(), func(value string, exists bool) (string, bitcask.UpdateOp) {
+		if len(value)+len(suffix) > maxStringSize {
+			appendErr = errMaxSize
+			return "", bitcask.UpdateKeep
+		}
+		length = len(value) + len(suffix)
+		return value + suffix, bitcask.UpdatePut
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if appendErr != nil {
+		return resp.ErrorValue(appendErr)
+	}
+
+	return resp.IntegerValue(length)
+}
+
+// strlen implements the callback method that handles strlen requests.
+// Reply with 0 if the key does not exist.
+func (r *RespServer) strlen(c *client, args []resp.Value) resp.Value {
+	value, err := c.store.Get(args[1].String())
+	if err != nil && !isNotExist(err) {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(len(value))
+}
+
+// getrange implements the callback method that handles getrange requests.
+// Negative offsets count from the end of the value and the range is clamped to the value,
+// an empty string is replied if the key does not exist.
+func (r *RespServer) getrange(c *client, args []resp.Value) resp.Value {
+	start, err := parseInt(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	end, err := parseInt(args[3].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	value, err := c.store.Get(args[1].String())
+	if err != nil && !isNotExist(err) {
+		return errorReply(err)
+	}
+
+	n := int64(len(value))
+	if start < 0 && end < 0 && start > end {
+		return resp.StringValue("")
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if n == 0 || start > end {
+		return resp.StringValue("")
+	}
+
+	return resp.StringValue(value[start : end+1])
+}
+
+// setrange implements the callback method that handles setrange requests.
+// The value is padded with zero bytes if the offset is after its end.
+// Reply with the length of the value after writing.
+func (r *RespServer) setrange(c *client, args []resp.Value) resp.Value {
+	offset, err := parseInt(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	if offset < 0 {
+		return resp.ErrorValue(errOffset)
+	}
+	patch := args[3].String()
+	if offset+int64(len(patch)) > maxStringSize {
+		return resp.ErrorValue(errMaxSize)
+	}
+
+	var length int
+	err = c.store.Update(args[1].String(), func(value string, exists bool) (string, bitcask.UpdateOp) {
+		if len(patch) == 0 {
+			length = len(value)
+			return "", bitcask.UpdateKeep
+		}
+
+		buf := []byte(value)
+		if end := int(offset) + len(patch); end > len(buf) {
+			buf = append(buf, make([]byte, end-len(buf))...)
+		}
+		copy(buf[offset:], patch)
+		length = len(buf)
+		return string(buf), bitcask.UpdatePut
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(length)
+}
+
+// incr implements the callback method that handles incr requests.
+func (r *RespServer) incr(c *client, args []resp.Value) resp.Value {
+	return r.incrBy(c, args[1].String(), 1)
+}
+
+// decr implements the callback method that handles decr requests.
+func (r *RespServer) decr(c *client, args []resp.Value) resp.Value {
+	return r.incrBy(c, args[1].String(), -1)
+}
+
+// incrby implements the callback method that handles incrby requests.
+func (r *RespServer) incrby(c *client, args []resp.Value) resp.Value {
+	delta, err := parseInt(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	return r.incrBy(c, args[1].String(), delta)
+}
+
+// decrby implements the callback method that handles decrby requests.
+func (r *RespServer) decrby(c *client, args []resp.Value) resp.Value {
+	delta, err := parseInt(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	if delta == math.MinInt64 {
+		return resp.ErrorValue(errOverflow)
+	}
+
+	return r.incrBy(c, args[1].String(), -delta)
+}
+
+// incrbyfloat implements the callback method that handles incrbyfloat requests.
+// Reply with the value after the increment.
+func (r *RespServer) incrbyfloat(c *client, args []resp.Value) resp.Value {
+	delta, err := parseFloat(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	var result string
+	var incrErr error
+	err = c.store.Update(args[1].String(), func(value string, exists bool) (string, bitcask.UpdateOp) {
+		n := 0.0
+		if exists {
+			n, incrErr = parseFloat(value)
+			if incrErr != nil {
+				return "", bitcask.UpdateKeep
+			}
+		}
+		n += delta
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			incrErr = errNaN
+			return "", bitcask.UpdateKeep
+		}
+		result = strconv.FormatFloat(n, 'f', -1, 64)
+		return result, bitcask.UpdatePut
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if incrErr != nil {
+		return resp.ErrorValue(incrErr)
+	}
+
+	return resp.StringValue(result)
+}
+
+// incrBy adds delta to the integer value of the key, a key that does not exist is set to delta.
+// Reply with the value after the increment.
+func (r *RespServer) incrBy(c *client, key string, delta int64) resp.Value {
+	var result int64
+	var incrErr error
+	err := c.store.Update(key, func(value string, exists bool) (string, bitcask.UpdateOp) {
+		var n int64
+		if exists {
+			n, incrErr = parseInt(value)
+			if incrErr != nil {
+				return "", bitcask.UpdateKeep
+			}
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			incrErr = errOverflow
+			return "", bitcask.UpdateKeep
+		}
+		result = n + delta
+		return strconv.FormatInt(result, 10), bitcask.UpdatePut
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+	if incrErr != nil {
+		return resp.ErrorValue(incrErr)
+	}
+
+	return resp.IntegerValue(int(result))
+}
+
+// value returns the reply of the value of the key, nil is replied if the key does not exist.
+func (r *RespServer) value(c *client, key string) resp.Value {
+	value, err := c.store.Get(key)
+	if isNotExist(err) {
+		return resp.NullValue()
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.StringValue(value)
+}
+
+// parseInt parses a 64 bit integer in the canonical form used by redis,
+// without a plus sign, spaces or leading zeros.
+// Return errNotInteger if the string is not an integer.
+func parseInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, errNotInteger
+	}
+
+	return n, nil
+}
+
+// parseFloat parses a finite float.
+// Return errNotFloat if the string is not a finite float.
+func parseFloat(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errNotFloat
+	}
+
+	return f, nil
+}
+
+// isNotExist reports whether the error happened because the key does not exist.
+func isNotExist(err error) bool {
+	return err != nil && strings.HasSuffix(err.Error(), datastore.ErrKeyNotExist.Error())
+}
+
+// errorReply returns the reply of a datastore error, errWrongType is replied as it is.
+func errorReply(err error) resp.Value {
+	if err == errWrongType {
+		return resp.ErrorValue(err)
+	}
+
+	return resp.ErrorValue(fmt.Errorf("ERR %s", err))
+}
@@ -0,0 +1,94 @@
+package respserver
+
+import (
+	"testing"
+
+	"github.com/IslamWalid/bitcask"
+)
+
+func TestStringCommands(t *testing.T) {
+	_, conn := newTestServer(t)
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"get", "key"}, "$-1\r\n"},
+		{[]interface{}{"mset", "key", "value", "other", "1"}, "+OK\r\n"},
+		{[]interface{}{"mset", "key"}, "-ERR wrong number of arguments for 'mset' command\r\n"},
+		{[]interface{}{"mget", "key", "missing", "other"}, "*3\r\n$5\r\nvalue\r\n$-1\r\n$1\r\n1\r\n"},
+		{[]interface{}{"exists", "key", "missing", "key"}, ":2\r\n"},
+		{[]interface{}{"setnx", "key", "new"}, ":0\r\n"},
+		{[]interface{}{"setnx", "new", "value"}, ":1\r\n"},
+		{[]interface{}{"getset", "key", "replaced"}, "$5\r\nvalue\r\n"},
+		{[]interface{}{"getset", "missing", "value"}, "$-1\r\n"},
+		{[]interface{}{"getdel", "missing"}, "$5\r\nvalue\r\n"},
+		{[]interface{}{"getdel", "missing"}, "$-1\r\n"},
+		{[]interface{}{"append", "key", "!"}, ":9\r\n"},
+		{[]interface{}{"strlen", "key"}, ":9\r\n"},
+		{[]interface{}{"strlen", "missing"}, ":0\r\n"},
+		{[]interface{}{"getrange", "key", "0", "3"}, "$4\r\nrepl\r\n"},
+		{[]interface{}{"getrange", "key", "-3", "-1"}, "$3\r\ned!\r\n"},
+		{[]interface{}{"getrange", "key", "5", "2"}, "$0\r\n\r\n"},
+		{[]interface{}{"getrange", "key", "a", "2"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]interface{}{"setrange", "key", "0", "RE"}, ":9\r\n"},
+		{[]interface{}{"setrange", "padded", "2", "x"}, ":3\r\n"},
+		{[]interface{}{"get", "padded"}, "$3\r\n\x00\x00x\r\n"},
+		{[]interface{}{"setrange", "key", "-1", "x"}, "-ERR offset is out of range\r\n"},
+		{[]interface{}{"incr", "other"}, ":2\r\n"},
+		{[]interface{}{"incrby", "other", "10"}, ":12\r\n"},
+		{[]interface{}{"decr", "counter"}, ":-1\r\n"},
+		{[]interface{}{"decrby", "counter", "4"}, ":-5\r\n"},
+		{[]interface{}{"incr", "key"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]interface{}{"incrby", "other", "+1"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]interface{}{"set", "max", "9223372036854775807"}, "+OK\r\n"},
+		{[]interface{}{"incr", "max"}, "-ERR increment or decrement would overflow\r\n"},
+		{[]interface{}{"incrbyfloat", "float", "10.5"}, "$4\r\n10.5\r\n"},
+		{[]interface{}{"incrbyfloat", "float", "0.1"}, "$4\r\n10.6\r\n"},
+		{[]interface{}{"incrbyfloat", "key", "1"}, "-ERR value is not a valid float\r\n"},
+		{[]interface{}{"incrbyfloat", "float", "inf"}, "-ERR value is not a valid float\r\n"},
+		{[]interface{}{"del", "key", "missing", "other"}, ":2\r\n"},
+	}
+
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+}
+
+func TestStringsMigration(t *testing.T) {
+	dir := t.TempDir()
+	b, err := bitcask.Open(dir, bitcask.ReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Put("escaped", "\x00\x00value")
+	b.Put("hash", "\x00hid\x001")
+	b.Put("plain", "value")
+	b.Close()
+
+	// the values are escaped once, so reopening the server keeps them.
+	for i := 0; i < 2; i++ {
+		r, err := New(dir, ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := newTestClient(t, r)
+		assertReply(t, do(t, conn, "get", "escaped"), "$7\r\n\x00\x00value\r\n")
+		assertReply(t, do(t, conn, "type", "hash"), "+string\r\n")
+		assertReply(t, do(t, conn, "get", "hash"), "$6\r\n\x00hid\x001\r\n")
+		assertReply(t, do(t, conn, "get", "plain"), "$5\r\nvalue\r\n")
+		if i == 1 {
+			// the encoding version is kept by FLUSHALL, so the strings written after it are not escaped again.
+			do(t, conn, "flushall")
+			do(t, conn, "set", "escaped", "\x00value")
+		}
+		r.Close()
+	}
+
+	r, err := New(dir, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	assertReply(t, do(t, newTestClient(t, r), "get", "escaped"), "$6\r\n\x00value\r\n")
+}
@@ -0,0 +1,157 @@
+package respserver
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/IslamWalid/bitcask"
+	"github.com/tidwall/resp"
+)
+
+var (
+	// errNestedMulti happens whenever MULTI is called inside a transaction.
+	errNestedMulti = errors.New("ERR MULTI calls can not be nested")
+	// errExecWithoutMulti happens whenever EXEC is called outside a transaction.
+	errExecWithoutMulti = errors.New("ERR EXEC without MULTI")
+	// errDiscardWithoutMulti happens whenever DISCARD is called outside a transaction.
+	errDiscardWithoutMulti = errors.New("ERR DISCARD without MULTI")
+	// errWatchInsideMulti happens whenever WATCH is called inside a transaction.
+	errWatchInsideMulti = errors.New("ERR WATCH inside MULTI is not allowed")
+	// errExecAbort happens whenever EXEC is called after a command failed to be queued.
+	errExecAbort = errors.New("EXECABORT Transaction discarded because of previous errors.")
+
+	// nullArray is the reply of an aborted transaction,
+	// it is parsed as the resp package has no constructor for null arrays.
+	nullArray, _, _ = resp.NewReader(strings.NewReader("*-1\r\n")).ReadValue()
+
+	// multiCommands are the commands that are run instead of being queued inside a transaction.
+	multiCommands = map[string]bool{
+		"multi":   true,
+		"exec":    true,
+		"discard": true,
+		"watch":   true,
+		"quit":    true,
+	}
+)
+
+// store represents the datastore operations used by the command handlers,
+// it is implemented by the indexed datastore, by the transactions and by their logical databases and keyspaces.
+type store interface {
+	Get(key string) (string, error)
+	Put(key, value string) error
+	Delete(key string) error
+	Update(key string, fn func(value string, exists bool) (string, bitcask.UpdateOp)) error
+	ListKeys() []string
+}
+
+// multi implements the callback method that handles multi requests.
+// The next commands are queued until EXEC or DISCARD is called.
+func (r *RespServer) multi(c *client, args []resp.Value) resp.Value {
+	if c.multi {
+		return resp.ErrorValue(errNestedMulti)
+	}
+	c.multi = true
+
+	return resp.SimpleStringValue("OK")
+}
+
+// execTx implements the callback method that handles exec requests.
+// The queued commands are run while the other commands wait and their writes are committed together.
+// Reply with the replies of the queued commands, or a null array if a watched key was written
+// since it was watched.
+func (r *RespServer) execTx(c *client, args []resp.Value) resp.Value {
+	if !c.multi {
+		return resp.ErrorValue(errExecWithoutMulti)
+	}
+	queued, failed, watched := c.queued, c.multiFailed, c.watched
+	c.resetMulti()
+	c.watched = nil
+	if failed {
+		return resp.ErrorValue(errExecAbort)
+	}
+
+	r.execMu.Lock()
+	defer r.execMu.Unlock()
+
+	tx := r.begin()
+	// the versions are read from the stored databases of the time of WATCH,
+	// so the keys of databases swapped meanwhile are read from other records and their versions differ.
+	for key, version := range watched {
+		stored := r.storedKey(key)
+		if r.bitcask.Version(stored) != version {
+			return nullArray
+		}
+		tx.AssertVersion(stored, version)
+	}
+
+	r.use(c, tx, c.db)
+	replies := make([]resp.Value, 0, len(queued))
+	for _, args := range queued {
+		replies = append(replies, r.run(c, args))
+	}
+	// SELECT inside the transaction keeps the selected database after EXEC.
+	r.use(c, indexedStore{r}, c.db)
+
+	// the other commands are not run meanwhile, so only writes done by other bitcask users conflict.
+	err := r.commit(tx)
+	if err == bitcask.ErrConflict {
+		return nullArray
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.ArrayValue(replies)
+}
+
+// discard implements the callback method that handles discard requests.
+func (r *RespServer) discard(c *client, args []resp.Value) resp.Value {
+	if !c.multi {
+		return resp.ErrorValue(errDiscardWithoutMulti)
+	}
+	c.resetMulti()
+	c.watched = nil
+
+	return resp.SimpleStringValue("OK")
+}
+
+// watch implements the callback method that handles watch requests.
+// The versions of the keys of the selected database are recorded, so EXEC aborts if any of them
+// is written before it is called.
+func (r *RespServer) watch(c *client, args []resp.Value) resp.Value {
+	if c.multi {
+		return resp.ErrorValue(errWatchInsideMulti)
+	}
+
+	if c.watched == nil {
+		c.watched = make(map[string]int64)
+	}
+	for _, arg := range args[1:] {
+		key := dbKey(c.db, arg.String())
+		if _, ok := c.watched[key]; !ok {
+			c.watched[key] = r.bitcask.Version(r.storedKey(key))
+		}
+	}
+
+	return resp.SimpleStringValue("OK")
+}
+
+// unwatch implements the callback method that handles unwatch requests.
+func (r *RespServer) unwatch(c *client, args []resp.Value) resp.Value {
+	c.watched = nil
+
+	return resp.SimpleStringValue("OK")
+}
+
+// atomically runs fn on a transaction committed after fn returns, so its writes are applied together.
+// Inside EXEC, fn uses the EXEC transaction.
+func (r *RespServer) atomically(c *client, fn func(tx *txn) error) error {
+	return c.store.atomically(fn)
+}
+
+// resetMulti leaves the transaction of the client dropping its queued commands.
+func (c *client) resetMulti() {
+	c.multi = false
+	c.multiFailed = false
+	c.queued = nil
+}
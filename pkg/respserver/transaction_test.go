@@ -0,0 +1,91 @@
+package respserver
+
+import (
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+func TestTransactions(t *testing.T) {
+	r, conn := newTestServer(t)
+	other := newTestClient(t, r)
+
+	cases := []struct {
+		conn *resp.Conn
+		args []interface{}
+		want string
+	}{
+		{conn, []interface{}{"exec"}, "-ERR EXEC without MULTI\r\n"},
+		{conn, []interface{}{"discard"}, "-ERR DISCARD without MULTI\r\n"},
+		{conn, []interface{}{"multi"}, "+OK\r\n"},
+		{conn, []interface{}{"multi"}, "-ERR MULTI calls can not be nested\r\n"},
+		{conn, []interface{}{"set", "key", "value"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"incr", "key"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"incr", "counter"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"get", "counter"}, "+QUEUED\r\n"},
+		{other, []interface{}{"get", "key"}, "$-1\r\n"},
+		{conn, []interface{}{"exec"},
+			"*4\r\n+OK\r\n-ERR value is not an integer or out of range\r\n:1\r\n$1\r\n1\r\n"},
+		{other, []interface{}{"get", "key"}, "$5\r\nvalue\r\n"},
+
+		{conn, []interface{}{"multi"}, "+OK\r\n"},
+		{conn, []interface{}{"set", "key", "discarded"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"discard"}, "+OK\r\n"},
+		{conn, []interface{}{"get", "key"}, "$5\r\nvalue\r\n"},
+
+		{conn, []interface{}{"multi"}, "+OK\r\n"},
+		{conn, []interface{}{"set", "key"}, "-ERR wrong number of arguments for 'set' command\r\n"},
+		{conn, []interface{}{"set", "key", "aborted"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"exec"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{conn, []interface{}{"get", "key"}, "$5\r\nvalue\r\n"},
+
+		{conn, []interface{}{"watch", "key", "missing"}, "+OK\r\n"},
+		{conn, []interface{}{"multi"}, "+OK\r\n"},
+		{conn, []interface{}{"watch", "key"}, "-ERR WATCH inside MULTI is not allowed\r\n"},
+		{conn, []interface{}{"set", "key", "watched"}, "+QUEUED\r\n"},
+		{other, []interface{}{"set", "missing", "value"}, "+OK\r\n"},
+		{conn, []interface{}{"exec"}, "*-1\r\n"},
+		{conn, []interface{}{"get", "key"}, "$5\r\nvalue\r\n"},
+
+		{conn, []interface{}{"watch", "created"}, "+OK\r\n"},
+		{other, []interface{}{"set", "created", "value"}, "+OK\r\n"},
+		{other, []interface{}{"del", "created"}, ":1\r\n"},
+		{conn, []interface{}{"multi"}, "+OK\r\n"},
+		{conn, []interface{}{"set", "key", "watched"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"exec"}, "*-1\r\n"},
+
+		{conn, []interface{}{"watch", "key"}, "+OK\r\n"},
+		{conn, []interface{}{"multi"}, "+OK\r\n"},
+		{conn, []interface{}{"rename", "key", "renamed"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"mget", "key", "renamed"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"exec"}, "*2\r\n+OK\r\n*2\r\n$-1\r\n$5\r\nvalue\r\n"},
+
+		{conn, []interface{}{"watch", "renamed"}, "+OK\r\n"},
+		{conn, []interface{}{"unwatch"}, "+OK\r\n"},
+		{other, []interface{}{"set", "renamed", "other"}, "+OK\r\n"},
+		{conn, []interface{}{"multi"}, "+OK\r\n"},
+		{conn, []interface{}{"get", "renamed"}, "+QUEUED\r\n"},
+		{conn, []interface{}{"exec"}, "*1\r\n$5\r\nother\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, c.conn, c.args...), c.want)
+	}
+}
+
+func TestTransactConflicts(t *testing.T) {
+	r, _ := newTestServer(t)
+
+	runs := 0
+	err := r.transact(func(tx *txn) error {
+		runs++
+		tx.Get("key")
+		// the key read by the transaction is written before every commit.
+		return r.bitcask.Put("key", "value")
+	})
+	if err != errTooManyConflicts {
+		t.Errorf("got %v, want %v", err, errTooManyConflicts)
+	}
+	if runs != maxConflicts {
+		t.Errorf("transaction ran %d times, want %d", runs, maxConflicts)
+	}
+}
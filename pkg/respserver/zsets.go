@@ -0,0 +1,554 @@
+package respserver
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/resp"
+)
+
+var (
+	// errScoreNaN happens whenever a score increment produces NaN.
+	errScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+	// errNotFloatRange happens whenever a bound of a range of scores cannot be parsed.
+	errNotFloatRange = errors.New("ERR min or max is not a float")
+	// errZAddXXNX happens whenever ZADD is called with both of XX and NX.
+	errZAddXXNX = errors.New("ERR XX and NX options at the same time are not compatible")
+	// errZAddGTLTNX happens whenever ZADD is called with more than one of GT, LT and NX.
+	errZAddGTLTNX = errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	// errZAddIncr happens whenever ZADD is called with INCR and more than one score member pair.
+	errZAddIncr = errors.New("ERR INCR option supports a single increment-element pair")
+	// errLimit happens whenever ZRANGE is called with LIMIT but without BYSCORE.
+	errLimit = errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+)
+
+type (
+	// zaddOpts represents the options of ZADD.
+	zaddOpts struct {
+		nx, xx, gt, lt bool
+		ch, incr       bool
+	}
+
+	// zrangeOpts represents the options of ZRANGE and ZRANGEBYSCORE,
+	// a negative count returns all the members after offset.
+	zrangeOpts struct {
+		byScore    bool
+		rev        bool
+		withScores bool
+		limit      bool
+		offset     int64
+		count      int64
+	}
+)
+
+// zadd implements the callback method that handles zadd requests.
+// NX only adds new members, XX only updates existing members,
+// GT and LT only update existing members if their new scores are greater or less than their current ones.
+// Reply with the number of the added members, or the changed members if CH is given,
+// or with the new score of the member if INCR is given, nil is replied if INCR is aborted by the options.
+func (r *RespServer) zadd(c *client, args []resp.Value) resp.Value {
+	key := args[1].String()
+	opts, pairs, err := parseZAddOpts(args[2:])
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	scores := make([]float64, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := parseScore(pairs[i].String())
+		if err != nil {
+			return resp.ErrorValue(err)
+		}
+		scores = append(scores, score)
+	}
+
+	var changed int
+	var result float64
+	var updated bool
+	err = r.atomically(c, func(tx *txn) error {
+		changed, updated = 0, false
+		m, err := tx.collectionOrNew(c.db, key, zsetType)
+		if err != nil {
+			return err
+		}
+
+		written := false
+		for i, score := range scores {
+			member := pairs[2*i+1].String()
+			old, exists, err := tx.memberScore(m.id, member)
+			if err != nil {
+				return err
+			}
+			if (opts.nx && exists) || (opts.xx && !exists) {
+				continue
+			}
+			if opts.incr && exists {
+				score += old
+				if math.IsNaN(score) {
+					return errScoreNaN
+				}
+			}
+			if exists && ((opts.gt && score <= old) || (opts.lt && score >= old)) {
+				continue
+			}
+
+			result, updated = score, true
+			if exists && score == old {
+				continue
+			}
+			err = tx.putScore(m.id, member, score)
+			if err != nil {
+				return err
+			}
+			written = true
+			if !exists {
+				m.size++
+				changed++
+			} else if opts.ch {
+				changed++
+			}
+		}
+		if !written {
+			return nil
+		}
+		return tx.saveCollection(c.db, key, m)
+	})
+	if err == errScoreNaN {
+		return resp.ErrorValue(err)
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if opts.incr {
+		if !updated {
+			return resp.NullValue()
+		}
+		return resp.StringValue(formatScore(result))
+	}
+
+	return resp.IntegerValue(changed)
+}
+
+// zincrby implements the callback method that handles zincrby requests.
+// A member that does not exist is added with the increment as its score.
+// Reply with the new score of the member.
+func (r *RespServer) zincrby(c *client, args []resp.Value) resp.Value {
+	key, member := args[1].String(), args[3].String()
+	delta, err := parseScore(args[2].String())
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+
+	var result float64
+	err = r.atomically(c, func(tx *txn) error {
+		m, err := tx.collectionOrNew(c.db, key, zsetType)
+		if err != nil {
+			return err
+		}
+
+		old, exists, err := tx.memberScore(m.id, member)
+		if err != nil {
+			return err
+		}
+		result = old + delta
+		if math.IsNaN(result) {
+			return errScoreNaN
+		}
+
+		err = tx.putScore(m.id, member, result)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			m.size++
+		}
+		return tx.saveCollection(c.db, key, m)
+	})
+	if err == errScoreNaN {
+		return resp.ErrorValue(err)
+	}
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.StringValue(formatScore(result))
+}
+
+// zrem implements the callback method that handles zrem requests.
+// The key is removed with its last member.
+// Reply with the number of the removed members.
+func (r *RespServer) zrem(c *client, args []resp.Value) resp.Value {
+	key := args[1].String()
+
+	var removed int
+	err := r.atomically(c, func(tx *txn) error {
+		removed = 0
+		m, ok, err := tx.collection(c.db, key, zsetType)
+		if err != nil || !ok {
+			return err
+		}
+
+		for _, arg := range args[2:] {
+			member := arg.String()
+			err := tx.Delete(scoreKey(m.id, member))
+			if isNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			tx.removeScore(m.id, member)
+			m.size--
+			removed++
+		}
+		if removed == 0 {
+			return nil
+		}
+		return tx.saveCollection(c.db, key, m)
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(removed)
+}
+
+// zcard implements the callback method that handles zcard requests.
+func (r *RespServer) zcard(c *client, args []resp.Value) resp.Value {
+	var size int64
+	err := r.atomically(c, func(tx *txn) error {
+		m, _, err := tx.collection(c.db, args[1].String(), zsetType)
+		size = m.size
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return resp.IntegerValue(int(size))
+}
+
+// zscore implements the callback method that handles zscore requests.
+// Reply with nil if the key or the member does not exist.
+func (r *RespServer) zscore(c *client, args []resp.Value) resp.Value {
+	value := resp.NullValue()
+	err := r.atomically(c, func(tx *txn) error {
+		value = resp.NullValue()
+		m, ok, err := tx.collection(c.db, args[1].String(), zsetType)
+		if err != nil || !ok {
+			return err
+		}
+
+		score, exists, err := tx.memberScore(m.id, args[2].String())
+		if exists {
+			value = resp.StringValue(formatScore(score))
+		}
+		return err
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	return value
+}
+
+// zrank implements the callback method that handles zrank requests.
+// Reply with the 0 based rank of the member in the ascending order of the scores,
+// followed by its score if WITHSCORE is given, nil is replied if the key or the member does not exist.
+func (r *RespServer) zrank(c *client, args []resp.Value) resp.Value {
+	if len(args) > 4 || (len(args) == 4 && !strings.EqualFold(args[3].String(), "withscore")) {
+		return resp.ErrorValue(errSyntax)
+	}
+	withScore := len(args) == 4
+	member := args[2].String()
+
+	var rank int
+	var score float64
+	var found bool
+	err := r.atomically(c, func(tx *txn) error {
+		found = false
+		m, ok, err := tx.collection(c.db, args[1].String(), zsetType)
+		if err != nil || !ok {
+			return err
+		}
+
+		tx.sortedSet(r, m.id, func(z *zset) {
+			rank, found = z.rank(member)
+			score, _ = z.score(member)
+		})
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	if !withScore {
+		if !found {
+			return resp.NullValue()
+		}
+		return resp.IntegerValue(rank)
+	}
+	if !found {
+		return nullArray
+	}
+
+	return resp.ArrayValue([]resp.Value{resp.IntegerValue(rank), resp.StringValue(formatScore(score))})
+}
+
+// zrange implements the callback method that handles zrange requests.
+// The range is of ranks unless BYSCORE is given, negative ranks count from the end.
+// REV reverses the order, so the start and the stop of a range of scores are its max and min.
+// Reply with the members in the range, each followed by its score if WITHSCORES is given.
+func (r *RespServer) zrange(c *client, args []resp.Value) resp.Value {
+	opts, err := parseZRangeOpts(args[4:], true)
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	if !opts.byScore {
+		start, err := parseInt(args[2].String())
+		if err != nil {
+			return resp.ErrorValue(err)
+		}
+		stop, err := parseInt(args[3].String())
+		if err != nil {
+			return resp.ErrorValue(err)
+		}
+		return r.rangeByRank(c, args[1].String(), start, stop, opts)
+	}
+
+	min, max := args[2].String(), args[3].String()
+	if opts.rev {
+		min, max = max, min
+	}
+
+	return r.rangeByScore(c, args[1].String(), min, max, opts)
+}
+
+// zrangebyscore implements the callback method that handles zrangebyscore requests.
+// A bound prefixed with ( is exclusive, -inf and +inf are the lowest and the highest scores.
+// Reply with the members whose scores are in the range, each followed by its score if WITHSCORES is given.
+func (r *RespServer) zrangebyscore(c *client, args []resp.Value) resp.Value {
+	opts, err := parseZRangeOpts(args[4:], false)
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	opts.byScore = true
+
+	return r.rangeByScore(c, args[1].String(), args[2].String(), args[3].String(), opts)
+}
+
+// rangeByRank returns the reply of the members of the sorted set stored at the key from start to stop inclusive,
+// the ranks are clamped to the sorted set.
+func (r *RespServer) rangeByRank(c *client, key string, start, stop int64, opts zrangeOpts) resp.Value {
+	return r.sortedRange(c, key, opts, func(z *zset) []zmember {
+		size := int64(z.len())
+		if start < 0 {
+			start += size
+		}
+		if stop < 0 {
+			stop += size
+		}
+		if start < 0 {
+			start = 0
+		}
+		if stop >= size {
+			stop = size - 1
+		}
+		if start > stop {
+			return nil
+		}
+		return z.byRank(int(start), int(stop), opts.rev)
+	})
+}
+
+// rangeByScore returns the reply of the members of the sorted set stored at the key
+// whose scores are between min and max.
+func (r *RespServer) rangeByScore(c *client, key, min, max string, opts zrangeOpts) resp.Value {
+	lower, err := parseBound(min)
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	upper, err := parseBound(max)
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	if opts.offset < 0 {
+		return resp.ArrayValue([]resp.Value{})
+	}
+
+	return r.sortedRange(c, key, opts, func(z *zset) []zmember {
+		return z.byScore(lower, upper, opts.rev, int(opts.offset), int(opts.count))
+	})
+}
+
+// sortedRange returns the reply of the members of the sorted set stored at the key returned by fn.
+func (r *RespServer) sortedRange(c *client, key string, opts zrangeOpts, fn func(z *zset) []zmember) resp.Value {
+	var members []zmember
+	err := r.atomically(c, func(tx *txn) error {
+		members = nil
+		m, ok, err := tx.collection(c.db, key, zsetType)
+		if err != nil || !ok {
+			return err
+		}
+
+		tx.sortedSet(r, m.id, func(z *zset) {
+			members = fn(z)
+		})
+		return nil
+	})
+	if err != nil {
+		return errorReply(err)
+	}
+
+	items := make([]resp.Value, 0, len(members))
+	for _, member := range members {
+		items = append(items, resp.StringValue(member.member))
+		if opts.withScores {
+			items = append(items, resp.StringValue(formatScore(member.score)))
+		}
+	}
+
+	return resp.ArrayValue(items)
+}
+
+// memberScore returns the score of the member of the sorted set with the given id and whether it is a member.
+func (tx *txn) memberScore(id, member string) (float64, bool, error) {
+	value, err := tx.Get(scoreKey(id, member))
+	if isNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	score, err := strconv.ParseFloat(value, 64)
+	return score, err == nil, err
+}
+
+// putScore sets the score of the member of the sorted set with the given id.
+func (tx *txn) putScore(id, member string, score float64) error {
+	err := tx.Put(scoreKey(id, member), strconv.FormatFloat(score, 'g', -1, 64))
+	if err != nil {
+		return err
+	}
+	tx.addScore(id, member, score)
+
+	return nil
+}
+
+// parseZAddOpts parses the options of ZADD that precede its score member pairs.
+// Return the options and the pairs, or an error if the options are not compatible or the pairs are invalid.
+func parseZAddOpts(args []resp.Value) (zaddOpts, []resp.Value, error) {
+	var opts zaddOpts
+	i := 0
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i].String()) {
+		case "nx":
+			opts.nx = true
+		case "xx":
+			opts.xx = true
+		case "gt":
+			opts.gt = true
+		case "lt":
+			opts.lt = true
+		case "ch":
+			opts.ch = true
+		case "incr":
+			opts.incr = true
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+	switch {
+	case opts.nx && opts.xx:
+		return opts, nil, errZAddXXNX
+	case (opts.gt && opts.lt) || (opts.nx && (opts.gt || opts.lt)):
+		return opts, nil, errZAddGTLTNX
+	case len(pairs) == 0 || len(pairs)%2 != 0:
+		return opts, nil, errSyntax
+	case opts.incr && len(pairs) > 2:
+		return opts, nil, errZAddIncr
+	}
+
+	return opts, pairs, nil
+}
+
+// parseZRangeOpts parses the options that follow the range of ZRANGE, or of ZRANGEBYSCORE if full is not set.
+// Return errSyntax if an option is not allowed or its value is missing.
+func parseZRangeOpts(args []resp.Value, full bool) (zrangeOpts, error) {
+	opts := zrangeOpts{count: -1}
+	for i := 0; i < len(args); i++ {
+		switch name := strings.ToLower(args[i].String()); {
+		case name == "withscores":
+			opts.withScores = true
+		case name == "byscore" && full:
+			opts.byScore = true
+		case name == "rev" && full:
+			opts.rev = true
+		case name == "limit" && i+2 < len(args):
+			offset, err := parseInt(args[i+1].String())
+			if err != nil {
+				return opts, err
+			}
+			count, err := parseInt(args[i+2].String())
+			if err != nil {
+				return opts, err
+			}
+			opts.limit, opts.offset, opts.count = true, offset, count
+			i += 2
+		default:
+			return opts, errSyntax
+		}
+	}
+	if opts.limit && !opts.byScore && full {
+		return opts, errLimit
+	}
+
+	return opts, nil
+}
+
+// parseScore parses a score, the infinities are allowed.
+// Return errNotFloat if the string is not a float or is NaN.
+func parseScore(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if (err != nil && !math.IsInf(f, 0)) || math.IsNaN(f) {
+		return 0, errNotFloat
+	}
+
+	return f, nil
+}
+
+// parseBound parses a bound of a range of scores, a bound prefixed with ( is exclusive.
+// Return errNotFloatRange if the bound is not a float.
+func parseBound(s string) (zbound, error) {
+	var b zbound
+	if strings.HasPrefix(s, "(") {
+		b.exclusive = true
+		s = s[1:]
+	}
+
+	score, err := parseScore(s)
+	if err != nil {
+		return zbound{}, errNotFloatRange
+	}
+	b.score = score
+
+	return b, nil
+}
+
+// formatScore formats a score like redis does, the infinities are formatted as inf and -inf.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
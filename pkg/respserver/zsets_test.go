@@ -0,0 +1,192 @@
+package respserver
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSortedSetCommands(t *testing.T) {
+	r, conn := newTestServer(t)
+
+	cases := []struct {
+		args []interface{}
+		want string
+	}{
+		{[]interface{}{"zadd", "board", "10", "a", "20", "b", "20", "c", "5", "d"}, ":4\r\n"},
+		{[]interface{}{"zadd", "board", "15", "d", "1", "e"}, ":1\r\n"},
+		{[]interface{}{"zadd", "board", "ch", "16", "d", "1", "e"}, ":1\r\n"},
+		{[]interface{}{"zadd", "board", "nx", "0", "a", "2", "f"}, ":1\r\n"},
+		{[]interface{}{"zadd", "board", "xx", "0", "g"}, ":0\r\n"},
+		{[]interface{}{"zadd", "board", "gt", "ch", "5", "a", "30", "b"}, ":1\r\n"},
+		{[]interface{}{"zadd", "board", "incr", "2", "f"}, "$1\r\n4\r\n"},
+		{[]interface{}{"zadd", "board", "nx", "incr", "2", "f"}, "$-1\r\n"},
+		{[]interface{}{"zadd", "board", "nx", "xx", "1", "a"}, "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{[]interface{}{"zadd", "board", "gt", "lt", "1", "a"}, "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n"},
+		{[]interface{}{"zadd", "board", "incr", "1", "a", "2", "b"}, "-ERR INCR option supports a single increment-element pair\r\n"},
+		{[]interface{}{"zadd", "board", "1", "a", "2"}, "-ERR syntax error\r\n"},
+		{[]interface{}{"zadd", "board", "one", "a"}, "-ERR value is not a valid float\r\n"},
+		{[]interface{}{"zadd", "board", "nan", "a"}, "-ERR value is not a valid float\r\n"},
+		{[]interface{}{"zcard", "board"}, ":6\r\n"},
+		{[]interface{}{"zcard", "missing"}, ":0\r\n"},
+		{[]interface{}{"zscore", "board", "d"}, "$2\r\n16\r\n"},
+		{[]interface{}{"zscore", "board", "missing"}, "$-1\r\n"},
+		{[]interface{}{"zincrby", "board", "0.5", "e"}, "$3\r\n1.5\r\n"},
+		{[]interface{}{"zincrby", "board", "7", "new"}, "$1\r\n7\r\n"},
+
+		// e=1.5 f=4 new=7 a=10 d=16 c=20 b=30
+		{[]interface{}{"zrange", "board", "0", "-1"},
+			"*7\r\n$1\r\ne\r\n$1\r\nf\r\n$3\r\nnew\r\n$1\r\na\r\n$1\r\nd\r\n$1\r\nc\r\n$1\r\nb\r\n"},
+		{[]interface{}{"zrange", "board", "-2", "100", "withscores"}, "*4\r\n$1\r\nc\r\n$2\r\n20\r\n$1\r\nb\r\n$2\r\n30\r\n"},
+		{[]interface{}{"zrange", "board", "0", "1", "rev"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]interface{}{"zrange", "board", "5", "2"}, "*0\r\n"},
+		{[]interface{}{"zrange", "board", "(4", "16", "byscore"}, "*3\r\n$3\r\nnew\r\n$1\r\na\r\n$1\r\nd\r\n"},
+		{[]interface{}{"zrange", "board", "+inf", "10", "byscore", "rev", "limit", "1", "2"}, "*2\r\n$1\r\nc\r\n$1\r\nd\r\n"},
+		{[]interface{}{"zrange", "board", "0", "1", "limit", "0", "1"},
+			"-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n"},
+		{[]interface{}{"zrange", "missing", "0", "-1"}, "*0\r\n"},
+		{[]interface{}{"zrangebyscore", "board", "-inf", "(10", "withscores"},
+			"*6\r\n$1\r\ne\r\n$3\r\n1.5\r\n$1\r\nf\r\n$1\r\n4\r\n$3\r\nnew\r\n$1\r\n7\r\n"},
+		{[]interface{}{"zrangebyscore", "board", "10", "+inf", "limit", "1", "-1"}, "*3\r\n$1\r\nd\r\n$1\r\nc\r\n$1\r\nb\r\n"},
+		{[]interface{}{"zrangebyscore", "board", "(20", "20"}, "*0\r\n"},
+		{[]interface{}{"zrangebyscore", "board", "low", "20"}, "-ERR min or max is not a float\r\n"},
+		{[]interface{}{"zrank", "board", "e"}, ":0\r\n"},
+		{[]interface{}{"zrank", "board", "d", "withscore"}, "*2\r\n:4\r\n$2\r\n16\r\n"},
+		{[]interface{}{"zrank", "board", "missing"}, "$-1\r\n"},
+		{[]interface{}{"zrank", "board", "missing", "withscore"}, "*-1\r\n"},
+
+		{[]interface{}{"zadd", "inf", "-inf", "low", "+inf", "high"}, ":2\r\n"},
+		{[]interface{}{"zrange", "inf", "0", "-1", "withscores"}, "*4\r\n$3\r\nlow\r\n$4\r\n-inf\r\n$4\r\nhigh\r\n$3\r\ninf\r\n"},
+		{[]interface{}{"zincrby", "inf", "-inf", "high"}, "-ERR resulting score is not a number (NaN)\r\n"},
+		{[]interface{}{"type", "inf"}, "+zset\r\n"},
+		{[]interface{}{"sadd", "inf", "x"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]interface{}{"zrem", "inf", "low", "high", "missing"}, ":2\r\n"},
+		{[]interface{}{"exists", "inf"}, ":0\r\n"},
+		{[]interface{}{"set", "board", "value"}, "+OK\r\n"},
+		{[]interface{}{"zcard", "board"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, do(t, conn, c.args...), c.want)
+	}
+
+	// the members of the overwritten and the emptied sorted sets are removed.
+	for _, key := range r.bitcask.ListKeys() {
+		if _, _, ok := parseElemKey(scorePrefix, key); ok {
+			t.Errorf("unexpected score record %q", key)
+		}
+	}
+	r.index.mu.Lock()
+	defer r.index.mu.Unlock()
+	if len(r.index.sorted) != 0 {
+		t.Errorf("got %d sorted sets in the index, want 0", len(r.index.sorted))
+	}
+}
+
+func TestSortedSetPersistence(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New(dir, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := newTestClient(t, r)
+
+	do(t, conn, "zadd", "board", "3", "a", "1", "b", "2", "c", "4", "d")
+	do(t, conn, "zincrby", "board", "10", "b")
+	do(t, conn, "zrem", "board", "d")
+	err = r.bitcask.Merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	// the score index is rebuilt from the score records.
+	r, err = New(dir, ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	conn = newTestClient(t, r)
+
+	assertReply(t, do(t, conn, "zrange", "board", "0", "-1", "withscores"),
+		"*6\r\n$1\r\nc\r\n$1\r\n2\r\n$1\r\na\r\n$1\r\n3\r\n$1\r\nb\r\n$2\r\n11\r\n")
+	assertReply(t, do(t, conn, "zrank", "board", "b"), ":2\r\n")
+}
+
+func TestSortedSetTransactions(t *testing.T) {
+	r, conn := newTestServer(t)
+	other := newTestClient(t, r)
+
+	do(t, conn, "zadd", "board", "1", "a", "2", "b")
+	assertReply(t, do(t, conn, "watch", "board"), "+OK\r\n")
+	assertReply(t, do(t, other, "zincrby", "board", "5", "a"), "$1\r\n6\r\n")
+	assertReply(t, do(t, conn, "multi"), "+OK\r\n")
+	assertReply(t, do(t, conn, "zadd", "board", "3", "c"), "+QUEUED\r\n")
+	assertReply(t, do(t, conn, "exec"), "*-1\r\n")
+
+	assertReply(t, do(t, conn, "multi"), "+OK\r\n")
+	assertReply(t, do(t, conn, "zadd", "board", "3", "c"), "+QUEUED\r\n")
+	assertReply(t, do(t, conn, "zrem", "board", "b"), "+QUEUED\r\n")
+	assertReply(t, do(t, conn, "zrange", "board", "0", "-1"), "+QUEUED\r\n")
+	assertReply(t, do(t, other, "zrange", "board", "0", "-1"), "*2\r\n$1\r\nb\r\n$1\r\na\r\n")
+	assertReply(t, do(t, conn, "exec"), "*3\r\n:1\r\n:1\r\n*2\r\n$1\r\nc\r\n$1\r\na\r\n")
+	assertReply(t, do(t, other, "zrange", "board", "0", "-1"), "*2\r\n$1\r\nc\r\n$1\r\na\r\n")
+}
+
+func TestZSet(t *testing.T) {
+	z := newZSet()
+	scores := make(map[string]float64)
+	for i := 0; i < 2000; i++ {
+		member := fmt.Sprintf("m%d", rand.Intn(500))
+		if rand.Intn(4) == 0 {
+			z.remove(member)
+			delete(scores, member)
+			continue
+		}
+		score := float64(rand.Intn(100))
+		z.add(member, score)
+		scores[member] = score
+	}
+
+	want := make([]zmember, 0, len(scores))
+	for member, score := range scores {
+		want = append(want, zmember{member, score})
+	}
+	sort.Slice(want, func(i, j int) bool {
+		return want[i].score < want[j].score || (want[i].score == want[j].score && want[i].member < want[j].member)
+	})
+
+	if z.len() != len(want) {
+		t.Fatalf("got %d members, want %d", z.len(), len(want))
+	}
+	if got := z.byRank(0, len(want)-1, false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, m := range want {
+		rank, ok := z.rank(m.member)
+		if !ok || rank != i {
+			t.Fatalf("got rank %d of %q, want %d", rank, m.member, i)
+		}
+		if got := z.byRank(len(want)-1-i, len(want)-1-i, true); len(got) != 1 || got[0] != m {
+			t.Fatalf("got %v at reverse rank %d, want %v", got, len(want)-1-i, m)
+		}
+	}
+
+	min, max := zbound{score: 20, exclusive: true}, zbound{score: 60}
+	inRange := make([]zmember, 0)
+	for _, m := range want {
+		if m.score > 20 && m.score <= 60 {
+			inRange = append(inRange, m)
+		}
+	}
+	if got := z.byScore(min, max, false, 0, -1); !reflect.DeepEqual(got, inRange) {
+		t.Fatalf("got %v, want %v", got, inRange)
+	}
+	if got := z.byScore(min, max, true, 1, 2); !reflect.DeepEqual(got, []zmember{inRange[len(inRange)-2], inRange[len(inRange)-3]}) {
+		t.Fatalf("got %v in reverse", got)
+	}
+	if got := z.clone().byRank(0, len(want)-1, false); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got clone %v, want %v", got, want)
+	}
+}
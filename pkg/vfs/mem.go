@@ -0,0 +1,538 @@
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type (
+	// Mem represents an in-memory filesystem.
+	// Mem keeps track of the data flushed by File.Sync and the directory entries flushed by SyncDir,
+	// so Crash can produce the filesystem as it would be found after a power loss.
+	// Directories are assumed to be durable once created.
+	Mem struct {
+		mu      sync.Mutex
+		nodes   map[string]*memNode
+		durable map[string]map[string]*memNode
+		locks   map[string]*memLock
+	}
+
+	// memNode represents a file or a directory stored in memory.
+	memNode struct {
+		dir     bool
+		perm    fs.FileMode
+		modTime time.Time
+		data    []byte
+		synced  []byte
+	}
+
+	// memFile represents an open file of the in-memory filesystem.
+	memFile struct {
+		mem    *Mem
+		node   *memNode
+		name   string
+		flag   int
+		offset int64
+		closed bool
+	}
+
+	// memInfo implements fs.FileInfo for in-memory nodes.
+	memInfo struct {
+		name    string
+		size    int64
+		dir     bool
+		perm    fs.FileMode
+		modTime time.Time
+	}
+
+	// memLock represents the lock state of an in-memory file.
+	memLock struct {
+		readers   int
+		exclusive bool
+	}
+
+	// memLocker releases a lock acquired on an in-memory file.
+	memLocker struct {
+		mem       *Mem
+		name      string
+		exclusive bool
+		released  bool
+	}
+)
+
+// NewMem creates a new empty in-memory filesystem.
+func NewMem() *Mem {
+	return &Mem{
+		nodes:   make(map[string]*memNode),
+		durable: make(map[string]map[string]*memNode),
+		locks:   make(map[string]*memLock),
+	}
+}
+
+// OpenFile implements FS.OpenFile.
+func (m *Mem) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := path.Clean(name)
+	n, err := m.lookup(p)
+	if err != nil && flag&os.O_CREATE == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case n == nil:
+		parent, err := m.lookup(path.Dir(p))
+		if err != nil || !parent.dir {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+		}
+		n = &memNode{perm: perm, modTime: time.Now()}
+		m.nodes[p] = n
+		parent.modTime = n.modTime
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EEXIST}
+	case n.dir && writable:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+
+	if flag&os.O_TRUNC != 0 && writable {
+		n.data = nil
+		n.modTime = time.Now()
+	}
+
+	return &memFile{mem: m, node: n, name: name, flag: flag}, nil
+}
+
+// ReadFile implements FS.ReadFile.
+func (m *Mem) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, err := m.lookup(path.Clean(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if n.dir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+
+	return append([]byte{}, n.data...), nil
+}
+
+// ReadDir implements FS.ReadDir.
+func (m *Mem) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := path.Clean(name)
+	n, err := m.lookup(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !n.dir {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	res := make([]fs.DirEntry, 0)
+	for childPath, child := range m.children(p) {
+		res = append(res, fs.FileInfoToDirEntry(child.info(path.Base(childPath))))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name() < res[j].Name()
+	})
+
+	return res, nil
+}
+
+// Stat implements FS.Stat.
+func (m *Mem) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := path.Clean(name)
+	n, err := m.lookup(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return n.info(path.Base(p)), nil
+}
+
+// MkdirAll implements FS.MkdirAll.
+func (m *Mem) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	missing := make([]string, 0)
+	for p := path.Clean(name); !isRoot(p); p = path.Dir(p) {
+		if n, ok := m.nodes[p]; ok {
+			if !n.dir {
+				return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+			}
+			break
+		}
+		missing = append(missing, p)
+	}
+
+	now := time.Now()
+	for i := len(missing) - 1; i >= 0; i-- {
+		p := missing[i]
+		m.nodes[p] = &memNode{dir: true, perm: perm | fs.ModeDir, modTime: now}
+		m.touch(path.Dir(p))
+	}
+
+	return nil
+}
+
+// Remove implements FS.Remove.
+func (m *Mem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := path.Clean(name)
+	n, err := m.lookup(p)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if n.dir && len(m.children(p)) != 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+
+	delete(m.nodes, p)
+	m.touch(path.Dir(p))
+
+	return nil
+}
+
+// Rename implements FS.Rename.
+func (m *Mem) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldPath, newPath := path.Clean(oldName), path.Clean(newName)
+	n, err := m.lookup(oldPath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	parent, err := m.lookup(path.Dir(newPath))
+	if err != nil || !parent.dir {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: syscall.ENOENT}
+	}
+
+	// the children are collected first, so the nodes map is not modified while ranging over it.
+	children := make([]string, 0)
+	if n.dir {
+		for p := range m.nodes {
+			if strings.HasPrefix(p, oldPath+"/") {
+				children = append(children, p)
+			}
+		}
+	}
+
+	delete(m.nodes, oldPath)
+	m.nodes[newPath] = n
+	for _, p := range children {
+		child := m.nodes[p]
+		delete(m.nodes, p)
+		m.nodes[newPath+strings.TrimPrefix(p, oldPath)] = child
+	}
+	m.touch(path.Dir(oldPath))
+	m.touch(path.Dir(newPath))
+
+	return nil
+}
+
+// Link implements Linker.Link, both names refer to the same node afterwards.
+func (m *Mem) Link(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldPath, newPath := path.Clean(oldName), path.Clean(newName)
+	n, err := m.lookup(oldPath)
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldName, New: newName, Err: err}
+	}
+	if n.dir {
+		return &os.LinkError{Op: "link", Old: oldName, New: newName, Err: syscall.EPERM}
+	}
+	if _, ok := m.nodes[newPath]; ok {
+		return &os.LinkError{Op: "link", Old: oldName, New: newName, Err: syscall.EEXIST}
+	}
+	parent, err := m.lookup(path.Dir(newPath))
+	if err != nil || !parent.dir {
+		return &os.LinkError{Op: "link", Old: oldName, New: newName, Err: syscall.ENOENT}
+	}
+
+	m.nodes[newPath] = n
+	m.touch(path.Dir(newPath))
+
+	return nil
+}
+
+// SyncDir implements FS.SyncDir by recording the current entries of the directory as durable.
+func (m *Mem) SyncDir(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := path.Clean(name)
+	n, err := m.lookup(p)
+	if err != nil {
+		return &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !n.dir {
+		return &fs.PathError{Op: "sync", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	m.durable[p] = make(map[string]*memNode)
+	for childPath, child := range m.children(p) {
+		m.durable[p][childPath] = child
+	}
+
+	return nil
+}
+
+// TryLock implements FS.TryLock, locks are held per Mem object.
+func (m *Mem) TryLock(name string, exclusive bool) (Locker, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := path.Clean(name)
+	lck, ok := m.locks[p]
+	if !ok {
+		lck = &memLock{}
+		m.locks[p] = lck
+	}
+
+	locker := &memLocker{mem: m, name: p, exclusive: exclusive}
+	if lck.exclusive || (exclusive && lck.readers != 0) {
+		locker.released = true
+		return locker, false, nil
+	}
+
+	if exclusive {
+		lck.exclusive = true
+	} else {
+		lck.readers++
+	}
+
+	return locker, true, nil
+}
+
+// Crash returns a new in-memory filesystem containing only the data
+// that would survive a power loss: the files listed in the last SyncDir of their directory
+// with the content they had on their last Sync.
+func (m *Mem) Crash() *Mem {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := NewMem()
+	for p, n := range m.nodes {
+		if n.dir {
+			res.nodes[p] = &memNode{dir: true, perm: n.perm, modTime: n.modTime}
+		}
+	}
+
+	for dir, entries := range m.durable {
+		if _, err := res.lookup(dir); err != nil {
+			continue
+		}
+		for p, n := range entries {
+			if n.dir {
+				continue
+			}
+			res.nodes[p] = &memNode{
+				perm:    n.perm,
+				modTime: n.modTime,
+				data:    append([]byte{}, n.synced...),
+				synced:  append([]byte{}, n.synced...),
+			}
+		}
+		res.durable[dir] = res.children(dir)
+	}
+
+	return res
+}
+
+// lookup finds the node of the given clean path.
+func (m *Mem) lookup(p string) (*memNode, error) {
+	if isRoot(p) {
+		return &memNode{dir: true, perm: fs.ModeDir | 0777}, nil
+	}
+
+	n, ok := m.nodes[p]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	return n, nil
+}
+
+// children returns the nodes inside the given directory by their paths.
+func (m *Mem) children(dir string) map[string]*memNode {
+	res := make(map[string]*memNode)
+	for p, n := range m.nodes {
+		if path.Dir(p) == dir && !isRoot(p) {
+			res[p] = n
+		}
+	}
+
+	return res
+}
+
+// touch updates the modification time of the given directory.
+func (m *Mem) touch(dir string) {
+	if n, ok := m.nodes[dir]; ok {
+		n.modTime = time.Now()
+	}
+}
+
+// isRoot reports whether the given clean path is a root directory.
+func isRoot(p string) bool {
+	return p == "." || p == "/"
+}
+
+// info returns the file info describing the node with the given name.
+func (n *memNode) info(name string) fs.FileInfo {
+	return &memInfo{
+		name:    name,
+		size:    int64(len(n.data)),
+		dir:     n.dir,
+		perm:    n.perm,
+		modTime: n.modTime,
+	}
+}
+
+// Read implements io.Reader.
+func (f *memFile) Read(b []byte) (int, error) {
+	n, err := f.ReadAt(b, f.offset)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.node.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(b, f.node.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Write implements io.Writer.
+func (f *memFile) Write(b []byte) (int, error) {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	end := f.offset + int64(len(b))
+	if end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], b)
+	f.offset = end
+	f.node.modTime = time.Now()
+
+	if f.flag&os.O_SYNC != 0 {
+		f.node.synced = append([]byte{}, f.node.data...)
+	}
+
+	return len(b), nil
+}
+
+// Close implements io.Closer.
+func (f *memFile) Close() error {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+
+	return nil
+}
+
+// Name implements File.Name.
+func (f *memFile) Name() string {
+	return f.name
+}
+
+// Sync implements File.Sync by recording the current content of the file as durable.
+func (f *memFile) Sync() error {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.node.synced = append([]byte{}, f.node.data...)
+
+	return nil
+}
+
+// Stat implements File.Stat.
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	return f.node.info(path.Base(f.name)), nil
+}
+
+// Unlock implements Locker.Unlock.
+func (l *memLocker) Unlock() error {
+	l.mem.mu.Lock()
+	defer l.mem.mu.Unlock()
+
+	if l.released {
+		return nil
+	}
+	l.released = true
+
+	lck := l.mem.locks[l.name]
+	if l.exclusive {
+		lck.exclusive = false
+	} else {
+		lck.readers--
+	}
+
+	return nil
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() fs.FileMode  { return i.perm }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.dir }
+func (i *memInfo) Sys() any           { return nil }
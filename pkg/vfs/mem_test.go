@@ -0,0 +1,264 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestMemFiles(t *testing.T) {
+	m := NewMem()
+	mkdirAll(t, m, "dir/sub")
+
+	writeFile(t, m, "dir/file", os.O_CREATE|os.O_WRONLY, "hello")
+	writeFile(t, m, "dir/file", os.O_WRONLY|os.O_APPEND, " world")
+	assertContent(t, m, "dir/file", "hello world")
+
+	writeFile(t, m, "dir/file", os.O_WRONLY|os.O_TRUNC, "bye")
+	assertContent(t, m, "dir/file", "bye")
+
+	f, err := m.OpenFile("dir/file", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2)
+	n, err := f.ReadAt(buf, 1)
+	if n != 2 || err != nil || string(buf) != "ye" {
+		t.Errorf("got ReadAt %q, %v, want %q", buf[:n], err, "ye")
+	}
+	if _, err := f.ReadAt(buf, 3); err != io.EOF {
+		t.Errorf("got ReadAt error %v at the end of the file, want io.EOF", err)
+	}
+	if _, err := f.Write([]byte("x")); !errors.Is(err, syscall.EBADF) {
+		t.Errorf("got Write error %v on a read only file, want EBADF", err)
+	}
+	f.Close()
+	if _, err := f.Read(buf); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("got Read error %v on a closed file, want ErrClosed", err)
+	}
+
+	cases := []struct {
+		name string
+		flag int
+		want error
+	}{
+		{"dir/missing", os.O_RDONLY, syscall.ENOENT},
+		{"missing/file", os.O_CREATE | os.O_WRONLY, syscall.ENOENT},
+		{"dir/file", os.O_CREATE | os.O_EXCL | os.O_WRONLY, syscall.EEXIST},
+		{"dir/sub", os.O_WRONLY, syscall.EISDIR},
+	}
+	for _, c := range cases {
+		if _, err := m.OpenFile(c.name, c.flag, 0666); !errors.Is(err, c.want) {
+			t.Errorf("got OpenFile(%q) error %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	info, err := m.Stat("dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "file" || info.Size() != 3 || info.IsDir() {
+		t.Errorf("got Stat name %q, size %d, dir %v", info.Name(), info.Size(), info.IsDir())
+	}
+	if err := m.MkdirAll("dir/file/sub", 0777); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("got MkdirAll error %v under a file, want ENOTDIR", err)
+	}
+}
+
+func TestMemDirs(t *testing.T) {
+	m := NewMem()
+	mkdirAll(t, m, "dir/sub")
+	writeFile(t, m, "dir/b", os.O_CREATE|os.O_WRONLY, "b")
+	writeFile(t, m, "dir/a", os.O_CREATE|os.O_WRONLY, "a")
+	writeFile(t, m, "dir/sub/c", os.O_CREATE|os.O_WRONLY, "c")
+
+	assertEntries(t, m, "dir", []string{"a", "b", "sub"})
+
+	if err := m.Remove("dir/sub"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("got Remove error %v on a non empty directory, want ENOTEMPTY", err)
+	}
+	if err := m.Remove("dir/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("dir/b"); !os.IsNotExist(err) {
+		t.Errorf("got Stat error %v on a removed file, want not exist", err)
+	}
+	assertEntries(t, m, "dir", []string{"a", "sub"})
+}
+
+func TestMemRename(t *testing.T) {
+	m := NewMem()
+	mkdirAll(t, m, "src/sub")
+	mkdirAll(t, m, "other")
+	for _, name := range []string{"src/a", "src/b", "src/sub/c", "src/sub/d"} {
+		writeFile(t, m, name, os.O_CREATE|os.O_WRONLY, name)
+	}
+	writeFile(t, m, "other/e", os.O_CREATE|os.O_WRONLY, "other/e")
+
+	// a directory is renamed along with all of its children.
+	if err := m.Rename("src", "other/dst"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("src"); !os.IsNotExist(err) {
+		t.Errorf("got Stat error %v on the renamed directory, want not exist", err)
+	}
+	assertEntries(t, m, "other", []string{"dst", "e"})
+	assertEntries(t, m, "other/dst", []string{"a", "b", "sub"})
+	assertEntries(t, m, "other/dst/sub", []string{"c", "d"})
+	assertContent(t, m, "other/dst/sub/d", "src/sub/d")
+
+	// a file replaces the file it is renamed to.
+	if err := m.Rename("other/e", "other/dst/a"); err != nil {
+		t.Fatal(err)
+	}
+	assertContent(t, m, "other/dst/a", "other/e")
+
+	if err := m.Rename("missing", "other/f"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("got Rename error %v on a missing file, want ENOENT", err)
+	}
+	if err := m.Rename("other/dst/b", "missing/b"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("got Rename error %v into a missing directory, want ENOENT", err)
+	}
+}
+
+func TestMemLink(t *testing.T) {
+	m := NewMem()
+	mkdirAll(t, m, "dir")
+	writeFile(t, m, "dir/file", os.O_CREATE|os.O_WRONLY, "data")
+
+	if err := m.Link("dir/file", "dir/link"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, m, "dir/file", os.O_WRONLY|os.O_APPEND, "+")
+	assertContent(t, m, "dir/link", "data+")
+
+	if err := m.Link("dir/file", "dir/link"); !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("got Link error %v to an existing file, want EEXIST", err)
+	}
+	if err := m.Link("dir", "dir2"); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("got Link error %v on a directory, want EPERM", err)
+	}
+}
+
+func TestMemCrash(t *testing.T) {
+	m := NewMem()
+	mkdirAll(t, m, "dir")
+
+	f, err := m.OpenFile("dir/synced", os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("durable"))
+	f.Sync()
+	f.Write([]byte(" lost"))
+	writeFile(t, m, "dir/unsynced", os.O_CREATE|os.O_WRONLY, "data")
+	if err := m.SyncDir("dir"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, m, "dir/unlisted", os.O_CREATE|os.O_WRONLY|os.O_SYNC, "data")
+
+	// only the files listed by the last SyncDir survive with the content of their last Sync.
+	crashed := m.Crash()
+	assertEntries(t, crashed, "dir", []string{"synced", "unsynced"})
+	assertContent(t, crashed, "dir/synced", "durable")
+	assertContent(t, crashed, "dir/unsynced", "")
+
+	if err := m.SyncDir("dir/synced"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("got SyncDir error %v on a file, want ENOTDIR", err)
+	}
+}
+
+func TestMemTryLock(t *testing.T) {
+	m := NewMem()
+
+	r1, ok, _ := m.TryLock("lck", false)
+	if !ok {
+		t.Fatal("could not acquire the first shared lock")
+	}
+	r2, ok, _ := m.TryLock("lck", false)
+	if !ok {
+		t.Fatal("could not acquire the second shared lock")
+	}
+	if _, ok, _ := m.TryLock("lck", true); ok {
+		t.Fatal("acquired an exclusive lock while shared locks are held")
+	}
+
+	r1.Unlock()
+	r1.Unlock()
+	if _, ok, _ := m.TryLock("lck", true); ok {
+		t.Fatal("acquired an exclusive lock while a shared lock is held")
+	}
+	r2.Unlock()
+
+	w, ok, _ := m.TryLock("lck", true)
+	if !ok {
+		t.Fatal("could not acquire the exclusive lock after the shared locks were released")
+	}
+	if _, ok, _ := m.TryLock("lck", false); ok {
+		t.Fatal("acquired a shared lock while an exclusive lock is held")
+	}
+	w.Unlock()
+	if _, ok, _ := m.TryLock("lck", false); !ok {
+		t.Fatal("could not acquire a shared lock after the exclusive lock was released")
+	}
+}
+
+// mkdirAll creates the named directory along with its parents in the given filesystem.
+func mkdirAll(t *testing.T, m *Mem, name string) {
+	t.Helper()
+
+	err := m.MkdirAll(name, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeFile opens the named file with the given flags and writes the given data to it.
+func writeFile(t *testing.T, m *Mem, name string, flag int, data string) {
+	t.Helper()
+
+	f, err := m.OpenFile(name, flag, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// assertContent asserts that the named file has the wanted content.
+func assertContent(t *testing.T, m *Mem, name, want string) {
+	t.Helper()
+
+	got, err := m.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q in %s, want %q", got, name, want)
+	}
+}
+
+// assertEntries asserts that the named directory has the wanted entries.
+func assertEntries(t *testing.T, m *Mem, name string, want []string) {
+	t.Helper()
+
+	entries, err := m.ReadDir(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v in %s, want %v", got, name, want)
+	}
+}
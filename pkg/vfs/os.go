@@ -0,0 +1,84 @@
+package vfs
+
+import (
+	"io/fs"
+	"os"
+
+	"github.com/gofrs/flock"
+)
+
+// OS is the filesystem implemented by the operating system.
+var OS FS = osFS{}
+
+// osFS implements FS using the os package.
+type osFS struct{}
+
+// OpenFile implements FS.OpenFile using os.OpenFile.
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+// ReadFile implements FS.ReadFile using os.ReadFile.
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// ReadDir implements FS.ReadDir using os.ReadDir.
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// Stat implements FS.Stat using os.Stat.
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// MkdirAll implements FS.MkdirAll using os.MkdirAll.
+func (osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// Remove implements FS.Remove using os.Remove.
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Rename implements FS.Rename using os.Rename.
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+// Link implements Linker.Link using os.Link.
+func (osFS) Link(oldName, newName string) error {
+	return os.Link(oldName, newName)
+}
+
+// SyncDir implements FS.SyncDir by syncing the opened directory.
+func (osFS) SyncDir(name string) error {
+	dir, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// TryLock implements FS.TryLock using flock(2).
+func (osFS) TryLock(name string, exclusive bool) (Locker, bool, error) {
+	var ok bool
+	var err error
+
+	flck := flock.New(name)
+	if exclusive {
+		ok, err = flck.TryLock()
+	} else {
+		ok, err = flck.TryRLock()
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return flck, ok, nil
+}
@@ -0,0 +1,61 @@
+// Package vfs provides the filesystem abstraction used by the bitcask datastore.
+// Every file operation done by the datastore goes through the FS interface,
+// so the datastore can run on the OS filesystem, in memory or on any user provided filesystem.
+package vfs
+
+import (
+	"io"
+	"io/fs"
+)
+
+type (
+	// File represents an open file of a filesystem.
+	File interface {
+		io.Reader
+		io.ReaderAt
+		io.Writer
+		io.Closer
+		// Name returns the name of the file as passed to OpenFile.
+		Name() string
+		// Sync flushes the content of the file to the stable storage.
+		Sync() error
+		// Stat returns the file info describing the file.
+		Stat() (fs.FileInfo, error)
+	}
+
+	// Locker represents an acquired lock on a file.
+	Locker interface {
+		// Unlock releases the lock.
+		Unlock() error
+	}
+
+	// FS represents the filesystem operations performed by the bitcask datastore.
+	FS interface {
+		// OpenFile opens the named file with the given flags and permissions, flags are the os.O_* flags.
+		OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+		// ReadFile reads the whole content of the named file.
+		ReadFile(name string) ([]byte, error)
+		// ReadDir reads the named directory and returns its entries sorted by file name.
+		ReadDir(name string) ([]fs.DirEntry, error)
+		// Stat returns the file info describing the named file.
+		Stat(name string) (fs.FileInfo, error)
+		// MkdirAll creates the named directory along with any necessary parents.
+		MkdirAll(name string, perm fs.FileMode) error
+		// Remove removes the named file.
+		Remove(name string) error
+		// Rename renames oldName to newName replacing newName if it exists.
+		Rename(oldName, newName string) error
+		// SyncDir flushes the entries of the named directory to the stable storage,
+		// so that files created, removed or renamed in it survive a system crash.
+		SyncDir(name string) error
+		// TryLock tries to acquire an exclusive or a shared lock on the named file without blocking.
+		// Return false if the lock is held by someone else.
+		TryLock(name string, exclusive bool) (Locker, bool, error)
+	}
+
+	// Linker is implemented by the filesystems supporting hard links.
+	Linker interface {
+		// Link creates newName as a hard link to the oldName file.
+		Link(oldName, newName string) error
+	}
+)
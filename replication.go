@@ -0,0 +1,734 @@
+package bitcask
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IslamWalid/bitcask/internal/datastore"
+	"github.com/IslamWalid/bitcask/internal/keydir"
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+)
+
+const (
+	// msgHello is sent by a follower with the sequence number of its last applied change.
+	msgHello msgKind = 0
+	// msgSnapshot starts a snapshot consistent with the sequence number of its event.
+	msgSnapshot msgKind = 1
+	// msgSnapshotRec carries a key/value pair of a snapshot.
+	msgSnapshotRec msgKind = 2
+	// msgSnapshotEnd ends a snapshot.
+	msgSnapshotEnd msgKind = 3
+	// msgChange carries a change written after the follower position.
+	msgChange msgKind = 4
+	// msgDenied is sent to a follower whose hello does not carry the leader token.
+	msgDenied msgKind = 5
+
+	// followRetryDelay is the time a follower waits before reconnecting to its leader.
+	followRetryDelay = 100 * time.Millisecond
+	// followDialTimeout is the time a follower waits for the connection to its leader.
+	followDialTimeout = 5 * time.Second
+	// snapshotAttempts is the number of snapshots sent to a follower before giving up
+	// if every snapshot is compacted by a merge before the changes after it are streamed.
+	snapshotAttempts = 3
+	// snapshotChunk is the number of snapshot values read from the data files at once.
+	snapshotChunk = 256
+)
+
+var (
+	// errFollower happens whenever a replication follower is written by its user.
+	errFollower = errors.New("datastore is a replication follower")
+
+	// errUnexpectedMsg happens whenever a replication peer sends a message out of the protocol order.
+	errUnexpectedMsg = errors.New("unexpected replication message")
+
+	// errReplicationDenied happens whenever a leader rejects the token of a follower.
+	errReplicationDenied = errors.New("replication denied: invalid token")
+)
+
+type (
+	// msgKind represents the kinds of the replication messages.
+	msgKind int
+
+	// replMsg represents a replication message.
+	// Token is only set in the hello of a follower.
+	replMsg struct {
+		Kind  msgKind
+		Event Event
+		Token string
+	}
+
+	// ReplicationOpt represents the options the user can pass to Lead and Follow.
+	ReplicationOpt interface {
+		applyReplication(*replOptions)
+	}
+
+	// tlsOpt is the replication option that sets the TLS config of the replication connections.
+	tlsOpt struct {
+		config *tls.Config
+	}
+
+	// tokenOpt is the replication option that sets the token shared by a leader and its followers.
+	tokenOpt string
+
+	// replOptions groups the options passed to Lead and Follow.
+	replOptions struct {
+		tls   *tls.Config
+		token string
+	}
+
+	// snapshotKey represents a key of a snapshot and the sequence number of its value.
+	snapshotKey struct {
+		key    string
+		tstamp int64
+	}
+
+	// snapshotWriter writes the records of a snapshot streamed by a leader to merge files,
+	// the snapshot replaces the data of the datastore once it is committed.
+	// The compaction lock is held until the snapshot is committed or aborted.
+	snapshotWriter struct {
+		b         *Bitcask
+		oldFiles  []string
+		mergeFile *datastore.AppendFile
+		keyDir    keydir.KeyDir
+	}
+
+	// Leader streams the changes of a ReadWrite bitcask to its followers over TCP.
+	Leader struct {
+		b        *Bitcask
+		listener net.Listener
+		token    string
+
+		mu        sync.Mutex
+		closed    bool
+		followers map[net.Conn]*Iterator
+		wg        sync.WaitGroup
+	}
+
+	// Follower applies the changes streamed by a leader to its bitcask.
+	// The bitcask serves reads while following and rejects Put and Delete until the follower is promoted.
+	Follower struct {
+		b    *Bitcask
+		addr string
+		opts replOptions
+		seq  atomic.Int64
+		stop chan struct{}
+		done chan struct{}
+		once sync.Once
+
+		mu      sync.Mutex
+		conn    net.Conn
+		lastErr error
+	}
+)
+
+// WithTLS makes Lead and Follow encrypt and authenticate the replication connections with the given TLS config.
+// The config of a leader must hold its certificate, and the config of a follower must trust it,
+// a leader config requiring client certificates also authenticates the followers.
+func WithTLS(config *tls.Config) ReplicationOpt {
+	return tlsOpt{config: config}
+}
+
+// WithToken makes a leader reject the followers that do not send the given token, and a follower send it.
+// The token is sent in clear text unless WithTLS is used too.
+func WithToken(token string) ReplicationOpt {
+	return tokenOpt(token)
+}
+
+// Lead starts streaming the changes of the bitcask to the followers that connect to the given TCP address.
+// A follower first receives the changes after its last applied change, or a full snapshot of the datastore
+// if these changes were removed by a merge, then the live writes.
+// The connections are neither encrypted nor authenticated by default, so anyone reaching the address can read
+// all the data, WithTLS and WithToken secure them on untrusted networks.
+// Return an error if ReadWrite permission is not set, if the bitcask is a follower or if the address cannot be listened on.
+func (b *Bitcask) Lead(addr string, opts ...ReplicationOpt) (*Leader, error) {
+	if b.usrOpts.accessPermission == ReadOnly {
+		return nil, fmt.Errorf("Lead: %s", errRequireWrite)
+	}
+	if b.following.Load() {
+		return nil, fmt.Errorf("Lead: %s", errFollower)
+	}
+
+	replOpts := parseReplOpts(opts)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if replOpts.tls != nil {
+		listener = tls.NewListener(listener, replOpts.tls)
+	}
+
+	l := &Leader{b: b, listener: listener, token: replOpts.token, followers: make(map[net.Conn]*Iterator)}
+	l.wg.Add(1)
+	go l.accept()
+
+	return l, nil
+}
+
+// Follow makes the bitcask follow the leader in the given TCP address, applying its changes with their sequence numbers.
+// The follower reconnects whenever the connection fails and resumes after its last applied change.
+// The options must match the ones of the leader, a follower rejected by the leader keeps retrying
+// and reports the denial from Err.
+// Return an error if ReadWrite permission is not set or if the bitcask is already a follower.
+func (b *Bitcask) Follow(leaderAddr string, opts ...ReplicationOpt) (*Follower, error) {
+	if b.usrOpts.accessPermission == ReadOnly {
+		return nil, fmt.Errorf("Follow: %s", errRequireWrite)
+	}
+	if !b.following.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("Follow: %s", errFollower)
+	}
+
+	f := &Follower{b: b, addr: leaderAddr, opts: parseReplOpts(opts), stop: make(chan struct{}), done: make(chan struct{})}
+	f.seq.Store(b.seqNum())
+	go f.run()
+
+	return f, nil
+}
+
+// Addr returns the address the leader listens on.
+func (l *Leader) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Close stops the leader and disconnects its followers.
+// The leader must be closed before its bitcask.
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	err := l.listener.Close()
+	for conn, it := range l.followers {
+		conn.Close()
+		if it != nil {
+			it.Close()
+		}
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+
+	return err
+}
+
+// Seq returns the sequence number of the last change applied by the follower.
+func (f *Follower) Seq() int64 {
+	return f.seq.Load()
+}
+
+// Err returns the error that broke the last connection to the leader, nil if it did not break.
+func (f *Follower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lastErr
+}
+
+// Close stops following the leader, the bitcask keeps rejecting writes until the follower is promoted.
+// The follower must be closed before its bitcask.
+func (f *Follower) Close() {
+	f.once.Do(func() {
+		close(f.stop)
+		f.mu.Lock()
+		if f.conn != nil {
+			f.conn.Close()
+		}
+		f.mu.Unlock()
+	})
+	<-f.done
+}
+
+// Promote stops following the leader and makes the bitcask accept writes,
+// so it can replace its leader and lead the other followers.
+func (f *Follower) Promote() {
+	f.Close()
+	f.b.following.Store(false)
+}
+
+// accept serves every connected follower in a separate goroutine until the leader is closed.
+func (l *Leader) accept() {
+	defer l.wg.Done()
+
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return
+		}
+		l.followers[conn] = nil
+		l.wg.Add(1)
+		l.mu.Unlock()
+
+		go l.serve(conn)
+	}
+}
+
+// serve streams the changes after the position of the follower connected with the given connection,
+// sending a snapshot first if these changes were removed by a merge.
+func (l *Leader) serve(conn net.Conn) {
+	defer l.wg.Done()
+	defer func() {
+		l.mu.Lock()
+		delete(l.followers, conn)
+		l.mu.Unlock()
+		conn.Close()
+	}()
+
+	w := bufio.NewWriter(conn)
+	enc := gob.NewEncoder(w)
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+
+	var hello replMsg
+	if dec.Decode(&hello) != nil || hello.Kind != msgHello {
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hello.Token), []byte(l.token)) != 1 {
+		if enc.Encode(replMsg{Kind: msgDenied}) == nil {
+			w.Flush()
+		}
+		return
+	}
+
+	it, err := l.b.ChangesSince(hello.Event.Seq)
+	for i := 0; errors.Is(err, ErrCompacted) && i < snapshotAttempts; i++ {
+		it, err = l.sendSnapshot(enc, w)
+	}
+	if err != nil {
+		return
+	}
+	defer it.Close()
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.followers[conn] = it
+	l.mu.Unlock()
+
+	for {
+		ev, err := it.Next()
+		if err != nil {
+			return
+		}
+
+		err = enc.Encode(replMsg{Kind: msgChange, Event: ev})
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sendSnapshot sends the live key/value pairs of the datastore sorted by their sequence numbers.
+// The values are read and sent in chunks, so only the keys of the snapshot are held in memory.
+// Return an iterator over the changes written after the snapshot,
+// ErrCompacted if a merge removed them before they could be iterated or an error on system failures.
+func (l *Leader) sendSnapshot(enc *gob.Encoder, w *bufio.Writer) (*Iterator, error) {
+	keys, seq := l.b.snapshotKeys()
+
+	err := enc.Encode(replMsg{Kind: msgSnapshot, Event: Event{Seq: seq}})
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(keys); i += snapshotChunk {
+		end := i + snapshotChunk
+		if end > len(keys) {
+			end = len(keys)
+		}
+		recs, err := l.b.snapshotValues(keys[i:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range recs {
+			err = enc.Encode(replMsg{Kind: msgSnapshotRec, Event: rec})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	err = enc.Encode(replMsg{Kind: msgSnapshotEnd})
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return l.b.ChangesSince(seq)
+}
+
+// run follows the leader until the follower is closed, reconnecting after every broken connection.
+func (f *Follower) run() {
+	defer close(f.done)
+
+	for {
+		err := f.follow()
+
+		f.mu.Lock()
+		f.lastErr = err
+		f.mu.Unlock()
+
+		select {
+		case <-f.stop:
+			return
+		case <-time.After(followRetryDelay):
+		}
+	}
+}
+
+// follow connects to the leader and applies the snapshots and changes it sends.
+// A snapshot is written while it is received, and removed if the connection breaks before its end.
+// The changes of a transaction are held until its last change is received, then applied together,
+// so a broken connection or a crash never leaves a part of the transaction applied.
+// Return the error that broke the connection.
+func (f *Follower) follow() error {
+	var conn net.Conn
+	var err error
+	if f.opts.tls != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: followDialTimeout}, "tcp", f.addr, f.opts.tls)
+	} else {
+		conn, err = net.DialTimeout("tcp", f.addr, followDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	f.mu.Lock()
+	select {
+	case <-f.stop:
+		f.mu.Unlock()
+		return nil
+	default:
+		f.conn = conn
+	}
+	f.mu.Unlock()
+
+	enc := gob.NewEncoder(conn)
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+
+	err = enc.Encode(replMsg{Kind: msgHello, Event: Event{Seq: f.seq.Load()}, Token: f.opts.token})
+	if err != nil {
+		return err
+	}
+
+	var snapshot *snapshotWriter
+	var snapshotSeq int64
+	var batch []Event
+	defer func() {
+		if snapshot != nil {
+			snapshot.abort()
+		}
+	}()
+	for {
+		var msg replMsg
+		err := dec.Decode(&msg)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case msg.Kind == msgDenied:
+			return errReplicationDenied
+		case msg.Kind == msgSnapshot && snapshot == nil:
+			snapshot, err = f.b.beginSnapshot()
+			if err != nil {
+				return err
+			}
+			snapshotSeq = msg.Event.Seq
+		case msg.Kind == msgSnapshotRec && snapshot != nil:
+			err = snapshot.write(msg.Event)
+			if err != nil {
+				return err
+			}
+		case msg.Kind == msgSnapshotEnd && snapshot != nil:
+			err = snapshot.commit(snapshotSeq)
+			snapshot = nil
+			if err != nil {
+				return err
+			}
+			f.seq.Store(snapshotSeq)
+		case msg.Kind == msgChange && snapshot == nil:
+			batch = append(batch, msg.Event)
+			if msg.Event.Batch > 0 {
+				continue
+			}
+			err = f.b.apply(batch)
+			if err != nil {
+				return err
+			}
+			batch = batch[:0]
+			f.seq.Store(msg.Event.Seq)
+		default:
+			return errUnexpectedMsg
+		}
+	}
+}
+
+// seqNum returns the sequence number of the last written change.
+func (b *Bitcask) seqNum() int64 {
+	b.accessMu.RLock()
+	defer b.accessMu.RUnlock()
+
+	return b.lastTstamp
+}
+
+// snapshotKeys returns the live keys of the datastore sorted by the sequence numbers of their values
+// and the sequence number of the last change the snapshot includes.
+func (b *Bitcask) snapshotKeys() ([]snapshotKey, int64) {
+	b.accessMu.RLock()
+	defer b.accessMu.RUnlock()
+
+	keys := make([]snapshotKey, 0, len(b.keyDir))
+	for key, rec := range b.keyDir {
+		keys = append(keys, snapshotKey{key: key, tstamp: rec.Tstamp})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].tstamp < keys[j].tstamp
+	})
+
+	return keys, b.lastTstamp
+}
+
+// snapshotValues reads the values of the given snapshot keys.
+// The keys changed after the snapshot are skipped, as their changes are streamed after it.
+// Return an error on system failures when reading the values.
+func (b *Bitcask) snapshotValues(keys []snapshotKey) ([]Event, error) {
+	b.filesMu.RLock()
+	defer b.filesMu.RUnlock()
+
+	b.accessMu.RLock()
+	recs := make([]recfmt.KeyDirRec, 0, len(keys))
+	found := make([]snapshotKey, 0, len(keys))
+	for _, key := range keys {
+		if rec, isExist := b.keyDir[key.key]; isExist && rec.Tstamp == key.tstamp {
+			recs = append(recs, rec)
+			found = append(found, key)
+		}
+	}
+	b.accessMu.RUnlock()
+
+	events := make([]Event, 0, len(recs))
+	for i, rec := range recs {
+		value, err := b.dataStore.ReadValueFromFile(rec)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, Event{Seq: rec.Tstamp, Type: EventPut, Key: found[i].key, Value: value})
+	}
+
+	return events, nil
+}
+
+// apply writes the changes of a transaction streamed by the leader with their sequence numbers
+// as a single batch, so they are all kept or all dropped on recovery.
+// Changes that are not newer than the version of their key are already applied and skipped,
+// the version of a missing key is the last removal, so replaying a put cannot bring back a key removed after it
+// and a follower can safely resume before its last applied change.
+// Return an error on any system failure when writing the data.
+func (b *Bitcask) apply(events []Event) error {
+	b.accessMu.Lock()
+	defer b.accessMu.Unlock()
+
+	recs := make([]datastore.BatchRec, 0, len(events))
+	for _, ev := range events {
+		if ev.Seq > b.lastTstamp {
+			b.lastTstamp = ev.Seq
+		}
+
+		if ev.Seq <= b.version(ev.Key) {
+			continue
+		}
+
+		switch ev.Type {
+		case EventPut:
+			recs = append(recs, datastore.BatchRec{Key: ev.Key, Value: ev.Value, Tstamp: ev.Seq})
+		case EventDelete:
+			if _, isExist := b.keyDir[ev.Key]; isExist {
+				recs = append(recs, datastore.BatchRec{Key: ev.Key, Value: datastore.TompStone, Tstamp: ev.Seq})
+			}
+		}
+	}
+	if len(recs) == 0 {
+		return nil
+	}
+
+	keyDirRecs, err := b.activeFile.WriteBatch(recs)
+	if err != nil {
+		return err
+	}
+	for i, rec := range recs {
+		if rec.Value == datastore.TompStone {
+			b.indexDelete(rec.Key, rec.Tstamp, len(recs)-1-i)
+			continue
+		}
+		b.indexPut(rec.Key, rec.Value, keyDirRecs[i], len(recs)-1-i)
+	}
+
+	return nil
+}
+
+// beginSnapshot starts replacing the data of the datastore with a snapshot streamed by a leader.
+// The active file is replaced first, so the files written before the snapshot can be deleted once it is committed.
+// Return an error on any system failures when creating the files.
+func (b *Bitcask) beginSnapshot() (*snapshotWriter, error) {
+	b.compactMu.Lock()
+
+	s, err := b.createSnapshotWriter()
+	if err != nil {
+		b.compactMu.Unlock()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// createSnapshotWriter replaces the active file and creates the merge file of a snapshot,
+// the caller must hold the compaction lock.
+// Return an error on any system failures when creating the files.
+func (b *Bitcask) createSnapshotWriter() (*snapshotWriter, error) {
+	b.accessMu.Lock()
+	err := b.activeFile.Close()
+	if err == nil {
+		b.activeFile = datastore.NewAppendFile(b.dataStore.FS(), b.dataStore.Path(), b.fileFlags, datastore.Active, &b.usrOpts.encoding)
+		err = b.activeFile.Create()
+	}
+	b.accessMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	oldFiles, err := b.listOldFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	mergeFile := datastore.NewAppendFile(b.dataStore.FS(), b.dataStore.Path(), b.fileFlags, datastore.Merge, &b.usrOpts.encoding)
+	err = mergeFile.Create()
+	if err != nil {
+		mergeFile.Close()
+		return nil, err
+	}
+
+	return &snapshotWriter{b: b, oldFiles: oldFiles, mergeFile: mergeFile, keyDir: keydir.KeyDir{}}, nil
+}
+
+// write writes a snapshot record to the merge files with its sequence number.
+// Return an error on any system failures when writing data.
+func (s *snapshotWriter) write(ev Event) error {
+	rec, err := s.mergeFile.WriteData(ev.Key, ev.Value, ev.Seq)
+	if err != nil {
+		return err
+	}
+	err = s.mergeFile.WriteHint(ev.Key, rec)
+	if err != nil {
+		return err
+	}
+	s.keyDir[ev.Key] = rec
+
+	return nil
+}
+
+// commit replaces the data of the datastore with the written snapshot that includes the changes
+// up to the given sequence number, then deletes all the previous files like Merge does,
+// so the changes before the snapshot are reported as compacted to the watchers and ChangesSince.
+// Return an error on any system failures when writing data.
+func (s *snapshotWriter) commit(seq int64) error {
+	b := s.b
+	defer b.compactMu.Unlock()
+
+	err := s.close()
+	if err != nil {
+		return err
+	}
+
+	b.accessMu.Lock()
+	b.keyDir = s.keyDir
+	b.lastTstamp = seq
+	b.lastDelete = seq
+	b.metrics.resetKeyDir(s.keyDir)
+	b.watchers.overflow(seq)
+	b.accessMu.Unlock()
+
+	b.filesMu.Lock()
+	defer b.filesMu.Unlock()
+
+	return b.deleteOldFiles(s.oldFiles)
+}
+
+// abort deletes the written snapshot files, leaving the data of the datastore unchanged.
+func (s *snapshotWriter) abort() {
+	b := s.b
+	defer b.compactMu.Unlock()
+
+	s.close()
+	files, err := b.listOldFiles()
+	if err != nil {
+		return
+	}
+
+	old := make(map[string]bool, len(s.oldFiles))
+	for _, name := range s.oldFiles {
+		old[name] = true
+	}
+	written := make([]string, 0)
+	for _, name := range files {
+		if !old[name] {
+			written = append(written, name)
+		}
+	}
+
+	b.filesMu.Lock()
+	defer b.filesMu.Unlock()
+
+	b.deleteOldFiles(written)
+}
+
+// close flushes the merge files of the snapshot to the disk and closes them.
+// Return an error on any system failures when flushing the data.
+func (s *snapshotWriter) close() error {
+	err := s.mergeFile.Sync()
+	if closeErr := s.mergeFile.Close(); err == nil {
+		err = closeErr
+	}
+	s.b.metrics.syncs.Add(s.mergeFile.Syncs())
+
+	return err
+}
+
+// parseReplOpts returns the replication options set by the given options.
+func parseReplOpts(opts []ReplicationOpt) replOptions {
+	replOpts := replOptions{}
+	for _, opt := range opts {
+		opt.applyReplication(&replOpts)
+	}
+
+	return replOpts
+}
+
+// applyReplication sets the TLS config of the replication connections.
+func (o tlsOpt) applyReplication(replOpts *replOptions) {
+	replOpts.tls = o.config
+}
+
+// applyReplication sets the token shared by a leader and its followers.
+func (t tokenOpt) applyReplication(replOpts *replOptions) {
+	replOpts.token = string(t)
+}
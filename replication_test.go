@@ -0,0 +1,333 @@
+package bitcask
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+// waitForSeq waits until the follower applies the change with the given sequence number.
+func waitForSeq(t *testing.T, f *Follower, seq int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Seq() < seq {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower is at sequence %d, want %d: %v", f.Seq(), seq, f.Err())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// assertSameData checks that both bitcasks have the same key/value pairs.
+func assertSameData(t *testing.T, want, got *Bitcask) {
+	t.Helper()
+
+	wantKeys, gotKeys := want.ListKeys(), got.ListKeys()
+	if len(wantKeys) != len(gotKeys) {
+		t.Fatalf("got %d keys, want %d", len(gotKeys), len(wantKeys))
+	}
+	for _, key := range wantKeys {
+		wantValue, _ := want.Get(key)
+		gotValue, err := got.Get(key)
+		if err != nil || gotValue != wantValue {
+			t.Errorf("%s: got %q and error %v, want %q", key, gotValue, err, wantValue)
+		}
+	}
+}
+
+// testTLSConfigs returns the TLS config of a leader with a self signed certificate
+// and the TLS config of a follower trusting it.
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "leader"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: pool}
+}
+
+func TestReplication(t *testing.T) {
+	t.Run("follower streams the leader changes", func(t *testing.T) {
+		leader, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer leader.Close()
+		for i := 0; i < 100; i++ {
+			leader.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+		}
+
+		l, err := leader.Lead("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		follower, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer follower.Close()
+		f, err := follower.Follow(l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		waitForSeq(t, f, leader.seqNum())
+		leader.Put("key1", "new")
+		leader.Delete("key2")
+		waitForSeq(t, f, leader.seqNum())
+		assertSameData(t, leader, follower)
+
+		err = follower.Put("key1", "value")
+		assertError(t, err, "Put: datastore is a replication follower")
+	})
+
+	t.Run("follower catches up from a snapshot", func(t *testing.T) {
+		follower, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer follower.Close()
+		follower.Put("stale", "value")
+
+		leader, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer leader.Close()
+		// the snapshot is sent in more than one chunk.
+		for i := 0; i < 1000; i++ {
+			leader.Put(fmt.Sprintf("key%d", i%(snapshotChunk+50)), fmt.Sprintf("value%d", i))
+		}
+		leader.Merge()
+
+		l, _ := leader.Lead("127.0.0.1:0")
+		defer l.Close()
+		f, _ := follower.Follow(l.Addr().String())
+		defer f.Close()
+
+		waitForSeq(t, f, leader.seqNum())
+		assertSameData(t, leader, follower)
+
+		_, err := follower.ChangesSince(0)
+		if err != ErrCompacted {
+			t.Errorf("expected %v on the follower after the snapshot, got %v", ErrCompacted, err)
+		}
+	})
+
+	t.Run("aborted snapshot leaves the data unchanged", func(t *testing.T) {
+		mem := vfs.NewMem()
+		follower, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		defer follower.Close()
+		follower.Put("stale", "value")
+		before, _ := mem.ReadDir(testBitcaskPath)
+
+		s, err := follower.beginSnapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			err = s.write(Event{Seq: int64(i + 1), Type: EventPut, Key: fmt.Sprintf("key%d", i), Value: "value"})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		s.abort()
+
+		got, _ := follower.Get("stale")
+		assertString(t, got, "value")
+		after, _ := mem.ReadDir(testBitcaskPath)
+		// only the active file replaced by the snapshot is left.
+		if len(after) != len(before)+1 {
+			t.Errorf("got %d files after the aborted snapshot, want %d", len(after), len(before)+1)
+		}
+	})
+
+	t.Run("follower with a wrong token is denied", func(t *testing.T) {
+		leader, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer leader.Close()
+		leader.Put("key1", "value1")
+
+		l, _ := leader.Lead("127.0.0.1:0", WithToken("secret"))
+		defer l.Close()
+
+		denied, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer denied.Close()
+		f, _ := denied.Follow(l.Addr().String(), WithToken("wrong"))
+		defer f.Close()
+		for deadline := time.Now().Add(5 * time.Second); f.Err() != errReplicationDenied; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %v, got %v", errReplicationDenied, f.Err())
+			}
+		}
+		if f.Seq() != 0 {
+			t.Errorf("denied follower is at sequence %d, want 0", f.Seq())
+		}
+
+		follower, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer follower.Close()
+		f2, _ := follower.Follow(l.Addr().String(), WithToken("secret"))
+		defer f2.Close()
+		waitForSeq(t, f2, leader.seqNum())
+		assertSameData(t, leader, follower)
+	})
+
+	t.Run("follower streams over tls", func(t *testing.T) {
+		serverConfig, clientConfig := testTLSConfigs(t)
+		leader, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer leader.Close()
+		leader.Put("key1", "value1")
+
+		l, err := leader.Lead("127.0.0.1:0", WithTLS(serverConfig))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		follower, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer follower.Close()
+		f, _ := follower.Follow(l.Addr().String(), WithTLS(clientConfig))
+		defer f.Close()
+		waitForSeq(t, f, leader.seqNum())
+		assertSameData(t, leader, follower)
+	})
+
+	t.Run("follower resumes after reconnecting and is promoted", func(t *testing.T) {
+		leader, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer leader.Close()
+		leader.Put("key1", "value1")
+
+		l, _ := leader.Lead("127.0.0.1:0")
+		addr := l.Addr().String()
+		follower, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer follower.Close()
+		f, _ := follower.Follow(addr)
+		waitForSeq(t, f, leader.seqNum())
+
+		l.Close()
+		leader.Put("key2", "value2")
+		l, err := leader.Lead(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		waitForSeq(t, f, leader.seqNum())
+		assertSameData(t, leader, follower)
+
+		f.Promote()
+		err = follower.Put("key3", "value3")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l2, err := follower.Lead("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l2.Close()
+		replica, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer replica.Close()
+		f2, _ := replica.Follow(l2.Addr().String())
+		defer f2.Close()
+		waitForSeq(t, f2, follower.seqNum())
+		assertSameData(t, follower, replica)
+	})
+
+	t.Run("follower applies a transaction as a single batch", func(t *testing.T) {
+		leader, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer leader.Close()
+		leader.Put("key1", "value1")
+		tx := leader.Begin()
+		tx.Put("key2", "value2")
+		tx.Delete("key1")
+		tx.Put("key3", "value3")
+		err := tx.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		it, _ := leader.ChangesSince(0)
+		for _, want := range []int{0, 2, 1, 0} {
+			ev, err := it.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Batch != want {
+				t.Errorf("got %+v, want %d changes left in its batch", ev, want)
+			}
+		}
+		it.Close()
+
+		l, _ := leader.Lead("127.0.0.1:0")
+		defer l.Close()
+		mem := vfs.NewMem()
+		follower, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		f, _ := follower.Follow(l.Addr().String())
+		waitForSeq(t, f, leader.seqNum())
+		f.Close()
+		follower.Close()
+
+		// the crash happened while the last record of the transaction was written.
+		entries, _ := mem.ReadDir(testBitcaskPath)
+		for _, entry := range entries {
+			name := path.Join(testBitcaskPath, entry.Name())
+			data, _ := mem.ReadFile(name)
+			if path.Ext(name) == ".data" && bytes.Contains(data, []byte("key3")) {
+				writeFileSync(mem, name, data[:len(data)-1])
+			}
+		}
+
+		follower, err = OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer follower.Close()
+		keys := follower.ListKeys()
+		if len(keys) != 1 || keys[0] != "key1" {
+			t.Errorf("got keys %v after reopening the follower, want only key1", keys)
+		}
+	})
+
+	t.Run("replayed changes do not bring back removed keys", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+
+		put := Event{Seq: 1, Type: EventPut, Key: "key1", Value: "value1"}
+		del := Event{Seq: 2, Type: EventDelete, Key: "key1"}
+		for _, events := range [][]Event{{put}, {del}, {put}, {put, del}} {
+			err := b.apply(events)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err := b.Get("key1")
+		assertError(t, err, "key1: key does not exist")
+	})
+}
@@ -0,0 +1,213 @@
+package bitcask
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IslamWalid/bitcask/internal/datastore"
+	"github.com/IslamWalid/bitcask/internal/keydir"
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+)
+
+const (
+	// opGet is the index of the Get operation counters.
+	opGet = iota
+	// opPut is the index of the Put operation counters.
+	opPut
+	// opDelete is the index of the Delete operation counters.
+	opDelete
+	// opMerge is the index of the Merge operation counters.
+	opMerge
+	// opSync is the index of the Sync operation counters.
+	opSync
+	// opCount is the number of the counted operations.
+	opCount
+)
+
+type (
+	// OpStats describes the calls of a bitcask operation.
+	// Errors does not count the calls that failed because the key does not exist.
+	OpStats struct {
+		Count     uint64        `json:"count"`
+		Errors    uint64        `json:"errors"`
+		TotalTime time.Duration `json:"total_time_ns"`
+		MaxTime   time.Duration `json:"max_time_ns"`
+	}
+
+	// MergeStats describes the last merge.
+	// ReclaimedBytes is the difference between the size of the data and hint files before and after the merge.
+	MergeStats struct {
+		Start          time.Time     `json:"start"`
+		Duration       time.Duration `json:"duration_ns"`
+		RemovedFiles   int           `json:"removed_files"`
+		ReclaimedBytes int64         `json:"reclaimed_bytes"`
+		Err            string        `json:"error,omitempty"`
+	}
+
+	// Stats describes the state of the bitcask datastore and the operations done since it was opened.
+	// LiveBytes is the size of the data file records the keydir points at and DeadBytes is the size of the rest
+	// of the data file records, KeyDirBytes is an estimation of the memory used by the keydir.
+	// SyncCalls is the number of times the data and hint files were flushed to the disk.
+	Stats struct {
+		Keys        int        `json:"keys"`
+		DataFiles   int        `json:"data_files"`
+		HintFiles   int        `json:"hint_files"`
+		DataBytes   int64      `json:"data_bytes"`
+		HintBytes   int64      `json:"hint_bytes"`
+		LiveBytes   int64      `json:"live_bytes"`
+		DeadBytes   int64      `json:"dead_bytes"`
+		KeyDirBytes int64      `json:"keydir_bytes"`
+		SyncCalls   int64      `json:"sync_calls"`
+		LastMerge   MergeStats `json:"last_merge"`
+		Get         OpStats    `json:"get"`
+		Put         OpStats    `json:"put"`
+		Delete      OpStats    `json:"delete"`
+		Merge       OpStats    `json:"merge"`
+		Sync        OpStats    `json:"sync"`
+	}
+
+	// opCounters counts the calls of a bitcask operation.
+	opCounters struct {
+		count    atomic.Uint64
+		errors   atomic.Uint64
+		nanos    atomic.Int64
+		maxNanos atomic.Int64
+	}
+
+	// metrics collects the bitcask statistics with atomic counters,
+	// so they are updated without extra locking on the operations paths.
+	metrics struct {
+		ops         [opCount]opCounters
+		liveBytes   atomic.Int64
+		keyDirBytes atomic.Int64
+		syncs       atomic.Int64
+		mergeMu     sync.Mutex
+		lastMerge   MergeStats
+	}
+)
+
+// Stats returns the current statistics of the bitcask datastore.
+// Return an error on system failures when reading the datastore directory.
+func (b *Bitcask) Stats() (Stats, error) {
+	usage, err := b.diskUsage()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	b.accessMu.RLock()
+	keys := len(b.keyDir)
+	b.accessMu.RUnlock()
+
+	s := Stats{
+		Keys:        keys,
+		DataFiles:   usage.DataFiles,
+		HintFiles:   usage.HintFiles,
+		DataBytes:   usage.DataBytes,
+		HintBytes:   usage.HintBytes,
+		LiveBytes:   b.metrics.liveBytes.Load(),
+		KeyDirBytes: b.metrics.keyDirBytes.Load(),
+		SyncCalls:   b.metrics.syncs.Load(),
+		Get:         b.metrics.ops[opGet].stats(),
+		Put:         b.metrics.ops[opPut].stats(),
+		Delete:      b.metrics.ops[opDelete].stats(),
+		Merge:       b.metrics.ops[opMerge].stats(),
+		Sync:        b.metrics.ops[opSync].stats(),
+	}
+	// the file headers are not reclaimed by a merge, so they are not dead.
+	recBytes := s.DataBytes - int64(s.DataFiles)*recfmt.FileHdr
+	if recBytes > s.LiveBytes {
+		s.DeadBytes = recBytes - s.LiveBytes
+	}
+	if b.usrOpts.accessPermission == ReadWrite {
+		s.SyncCalls += b.activeFile.Syncs()
+	}
+
+	b.metrics.mergeMu.Lock()
+	s.LastMerge = b.metrics.lastMerge
+	b.metrics.mergeMu.Unlock()
+
+	return s, nil
+}
+
+// Mean returns the average time of the operation calls.
+func (o OpStats) Mean() time.Duration {
+	if o.Count == 0 {
+		return 0
+	}
+
+	return o.TotalTime / time.Duration(o.Count)
+}
+
+// diskUsage returns the number and size of the data and hint files
+// while preventing Merge from deleting files.
+func (b *Bitcask) diskUsage() (datastore.Usage, error) {
+	b.filesMu.RLock()
+	defer b.filesMu.RUnlock()
+
+	return b.dataStore.DiskUsage()
+}
+
+// observe counts a call of the given operation that started at the given time and returned the given error.
+func (m *metrics) observe(op int, start time.Time, err error) {
+	elapsed := int64(time.Since(start))
+	c := &m.ops[op]
+
+	c.count.Add(1)
+	if err != nil && !strings.HasSuffix(err.Error(), datastore.ErrKeyNotExist.Error()) {
+		c.errors.Add(1)
+	}
+	c.nanos.Add(elapsed)
+	for {
+		max := c.maxNanos.Load()
+		if elapsed <= max || c.maxNanos.CompareAndSwap(max, elapsed) {
+			break
+		}
+	}
+}
+
+// addRec accounts for a record added to the keydir.
+func (m *metrics) addRec(key string, rec recfmt.KeyDirRec) {
+	m.liveBytes.Add(keydir.DiskSize(rec))
+	m.keyDirBytes.Add(keydir.MemSize(key, rec))
+}
+
+// removeRec accounts for a record removed from the keydir.
+func (m *metrics) removeRec(key string, rec recfmt.KeyDirRec) {
+	m.liveBytes.Add(-keydir.DiskSize(rec))
+	m.keyDirBytes.Add(-keydir.MemSize(key, rec))
+}
+
+// resetKeyDir accounts for all the records of a newly built keydir.
+func (m *metrics) resetKeyDir(k keydir.KeyDir) {
+	liveBytes, memBytes := k.Usage()
+	m.liveBytes.Store(liveBytes)
+	m.keyDirBytes.Store(memBytes)
+}
+
+// setLastMerge records the result of the merge that started at the given time.
+func (m *metrics) setLastMerge(start time.Time, removedFiles int, reclaimedBytes int64, err error) {
+	m.mergeMu.Lock()
+	defer m.mergeMu.Unlock()
+
+	m.lastMerge = MergeStats{
+		Start:          start,
+		Duration:       time.Since(start),
+		RemovedFiles:   removedFiles,
+		ReclaimedBytes: reclaimedBytes,
+	}
+	if err != nil {
+		m.lastMerge.Err = err.Error()
+	}
+}
+
+// stats returns a snapshot of the operation counters.
+func (c *opCounters) stats() OpStats {
+	return OpStats{
+		Count:     c.count.Load(),
+		Errors:    c.errors.Load(),
+		TotalTime: time.Duration(c.nanos.Load()),
+		MaxTime:   time.Duration(c.maxNanos.Load()),
+	}
+}
@@ -0,0 +1,101 @@
+package bitcask
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/encrypt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+func TestStats(t *testing.T) {
+	mem := vfs.NewMem()
+	b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+	defer b.Close()
+
+	for i := 0; i < 1000; i++ {
+		b.Put(fmt.Sprintf("key%d", i%100), fmt.Sprintf("value%d", i))
+	}
+	b.Delete("key1")
+	b.Get("key2")
+	b.Get("key1")
+	b.Sync()
+
+	s, err := b.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Keys != 99 || s.Put.Count != 1000 || s.Delete.Count != 1 || s.Get.Count != 2 || s.Get.Errors != 0 {
+		t.Errorf("got keys %d, puts %d, deletes %d, gets %d and get errors %d",
+			s.Keys, s.Put.Count, s.Delete.Count, s.Get.Count, s.Get.Errors)
+	}
+	if s.DataFiles < 2 || s.LiveBytes == 0 || s.DeadBytes <= s.LiveBytes || s.SyncCalls == 0 {
+		t.Errorf("unexpected disk stats %+v", s)
+	}
+
+	err = b.Merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := b.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Merge.Count != 1 || merged.LastMerge.RemovedFiles == 0 || merged.LastMerge.ReclaimedBytes <= 0 {
+		t.Errorf("unexpected merge stats %+v", merged.LastMerge)
+	}
+	if merged.Keys != 99 || merged.LiveBytes != s.LiveBytes || merged.DeadBytes >= s.DeadBytes {
+		t.Errorf("got live bytes %d and dead bytes %d after merge, before %d and %d",
+			merged.LiveBytes, merged.DeadBytes, s.LiveBytes, s.DeadBytes)
+	}
+}
+
+func TestStatsHeaders(t *testing.T) {
+	b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+	defer b.Close()
+
+	// the values are large enough to fill several data files, whose headers are not dead bytes.
+	value := strings.Repeat("v", 1000)
+	for i := 0; i < 50; i++ {
+		b.Put(fmt.Sprintf("key%d", i), value)
+	}
+
+	s, err := b.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.DataFiles < 2 || s.DeadBytes != 0 || s.DataBytes != s.LiveBytes+int64(s.DataFiles)*recfmt.FileHdr {
+		t.Errorf("got %d data files with %d bytes, %d live and %d dead bytes", s.DataFiles, s.DataBytes, s.LiveBytes, s.DeadBytes)
+	}
+}
+
+func TestStatsEncrypted(t *testing.T) {
+	mem := vfs.NewMem()
+	keys := &encrypt.Keys{Current: 1, ByID: map[uint32][]byte{1: []byte("0123456789abcdef0123456789abcdef")}}
+
+	b1, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem), WithEncryption(keys))
+	for i := 0; i < 500; i++ {
+		b1.Put(fmt.Sprintf("key%d", i%100), fmt.Sprintf("value%d", i))
+	}
+	err := b1.Merge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, _ := b1.Stats()
+	b1.Close()
+
+	// the merged data files hold only live records, so they are all counted as live bytes
+	// along with the encrypted keys and values.
+	b2, _ := OpenWith(testBitcaskPath, WithFS(mem), WithEncryption(keys))
+	defer b2.Close()
+	s, err := b2.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live := s.DataBytes - int64(s.DataFiles*recfmt.FileHdr); s.LiveBytes != live || written.LiveBytes != live {
+		t.Errorf("got live bytes %d after merge and %d after reopen, want %d", written.LiveBytes, s.LiveBytes, live)
+	}
+}
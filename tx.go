@@ -0,0 +1,300 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IslamWalid/bitcask/internal/datastore"
+)
+
+var (
+	// ErrConflict happens whenever a transaction is committed after a key it read was written by someone else.
+	ErrConflict = errors.New("transaction conflicts with a concurrent write")
+
+	// errTxDone happens whenever a transaction is used after it is committed or discarded.
+	errTxDone = errors.New("transaction is already committed or discarded")
+)
+
+type (
+	// Tx represents a transaction that groups several writes committed atomically.
+	// The writes are buffered until Commit and the reads see the buffered writes.
+	// Transactions are optimistic, the datastore is not locked until Commit, which fails
+	// if a key read by the transaction was written since it was read.
+	// A Tx must not be used by several goroutines at the same time.
+	Tx struct {
+		b        *Bitcask
+		writes   map[string]txWrite
+		order    []string
+		versions map[string]int64
+		conflict bool
+		listed   bool
+		listedAt int64
+		done     bool
+	}
+
+	// txWrite represents a buffered write of a transaction.
+	txWrite struct {
+		value   string
+		deleted bool
+	}
+)
+
+// Begin starts a new transaction.
+func (b *Bitcask) Begin() *Tx {
+	return &Tx{
+		b:        b,
+		writes:   make(map[string]txWrite),
+		versions: make(map[string]int64),
+	}
+}
+
+// Version returns the version of the value of a key.
+// The version of an existing key is the sequence number of its last write, so it changes whenever the key is written.
+// The version of a missing key is the sequence number of the last removal of any key, so writing then removing
+// the key changes it too, while removing other keys changes it without the key being written.
+func (b *Bitcask) Version(key string) int64 {
+	b.accessMu.RLock()
+	defer b.accessMu.RUnlock()
+
+	return b.version(key)
+}
+
+// version returns the version of the value of a key, the caller must hold the access lock.
+func (b *Bitcask) version(key string) int64 {
+	if rec, isExist := b.keyDir[key]; isExist {
+		return rec.Tstamp
+	}
+
+	return b.lastDelete
+}
+
+// Get retrieves the value by key as seen by the transaction.
+// Return an error if key does not exist or was removed by the transaction.
+func (tx *Tx) Get(key string) (string, error) {
+	if tx.done {
+		return "", fmt.Errorf("Get: %s", errTxDone)
+	}
+	if w, ok := tx.writes[key]; ok {
+		if w.deleted {
+			return "", fmt.Errorf("%s: %s", key, datastore.ErrKeyNotExist)
+		}
+		return w.value, nil
+	}
+
+	start := time.Now()
+	b := tx.b
+	b.accessMu.RLock()
+	version := b.version(key)
+	value, err := b.get(key)
+	b.accessMu.RUnlock()
+	b.metrics.observe(opGet, start, err)
+
+	tx.AssertVersion(key, version)
+
+	return value, err
+}
+
+// Put buffers a write of the value by key.
+// Return an error if the value is reserved by the datastore or if the transaction is already committed or discarded.
+func (tx *Tx) Put(key, value string) error {
+	if tx.done {
+		return fmt.Errorf("Put: %s", errTxDone)
+	}
+	if datastore.IsReserved(value) {
+		return fmt.Errorf("Put: %s", errReservedValue)
+	}
+	tx.write(key, txWrite{value: value})
+
+	return nil
+}
+
+// Delete buffers the removal of a key.
+// Return an error if key does not exist or if the transaction is already committed or discarded.
+func (tx *Tx) Delete(key string) error {
+	if tx.done {
+		return fmt.Errorf("Delete: %s", errTxDone)
+	}
+
+	_, err := tx.Get(key)
+	if err != nil {
+		return err
+	}
+	tx.write(key, txWrite{deleted: true})
+
+	return nil
+}
+
+// Update buffers the write returned by fn for the value of the key as seen by the transaction.
+// Return an error if the value cannot be read, if the written value is reserved by the datastore
+// or if the transaction is already committed or discarded.
+func (tx *Tx) Update(key string, fn func(value string, exists bool) (string, UpdateOp)) error {
+	value, err := tx.Get(key)
+	exists := err == nil
+	if err != nil && !isNotExist(err) {
+		return err
+	}
+
+	value, op := fn(value, exists)
+	switch {
+	case op == UpdatePut && datastore.IsReserved(value):
+		return fmt.Errorf("Update: %s", errReservedValue)
+	case op == UpdatePut:
+		tx.write(key, txWrite{value: value})
+	case op == UpdateDelete && exists:
+		tx.write(key, txWrite{deleted: true})
+	}
+
+	return nil
+}
+
+// ListKeys list all keys as seen by the transaction.
+// Listing the keys makes Commit fail if any key is written before the transaction is committed.
+func (tx *Tx) ListKeys() []string {
+	b := tx.b
+	b.accessMu.RLock()
+	if !tx.listed {
+		tx.listed = true
+		tx.listedAt = b.lastTstamp
+	} else if tx.listedAt != b.lastTstamp {
+		tx.conflict = true
+	}
+	res := make([]string, 0, len(b.keyDir)+len(tx.writes))
+	for key := range b.keyDir {
+		if _, ok := tx.writes[key]; !ok {
+			res = append(res, key)
+		}
+	}
+	b.accessMu.RUnlock()
+
+	for _, key := range tx.order {
+		if !tx.writes[key].deleted {
+			res = append(res, key)
+		}
+	}
+
+	return res
+}
+
+// AssertVersion makes Commit fail if the version of the key is not the given version when committing,
+// it is used to commit only if the keys read before the transaction started were not written since.
+func (tx *Tx) AssertVersion(key string, version int64) {
+	if old, ok := tx.versions[key]; ok {
+		if old != version {
+			tx.conflict = true
+		}
+		return
+	}
+	tx.versions[key] = version
+}
+
+// Commit appends all the buffered writes to the active file with a single write,
+// so they are written together and are visible to the readers at the same time, then notifies the watchers.
+// The writes are framed as a batch, so a commit cut by a crash is ignored as a whole when the datastore
+// is opened again, Commit does not flush them to the disk.
+// A transaction without writes only checks its reads, so it does not wait for the other readers.
+// The transaction cannot be used after Commit.
+// Return ErrConflict if a key read by the transaction was written since it was read,
+// an error if ReadWrite permission is not set, if the datastore is a replication follower,
+// if the transaction is already committed or discarded or on any system failure when writing the data.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return fmt.Errorf("Commit: %s", errTxDone)
+	}
+	tx.done = true
+
+	b := tx.b
+	if b.usrOpts.accessPermission == ReadOnly {
+		return fmt.Errorf("Commit: %s", errRequireWrite)
+	}
+	if b.following.Load() {
+		return fmt.Errorf("Commit: %s", errFollower)
+	}
+
+	if len(tx.writes) == 0 {
+		// nothing is written, so the reads are checked along with the other readers.
+		b.accessMu.RLock()
+		defer b.accessMu.RUnlock()
+		return tx.validate()
+	}
+
+	start := time.Now()
+	b.accessMu.Lock()
+	defer b.accessMu.Unlock()
+
+	err := tx.validate()
+	if err != nil {
+		return err
+	}
+
+	recs := make([]datastore.BatchRec, 0, len(tx.order))
+	for _, key := range tx.order {
+		w := tx.writes[key]
+		if !w.deleted {
+			recs = append(recs, datastore.BatchRec{Key: key, Value: w.value})
+			continue
+		}
+		if _, isExist := b.keyDir[key]; isExist {
+			recs = append(recs, datastore.BatchRec{Key: key, Value: datastore.TompStone})
+		}
+	}
+	if len(recs) == 0 {
+		return nil
+	}
+	for i := range recs {
+		recs[i].Tstamp = b.nextTstamp()
+	}
+
+	keyDirRecs, err := b.activeFile.WriteBatch(recs)
+	if err != nil {
+		b.metrics.observe(opPut, start, err)
+		return err
+	}
+	for i, rec := range recs {
+		if rec.Value == datastore.TompStone {
+			b.indexDelete(rec.Key, rec.Tstamp, len(recs)-1-i)
+			b.metrics.observe(opDelete, start, nil)
+			continue
+		}
+		b.indexPut(rec.Key, rec.Value, keyDirRecs[i], len(recs)-1-i)
+		b.metrics.observe(opPut, start, nil)
+	}
+
+	return nil
+}
+
+// validate checks that the keys read by the transaction were not written since they were read,
+// the caller must hold the access lock.
+// Return ErrConflict if a read key was written.
+func (tx *Tx) validate() error {
+	b := tx.b
+	if tx.conflict || (tx.listed && tx.listedAt != b.lastTstamp) {
+		return ErrConflict
+	}
+	for key, version := range tx.versions {
+		if b.version(key) != version {
+			return ErrConflict
+		}
+	}
+
+	return nil
+}
+
+// Discard drops the buffered writes, the transaction cannot be used after Discard.
+func (tx *Tx) Discard() {
+	tx.done = true
+}
+
+// write buffers a write of the key, keeping the position of its first write.
+func (tx *Tx) write(key string, w txWrite) {
+	if _, ok := tx.writes[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = w
+}
+
+// isNotExist reports whether the error happened because the key does not exist.
+func isNotExist(err error) bool {
+	return err != nil && strings.HasSuffix(err.Error(), datastore.ErrKeyNotExist.Error())
+}
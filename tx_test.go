@@ -0,0 +1,175 @@
+package bitcask
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+func TestTx(t *testing.T) {
+	t.Run("commit applies the buffered writes together", func(t *testing.T) {
+		fsys := vfs.NewMem()
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(fsys))
+		b.Put("key1", "value1")
+		b.Put("key2", "value2")
+
+		tx := b.Begin()
+		tx.Put("key3", "value3")
+		tx.Delete("key1")
+		tx.Update("key2", func(value string, exists bool) (string, UpdateOp) {
+			return value + "!", UpdatePut
+		})
+
+		if got, _ := tx.Get("key2"); got != "value2!" {
+			t.Errorf("transaction reads %q, want its buffered write", got)
+		}
+		if _, err := b.Get("key3"); err == nil {
+			t.Error("buffered write is visible before commit")
+		}
+
+		err := tx.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Close()
+
+		b, _ = OpenWith(testBitcaskPath, ReadWrite, WithFS(fsys))
+		defer b.Close()
+		keys := b.ListKeys()
+		sort.Strings(keys)
+		if len(keys) != 2 || keys[0] != "key2" || keys[1] != "key3" {
+			t.Errorf("got keys %v after reopening", keys)
+		}
+		if got, _ := b.Get("key2"); got != "value2!" {
+			t.Errorf("got %q, want %q", got, "value2!")
+		}
+
+		err = tx.Commit()
+		assertError(t, err, "Commit: transaction is already committed or discarded")
+	})
+
+	t.Run("commit fails after a read key is written", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+		b.Put("key1", "value1")
+
+		tx := b.Begin()
+		tx.Get("key1")
+		tx.Put("key2", "value2")
+		b.Put("key1", "other")
+		if err := tx.Commit(); err != ErrConflict {
+			t.Errorf("got %v, want %v", err, ErrConflict)
+		}
+		if _, err := b.Get("key2"); err == nil {
+			t.Error("conflicting transaction was committed")
+		}
+
+		version := b.Version("key1")
+		tx = b.Begin()
+		tx.AssertVersion("key1", version)
+		tx.Put("key2", "value2")
+		if err := tx.Commit(); err != nil {
+			t.Errorf("got %v for an unchanged version", err)
+		}
+
+		// a transaction without writes still checks its reads.
+		tx = b.Begin()
+		tx.Get("key1")
+		b.Put("key1", "again")
+		if err := tx.Commit(); err != ErrConflict {
+			t.Errorf("got %v for a read only transaction, want %v", err, ErrConflict)
+		}
+
+		// writing then removing a missing key changes its version too.
+		version = b.Version("missing")
+		tx = b.Begin()
+		tx.Get("missing")
+		tx.Put("key2", "other")
+		b.Put("missing", "value")
+		b.Delete("missing")
+		if b.Version("missing") == version {
+			t.Error("version of a missing key is kept after writing and removing it")
+		}
+		if err := tx.Commit(); err != ErrConflict {
+			t.Errorf("got %v after writing and removing a read missing key, want %v", err, ErrConflict)
+		}
+
+		tx = b.Begin()
+		tx.ListKeys()
+		b.Put("key3", "value3")
+		if err := tx.Commit(); err != ErrConflict {
+			t.Errorf("got %v after listing keys, want %v", err, ErrConflict)
+		}
+	})
+
+	t.Run("committed writes are watched", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+		b.Put("key1", "value1")
+		events, cancel := b.Watch("")
+		defer cancel()
+
+		tx := b.Begin()
+		tx.Put("key2", "value2")
+		tx.Delete("key1")
+		tx.Commit()
+
+		put, del := <-events, <-events
+		if put.Type != EventPut || put.Key != "key2" || del.Type != EventDelete || del.Key != "key1" || del.Seq <= put.Seq {
+			t.Errorf("got events %v and %v", put, del)
+		}
+	})
+
+	t.Run("commit cut by a crash is ignored", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		b.Put("key1", "value1")
+		tx := b.Begin()
+		tx.Put("key2", "value2")
+		tx.Put("key3", "value3")
+		err := tx.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Close()
+
+		// the crash happened while the last record of the transaction was written.
+		entries, _ := mem.ReadDir(testBitcaskPath)
+		for _, entry := range entries {
+			name := path.Join(testBitcaskPath, entry.Name())
+			data, _ := mem.ReadFile(name)
+			if path.Ext(name) == ".data" && bytes.Contains(data, []byte("key3")) {
+				writeFileSync(mem, name, data[:len(data)-1])
+			}
+		}
+
+		b, err = OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		keys := b.ListKeys()
+		if len(keys) != 1 || keys[0] != "key1" {
+			t.Errorf("got keys %v after reopening, want only key1", keys)
+		}
+
+		b.Put("key4", "value4")
+		it, err := b.ChangesSince(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		for _, want := range []string{"key1", "key4"} {
+			ev, err := it.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Key != want {
+				t.Errorf("got change of %q, want %q", ev.Key, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,360 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/IslamWalid/bitcask/internal/datastore"
+	"github.com/IslamWalid/bitcask/internal/keydir"
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+type (
+	// Problem describes a corrupted or inconsistent record found in a datastore file.
+	Problem struct {
+		File   string `json:"file"`
+		Offset int64  `json:"offset"`
+		Key    string `json:"key,omitempty"`
+		Err    string `json:"error"`
+	}
+
+	// Report describes the result of verifying or repairing a datastore directory.
+	// Records is the number of readable data file records and
+	// Salvaged is the number of live keys written by Repair.
+	Report struct {
+		Files    int       `json:"files"`
+		Records  int       `json:"records"`
+		Salvaged int       `json:"salvaged"`
+		Problems []Problem `json:"problems"`
+	}
+
+	// scannedRec represents a readable record found in a data file.
+	scannedRec struct {
+		length int
+		rec    *recfmt.DataRec
+	}
+
+	// scannedFiles maps every data file name to its readable records by their offsets.
+	scannedFiles map[string]map[uint32]scannedRec
+)
+
+// OK reports whether no problems were found.
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify walks every data, hint and keydir file of the given datastore and decodes all of their records.
+// Corrupted records are reported with their file, offset and key if it can be read,
+// hint and keydir records are reported if they do not point at a matching data file record.
+// The keydir file is only checked if it represents the current state of the datastore, old keydir files are ignored by Open.
+// It can take WithFS and WithEncryption config options to read the datastore, the other options are ignored.
+// Verify takes a shared lock on the datastore, so it cannot run while a writer has it open.
+// Return an error if the datastore cannot be accessed or on any system failures.
+func Verify(dataStorePath string, opts ...Option) (*Report, error) {
+	usrOpts := parseUsrOpts(opts)
+
+	d, err := datastore.NewDataStore(usrOpts.fsys, dataStorePath, datastore.SharedLock, &usrOpts.encoding)
+	if err != nil {
+		return nil, fmt.Errorf("Verify: %s", err)
+	}
+	defer d.Close()
+
+	r := &Report{Problems: make([]Problem, 0)}
+	_, err = verifyDataStore(usrOpts.fsys, dataStorePath, &usrOpts.encoding, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Repair salvages every readable record of the source datastore into a fresh datastore in the destination directory,
+// creating it if it does not exist, the source datastore is never modified.
+// Only the latest value of every key is written and deleted keys are dropped.
+// Corrupted records are skipped and reported like in Verify.
+// It can take WithFS, WithCompression and WithEncryption config options used for both datastores, the modes are ignored.
+// Return an error if the destination directory has files in it, if the source datastore cannot be accessed
+// or on any system failures.
+func Repair(srcPath, dstPath string, opts ...Option) (*Report, error) {
+	usrOpts := parseUsrOpts(opts)
+
+	d, err := datastore.NewDataStore(usrOpts.fsys, srcPath, datastore.SharedLock, &usrOpts.encoding)
+	if err != nil {
+		return nil, fmt.Errorf("Repair: %s", err)
+	}
+	defer d.Close()
+
+	r := &Report{Problems: make([]Problem, 0)}
+	files, err := verifyDataStore(usrOpts.fsys, srcPath, &usrOpts.encoding, r)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]*recfmt.DataRec)
+	for _, recs := range files {
+		for _, s := range recs {
+			if _, ok := datastore.BatchLen(s.rec); ok {
+				continue
+			}
+			old, isExist := latest[s.rec.Key]
+			if !isExist || old.Tstamp < s.rec.Tstamp {
+				latest[s.rec.Key] = s.rec
+			}
+		}
+	}
+
+	live := make([]*recfmt.DataRec, 0, len(latest))
+	for _, rec := range latest {
+		if rec.Value != datastore.TompStone {
+			live = append(live, rec)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].Tstamp < live[j].Tstamp
+	})
+
+	err = prepareDir(usrOpts.fsys, dstPath)
+	if err != nil {
+		return nil, fmt.Errorf("Repair: %s", err)
+	}
+
+	dstOpts := []Option{ReadWrite}
+	for _, opt := range opts {
+		if _, isConfigOpt := opt.(ConfigOpt); !isConfigOpt {
+			dstOpts = append(dstOpts, opt)
+		}
+	}
+
+	b, err := OpenWith(dstPath, dstOpts...)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	for _, rec := range live {
+		err := b.Put(rec.Key, rec.Value)
+		if err != nil {
+			return nil, err
+		}
+		r.Salvaged++
+	}
+
+	return r, b.Sync()
+}
+
+// verifyDataStore checks all the datastore files and adds the problems found to the given report.
+// Return the readable records of the data files.
+// Return an error on any system failures.
+func verifyDataStore(fsys vfs.FS, dataStorePath string, enc *recfmt.Encoding, r *Report) (scannedFiles, error) {
+	entries, err := fsys.ReadDir(dataStorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	hintFiles := make([]string, 0)
+	files := scannedFiles{}
+	hasKeyDir := false
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, ".data"):
+			data, err := fsys.ReadFile(path.Join(dataStorePath, name))
+			if err != nil {
+				return nil, err
+			}
+			files[name] = scanDataFile(name, data, enc, r)
+			r.Files++
+		case strings.HasSuffix(name, ".hint"):
+			hintFiles = append(hintFiles, name)
+		case name == keydir.FileName:
+			hasKeyDir = true
+		}
+	}
+
+	for _, name := range hintFiles {
+		err := files.verifyHintFile(fsys, dataStorePath, name, enc, r)
+		if err != nil {
+			return nil, err
+		}
+		r.Files++
+	}
+
+	if hasKeyDir {
+		old, err := keydir.IsOld(fsys, dataStorePath)
+		if err != nil {
+			return nil, err
+		}
+		if !old {
+			err := files.verifyKeyDirFile(fsys, dataStorePath, enc, r)
+			if err != nil {
+				return nil, err
+			}
+			r.Files++
+		}
+	}
+
+	return files, nil
+}
+
+// scanDataFile decodes all the records of the given data file content.
+// Corrupted records are added to the report and skipped.
+// Return the readable records by their offsets.
+func scanDataFile(name string, data []byte, enc *recfmt.Encoding, r *Report) map[uint32]scannedRec {
+	recs := make(map[uint32]scannedRec)
+
+	hdr, i, err := recfmt.ExtractFileHdr(data)
+	if errors.Is(err, recfmt.ErrLegacyFormat) {
+		return scanLegacyDataFile(name, data, r)
+	}
+	if err != nil {
+		r.Problems = append(r.Problems, Problem{File: name, Err: err.Error()})
+		return recs
+	}
+
+	for i < len(data) {
+		rec, recLen, err := recfmt.ExtractDataFileRec(data[i:], hdr, enc)
+		if err == nil {
+			recs[uint32(i)] = scannedRec{length: int(recLen), rec: rec}
+			r.Records++
+			i += int(recLen)
+			continue
+		}
+
+		key := ""
+		if peeked, _, ok := recfmt.PeekDataFileRec(data[i:], hdr); ok {
+			key = peeked.Key
+		}
+		r.Problems = append(r.Problems, Problem{
+			File:   name,
+			Offset: int64(i),
+			Key:    key,
+			Err:    err.Error(),
+		})
+		i += recfmt.NextDataFileRec(data[i:], hdr)
+	}
+
+	return recs
+}
+
+// scanLegacyDataFile decodes the records of the given legacy data file content,
+// which are migrated when the datastore is opened with write permission.
+// Scanning stops at the first corrupted record which is added to the report.
+// Return the readable records by their offsets.
+func scanLegacyDataFile(name string, data []byte, r *Report) map[uint32]scannedRec {
+	recs := make(map[uint32]scannedRec)
+
+	for i := 0; i < len(data); {
+		rec, recLen, err := recfmt.ExtractLegacyDataFileRec(data[i:])
+		if err != nil {
+			r.Problems = append(r.Problems, Problem{File: name, Offset: int64(i), Err: err.Error()})
+			break
+		}
+		recs[uint32(i)] = scannedRec{length: int(recLen), rec: rec}
+		r.Records++
+		i += int(recLen)
+	}
+
+	return recs
+}
+
+// verifyHintFile checks that every record of the given hint file points at
+// a matching record in its data file and adds the problems found to the report.
+// Return an error on any system failures.
+func (s scannedFiles) verifyHintFile(fsys vfs.FS, dataStorePath, name string, enc *recfmt.Encoding, r *Report) error {
+	data, err := fsys.ReadFile(path.Join(dataStorePath, name))
+	if err != nil {
+		return err
+	}
+
+	// legacy hint files are removed by the migration, so they are not checked.
+	hdr, i, err := recfmt.ExtractFileHdr(data)
+	if errors.Is(err, recfmt.ErrLegacyFormat) {
+		return nil
+	}
+	if err != nil {
+		r.Problems = append(r.Problems, Problem{File: name, Err: err.Error()})
+		return nil
+	}
+
+	dataFile := strings.TrimSuffix(name, ".hint") + ".data"
+	for i < len(data) {
+		key, rec, recLen, err := recfmt.ExtractHintFileRec(data[i:], hdr, enc)
+		if err != nil {
+			r.Problems = append(r.Problems, Problem{File: name, Offset: int64(i), Err: err.Error()})
+			return nil
+		}
+
+		rec.FileId = dataFile
+		if !s.match(key, rec) {
+			r.Problems = append(r.Problems, Problem{
+				File:   name,
+				Offset: int64(i),
+				Key:    key,
+				Err:    fmt.Sprintf("hint record does not match %s at offset %d", dataFile, rec.ValuePos),
+			})
+		}
+		i += recLen
+	}
+
+	return nil
+}
+
+// verifyKeyDirFile checks that every record of the keydir file points at
+// a matching data file record and adds the problems found to the report.
+// Return an error on any system failures.
+func (s scannedFiles) verifyKeyDirFile(fsys vfs.FS, dataStorePath string, enc *recfmt.Encoding, r *Report) error {
+	data, err := fsys.ReadFile(path.Join(dataStorePath, keydir.FileName))
+	if err != nil {
+		return err
+	}
+
+	// legacy keydir files are ignored by Open, so they are not checked.
+	hdr, i, err := recfmt.ExtractFileHdr(data)
+	if errors.Is(err, recfmt.ErrLegacyFormat) {
+		return nil
+	}
+	if err == nil {
+		_, err = recfmt.ExtractKeyDirFileTstamp(data[i:])
+	}
+	if err != nil {
+		r.Problems = append(r.Problems, Problem{File: keydir.FileName, Err: err.Error()})
+		return nil
+	}
+
+	for i += recfmt.KeyDirFileTstamp; i < len(data); {
+		key, rec, recLen, err := recfmt.ExtractKeyDirRec(data[i:], hdr, enc)
+		if err != nil {
+			r.Problems = append(r.Problems, Problem{File: keydir.FileName, Offset: int64(i), Err: err.Error()})
+			return nil
+		}
+
+		if !s.match(key, rec) {
+			r.Problems = append(r.Problems, Problem{
+				File:   keydir.FileName,
+				Offset: int64(i),
+				Key:    key,
+				Err:    fmt.Sprintf("keydir record does not match %s at offset %d", rec.FileId, rec.ValuePos),
+			})
+		}
+		i += recLen
+	}
+
+	return nil
+}
+
+// match reports whether the given keydir record points at a readable data file record
+// with the same key, timestamp and size.
+func (s scannedFiles) match(key string, rec recfmt.KeyDirRec) bool {
+	found, isExist := s[rec.FileId][rec.ValuePos]
+	if !isExist {
+		return false
+	}
+
+	return found.rec.Key == key &&
+		found.rec.Tstamp == rec.Tstamp &&
+		found.length == recfmt.DataFileRecHdr+int(rec.KeySize)+int(rec.ValueSize)
+}
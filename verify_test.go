@@ -0,0 +1,89 @@
+package bitcask
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/IslamWalid/bitcask/internal/recfmt"
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+func TestVerify(t *testing.T) {
+	t.Run("verify a healthy datastore", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		for i := 0; i < 1000; i++ {
+			b.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+		}
+		b.Merge()
+		b.Delete("key1")
+		b.Close()
+
+		r, _ := OpenWith(testBitcaskPath, WithFS(mem))
+		r.Close()
+
+		report, err := Verify(testBitcaskPath, WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.OK() {
+			t.Errorf("expected no problems, found %v", report.Problems)
+		}
+	})
+
+	t.Run("verify and repair a corrupted datastore", func(t *testing.T) {
+		mem := vfs.NewMem()
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(mem))
+		for i := 0; i < 100; i++ {
+			b.Put(fmt.Sprintf("key%d", i+1), fmt.Sprintf("value%d", i+1))
+		}
+		b.Delete("key2")
+		rec := b.keyDir["key50"]
+		b.Close()
+
+		name := path.Join(testBitcaskPath, rec.FileId)
+		data, _ := mem.ReadFile(name)
+		data[int(rec.ValuePos)+recfmt.DataFileRecHdr+len("key50")] ^= 0xff
+		writeFileSync(mem, name, data)
+
+		_, err := OpenWith(testBitcaskPath, WithFS(mem))
+		if err == nil {
+			t.Fatal("expected open to fail on the corrupted datastore")
+		}
+
+		report, err := Verify(testBitcaskPath, WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Problems) != 1 {
+			t.Fatalf("expected one problem, found %v", report.Problems)
+		}
+		want := Problem{File: rec.FileId, Offset: int64(rec.ValuePos), Key: "key50", Err: "corrution detected: datastore files are corrupted"}
+		if got := report.Problems[0]; got != want {
+			t.Errorf("got:\n%v\nwant:\n%v", got, want)
+		}
+
+		report, err = Repair(testBitcaskPath, "repaired", WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Salvaged != 98 {
+			t.Errorf("expected 98 salvaged keys, found %d", report.Salvaged)
+		}
+
+		r, err := OpenWith("repaired", WithFS(mem))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		got, _ := r.Get("key51")
+		assertString(t, got, "value51")
+		_, err = r.Get("key2")
+		if err == nil || !strings.HasSuffix(err.Error(), "key does not exist") {
+			t.Errorf("expected deleted key to stay deleted, got %v", err)
+		}
+	})
+}
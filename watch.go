@@ -0,0 +1,183 @@
+package bitcask
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EventPut is emitted after a value is stored by Put.
+	EventPut EventType = 0
+	// EventDelete is emitted after a key is removed by Delete.
+	EventDelete EventType = 1
+	// EventExpire is reserved for keys removed because they expired,
+	// the datastore does not expire keys yet so it is never emitted.
+	EventExpire EventType = 2
+	// EventOverflow is emitted after the watcher buffer got full and events were dropped,
+	// its Seq is the sequence number of the last dropped event.
+	EventOverflow EventType = 3
+
+	// watchBufferSize is the number of events buffered for every watcher.
+	watchBufferSize = 1024
+)
+
+type (
+	// EventType represents the kinds of the events emitted by Watch.
+	EventType int
+
+	// Event describes a change of a key.
+	// Seq is the timestamp of the written record, it increases with every write.
+	// Value is only set for EventPut.
+	// Batch is the number of the changes committed by the same transaction after the event,
+	// including the changes of the keys that are not watched, so it is 0 for the last change of a transaction
+	// and for the changes written alone.
+	Event struct {
+		Seq   int64
+		Type  EventType
+		Key   string
+		Value string
+		Batch int
+	}
+
+	// watcher represents a channel that receives the events of the keys with a prefix.
+	watcher struct {
+		prefix     string
+		events     chan Event
+		overflowed bool
+		dropped    int64
+	}
+
+	// watchers groups the active watchers of a bitcask.
+	watchers struct {
+		mu     sync.Mutex
+		nextID int
+		active map[int]*watcher
+	}
+)
+
+// Watch returns a channel that receives an event after every successful Put and Delete of the keys
+// starting with the given prefix, all the keys are watched if the prefix is empty,
+// and a function that stops watching and closes the channel.
+// Events are buffered, if the buffer gets full the next events are dropped
+// until the buffer has room for an EventOverflow event that reports the loss.
+// Only the writes done by this bitcask object are watched, so a ReadOnly bitcask never emits events.
+// The channel is closed when the bitcask is closed.
+func (b *Bitcask) Watch(prefix string) (<-chan Event, func()) {
+	w := &watcher{prefix: prefix, events: make(chan Event, watchBufferSize)}
+
+	b.watchers.mu.Lock()
+	defer b.watchers.mu.Unlock()
+
+	if b.watchers.active == nil {
+		b.watchers.active = make(map[int]*watcher)
+	}
+	id := b.watchers.nextID
+	b.watchers.nextID++
+	b.watchers.active[id] = w
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.watchers.mu.Lock()
+			defer b.watchers.mu.Unlock()
+
+			if _, ok := b.watchers.active[id]; ok {
+				delete(b.watchers.active, id)
+				close(w.events)
+			}
+		})
+	}
+
+	return w.events, cancel
+}
+
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventOverflow:
+		return "overflow"
+	default:
+		return "unknown"
+	}
+}
+
+// nextTstamp returns the timestamp of the next written record, the caller must hold the access lock.
+// Timestamps strictly increase even if the clock goes back or two records are written in the same microsecond,
+// so they can be used as sequence numbers.
+func (b *Bitcask) nextTstamp() int64 {
+	tstamp := time.Now().UnixMicro()
+	if tstamp <= b.lastTstamp {
+		tstamp = b.lastTstamp + 1
+	}
+	b.lastTstamp = tstamp
+
+	return tstamp
+}
+
+// publish sends the event to the watchers of its key without blocking.
+// The caller must hold the access lock, so the watchers receive the events in the order of their writes.
+func (w *watchers) publish(ev Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, watcher := range w.active {
+		if strings.HasPrefix(ev.Key, watcher.prefix) {
+			watcher.send(ev)
+		}
+	}
+}
+
+// closeAll closes the channels of all the watchers.
+func (w *watchers) closeAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for id, watcher := range w.active {
+		delete(w.active, id)
+		close(watcher.events)
+	}
+}
+
+// overflow reports to all the watchers that the events up to the given sequence number were dropped.
+func (w *watchers) overflow(seq int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, watcher := range w.active {
+		watcher.overflowed = true
+		watcher.dropped = seq
+		select {
+		case watcher.events <- Event{Seq: seq, Type: EventOverflow}:
+			watcher.overflowed = false
+		default:
+		}
+	}
+}
+
+// send buffers the event, or drops it if the buffer is full.
+// After dropping events, the overflow event is sent before the next events.
+func (w *watcher) send(ev Event) {
+	if w.overflowed {
+		select {
+		case w.events <- Event{Seq: w.dropped, Type: EventOverflow}:
+			w.overflowed = false
+		default:
+			w.dropped = ev.Seq
+			return
+		}
+	}
+
+	select {
+	case w.events <- ev:
+	default:
+		w.overflowed = true
+		w.dropped = ev.Seq
+	}
+}
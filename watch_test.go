@@ -0,0 +1,77 @@
+package bitcask
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/IslamWalid/bitcask/pkg/vfs"
+)
+
+func TestWatch(t *testing.T) {
+	t.Run("put and delete events", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+
+		events, cancel := b.Watch("user:")
+		b.Put("user:1", "value1")
+		b.Put("order:1", "value2")
+		b.Put("user:1", "value3")
+		b.Delete("user:1")
+		cancel()
+
+		want := []Event{
+			{Type: EventPut, Key: "user:1", Value: "value1"},
+			{Type: EventPut, Key: "user:1", Value: "value3"},
+			{Type: EventDelete, Key: "user:1"},
+		}
+		var got []Event
+		for ev := range events {
+			got = append(got, ev)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %d events, want %d", len(got), len(want))
+		}
+		for i, ev := range got {
+			if ev.Type != want[i].Type || ev.Key != want[i].Key || ev.Value != want[i].Value {
+				t.Errorf("event %d: got %+v, want %+v", i, ev, want[i])
+			}
+			if i > 0 && ev.Seq <= got[i-1].Seq {
+				t.Errorf("event %d: sequence %d is not after %d", i, ev.Seq, got[i-1].Seq)
+			}
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		defer b.Close()
+
+		events, cancel := b.Watch("")
+		defer cancel()
+		for i := 0; i < watchBufferSize+10; i++ {
+			b.Put(fmt.Sprintf("key%d", i), "value")
+		}
+		for i := 0; i < watchBufferSize; i++ {
+			<-events
+		}
+		b.Put("last", "value")
+
+		overflow := <-events
+		if overflow.Type != EventOverflow || overflow.Seq == 0 {
+			t.Errorf("expected an overflow event, got %+v", overflow)
+		}
+		if ev := <-events; ev.Key != "last" || ev.Seq <= overflow.Seq {
+			t.Errorf("expected the event of key last after the overflow, got %+v", ev)
+		}
+	})
+
+	t.Run("close closes the watchers", func(t *testing.T) {
+		b, _ := OpenWith(testBitcaskPath, ReadWrite, WithFS(vfs.NewMem()))
+		events, cancel := b.Watch("")
+		b.Close()
+		cancel()
+
+		if _, ok := <-events; ok {
+			t.Error("expected the events channel to be closed")
+		}
+	})
+}